@@ -74,3 +74,24 @@ func (a *Array[T]) Set(cs []int, t T) error {
 	a.data[ix] = t
 	return nil
 }
+
+// Dims returns the upper bounds of the dimensions
+func (a *Array[T]) Dims() []int {
+	dims := make([]int, len(a.dims))
+	for i, dim := range a.dims {
+		dims[i] = dim
+		if !idxOf1 {
+			dims[i]--
+		}
+	}
+	return dims
+}
+
+// Values returns the elements in row-major order
+func (a *Array[T]) Values() []interface{} {
+	vs := make([]interface{}, len(a.data))
+	for i, v := range a.data {
+		vs[i] = v
+	}
+	return vs
+}
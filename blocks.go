@@ -0,0 +1,355 @@
+package gobas
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BlockPos addresses a statement by the index of its line and its index in the flattened code of the line
+type BlockPos struct {
+	LineIdx int
+	StmtIdx int
+}
+
+// Blocks links the statements of structured blocks, which may span several lines. WHILE and WEND as well as
+// DO and LOOP are linked with each other and EXIT DO with the LOOP of its block. IF, ELSEIF and ELSE are linked
+// with the next clause of their block, the last one with the END IF. SUB and FUNCTION are linked with their END.
+type Blocks map[BlockPos]BlockPos
+
+// ResolveBlocks matches the structured blocks of lines. Unbalanced blocks are reported with their line numbers.
+func ResolveBlocks(lines []Line) (Blocks, error) {
+	b := Blocks{}
+	err := b.resolve(lines, 0)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// resolve adds the blocks of lines, where the first one has the index firstIdx
+func (b Blocks) resolve(lines []Line, firstIdx int) error {
+	return lineErrors(b.resolveLines(lines, firstIdx))
+}
+
+type openBlock struct {
+	kind string
+	// name is the name of a SUB or FUNCTION
+	name    string
+	lineNum int
+	pos     BlockPos
+	clause  BlockPos
+	hasElse bool
+	exits   []BlockPos
+}
+
+var blockEnds = map[string]string{
+	"WHILE":    "WEND",
+	"DO":       "LOOP",
+	"IF":       "END IF",
+	"SUB":      "END SUB",
+	"FUNCTION": "END FUNCTION",
+}
+
+func (b Blocks) resolveLines(lines []Line, firstIdx int) []lineError {
+	var errs []lineError
+	var open []*openBlock
+	innermost := func(kind string) *openBlock {
+		for i := len(open) - 1; i >= 0; i-- {
+			switch {
+			case open[i].kind == kind:
+				return open[i]
+			case isProc(open[i].kind):
+				return nil
+			}
+		}
+		return nil
+	}
+	top := func(kind string) *openBlock {
+		if len(open) == 0 || open[len(open)-1].kind != kind {
+			return nil
+		}
+		return open[len(open)-1]
+	}
+	// proc returns the SUB or FUNCTION, which is open, like "SUB P" or "" in the main program
+	proc := func() string {
+		for i := len(open) - 1; i >= 0; i-- {
+			if isProc(open[i].kind) {
+				return open[i].kind + " " + open[i].name
+			}
+		}
+		return ""
+	}
+	type jumpFrom struct {
+		lineNum int
+		pos     BlockPos
+		proc    string
+		targets []int
+	}
+	var jumps []jumpFrom
+	procOfLine := map[int]string{}
+
+	for i, line := range lines {
+		fail := func(pos BlockPos, format string, args ...interface{}) {
+			errs = append(errs, lineError{lineNum: line.num, pos: pos, msg: fmt.Sprintf(format, args...)})
+		}
+		if _, ok := procOfLine[line.num]; !ok {
+			procOfLine[line.num] = proc()
+		}
+		for j, stmt := range flatten(line.stmts) {
+			pos := BlockPos{LineIdx: firstIdx + i, StmtIdx: j}
+			if targets := jumpTargets(stmt); len(targets) > 0 {
+				jumps = append(jumps, jumpFrom{lineNum: line.num, pos: pos, proc: proc(), targets: targets})
+			}
+			switch stmt := stmt.(type) {
+			case WHILE:
+				open = append(open, &openBlock{kind: "WHILE", lineNum: line.num, pos: pos})
+			case DO:
+				open = append(open, &openBlock{kind: "DO", lineNum: line.num, pos: pos})
+			case IFBLOCK:
+				open = append(open, &openBlock{kind: "IF", lineNum: line.num, pos: pos, clause: pos})
+			case WEND:
+				ob := top("WHILE")
+				if ob == nil {
+					fail(pos, "WEND without WHILE")
+					continue
+				}
+				b[ob.pos], b[pos] = pos, ob.pos
+				open = open[:len(open)-1]
+			case LOOP:
+				ob := top("DO")
+				if ob == nil {
+					fail(pos, "LOOP without DO")
+					continue
+				}
+				b[ob.pos], b[pos] = pos, ob.pos
+				for _, exit := range ob.exits {
+					b[exit] = pos
+				}
+				open = open[:len(open)-1]
+			case EXITDO:
+				ob := innermost("DO")
+				if ob == nil {
+					fail(pos, "EXIT DO outside of DO")
+					continue
+				}
+				ob.exits = append(ob.exits, pos)
+			case SUB, FUNCTION:
+				kind := StmtKind(stmt)
+				if len(open) > 0 {
+					fail(pos, "%s inside of %s", kind, open[len(open)-1].kind)
+				}
+				ob := &openBlock{kind: kind, lineNum: line.num, pos: pos}
+				if sub, ok := stmt.(SUB); ok {
+					ob.name = sub.Name
+				} else {
+					ob.name = stmt.(FUNCTION).Name
+				}
+				open = append(open, ob)
+			case ENDSUB, ENDFUNCTION:
+				kind := strings.TrimPrefix(StmtKind(stmt), "END")
+				ob := top(kind)
+				if ob == nil {
+					fail(pos, "END %s without %s", kind, kind)
+					continue
+				}
+				b[ob.pos], b[pos] = pos, ob.pos
+				open = open[:len(open)-1]
+			case EXITSUB, EXITFUNCTION:
+				kind := strings.TrimPrefix(StmtKind(stmt), "EXIT")
+				if innermost(kind) == nil {
+					fail(pos, "EXIT %s outside of %s", kind, kind)
+				}
+			case ELSEIF, ELSE:
+				name := StmtKind(stmt)
+				ob := top("IF")
+				switch {
+				case ob == nil:
+					fail(pos, "%s without IF", name)
+					continue
+				case ob.hasElse:
+					fail(pos, "%s after ELSE", name)
+					continue
+				}
+				b[ob.clause] = pos
+				ob.clause = pos
+				_, ob.hasElse = stmt.(ELSE)
+			case ENDIF:
+				ob := top("IF")
+				if ob == nil {
+					fail(pos, "END IF without IF")
+					continue
+				}
+				b[ob.clause] = pos
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for _, ob := range open {
+		errs = append(errs, lineError{lineNum: ob.lineNum, pos: ob.pos, msg: fmt.Sprintf("%s without %s", ob.kind, blockEnds[ob.kind])})
+	}
+	// like QuickBASIC, GOTO and GOSUB don't cross the bounds of a SUB or FUNCTION. A SUB or FUNCTION
+	// statement at the start of a line belongs to the code before, which jumps over the definition.
+	for _, jf := range jumps {
+		for _, num := range jf.targets {
+			to, ok := procOfLine[num]
+			switch {
+			case !ok || to == jf.proc:
+			case jf.proc != "":
+				errs = append(errs, lineError{lineNum: jf.lineNum, pos: jf.pos, msg: fmt.Sprintf("jump to line %d leaves %s", num, jf.proc)})
+			default:
+				errs = append(errs, lineError{lineNum: jf.lineNum, pos: jf.pos, msg: fmt.Sprintf("jump to line %d enters %s", num, to)})
+			}
+		}
+	}
+	return errs
+}
+
+// lineErrors returns the errors sorted by their lines
+func lineErrors(errs []lineError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].lineNum < errs[j].lineNum
+	})
+	perrs := make(ParseErrors, len(errs))
+	for i, e := range errs {
+		perrs[i] = e.err()
+	}
+	return perrs
+}
+
+// isProc tells, if a block of kind is a procedure, which no other block and no EXIT may span
+func isProc(kind string) bool {
+	return kind == "SUB" || kind == "FUNCTION"
+}
+
+type lineError struct {
+	lineNum int
+	// pos is the statement, which the error is about
+	pos BlockPos
+	msg string
+}
+
+func (e lineError) err() error {
+	if e.lineNum == immediateLineNum {
+		return errors.New(e.msg)
+	}
+	return errors.Errorf("in line %d: %s", e.lineNum, e.msg)
+}
+
+func (s *State) blockPos() BlockPos {
+	return BlockPos{LineIdx: s.currIdx, StmtIdx: s.stmtIdx}
+}
+
+func (s *State) stmtAt(pos BlockPos) Stmt {
+	line, _ := s.line(pos.LineIdx)
+	return line.code[pos.StmtIdx]
+}
+
+func (s *State) jumpTo(pos BlockPos) {
+	s.jump(pos.LineIdx, pos.StmtIdx)
+}
+
+func (s *State) jumpBehind(pos BlockPos) {
+	s.jump(pos.LineIdx, pos.StmtIdx+1)
+}
+
+func (s *State) cond(e Expr) (bool, error) {
+	val, err := s.eval(e)
+	if err != nil {
+		return false, errors.Wrapf(err, "eval %q", e.Raw)
+	}
+	return s.boolVal(val), nil
+}
+
+// loops tells, if the condition of a DO or LOOP lets the loop continue
+func (s *State) loops(cond *LoopCond) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	ok, err := s.cond(cond.Expr)
+	if err != nil {
+		return false, err
+	}
+	return ok != cond.Until, nil
+}
+
+// enterClause continues in the first clause behind the one at pos, whose condition is true
+func (s *State) enterClause(pos BlockPos) error {
+	for {
+		pos = s.blocks[pos]
+		elseIf, ok := s.stmtAt(pos).(ELSEIF)
+		if !ok {
+			s.jumpBehind(pos)
+			return nil
+		}
+		ok, err := s.cond(elseIf.Expr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.jumpBehind(pos)
+			return nil
+		}
+	}
+}
+
+// leaveIf continues behind the END IF of the block, the clause at pos belongs to
+func (s *State) leaveIf(pos BlockPos) {
+	for {
+		if _, ok := s.stmtAt(pos).(ENDIF); ok {
+			s.jumpBehind(pos)
+			return
+		}
+		pos = s.blocks[pos]
+	}
+}
+
+func (s *State) execBlock(stmt Stmt) error {
+	pos := s.blockPos()
+	switch stmt := stmt.(type) {
+	case WHILE:
+		ok, err := s.cond(stmt.Expr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.jumpBehind(s.blocks[pos])
+		}
+	case WEND:
+		s.jumpTo(s.blocks[pos])
+	case DO:
+		ok, err := s.loops(stmt.Cond)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.jumpBehind(s.blocks[pos])
+		}
+	case LOOP:
+		ok, err := s.loops(stmt.Cond)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.jumpTo(s.blocks[pos])
+		}
+	case EXITDO:
+		s.jumpBehind(s.blocks[pos])
+	case IFBLOCK:
+		ok, err := s.cond(stmt.Expr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return s.enterClause(pos)
+		}
+	case ELSEIF, ELSE:
+		s.leaveIf(pos)
+	}
+	return nil
+}
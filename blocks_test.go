@@ -0,0 +1,203 @@
+package gobas
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{
+			name: "while wend",
+			src: `
+				10 I=1
+				20 WHILE I<=3
+				30   PRINT I;
+				40   I=I+1
+				50 WEND
+				60 PRINT "DONE"`,
+			expect: " 1  2  3 DONE\n",
+		},
+		{
+			name:   "while not entered",
+			src:    "10 WHILE 0: PRINT \"NO\": WEND: PRINT \"YES\"",
+			expect: "YES\n",
+		},
+		{
+			name: "nested while",
+			src: `
+				10 WHILE I<2
+				20   J=0: WHILE J<2: PRINT I;J;"|";: J=J+1: WEND
+				30   I=I+1
+				40 WEND`,
+			expect: " 0  0 | 0  1 | 1  0 | 1  1 |",
+		},
+		{
+			name:   "do while",
+			src:    "10 DO WHILE I<3: I=I+1: PRINT I;: LOOP",
+			expect: " 1  2  3 ",
+		},
+		{
+			name:   "do loop until runs at least once",
+			src:    "10 DO: PRINT \"X\";: LOOP UNTIL 1",
+			expect: "X",
+		},
+		{
+			name: "exit do",
+			src: `
+				10 DO
+				20   I=I+1
+				30   IF I=4 THEN EXIT DO
+				40   IF I MOD 2 THEN PRINT I;
+				50 LOOP
+				60 PRINT "AT";I`,
+			expect: " 1  3 AT 4 \n",
+		},
+		{
+			name: "block if",
+			src: `
+				10 FOR I=1 TO 4
+				20   IF I=1 THEN
+				30     PRINT "ONE";
+				40   ELSEIF I=2 THEN
+				50     PRINT "TWO";
+				60   ELSEIF I=3 THEN
+				70     PRINT "THREE";
+				80   ELSE
+				90     PRINT "MANY";
+				100  END IF
+				110  PRINT "|";
+				120 NEXT`,
+			expect: "ONE|TWO|THREE|MANY|",
+		},
+		{
+			name: "block if without else",
+			src: `
+				10 IF 0 THEN
+				20   PRINT "NO"
+				30 ELSEIF 0 THEN
+				40   PRINT "NO"
+				50 END IF
+				60 PRINT "YES"`,
+			expect: "YES\n",
+		},
+		{
+			name: "nested block if",
+			src: `
+				10 A=1: B=0
+				20 IF A THEN
+				30   IF B THEN
+				40     PRINT "A AND B"
+				50   ELSE
+				60     PRINT "A NOT B"
+				70   END IF
+				80 ELSE
+				90   PRINT "NOT A"
+				100 END IF`,
+			expect: "A NOT B\n",
+		},
+		{
+			name: "gosub inside while",
+			src: `
+				10 WHILE I<2: GOSUB 100: WEND
+				20 END
+				100 I=I+1: PRINT I;: RETURN`,
+			expect: " 1  2 ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestUnbalancedBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name:   "missing wend",
+			src:    "10 WHILE 1\n20 PRINT",
+			expect: []string{"in line 10: WHILE without WEND"},
+		},
+		{
+			name:   "crossed blocks",
+			src:    "10 WHILE 1\n20 DO\n30 WEND\n40 LOOP",
+			expect: []string{"in line 10: WHILE without WEND", "in line 30: WEND without WHILE"},
+		},
+		{
+			name:   "exit outside of do",
+			src:    "10 EXIT DO\n20 LOOP",
+			expect: []string{"in line 10: EXIT DO outside of DO", "in line 20: LOOP without DO"},
+		},
+		{
+			name:   "if clauses",
+			src:    "10 ELSE\n20 IF 1 THEN\n30 ELSE\n40 ELSEIF 1 THEN\n50 END IF\n60 END IF",
+			expect: []string{"in line 10: ELSE without IF", "in line 40: ELSEIF after ELSE", "in line 60: END IF without IF"},
+		},
+		{
+			name:   "missing end if",
+			src:    "10 IF 1 THEN\n20 PRINT",
+			expect: []string{"in line 10: IF without END IF"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewParser().Parse(strings.NewReader(test.src))
+			if err == nil {
+				t.Fatalf("want error, got NO error")
+			}
+			if want := strings.Join(test.expect, "\n"); err.Error() != want {
+				t.Fatalf("want %q, got %q", want, err.Error())
+			}
+		})
+	}
+}
+
+func TestExecBlocks(t *testing.T) {
+	state, err := NewParser().Parse(strings.NewReader("10 WHILE I<2: I=I+1: WEND"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	state.SetStreams(Streams{Stdout: stdout})
+	_, err = state.Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	stmts, err := NewParser().ParseStmts("DO: I=I+1: LOOP UNTIL I=5: PRINT I")
+	if err != nil {
+		t.Fatalf("parse stmts: %v", err)
+	}
+	_, err = state.Exec(stmts)
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if want := " 5 \n"; stdout.String() != want {
+		t.Fatalf("want stdout %q, got %q", want, stdout.String())
+	}
+
+	stmts, err = NewParser().ParseStmts("DO: I=I+1")
+	if err != nil {
+		t.Fatalf("parse stmts: %v", err)
+	}
+	_, err = state.Exec(stmts)
+	if err == nil || err.Error() != "DO without LOOP" {
+		t.Fatalf("want unbalanced DO, got %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mazzegi/gobas"
+	"github.com/mazzegi/gobas/dap"
+	"github.com/mazzegi/gobas/debug"
+	"github.com/mazzegi/gobas/repl"
+	"github.com/mazzegi/gobas/transpile"
+)
+
+func runCmd(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	source := sourceFlag(flags)
+	vm := flags.Bool("vm", false, "compile the program to bytecode and run it on the VM")
+	zoneWidth := flags.Int("zone-width", gobas.DefaultZoneWidth, "width of the print zones, a comma in PRINT advances to")
+	trace := flags.String("trace", "", "trace the executed statements to a file, - is stderr")
+	traceFormat := flags.String("trace-format", "text", "format of the trace: text or json")
+	profile := flags.String("profile", "", "write a profile of the run in pprof format to a file")
+	profileList := flags.String("profile-list", "", "write the program annotated with the profile to a file, - is stderr")
+	cover := flags.Bool("cover", false, "record the coverage of lines and IF branches and print a summary to stderr")
+	coverProfile := flags.String("coverprofile", "", "write the coverage to a file, implies -cover")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("run", flags.Args())
+	if !ok {
+		return 2
+	}
+	format, err := gobas.ParseTraceFormat(*traceFormat)
+	if err != nil {
+		printError(err)
+		return 2
+	}
+	if *coverProfile != "" {
+		*cover = true
+	}
+	if *zoneWidth < 1 {
+		printError(errors.New("-zone-width must be positive"))
+		return 2
+	}
+	if *vm && (*trace != "" || *profile != "" || *profileList != "" || *cover) {
+		printError(errors.New("-trace, -profile and -cover are not supported with -vm"))
+		return 2
+	}
+	state, err := parseSource(file, *source)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	state.SetZoneWidth(*zoneWidth)
+	switch *trace {
+	case "":
+	case "-":
+		state.SetTrace(state.Stderr(), format)
+	default:
+		f, err := os.Create(*trace)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		state.SetTrace(w, format)
+	}
+	if *vm {
+		prog, err := gobas.Compile(state.Lines())
+		switch {
+		case errors.Is(err, gobas.ErrProcedures):
+			// the interpreter runs the program instead
+			fmt.Fprintln(os.Stderr, "gobas: the VM does not run procedures yet, running the program in the interpreter")
+		case err != nil:
+			printError(err)
+			return 1
+		default:
+			prog.SetZoneWidth(*zoneWidth)
+			_, err = prog.Run(gobas.Streams{})
+			if err != nil {
+				printError(err)
+				return 1
+			}
+			return 0
+		}
+	}
+	var prof *gobas.Profile
+	if *profile != "" || *profileList != "" {
+		prof = gobas.NewProfile()
+		state.SetProfile(prof)
+	}
+	var cov *gobas.Coverage
+	if *cover {
+		cov = gobas.NewCoverage()
+		state.SetCoverage(cov, file)
+	}
+	_, runErr := state.Run()
+	if prof != nil {
+		if err := writeProfile(prof, file, *profile, *profileList); err != nil {
+			printError(err)
+			return 1
+		}
+	}
+	if cov != nil {
+		fmt.Fprintf(os.Stderr, "coverage: %s\n", cov.Summary(file))
+		if *coverProfile != "" {
+			if err := writeFile(*coverProfile, cov.WriteProfile); err != nil {
+				printError(err)
+				return 1
+			}
+		}
+	}
+	if runErr != nil {
+		printError(runErr)
+		return 1
+	}
+	return 0
+}
+
+// writeProfile writes the profile in pprof format to pprofFile and as annotated listing to listFile
+func writeProfile(prof *gobas.Profile, file string, pprofFile string, listFile string) error {
+	if pprofFile != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(pprofFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := prof.WritePprof(f, abs); err != nil {
+			return err
+		}
+	}
+	switch listFile {
+	case "":
+		return nil
+	case "-":
+		return prof.WriteListing(os.Stderr, 10)
+	default:
+		f, err := os.Create(listFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return prof.WriteListing(f, 10)
+	}
+}
+
+func writeFile(name string, write func(w io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// coverCmd merges coverage profiles and reports them as annotated source
+func coverCmd(args []string) int {
+	flags := flag.NewFlagSet("cover", flag.ContinueOnError)
+	htmlFile := flags.String("html", "", "write the report as HTML to a file instead of text to stdout")
+	out := flags.String("o", "", "write the merged profile to a file instead of a report")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: gobas %s\n", commands["cover"].usage)
+		return 2
+	}
+	cov := gobas.NewCoverage()
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		c, err := gobas.ReadCoverage(f)
+		f.Close()
+		if err != nil {
+			printError(fmt.Errorf("%s: %v", name, err))
+			return 1
+		}
+		cov.Merge(c)
+	}
+	var err error
+	switch {
+	case *out != "":
+		err = writeFile(*out, cov.WriteProfile)
+	case *htmlFile != "":
+		err = writeFile(*htmlFile, func(w io.Writer) error {
+			return cov.WriteHTML(w, os.ReadFile)
+		})
+	default:
+		err = cov.WriteText(os.Stdout, os.ReadFile)
+	}
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func checkCmd(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	source := sourceFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("check", flags.Args())
+	if !ok {
+		return 2
+	}
+	_, err := parseSource(file, *source)
+	if err == nil {
+		return 0
+	}
+	var perrs gobas.ParseErrors
+	if errors.As(err, &perrs) {
+		for _, perr := range perrs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, perr)
+		}
+		fmt.Fprintf(os.Stderr, "%d error(s)\n", len(perrs))
+		return 1
+	}
+	printError(err)
+	return 1
+}
+
+func debugCmd(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	source := sourceFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("debug", flags.Args())
+	if !ok {
+		return 2
+	}
+	state, err := parseSource(file, *source)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	_, err = debug.NewTerminal(state, os.Stdin, os.Stdout).Run()
+	switch {
+	case errors.Is(err, debug.ErrQuit):
+		return 0
+	case err != nil:
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func dapCmd(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: gobas %s\n", commands["dap"].usage)
+		return 2
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func lintCmd(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	source := sourceFlag(flags)
+	asJSON := flags.Bool("json", false, "print the diagnostics as a JSON array")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("lint", flags.Args())
+	if !ok {
+		return 2
+	}
+	diags, err := lintSource(file, *source)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if *asJSON {
+		type fileDiagnostic struct {
+			File string `json:"file"`
+			gobas.Diagnostic
+		}
+		fds := []fileDiagnostic{}
+		for _, d := range diags {
+			fds = append(fds, fileDiagnostic{File: file, Diagnostic: d})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(fds)
+	} else {
+		for _, d := range diags {
+			fmt.Printf("%s:%s\n", file, d)
+		}
+	}
+	if len(diags) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lintSource lints the unlinked lines of file. The errors parsing it are returned as diagnostics as well.
+func lintSource(file string, source string) ([]gobas.Diagnostic, error) {
+	mode, err := gobas.ParseSourceMode(source)
+	if err != nil {
+		return nil, err
+	}
+	r := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	p := gobas.NewParser()
+	p.SetSourceMode(mode)
+	lines, err := p.ParseLines(r)
+	if err != nil {
+		return gobas.SyntaxDiagnostics(err), nil
+	}
+	return gobas.Lint(lines), nil
+}
+
+func parseDirCmd(args []string) int {
+	dir, ok := fileArg("parse-dir", args)
+	if !ok {
+		return 2
+	}
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	var passed, failed int
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".bas" {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		t0 := time.Now()
+		_, err := gobas.NewParser().ParseFile(path)
+		if err != nil {
+			failed++
+			fmt.Printf("FAILED: %q: %v\n", path, err)
+		} else {
+			passed++
+			fmt.Printf("OK: %q => %s\n", path, time.Since(t0))
+		}
+	}
+	fmt.Printf("*** passed: %d, failed: %d ***\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func listCmd(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	source := sourceFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("list", flags.Args())
+	if !ok {
+		return 2
+	}
+	state, err := parseSource(file, *source)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	err = gobas.List(os.Stdout, state.Lines())
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func transpileCmd(args []string) int {
+	flags := flag.NewFlagSet("transpile", flag.ContinueOnError)
+	out := flags.String("o", "", "write the generated Go source to this file instead of stdout")
+	source := sourceFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	file, ok := fileArg("transpile", flags.Args())
+	if !ok {
+		return 2
+	}
+	state, err := parseSource(file, *source)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	src, err := transpile.Transpile(state.Lines())
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return 0
+	}
+	err = os.WriteFile(*out, src, 0644)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func replCmd(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "usage: gobas %s\n", commands["repl"].usage)
+		return 2
+	}
+	r := repl.New(os.Stdin, os.Stdout)
+	if len(args) == 1 {
+		r.Handle(fmt.Sprintf("LOAD %q", args[0]))
+	}
+	err := r.Run()
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
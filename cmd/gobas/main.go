@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mazzegi/gobas"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run":       {usage: "run [-source mode] [-vm] [-zone-width n] [-trace file] [-trace-format text|json] [-profile file] [-profile-list file] [-cover] [-coverprofile file] file.bas", run: runCmd},
+		"cover":     {usage: "cover [-html file] [-o file] profile...", run: coverCmd},
+		"check":     {usage: "check [-source mode] file.bas", run: checkCmd},
+		"parse-dir": {usage: "parse-dir dir", run: parseDirCmd},
+		"debug":     {usage: "debug [-source mode] file.bas", run: debugCmd},
+		"dap":       {usage: "dap", run: dapCmd},
+		"lint":      {usage: "lint [-source mode] [-json] file.bas", run: lintCmd},
+		"list":      {usage: "list [-source mode] file.bas", run: listCmd},
+		"transpile": {usage: "transpile [-o main.go] [-source mode] file.bas", run: transpileCmd},
+		"repl":      {usage: "repl [file.bas]", run: replCmd},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gobas <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  gobas %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "a file named - is read from stdin")
+	fmt.Fprintln(os.Stderr, "the source mode is auto (default), numbered or unnumbered")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+// sourceFlag adds the flag, which sets if the lines of the source are numbered
+func sourceFlag(flags *flag.FlagSet) *string {
+	return flags.String("source", "auto", "numbering of the source lines: auto, numbered or unnumbered")
+}
+
+func parseSource(file string, source string) (*gobas.State, error) {
+	mode, err := gobas.ParseSourceMode(source)
+	if err != nil {
+		return nil, err
+	}
+	p := gobas.NewParser()
+	p.SetSourceMode(mode)
+	if file == "-" {
+		return p.Parse(os.Stdin)
+	}
+	return p.ParseFile(file)
+}
+
+// fileArg returns the single file argument of a subcommand or reports its usage
+func fileArg(name string, args []string) (string, bool) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: gobas %s\n", commands[name].usage)
+		return "", false
+	}
+	return args[0], true
+}
+
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+}
@@ -0,0 +1,43 @@
+package gobas
+
+// condJump continues at statement index to of the line, if expr evaluates to false
+type condJump struct {
+	stmt Stmt
+	expr Expr
+	to   int
+}
+
+// jump continues at statement index to of the line
+type jump struct {
+	to int
+}
+
+// flatten inlines the branches of single-line IF statements, so every executed statement of a line
+// can be addressed by its index. This is required to resume in the middle of a branch, e.g. after a RETURN.
+func flatten(stmts []Stmt) []Stmt {
+	return flattenInto([]Stmt{}, stmts)
+}
+
+func flattenInto(code []Stmt, stmts []Stmt) []Stmt {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case IFSTMT:
+			at := len(code)
+			code = append(code, nil)
+			code = flattenInto(code, stmt.Stmts)
+			code[at] = condJump{stmt: stmt, expr: stmt.Expr, to: len(code)}
+		case IFELSESTMT:
+			at := len(code)
+			code = append(code, nil)
+			code = flattenInto(code, stmt.Stmts)
+			jumpAt := len(code)
+			code = append(code, nil)
+			code[at] = condJump{stmt: stmt, expr: stmt.Expr, to: len(code)}
+			code = flattenInto(code, stmt.ElseStmts)
+			code[jumpAt] = jump{to: len(code)}
+		default:
+			code = append(code, stmt)
+		}
+	}
+	return code
+}
@@ -0,0 +1,652 @@
+package gobas
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// Compile translates the lines of a program to bytecode. Jumps are resolved to offsets of instructions and
+// variables to slots, which works as all variables have a type known before the program runs. Errors, which
+// the interpreter reports when a statement runs (like a type mismatch), are compiled to instructions failing
+// at the same point. Programs with SUBs or FUNCTIONs fail with ErrProcedures.
+func Compile(lines []Line) (*Program, error) {
+	blocks, err := ResolveBlocks(lines)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{
+		prog:     &Program{zoneWidth: DefaultZoneWidth},
+		lines:    lines,
+		lineIdx:  indexLines(lines),
+		blocks:   blocks,
+		types:    DeclaredTypes(lines),
+		builtins: BuiltinFuncs(),
+		numSlots: map[string]int{},
+		strSlots: map[string]int{},
+		arrays:   map[string]int{},
+		fns:      map[string]int{},
+	}
+	c.declare()
+	tron := usesTron(c.code)
+	for i, line := range lines {
+		c.stmtPCs = append(c.stmtPCs, make([]int, len(c.code[i])+1))
+		for j, stmt := range c.code[i] {
+			c.stmtPCs[i][j] = len(c.prog.code)
+			c.prog.stmts = append(c.prog.stmts, stmtInfo{pc: len(c.prog.code), line: line.num, stmtIdx: j, kind: StmtKind(stmt)})
+			if tron && j == 0 {
+				c.emit(instr{op: opLine, a: line.num})
+			}
+			err := c.compileStmt(BlockPos{LineIdx: i, StmtIdx: j}, stmt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "in line %d", line.num)
+			}
+		}
+		c.stmtPCs[i][len(c.code[i])] = len(c.prog.code)
+	}
+	c.emit(instr{op: opHalt, a: int(ExitLastLine)})
+	c.resolve()
+	return c.prog, nil
+}
+
+// ErrProcedures is returned by Compile for programs with procedures, which the VM does not run yet
+var ErrProcedures = errors.New("procedures are not supported by the VM")
+
+// usesTron tells, if the code turns on the tracing of line numbers. Only then the starts of lines are marked.
+func usesTron(code [][]Stmt) bool {
+	for _, stmts := range code {
+		for _, stmt := range stmts {
+			if _, ok := stmt.(TRON); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type compiler struct {
+	prog     *Program
+	lines    []Line
+	lineIdx  lineIndex
+	code     [][]Stmt
+	blocks   Blocks
+	types    DefTypes
+	builtins *expr.Funcs
+	numSlots map[string]int
+	strSlots map[string]int
+	arrays   map[string]int
+	fns      map[string]int
+	// params are the slots of the parameters of the DEF FN, whose body is compiled
+	params  map[string]param
+	stmtPCs [][]int
+	fixups  []fixup
+}
+
+// fixup is a jump to a statement, which is resolved when the offsets of all statements are known.
+// Offset skips instructions at the start of the statement.
+type fixup struct {
+	at     int
+	pos    BlockPos
+	offset int
+}
+
+// declare flattens the lines and collects the data, the arrays and the DEF FNs of the program
+func (c *compiler) declare() {
+	c.code = make([][]Stmt, len(c.lines))
+	for i, line := range c.lines {
+		c.code[i] = flatten(line.stmts)
+		for _, stmt := range c.code[i] {
+			switch stmt := stmt.(type) {
+			case DATA:
+				for _, cn := range stmt.Consts {
+					c.prog.data = append(c.prog.data, strings.Trim(cn, `"`))
+				}
+			case DIM:
+				for _, ad := range stmt.Arrays {
+					c.array(ad.Var)
+				}
+			case DEF:
+				if _, ok := c.fns[stmt.Name]; !ok {
+					c.fns[stmt.Name] = len(c.prog.fnNames)
+					c.prog.fnNames = append(c.prog.fnNames, stmt.Name)
+				}
+			}
+		}
+	}
+}
+
+func (c *compiler) emit(in instr) int {
+	c.prog.code = append(c.prog.code, in)
+	return len(c.prog.code) - 1
+}
+
+func (c *compiler) throw(err error) {
+	c.prog.errs = append(c.prog.errs, err)
+	c.emit(instr{op: opThrow, a: len(c.prog.errs) - 1})
+}
+
+func (c *compiler) constant(s string) int {
+	c.prog.consts = append(c.prog.consts, s)
+	return len(c.prog.consts) - 1
+}
+
+// slot returns the slot of the variable name, which is a slot for numbers or strings by its type
+func (c *compiler) slot(name string) (int, expr.Type) {
+	if p, ok := c.params[name]; ok {
+		return p.slot, p.t
+	}
+	t := c.types.TypeOf(name)
+	slots := c.numSlots
+	if t == expr.TypeString {
+		slots = c.strSlots
+	}
+	if slot, ok := slots[name]; ok {
+		return slot, t
+	}
+	slot := c.newSlot(t)
+	slots[name] = slot
+	return slot, t
+}
+
+func (c *compiler) newSlot(t expr.Type) int {
+	if t == expr.TypeString {
+		c.prog.strVars++
+		return c.prog.strVars - 1
+	}
+	c.prog.numVars++
+	return c.prog.numVars - 1
+}
+
+func (c *compiler) array(name string) int {
+	if idx, ok := c.arrays[name]; ok {
+		return idx
+	}
+	c.arrays[name] = len(c.prog.arrays)
+	c.prog.arrays = append(c.prog.arrays, arrayInfo{name: name, t: c.types.TypeOf(name)})
+	return len(c.prog.arrays) - 1
+}
+
+// jumpTo emits a jump to the statement at pos
+func (c *compiler) jumpTo(op opcode, pos BlockPos, offset int) {
+	at := c.emit(instr{op: op})
+	c.fixups = append(c.fixups, fixup{at: at, pos: pos, offset: offset})
+}
+
+func (c *compiler) jumpBehind(op opcode, pos BlockPos) {
+	c.jumpTo(op, BlockPos{LineIdx: pos.LineIdx, StmtIdx: pos.StmtIdx + 1}, 0)
+}
+
+// jumpToLine emits a jump to the line num. A jump to a line, which does not exist, fails when it is taken.
+func (c *compiler) jumpToLine(op opcode, num int) {
+	idx := c.lineIdx.lookup(num)
+	if idx >= 0 {
+		c.jumpTo(op, BlockPos{LineIdx: idx}, 0)
+		return
+	}
+	err := errors.Errorf("no such line %d", num)
+	switch op {
+	case opJumpTrue:
+		skip := c.emit(instr{op: opJumpFalse})
+		c.throw(err)
+		c.prog.code[skip].a = len(c.prog.code)
+	case opJumpFalse:
+		skip := c.emit(instr{op: opJumpTrue})
+		c.throw(err)
+		c.prog.code[skip].a = len(c.prog.code)
+	default:
+		c.throw(err)
+	}
+}
+
+func (c *compiler) pc(pos BlockPos) int {
+	return c.stmtPCs[pos.LineIdx][pos.StmtIdx]
+}
+
+// resolve sets the targets of all jumps
+func (c *compiler) resolve() {
+	for _, fx := range c.fixups {
+		c.prog.code[fx.at].a = c.pc(fx.pos) + fx.offset
+	}
+	for i, on := range c.prog.ons {
+		for j, idx := range on.targets {
+			if idx >= 0 {
+				c.prog.ons[i].targets[j] = c.stmtPCs[idx][0]
+			}
+		}
+	}
+}
+
+func (c *compiler) compileStmt(pos BlockPos, stmt Stmt) error {
+	switch stmt := stmt.(type) {
+	case DATA, DEFTYPE, REM, ENDIF:
+	case DEF:
+		c.compileDef(stmt)
+	case DIM:
+		for _, ad := range stmt.Arrays {
+			for _, d := range ad.Dimensions {
+				c.compileEvalFloat(d)
+			}
+			c.emit(instr{op: opDim, a: c.array(ad.Var), b: len(ad.Dimensions)})
+		}
+	case END:
+		c.emit(instr{op: opHalt, a: int(ExitEnd)})
+	case STOP:
+		c.emit(instr{op: opHalt, a: int(ExitStop)})
+	case TRON:
+		c.emit(instr{op: opTron, a: 1})
+	case TROFF:
+		c.emit(instr{op: opTron, a: 0})
+	case FOR:
+		c.wrap("eval initial", func() { c.compileFloat(stmt.Initial.Tree) })
+		c.wrap("eval to", func() { c.compileFloat(stmt.To.Tree) })
+		c.wrap("eval step", func() { c.compileFloat(stmt.Step.Tree) })
+		slot, t := c.slot(stmt.Var)
+		if t == expr.TypeString {
+			c.throw(expr.ErrTypeMismatch)
+			break
+		}
+		c.emit(instr{op: opFor, a: slot, t: t})
+	case NEXT:
+		if len(stmt.Vars) == 0 {
+			c.emit(instr{op: opNext, a: -1})
+		}
+		for _, name := range stmt.Vars {
+			slot, t := c.slot(name)
+			if t == expr.TypeString {
+				// never looping
+				slot = -2
+			}
+			c.emit(instr{op: opNext, a: slot})
+		}
+	case GOSUB:
+		c.jumpToLine(opGosub, stmt.Line)
+	case GOTO:
+		c.jumpToLine(opJump, stmt.Line)
+	case IFLN:
+		c.compileCond(stmt.Expr)
+		c.jumpToLine(opJumpTrue, stmt.Line)
+	case IFELSELN:
+		c.compileCond(stmt.Expr)
+		c.jumpToLine(opJumpTrue, stmt.Line)
+		c.jumpToLine(opJump, stmt.ElseLine)
+	case condJump:
+		c.compileCond(stmt.expr)
+		c.jumpTo(opJumpFalse, BlockPos{LineIdx: pos.LineIdx, StmtIdx: stmt.to}, 0)
+	case jump:
+		c.jumpTo(opJump, BlockPos{LineIdx: pos.LineIdx, StmtIdx: stmt.to}, 0)
+	case ONGOTO:
+		c.compileOn(stmt.Expr, stmt.Lines, false)
+	case ONGOSUB:
+		c.compileOn(stmt.Expr, stmt.Lines, true)
+	case RETURN:
+		c.emit(instr{op: opReturn})
+	case WHILE:
+		c.compileCond(stmt.Expr)
+		c.jumpBehind(opJumpFalse, c.blocks[pos])
+	case WEND:
+		c.jumpTo(opJump, c.blocks[pos], 0)
+	case DO:
+		if stmt.Cond != nil {
+			c.compileCond(stmt.Cond.Expr)
+			c.jumpBehind(loopExit(stmt.Cond), c.blocks[pos])
+		}
+	case LOOP:
+		if stmt.Cond == nil {
+			c.jumpTo(opJump, c.blocks[pos], 0)
+			break
+		}
+		c.compileCond(stmt.Cond.Expr)
+		op := opJumpTrue
+		if stmt.Cond.Until {
+			op = opJumpFalse
+		}
+		c.jumpTo(op, c.blocks[pos], 0)
+	case EXITDO:
+		c.jumpBehind(opJump, c.blocks[pos])
+	case IFBLOCK:
+		c.compileCond(stmt.Expr)
+		c.jumpToClause(pos)
+	case ELSEIF:
+		// coming from the clause before, the block is done. The condition is tested at the next instruction.
+		c.jumpBehindIf(pos)
+		c.compileCond(stmt.Expr)
+		c.jumpToClause(pos)
+	case ELSE:
+		c.jumpBehindIf(pos)
+	case INPUT:
+		info := inputInfo{prompt: stmt.Prompt()}
+		for _, ref := range stmt.Vars {
+			info.types = append(info.types, c.types.TypeOf(ref))
+		}
+		c.prog.inputs = append(c.prog.inputs, info)
+		c.emit(instr{op: opInput, a: len(c.prog.inputs) - 1})
+		for i, ref := range stmt.Vars {
+			c.emit(instr{op: opInputValue, a: i})
+			c.compileStoreRef(ref, info.types[i])
+		}
+	case LINEINPUT:
+		c.emit(instr{op: opLineInput, a: c.constant(stmt.Msg)})
+		c.compileStoreRef(stmt.Var, expr.TypeString)
+	case LET:
+		c.compileStoreVar(stmt.Var, c.compileEval(stmt.Expr))
+	case ASSIGN:
+		c.compileStoreVar(stmt.Var, c.compileEval(stmt.Expr))
+	case ASSIGN_ARRAY:
+		c.compileStoreElem(stmt.Array, c.compileEval(stmt.Expr))
+	case PRINT:
+		c.compilePrint(stmt)
+	case PRINTUSING:
+		if c.compileEval(stmt.Format) != expr.TypeString {
+			c.throw(expr.ErrTypeMismatch)
+			break
+		}
+		info := usingInfo{newline: true}
+		for _, pi := range stmt.Items {
+			info.newline = false
+			if e, ok := pi.(Expr); ok {
+				info.types = append(info.types, c.compileEval(e))
+				info.newline = true
+			}
+		}
+		c.prog.usings = append(c.prog.usings, info)
+		c.emit(instr{op: opPrintUsing, a: len(c.prog.usings) - 1})
+	case READ:
+		for _, ref := range stmt.Vars {
+			t := c.types.TypeOf(ref)
+			c.emit(instr{op: opRead, a: c.constant(ref), t: t})
+			c.compileStoreRef(ref, t)
+		}
+	case RESTORE:
+		c.emit(instr{op: opRestore})
+	case SUB, FUNCTION, CALL, ENDSUB, ENDFUNCTION, EXITSUB, EXITFUNCTION, SHARED, STATIC:
+		return ErrProcedures
+	default:
+		return errors.Errorf("unsupported statement %s", StmtKind(stmt))
+	}
+	return nil
+}
+
+// loopExit is the jump, which leaves a loop by the condition of its DO
+func loopExit(cond *LoopCond) opcode {
+	if cond.Until {
+		return opJumpTrue
+	}
+	return opJumpFalse
+}
+
+// jumpToClause emits the jump to the next clause of a block IF, if the condition of the clause at pos is false.
+// The conditions of ELSEIFs are tested behind their first instruction, the clause of an ELSE starts there.
+func (c *compiler) jumpToClause(pos BlockPos) {
+	next := c.blocks[pos]
+	switch c.code[next.LineIdx][next.StmtIdx].(type) {
+	case ELSEIF, ELSE:
+		c.jumpTo(opJumpFalse, next, 1)
+	default:
+		c.jumpBehind(opJumpFalse, next)
+	}
+}
+
+func (c *compiler) jumpBehindIf(pos BlockPos) {
+	for {
+		if _, ok := c.code[pos.LineIdx][pos.StmtIdx].(ENDIF); ok {
+			c.jumpBehind(opJump, pos)
+			return
+		}
+		pos = c.blocks[pos]
+	}
+}
+
+func (c *compiler) compileOn(e Expr, lines []int, gosub bool) {
+	c.compileEvalFloat(e)
+	info := onInfo{lines: lines, gosub: gosub}
+	for _, num := range lines {
+		info.targets = append(info.targets, c.lineIdx.lookup(num))
+	}
+	c.prog.ons = append(c.prog.ons, info)
+	c.emit(instr{op: opOn, a: len(c.prog.ons) - 1})
+}
+
+func (c *compiler) compilePrint(stmt PRINT) {
+	newline := true
+	for _, pi := range stmt.Items {
+		newline = false
+		switch pi := pi.(type) {
+		case Expr:
+			c.emit(instr{op: opPrint, t: c.compileEval(pi)})
+			newline = true
+		case PrintComma:
+			c.emit(instr{op: opPrintComma})
+		case PrintTab:
+			c.compileEvalFloat(pi.Expr)
+			c.emit(instr{op: opTab})
+		case PrintSpc:
+			c.compileEvalFloat(pi.Expr)
+			c.emit(instr{op: opSpc})
+		}
+	}
+	if newline {
+		c.emit(instr{op: opPrintln})
+	}
+}
+
+// compileDef compiles the body of a DEF FN right behind the instruction defining it, which jumps over the body
+func (c *compiler) compileDef(stmt DEF) {
+	def := &fnDef{name: stmt.Name, fn: c.fns[stmt.Name]}
+	c.prog.defs = append(c.prog.defs, def)
+	c.emit(instr{op: opDef, a: len(c.prog.defs) - 1})
+	skip := c.emit(instr{op: opJump})
+	def.pc = len(c.prog.code)
+	c.params = map[string]param{}
+	for _, name := range stmt.Params {
+		t := c.types.TypeOf(name)
+		p := param{name: name, slot: c.newSlot(t), t: t}
+		def.params = append(def.params, p)
+		c.params[name] = p
+	}
+	var t expr.Type
+	c.wrap(stmt.Name, func() { t = c.compileExpr(stmt.Expr.Tree) })
+	c.compileConvert(t, c.types.TypeOf(stmt.Name))
+	c.params = nil
+	c.emit(instr{op: opRet})
+	c.prog.code[skip].a = len(c.prog.code)
+}
+
+// compileExpr compiles the evaluation of an expression and returns the type of its value
+func (c *compiler) compileExpr(ev expr.Evaler) expr.Type {
+	switch ev := ev.(type) {
+	case expr.NumberEvaler[int16]:
+		c.emit(instr{op: opPushNum, f: float64(ev.V)})
+		return expr.TypeInteger
+	case expr.NumberEvaler[float32]:
+		c.emit(instr{op: opPushNum, f: float64(ev.V)})
+		return expr.TypeSingle
+	case expr.NumberEvaler[float64]:
+		c.emit(instr{op: opPushNum, f: ev.V})
+		return expr.TypeDouble
+	case expr.StringEvaler:
+		c.emit(instr{op: opPushStr, a: c.constant(string(ev))})
+		return expr.TypeString
+	case expr.VarEvaler:
+		slot, t := c.slot(string(ev))
+		if t == expr.TypeString {
+			c.emit(instr{op: opLoadStr, a: slot})
+		} else {
+			c.emit(instr{op: opLoadNum, a: slot})
+		}
+		return t
+	case expr.UnaryEvaler:
+		xt := c.compileExpr(ev.X)
+		t, err := expr.UnaryResultType(ev.Op, xt)
+		if err != nil {
+			c.throw(err)
+			return expr.TypeSingle
+		}
+		if ev.Op == expr.OpNOT {
+			c.emit(instr{op: opNot})
+		} else {
+			c.emit(instr{op: opNeg, t: t})
+		}
+		return t
+	case expr.BinaryEvaler:
+		return c.compileBinary(ev)
+	case expr.FuncEvaler:
+		return c.compileFunc(ev)
+	default:
+		c.throw(errors.Errorf("%s used as a value", ev.String()))
+		return expr.TypeSingle
+	}
+}
+
+var relationalOps = map[expr.Op]opcode{
+	expr.OpEq:    opEq,
+	expr.OpNotEq: opNotEq,
+	expr.OpLs:    opLs,
+	expr.OpGt:    opGt,
+	expr.OpLsEq:  opLsEq,
+	expr.OpGtEq:  opGtEq,
+}
+
+var arithOpcodes = map[expr.Op]opcode{
+	expr.OpPlus:  opAdd,
+	expr.OpMinus: opSub,
+	expr.OpTimes: opMul,
+	expr.OpDiv:   opDiv,
+}
+
+func (c *compiler) compileBinary(ev expr.BinaryEvaler) expr.Type {
+	xt := c.compileExpr(ev.X)
+	yt := c.compileExpr(ev.Y)
+	t, err := expr.ResultType(ev.Op, xt, yt)
+	if err != nil {
+		c.throw(err)
+		return expr.TypeSingle
+	}
+	rel, isRel := relationalOps[ev.Op]
+	switch {
+	case xt == expr.TypeString && isRel:
+		c.emit(instr{op: opCompareStr, b: int(rel)})
+	case xt == expr.TypeString:
+		c.emit(instr{op: opConcat})
+	case isRel:
+		c.emit(instr{op: rel})
+	default:
+		if op, ok := arithOpcodes[ev.Op]; ok {
+			c.emit(instr{op: op, t: t})
+			break
+		}
+		for i, op := range arithOps {
+			if op == ev.Op {
+				c.emit(instr{op: opArith, a: i, t: t})
+			}
+		}
+	}
+	return t
+}
+
+// compileFunc compiles the read of an array element or a call. Like in the interpreter arrays hide
+// builtin functions of the same name.
+func (c *compiler) compileFunc(ev expr.FuncEvaler) expr.Type {
+	if idx, ok := c.arrays[ev.Name]; ok {
+		for _, arg := range ev.Args {
+			c.compileFloat(arg)
+		}
+		c.emit(instr{op: opLoadElem, a: idx, b: len(ev.Args)})
+		return c.prog.arrays[idx].t
+	}
+	ci := callInfo{name: ev.Name}
+	for _, arg := range ev.Args {
+		ci.argTypes = append(ci.argTypes, c.compileExpr(arg))
+	}
+	if fn, ok := c.fns[ev.Name]; ok {
+		ci.fn = fn
+		c.prog.calls = append(c.prog.calls, ci)
+		c.emit(instr{op: opCallFn, a: len(c.prog.calls) - 1})
+		return c.types.TypeOf(ev.Name)
+	}
+	if !c.builtins.Contains(ev.Name) {
+		c.throw(errors.Errorf("no such func %q", ev.Name))
+		return expr.TypeSingle
+	}
+	c.prog.calls = append(c.prog.calls, ci)
+	c.emit(instr{op: opCall, a: len(c.prog.calls) - 1})
+	return FuncType(ev.Name, ci.argTypes)
+}
+
+// compileFloat compiles an expression, whose value is used as a number
+func (c *compiler) compileFloat(ev expr.Evaler) {
+	if c.compileExpr(ev) == expr.TypeString {
+		c.throw(fmt.Errorf("cannot convert value of type string to type float64"))
+	}
+}
+
+// compileCond compiles a condition. Like in the interpreter strings are false.
+func (c *compiler) compileCond(e Expr) {
+	if c.compileEval(e) == expr.TypeString {
+		c.emit(instr{op: opStrCond})
+	}
+}
+
+// wrap compiles the instructions emitted by compile so that their errors are wrapped with msg, like the
+// interpreter wraps the errors of evaluating expressions
+func (c *compiler) wrap(msg string, compile func()) {
+	start := len(c.prog.code)
+	compile()
+	c.prog.wraps = append(c.prog.wraps, wrapSpan{start: start, end: len(c.prog.code), msg: msg})
+}
+
+// compileEval compiles the evaluation of the expression of a statement
+func (c *compiler) compileEval(e Expr) expr.Type {
+	var t expr.Type
+	c.wrap(fmt.Sprintf("eval %q", e.Raw), func() { t = c.compileExpr(e.Tree) })
+	return t
+}
+
+func (c *compiler) compileEvalFloat(e Expr) {
+	c.wrap(fmt.Sprintf("eval-float %q", e.Raw), func() { c.compileFloat(e.Tree) })
+}
+
+// compileConvert converts a value of type from to type to, like an assignment does
+func (c *compiler) compileConvert(from, to expr.Type) {
+	switch {
+	case (from == expr.TypeString) != (to == expr.TypeString):
+		c.throw(expr.ErrTypeMismatch)
+	case to != expr.TypeString && to != from:
+		c.emit(instr{op: opNarrow, t: to})
+	}
+}
+
+func (c *compiler) compileStoreVar(name string, t expr.Type) {
+	slot, vt := c.slot(name)
+	switch {
+	case (t == expr.TypeString) != (vt == expr.TypeString):
+		c.throw(expr.ErrTypeMismatch)
+	case vt == expr.TypeString:
+		c.emit(instr{op: opStoreStr, a: slot})
+	default:
+		c.emit(instr{op: opStoreNum, a: slot, t: vt})
+	}
+}
+
+// compileStoreElem stores a value of type t into an array element. The indexes are evaluated after the value.
+func (c *compiler) compileStoreElem(ad ArrayDef, t expr.Type) {
+	idx := c.array(ad.Var)
+	for _, d := range ad.Dimensions {
+		c.compileEvalFloat(d)
+	}
+	if (t == expr.TypeString) != (c.prog.arrays[idx].t == expr.TypeString) {
+		c.throw(expr.ErrTypeMismatch)
+		return
+	}
+	c.emit(instr{op: opStoreElem, a: idx, b: len(ad.Dimensions)})
+}
+
+// compileStoreRef stores a value of type t into a variable or an array element, like READ and INPUT reference them
+func (c *compiler) compileStoreRef(ref string, t expr.Type) {
+	if isArray(ref) {
+		c.compileStoreElem(mustParseArray(ref), t)
+		return
+	}
+	c.compileStoreVar(ref, t)
+}
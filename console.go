@@ -0,0 +1,171 @@
+package gobas
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// Console is the terminal, PRINT writes to and INPUT reads from. Transpiled programs use it as well,
+// so they behave like the interpreter. It tracks the column of the cursor for print zones and TAB.
+type Console struct {
+	in        *bufio.Reader
+	out       io.Writer
+	col       int
+	zoneWidth int
+	width     int
+}
+
+const (
+	DefaultZoneWidth = 14
+	DefaultWidth     = 80
+)
+
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	return &Console{
+		in:        bufio.NewReader(in),
+		out:       out,
+		zoneWidth: DefaultZoneWidth,
+		width:     DefaultWidth,
+	}
+}
+
+// SetZoneWidth sets the width of the print zones, a comma in PRINT advances to. The C64 has zones of 10 columns.
+func (c *Console) SetZoneWidth(n int) {
+	c.zoneWidth = n
+}
+
+func (c *Console) write(s string) {
+	io.WriteString(c.out, s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		c.col = len(s) - i - 1
+	} else {
+		c.col += len(s)
+	}
+}
+
+// Print prints a string or a number. Numbers are followed by a space and positive numbers have a leading space.
+func (c *Console) Print(v interface{}) {
+	if str, ok := v.(string); ok {
+		c.write(str)
+		return
+	}
+	c.write(FormatNumber(v) + " ")
+}
+
+// PrintComma advances to the next print zone. If there is no complete zone left in the line, it starts a new line.
+func (c *Console) PrintComma() {
+	next := (c.col/c.zoneWidth + 1) * c.zoneWidth
+	if next+c.zoneWidth > c.width {
+		c.Println()
+		return
+	}
+	c.write(strings.Repeat(" ", next-c.col))
+}
+
+// Tab moves the cursor to the 1-based column n. If the cursor is already beyond, it moves there in the next line.
+func (c *Console) Tab(n int) {
+	n--
+	if n < 0 {
+		n = 0
+	}
+	if c.col > n {
+		c.Println()
+	}
+	c.write(strings.Repeat(" ", n-c.col))
+}
+
+func (c *Console) Spc(n int) {
+	if n > 0 {
+		c.write(strings.Repeat(" ", n))
+	}
+}
+
+func (c *Console) Println() {
+	c.write("\n")
+}
+
+// Input writes prompt and reads a line with a comma separated value for each of types, like INPUT in Microsoft BASIC.
+// Strings may be quoted to contain commas. If values are missing, it prompts with "??" for more. On an invalid
+// number it prints "?Redo from start" and reads all values again. Values beyond types are ignored with a message.
+// At the end of the input it returns io.EOF.
+func (c *Console) Input(prompt string, types []expr.Type) ([]interface{}, error) {
+	c.write(prompt)
+	vs := make([]interface{}, 0, len(types))
+	for len(vs) < len(types) {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		fields := []string{""}
+		if line != "" {
+			fields = splitOutsideQuotes(line, ',')
+		}
+		rest := types[len(vs):]
+		extra := len(fields) > len(rest)
+		if extra {
+			fields = fields[:len(rest)]
+		}
+		ok := true
+		for i, field := range fields {
+			v, err := parseInputField(field, rest[i])
+			if err != nil {
+				ok = false
+				break
+			}
+			vs = append(vs, v)
+		}
+		switch {
+		case !ok:
+			c.write("?Redo from start\n" + prompt)
+			vs = vs[:0]
+		case extra:
+			c.write("?Extra ignored\n")
+		case len(vs) < len(types):
+			c.write("?? ")
+		}
+	}
+	return vs, nil
+}
+
+// LineInput writes prompt and reads a whole line, like LINE INPUT. At the end of the input it returns io.EOF.
+func (c *Console) LineInput(prompt string) (string, error) {
+	c.write(prompt)
+	return c.readLine()
+}
+
+func (c *Console) readLine() (string, error) {
+	line, err := c.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	// the input is echoed by the terminal including the newline
+	c.col = 0
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseInputField converts a field of an input line to type t. Strings may be quoted, empty numbers are 0.
+func parseInputField(field string, t expr.Type) (interface{}, error) {
+	field = strings.Trim(field, " \t")
+	if t == expr.TypeString {
+		if !strings.HasPrefix(field, `"`) {
+			return field, nil
+		}
+		str, rest, found := strings.Cut(field[1:], `"`)
+		if found && strings.Trim(rest, " \t") != "" {
+			return nil, errors.Errorf("invalid string %s", field)
+		}
+		return str, nil
+	}
+	if field == "" {
+		return t.Zero(), nil
+	}
+	f, err := expr.ParseFloat(field)
+	if err != nil {
+		return nil, err
+	}
+	return t.Number(f)
+}
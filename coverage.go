@@ -0,0 +1,304 @@
+package gobas
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Coverage records how often the lines of programs and the branches of their single-line IFs executed.
+// The coverage of several runs, even of different programs, merges into one.
+type Coverage struct {
+	counts map[CoverBlock]int64
+}
+
+type CoverKind string
+
+const (
+	CoverLine CoverKind = "line"
+	// CoverThen is the branch of an IF taken, when its condition is true
+	CoverThen CoverKind = "then"
+	// CoverElse is the branch of an IF taken, when its condition is false. Without an ELSE it is the next statement.
+	CoverElse CoverKind = "else"
+)
+
+// CoverBlock is a line or an IF branch of a program. StmtIdx addresses the IF in the line, it is 0 for lines.
+type CoverBlock struct {
+	File       string
+	SourceLine int
+	Line       int
+	StmtIdx    int
+	Kind       CoverKind
+}
+
+// CoverSummary counts the lines and branches of a file and how many of them executed
+type CoverSummary struct {
+	Lines           int
+	CoveredLines    int
+	Branches        int
+	CoveredBranches int
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{counts: map[CoverBlock]int64{}}
+}
+
+type coverRun struct {
+	c    *Coverage
+	file string
+}
+
+// SetCoverage records the coverage of the next runs in c. All executable lines and IF branches of the program
+// are added, so the ones which never execute are reported. file names the source of the program.
+// A nil c turns coverage off.
+func (s *State) SetCoverage(c *Coverage, file string) {
+	if c == nil {
+		s.cover = nil
+		return
+	}
+	s.cover = &coverRun{c: c, file: file}
+	for _, line := range s.lines {
+		if !coverable(line) {
+			continue
+		}
+		c.add(s.cover.block(line, 0, CoverLine), 0)
+		for j, stmt := range line.code {
+			if isBranch(stmt) {
+				c.add(s.cover.block(line, j, CoverThen), 0)
+				c.add(s.cover.block(line, j, CoverElse), 0)
+			}
+		}
+	}
+}
+
+// coverable reports if a line has statements, which do something, when they are executed
+func coverable(line Line) bool {
+	for _, stmt := range line.code {
+		switch stmt.(type) {
+		case REM, DATA:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func isBranch(stmt Stmt) bool {
+	switch stmt.(type) {
+	case IFLN, IFELSELN, condJump:
+		return true
+	}
+	return false
+}
+
+func (r *coverRun) block(line Line, stmtIdx int, kind CoverKind) CoverBlock {
+	return CoverBlock{File: r.file, SourceLine: line.SourceLine(), Line: line.num, StmtIdx: stmtIdx, Kind: kind}
+}
+
+func (s *State) coverLine() {
+	if s.cover == nil || s.currIdx >= len(s.lines) || !coverable(s.lines[s.currIdx]) {
+		return
+	}
+	s.cover.c.add(s.cover.block(s.lines[s.currIdx], 0, CoverLine), 1)
+}
+
+func (s *State) coverBranch(then bool) {
+	if s.cover == nil || s.currIdx >= len(s.lines) {
+		return
+	}
+	kind := CoverElse
+	if then {
+		kind = CoverThen
+	}
+	s.cover.c.add(s.cover.block(s.lines[s.currIdx], s.stmtIdx, kind), 1)
+}
+
+func (c *Coverage) add(b CoverBlock, n int64) {
+	c.counts[b] += n
+}
+
+// Merge adds the counts of o to c
+func (c *Coverage) Merge(o *Coverage) {
+	for b, n := range o.counts {
+		c.add(b, n)
+	}
+}
+
+// Count returns how often b executed
+func (c *Coverage) Count(b CoverBlock) int64 {
+	return c.counts[b]
+}
+
+// Blocks returns the blocks ordered by file, line, statement and kind
+func (c *Coverage) Blocks() []CoverBlock {
+	bs := make([]CoverBlock, 0, len(c.counts))
+	for b := range c.counts {
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		bi, bj := bs[i], bs[j]
+		switch {
+		case bi.File != bj.File:
+			return bi.File < bj.File
+		case bi.SourceLine != bj.SourceLine:
+			return bi.SourceLine < bj.SourceLine
+		case bi.Line != bj.Line:
+			return bi.Line < bj.Line
+		case bi.StmtIdx != bj.StmtIdx:
+			return bi.StmtIdx < bj.StmtIdx
+		default:
+			return kindOrder(bi.Kind) < kindOrder(bj.Kind)
+		}
+	})
+	return bs
+}
+
+func kindOrder(k CoverKind) int {
+	switch k {
+	case CoverLine:
+		return 0
+	case CoverThen:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Files returns the sorted names of the covered files
+func (c *Coverage) Files() []string {
+	var files []string
+	for _, b := range c.Blocks() {
+		if len(files) == 0 || files[len(files)-1] != b.File {
+			files = append(files, b.File)
+		}
+	}
+	return files
+}
+
+func (c *Coverage) Summary(file string) CoverSummary {
+	var sum CoverSummary
+	for b, n := range c.counts {
+		if b.File != file {
+			continue
+		}
+		if b.Kind == CoverLine {
+			sum.Lines++
+			if n > 0 {
+				sum.CoveredLines++
+			}
+			continue
+		}
+		sum.Branches++
+		if n > 0 {
+			sum.CoveredBranches++
+		}
+	}
+	return sum
+}
+
+func (sum CoverSummary) String() string {
+	return fmt.Sprintf("%s of %d lines, %s of %d branches",
+		percent(sum.CoveredLines, sum.Lines), sum.Lines, percent(sum.CoveredBranches, sum.Branches), sum.Branches)
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+const coverMode = "mode: count"
+
+// WriteProfile writes the coverage as text with a line like `prog.bas:12 120.1 then 3` per block, which is
+// the file and source line, the line number and statement index, the kind and the count.
+func (c *Coverage) WriteProfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, coverMode)
+	for _, b := range c.Blocks() {
+		fmt.Fprintf(bw, "%s:%d %d.%d %s %d\n", b.File, b.SourceLine, b.Line, b.StmtIdx, b.Kind, c.counts[b])
+	}
+	return bw.Flush()
+}
+
+// ReadCoverage reads a profile written by WriteProfile
+func ReadCoverage(r io.Reader) (*Coverage, error) {
+	c := NewCoverage()
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		text := scanner.Text()
+		if n == 1 {
+			if text != coverMode {
+				return nil, errors.Errorf("line 1: want %q, got %q", coverMode, text)
+			}
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		b, count, err := parseCoverBlock(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", n)
+		}
+		c.add(b, count)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("empty coverage profile")
+	}
+	return c, nil
+}
+
+func parseCoverBlock(text string) (CoverBlock, int64, error) {
+	// the file name may contain spaces, so the fields are split off from the end
+	var fields [3]string
+	rest := text
+	for i := len(fields) - 1; i >= 0; i-- {
+		at := strings.LastIndexByte(rest, ' ')
+		if at < 0 {
+			return CoverBlock{}, 0, errors.Errorf("invalid block %q", text)
+		}
+		fields[i], rest = rest[at+1:], rest[:at]
+	}
+	var b CoverBlock
+	at := strings.LastIndexByte(rest, ':')
+	if at < 0 {
+		return CoverBlock{}, 0, errors.Errorf("invalid position %q", rest)
+	}
+	b.File = rest[:at]
+	srcLine, err := strconv.Atoi(rest[at+1:])
+	if err != nil {
+		return CoverBlock{}, 0, errors.Errorf("invalid source line %q", rest[at+1:])
+	}
+	b.SourceLine = srcLine
+	lineStr, stmtStr, ok := strings.Cut(fields[0], ".")
+	if !ok {
+		return CoverBlock{}, 0, errors.Errorf("invalid statement %q", fields[0])
+	}
+	if b.Line, err = strconv.Atoi(lineStr); err != nil {
+		return CoverBlock{}, 0, errors.Errorf("invalid line number %q", lineStr)
+	}
+	if b.StmtIdx, err = strconv.Atoi(stmtStr); err != nil {
+		return CoverBlock{}, 0, errors.Errorf("invalid statement index %q", stmtStr)
+	}
+	b.Kind = CoverKind(fields[1])
+	switch b.Kind {
+	case CoverLine, CoverThen, CoverElse:
+	default:
+		return CoverBlock{}, 0, errors.Errorf("invalid kind %q", fields[1])
+	}
+	count, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return CoverBlock{}, 0, errors.Errorf("invalid count %q", fields[2])
+	}
+	return b, count, nil
+}
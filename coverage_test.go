@@ -0,0 +1,135 @@
+package gobas
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const coverProgram = `10 REM the sign of X
+20 INPUT X
+30 GOSUB 100
+40 PRINT S
+50 END
+100 IF X < 0 THEN S = -1 ELSE S = 1
+110 IF X = 0 THEN S = 0: IF X > 1 THEN 130
+120 RETURN
+130 PRINT "never": RETURN`
+
+func runCovered(t *testing.T, input string) *Coverage {
+	state, err := NewParser().Parse(strings.NewReader(coverProgram))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	state.SetStreams(Streams{Stdin: strings.NewReader(input), Stdout: &bytes.Buffer{}})
+	cov := NewCoverage()
+	state.SetCoverage(cov, "sign.bas")
+	if _, err := state.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return cov
+}
+
+func TestCoverage(t *testing.T) {
+	tests := []struct {
+		input string
+		want  CoverSummary
+	}{
+		{"0\n", CoverSummary{Lines: 8, CoveredLines: 7, Branches: 6, CoveredBranches: 3}},
+		{"-3\n", CoverSummary{Lines: 8, CoveredLines: 7, Branches: 6, CoveredBranches: 2}},
+	}
+	merged := NewCoverage()
+	for _, test := range tests {
+		cov := runCovered(t, test.input)
+		if sum := cov.Summary("sign.bas"); sum != test.want {
+			t.Fatalf("input %q: want %+v, got %+v", test.input, test.want, sum)
+		}
+		merged.Merge(cov)
+	}
+	want := CoverSummary{Lines: 8, CoveredLines: 7, Branches: 6, CoveredBranches: 5}
+	if sum := merged.Summary("sign.bas"); sum != want {
+		t.Fatalf("merged: want %+v, got %+v", want, sum)
+	}
+	if n := merged.Count(CoverBlock{File: "sign.bas", SourceLine: 6, Line: 100, Kind: CoverThen}); n != 1 {
+		t.Fatalf("want THEN of line 100 once, got %d", n)
+	}
+	if n := merged.Count(CoverBlock{File: "sign.bas", SourceLine: 7, Line: 110, StmtIdx: 2, Kind: CoverElse}); n != 1 {
+		t.Fatalf("want the second IF of line 110 false once, got %d", n)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := merged.WriteProfile(buf); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	if !strings.Contains(buf.String(), "sign.bas:6 100.0 then 1\n") {
+		t.Fatalf("want the THEN of line 100 in the profile, got\n%s", buf.String())
+	}
+	read, err := ReadCoverage(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("read profile: %v", err)
+	}
+	read.Merge(merged)
+	if n := read.Count(CoverBlock{File: "sign.bas", SourceLine: 2, Line: 20, Kind: CoverLine}); n != 4 {
+		t.Fatalf("want line 20 4 times, got %d", n)
+	}
+}
+
+func TestReadCoverageErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"sign.bas:2 20.0 line 1\n",
+		"mode: count\nsign.bas 20.0 line 1\n",
+		"mode: count\nsign.bas:2 20 line 1\n",
+		"mode: count\nsign.bas:2 20.0 loop 1\n",
+		"mode: count\nsign.bas:2 20.0 line x\n",
+	}
+	for _, test := range tests {
+		if _, err := ReadCoverage(strings.NewReader(test)); err == nil {
+			t.Fatalf("%q: want an error", test)
+		}
+	}
+	cov, err := ReadCoverage(strings.NewReader("mode: count\nmy lib.bas:2 20.0 line 1\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if files := cov.Files(); len(files) != 1 || files[0] != "my lib.bas" {
+		t.Fatalf("want the file with a space, got %v", files)
+	}
+}
+
+func TestCoverageReport(t *testing.T) {
+	cov := runCovered(t, "0\n")
+	src := func(file string) ([]byte, error) {
+		return []byte(coverProgram), nil
+	}
+	buf := &bytes.Buffer{}
+	if err := cov.WriteText(buf, src); err != nil {
+		t.Fatalf("write text: %v", err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	wants := map[int]string{
+		0:  "sign.bas: 87.5% of 8 lines, 50.0% of 6 branches",
+		2:  "                     10 REM the sign of X",
+		7:  "?       1  0/1       100 IF X < 0 THEN S = -1 ELSE S = 1",
+		8:  "?       1  1/0 0/1   110 IF X = 0 THEN S = 0: IF X > 1 THEN 130",
+		10: `!       0            130 PRINT "never": RETURN`,
+	}
+	for i, want := range wants {
+		if lines[i] != want {
+			t.Fatalf("line %d: want %q, got %q", i, want, lines[i])
+		}
+	}
+
+	buf.Reset()
+	if err := cov.WriteHTML(buf, src); err != nil {
+		t.Fatalf("write html: %v", err)
+	}
+	for _, want := range []string{
+		`<span class="partial" title="executed 1 times, then/else 0/1">100 IF X &lt; 0 THEN S = -1 ELSE S = 1</span>`,
+		`<span class="uncovered" title="executed 0 times">130 PRINT &#34;never&#34;: RETURN</span>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("want %q in\n%s", want, buf.String())
+		}
+	}
+}
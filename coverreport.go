@@ -0,0 +1,169 @@
+package gobas
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// coverLine is the coverage of a source line
+type coverLine struct {
+	executable bool
+	count      int64
+	// branches are the then and else counts of the IFs in the line
+	branches [][2]int64
+}
+
+func (cl coverLine) status() string {
+	switch {
+	case !cl.executable:
+		return "none"
+	case cl.count == 0:
+		return "uncovered"
+	}
+	for _, br := range cl.branches {
+		if br[0] == 0 || br[1] == 0 {
+			return "partial"
+		}
+	}
+	return "covered"
+}
+
+func (cl coverLine) branchText() string {
+	sl := make([]string, len(cl.branches))
+	for i, br := range cl.branches {
+		sl[i] = fmt.Sprintf("%d/%d", br[0], br[1])
+	}
+	return strings.Join(sl, " ")
+}
+
+// sourceLines returns the coverage of the source lines of file by their 1-based number
+func (c *Coverage) sourceLines(file string) map[int]*coverLine {
+	lines := map[int]*coverLine{}
+	branchIdx := map[[2]int]int{}
+	for _, b := range c.Blocks() {
+		if b.File != file {
+			continue
+		}
+		cl, ok := lines[b.SourceLine]
+		if !ok {
+			cl = &coverLine{}
+			lines[b.SourceLine] = cl
+		}
+		n := c.counts[b]
+		if b.Kind == CoverLine {
+			cl.executable = true
+			cl.count += n
+			continue
+		}
+		key := [2]int{b.Line, b.StmtIdx}
+		i, ok := branchIdx[key]
+		if !ok {
+			i = len(cl.branches)
+			branchIdx[key] = i
+			cl.branches = append(cl.branches, [2]int64{})
+		}
+		if b.Kind == CoverThen {
+			cl.branches[i][0] += n
+		} else {
+			cl.branches[i][1] += n
+		}
+	}
+	return lines
+}
+
+func readSource(file string, src func(file string) ([]byte, error)) ([]string, error) {
+	data, err := src(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read source %q", file)
+	}
+	return strings.Split(strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n"), nil
+}
+
+var coverMarks = map[string]string{"none": " ", "covered": " ", "partial": "?", "uncovered": "!"}
+
+// WriteText writes the source of the covered files read by src. Each line is annotated with how often it
+// executed and the then/else counts of its IFs. Lines, which never executed, are marked with ! and lines
+// with an IF branch never taken with ?.
+func (c *Coverage) WriteText(w io.Writer, src func(file string) ([]byte, error)) error {
+	bw := bufio.NewWriter(w)
+	for i, file := range c.Files() {
+		source, err := readSource(file, src)
+		if err != nil {
+			return err
+		}
+		lines := c.sourceLines(file)
+		width := len("BRANCHES")
+		for _, cl := range lines {
+			if n := len(cl.branchText()); n > width {
+				width = n
+			}
+		}
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "%s: %s\n", file, c.Summary(file))
+		fmt.Fprintf(bw, "  %7s  %-*s  %s\n", "COUNT", width, "BRANCHES", "SOURCE")
+		for j, text := range source {
+			cl, ok := lines[j+1]
+			if !ok || !cl.executable {
+				fmt.Fprintf(bw, "  %7s  %-*s  %s\n", "", width, "", text)
+				continue
+			}
+			fmt.Fprintf(bw, "%s %7d  %-*s  %s\n", coverMarks[cl.status()], cl.count, width, cl.branchText(), text)
+		}
+	}
+	return bw.Flush()
+}
+
+const coverHTMLHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gobas coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.covered { background: #c8f0c8; }
+.partial { background: #f8eca0; }
+.uncovered { background: #f8c0c0; }
+.count { color: #888; }
+</style>
+</head>
+<body>
+`
+
+// WriteHTML writes the source of the covered files read by src as HTML page. Executed lines are green, lines
+// with an IF branch never taken are yellow and lines, which never executed, are red.
+func (c *Coverage) WriteHTML(w io.Writer, src func(file string) ([]byte, error)) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(coverHTMLHead)
+	for _, file := range c.Files() {
+		source, err := readSource(file, src)
+		if err != nil {
+			return err
+		}
+		lines := c.sourceLines(file)
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<p>%s</p>\n<pre>\n", html.EscapeString(file), html.EscapeString(c.Summary(file).String()))
+		for j, text := range source {
+			cl, ok := lines[j+1]
+			if !ok || !cl.executable {
+				fmt.Fprintf(bw, "<span class=\"count\">%7s</span> %s\n", "", html.EscapeString(text))
+				continue
+			}
+			title := fmt.Sprintf("executed %d times", cl.count)
+			if len(cl.branches) > 0 {
+				title += ", then/else " + cl.branchText()
+			}
+			fmt.Fprintf(bw, "<span class=\"count\">%7d</span> <span class=\"%s\" title=\"%s\">%s</span>\n",
+				cl.count, cl.status(), title, html.EscapeString(text))
+		}
+		bw.WriteString("</pre>\n")
+	}
+	bw.WriteString("</body>\n</html>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,503 @@
+// Package dap implements a server for the Debug Adapter Protocol, which lets editors debug BASIC programs.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mazzegi/gobas"
+	"github.com/mazzegi/gobas/debug"
+	"github.com/pkg/errors"
+)
+
+// threadID is the id of the only thread, the program runs in
+const threadID = 1
+
+// The references of the scopes. The arrays are referenced from firstArrayRef on in the order of State.Arrays.
+const (
+	localsRef = iota + 1
+	globalsRef
+	arraysRef
+	firstArrayRef
+)
+
+// Server serves a debug session over a single connection. The program can't read input, INPUT fails with
+// ErrNoInput. Its output is sent as output events.
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	writeMu sync.Mutex
+	seq     int
+
+	program    string
+	state      *gobas.State
+	dbg        *debug.Debugger
+	configured bool
+	started    bool
+	resume     chan debug.Action
+	// resumeWith is the action, the stopped program is resumed with after the response
+	resumeWith *debug.Action
+	done       chan struct{}
+
+	// mu guards the fields below, which are shared with the goroutine running the program
+	mu       sync.Mutex
+	stopped  *debug.Stop
+	quitting bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:     bufio.NewReader(in),
+		out:    out,
+		resume: make(chan debug.Action),
+		done:   make(chan struct{}),
+	}
+}
+
+// Run serves requests until the client disconnects or closes the connection
+func (s *Server) Run() error {
+	defer s.quit()
+	for {
+		data, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return errors.Wrap(err, "decode request")
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.quit()
+			return s.respond(req, nil, nil)
+		}
+		body, err := s.handle(req)
+		if err := s.respond(req, body, err); err != nil {
+			return err
+		}
+		if req.Command == "launch" && err == nil {
+			s.event("initialized", nil)
+		}
+		if s.resumeWith != nil {
+			s.resume <- *s.resumeWith
+			s.resumeWith = nil
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch":
+		var args launchArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args)
+	case "configurationDone":
+		s.configured = true
+		s.start()
+		return nil, nil
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		return s.stackTrace()
+	case "scopes":
+		return s.scopes()
+	case "variables":
+		var args variablesArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args.VariablesReference)
+	case "evaluate":
+		var args evaluateArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args.Expression)
+	case "continue":
+		return continueBody{AllThreadsContinued: true}, s.continueWith(debug.Continue)
+	case "next":
+		return nil, s.continueWith(debug.Next)
+	case "stepIn":
+		return nil, s.continueWith(debug.Step)
+	case "stepOut":
+		return nil, s.continueWith(debug.Out)
+	case "pause":
+		if s.dbg == nil {
+			return nil, errors.New("no program launched")
+		}
+		s.dbg.Pause()
+		return nil, nil
+	case "terminate":
+		s.quit()
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unsupported command %q", req.Command)
+	}
+}
+
+func decode(req request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(req.Arguments, args), "decode arguments of %s", req.Command)
+}
+
+func (s *Server) respond(req request, body interface{}, err error) error {
+	resp := &response{
+		protocolMessage: protocolMessage{Type: "response"},
+		RequestSeq:      req.Seq,
+		Success:         err == nil,
+		Command:         req.Command,
+		Body:            body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	return s.send(resp, &resp.protocolMessage)
+}
+
+// event sends an event. Errors are dropped, as events are also sent from the goroutine running the program.
+func (s *Server) event(name string, body interface{}) {
+	ev := &event{
+		protocolMessage: protocolMessage{Type: "event"},
+		Event:           name,
+		Body:            body,
+	}
+	s.send(ev, &ev.protocolMessage)
+}
+
+func (s *Server) send(msg interface{}, pm *protocolMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	pm.setSeq(s.seq)
+	return writeMessage(s.out, msg)
+}
+
+func (s *Server) launch(args launchArguments) error {
+	if s.state != nil {
+		return errors.New("program is already launched")
+	}
+	if args.Source == "" {
+		args.Source = "auto"
+	}
+	mode, err := gobas.ParseSourceMode(args.Source)
+	if err != nil {
+		return err
+	}
+	p := gobas.NewParser()
+	p.SetSourceMode(mode)
+	state, err := p.ParseFile(args.Program)
+	if err != nil {
+		return err
+	}
+	s.program, err = filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	s.state = state
+	s.state.SetStreams(gobas.Streams{
+		Stdin:  noInput{},
+		Stdout: outputWriter{server: s, category: "stdout"},
+		Stderr: outputWriter{server: s, category: "stderr"},
+	})
+	s.dbg = debug.New(state, s.stop, args.StopOnEntry)
+	s.start()
+	return nil
+}
+
+// start runs the program, once it is launched and configured
+func (s *Server) start() {
+	if s.state == nil || !s.configured || s.started {
+		return
+	}
+	s.started = true
+	go func() {
+		defer close(s.done)
+		code := 0
+		_, err := s.state.Run()
+		switch {
+		case errors.Is(err, debug.ErrQuit):
+		case err != nil:
+			s.event("output", outputBody{Category: "stderr", Output: err.Error() + "\n"})
+			code = 1
+		}
+		s.event("exited", exitedBody{ExitCode: code})
+		s.event("terminated", nil)
+	}()
+}
+
+// quit quits the program and waits for it to end
+func (s *Server) quit() {
+	if !s.started {
+		return
+	}
+	s.mu.Lock()
+	s.quitting = true
+	stopped := s.stopped != nil
+	s.stopped = nil
+	s.mu.Unlock()
+	if stopped {
+		s.resume <- debug.Quit
+	} else {
+		s.dbg.Pause()
+	}
+	<-s.done
+}
+
+// stop is the handler of the debugger. It runs in the goroutine of the program and blocks until the client resumes it.
+func (s *Server) stop(stop debug.Stop) debug.Action {
+	s.mu.Lock()
+	if s.quitting {
+		s.mu.Unlock()
+		return debug.Quit
+	}
+	s.stopped = &stop
+	s.mu.Unlock()
+
+	body := stoppedBody{
+		Reason:            stop.Reason,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	}
+	if stop.Breakpoint != nil {
+		body.HitBreakpointIDs = []int{stop.Breakpoint.ID}
+	}
+	if stop.Err != nil {
+		body.Text = stop.Err.Error()
+	}
+	s.event("stopped", body)
+	return <-s.resume
+}
+
+// continueWith resumes the stopped program with the action, once the response is sent
+func (s *Server) continueWith(action debug.Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped == nil {
+		return errors.New("program is not stopped")
+	}
+	s.stopped = nil
+	s.resumeWith = &action
+	return nil
+}
+
+// whileStopped checks, that the program is stopped and may be inspected
+func (s *Server) whileStopped() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped == nil {
+		return errors.New("program is not stopped")
+	}
+	return nil
+}
+
+// setBreakpoints replaces the breakpoints. The breakpoint of a source line is set to the BASIC line starting
+// in or after it.
+func (s *Server) setBreakpoints(args setBreakpointsArguments) (interface{}, error) {
+	if s.dbg == nil {
+		return nil, errors.New("no program launched")
+	}
+	s.dbg.ClearBreakpoints()
+	body := breakpointsBody{Breakpoints: []breakpoint{}}
+	for _, sbp := range args.Breakpoints {
+		bp := breakpoint{Source: args.Source, Line: sbp.Line}
+		line, ok := s.lineAtSource(sbp.Line)
+		if !ok {
+			bp.Message = "no BASIC line in or after source line " + strconv.Itoa(sbp.Line)
+			body.Breakpoints = append(body.Breakpoints, bp)
+			continue
+		}
+		dbp, err := s.dbg.AddBreakpoint(line.Num(), -1, sbp.Condition)
+		if err != nil {
+			bp.Message = err.Error()
+			body.Breakpoints = append(body.Breakpoints, bp)
+			continue
+		}
+		bp.ID, bp.Verified, bp.Line = dbp.ID, true, line.SourceLine()
+		body.Breakpoints = append(body.Breakpoints, bp)
+	}
+	return body, nil
+}
+
+func (s *Server) lineAtSource(srcLine int) (gobas.Line, bool) {
+	for _, l := range s.state.Lines() {
+		if l.SourceLine() >= srcLine {
+			return l, true
+		}
+	}
+	return gobas.Line{}, false
+}
+
+func (s *Server) lineByNum(num int) (gobas.Line, bool) {
+	for _, l := range s.state.Lines() {
+		if l.Num() == num {
+			return l, true
+		}
+	}
+	return gobas.Line{}, false
+}
+
+// stackTrace returns the current statement followed by the lines of the active GOSUBs, FOR loops and calls,
+// the innermost first. A frame is named by the GOSUB, FOR loop or call it is in.
+func (s *Server) stackTrace() (interface{}, error) {
+	if err := s.whileStopped(); err != nil {
+		return nil, err
+	}
+	src := source{Name: filepath.Base(s.program), Path: s.program}
+	frames := s.state.Frames()
+	name := func(i int) string {
+		if i < 0 {
+			return "main"
+		}
+		return debug.FrameName(frames[i])
+	}
+	loc := s.state.Location()
+	top := stackFrame{ID: 1, Name: name(len(frames) - 1), Source: src, Line: loc.SourceLine, Column: 1}
+	if line, ok := s.lineByNum(loc.Line); ok {
+		top.Column = line.Col(loc.StmtIdx)
+	}
+	sfs := []stackFrame{top}
+	for i := len(frames) - 1; i >= 0; i-- {
+		sfs = append(sfs, stackFrame{
+			ID:     len(sfs) + 1,
+			Name:   name(i - 1),
+			Source: src,
+			Line:   frames[i].SourceLine,
+			Column: 1,
+		})
+	}
+	return stackTraceBody{StackFrames: sfs, TotalFrames: len(sfs)}, nil
+}
+
+// scopes returns the scopes of the current statement. Locals are only there inside of a procedure.
+func (s *Server) scopes() (interface{}, error) {
+	if err := s.whileStopped(); err != nil {
+		return nil, err
+	}
+	var scs []scope
+	for _, f := range s.state.Frames() {
+		if f.Kind == "CALL" {
+			scs = append(scs, scope{Name: "Locals", VariablesReference: localsRef})
+			break
+		}
+	}
+	scs = append(scs,
+		scope{Name: "Globals", VariablesReference: globalsRef},
+		scope{Name: "Arrays", VariablesReference: arraysRef},
+	)
+	return scopesBody{Scopes: scs}, nil
+}
+
+func (s *Server) variables(ref int) (interface{}, error) {
+	if err := s.whileStopped(); err != nil {
+		return nil, err
+	}
+	vs := []variable{}
+	switch ref {
+	case localsRef:
+		vs = appendVars(vs, s.state.Variables())
+	case globalsRef:
+		vs = appendVars(vs, s.state.Globals())
+	case arraysRef:
+		for i, a := range s.state.Arrays() {
+			vs = append(vs, variable{
+				Name:               a.Name,
+				Value:              arrayName(a.Name, a.Dims),
+				VariablesReference: firstArrayRef + i,
+			})
+		}
+	default:
+		arrays := s.state.Arrays()
+		i := ref - firstArrayRef
+		if i < 0 || i >= len(arrays) {
+			return nil, errors.Errorf("invalid variables reference %d", ref)
+		}
+		a := arrays[i]
+		idx := make([]int, len(a.Dims))
+		for _, v := range a.Values {
+			vs = append(vs, variable{Name: arrayName(a.Name, idx), Value: gobas.FormatValue(v)})
+			// advance the index in row-major order
+			for d := len(idx) - 1; d >= 0; d-- {
+				idx[d]++
+				if idx[d] <= a.Dims[d] {
+					break
+				}
+				idx[d] = 0
+			}
+		}
+	}
+	return variablesBody{Variables: vs}, nil
+}
+
+func appendVars(vs []variable, gvs []gobas.Variable) []variable {
+	for _, v := range gvs {
+		vs = append(vs, variable{Name: v.Name, Value: gobas.FormatValue(v.Value)})
+	}
+	return vs
+}
+
+func arrayName(name string, idx []int) string {
+	sl := make([]string, len(idx))
+	for i, n := range idx {
+		sl[i] = strconv.Itoa(n)
+	}
+	return name + "(" + strings.Join(sl, ",") + ")"
+}
+
+func (s *Server) evaluate(src string) (interface{}, error) {
+	if err := s.whileStopped(); err != nil {
+		return nil, err
+	}
+	v, err := s.state.Eval(src)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateBody{Result: gobas.FormatValue(v)}, nil
+}
+
+// ErrNoInput is the error of INPUT and LINE INPUT, as the protocol has no way to read the input of a program
+var ErrNoInput = errors.New("input is not supported by the debug adapter")
+
+type noInput struct{}
+
+func (noInput) Read(p []byte) (int, error) {
+	return 0, ErrNoInput
+}
+
+// outputWriter sends the output of the program as output events
+type outputWriter struct {
+	server   *Server
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.server.event("output", outputBody{Category: w.category, Output: string(p)})
+	return len(p), nil
+}
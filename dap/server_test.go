@@ -0,0 +1,365 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// client is a scripted DAP client. It collects the events, which arrive while it waits for responses.
+type client struct {
+	t      *testing.T
+	msgs   chan []byte
+	w      io.WriteCloser
+	seq    int
+	events []message
+	output string
+	errc   chan error
+}
+
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func startServer(t *testing.T) *client {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	c := &client{t: t, msgs: make(chan []byte, 1000), w: inw, errc: make(chan error, 1)}
+	go func() {
+		c.errc <- NewServer(inr, outw).Run()
+		outw.Close()
+	}()
+	// read the messages in the background, as the pipes block the server, while the client writes
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(outr)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			c.msgs <- data
+		}
+	}()
+	return c
+}
+
+func writeProgram(t *testing.T, src string) string {
+	file := filepath.Join(t.TempDir(), "prog.bas")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("write program: %v", err)
+	}
+	return file
+}
+
+func (c *client) request(cmd string, args interface{}) message {
+	c.t.Helper()
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": cmd}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := writeMessage(c.w, req); err != nil {
+		c.t.Fatalf("send %s: %v", cmd, err)
+	}
+	for {
+		msg := c.read()
+		if msg.Type == "response" {
+			if msg.RequestSeq != c.seq || msg.Command != cmd {
+				c.t.Fatalf("want response to %s (%d), got %s (%d)", cmd, c.seq, msg.Command, msg.RequestSeq)
+			}
+			return msg
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+// success sends a request, which has to succeed, and decodes the body of its response into body
+func (c *client) success(cmd string, args interface{}, body interface{}) {
+	c.t.Helper()
+	resp := c.request(cmd, args)
+	if !resp.Success {
+		c.t.Fatalf("%s: want success, got %q", cmd, resp.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(resp.Body, body); err != nil {
+			c.t.Fatalf("%s: decode body: %v", cmd, err)
+		}
+	}
+}
+
+// event waits for the next event with the name and decodes its body into body
+func (c *client) event(name string, body interface{}) {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Type != "event" || msg.Event != name {
+			continue
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("%s: decode body: %v", name, err)
+			}
+		}
+		return
+	}
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	var data []byte
+	select {
+	case d, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatalf("server closed the connection")
+		}
+		data = d
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timeout waiting for a message")
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("decode message: %v", err)
+	}
+	if msg.Type == "event" && msg.Event == "output" {
+		var ob outputBody
+		json.Unmarshal(msg.Body, &ob)
+		c.output += ob.Output
+	}
+	return msg
+}
+
+func (c *client) disconnect() {
+	c.t.Helper()
+	c.success("disconnect", nil, nil)
+	c.w.Close()
+	select {
+	case err := <-c.errc:
+		if err != nil {
+			c.t.Fatalf("run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("server didn't end")
+	}
+}
+
+func (c *client) stackLines() []int {
+	c.t.Helper()
+	var body stackTraceBody
+	c.success("stackTrace", map[string]int{"threadId": threadID}, &body)
+	var lines []int
+	for _, sf := range body.StackFrames {
+		lines = append(lines, sf.Line)
+	}
+	return lines
+}
+
+func (c *client) variables(ref int) map[string]variable {
+	c.t.Helper()
+	var body variablesBody
+	c.success("variables", map[string]int{"variablesReference": ref}, &body)
+	vs := map[string]variable{}
+	for _, v := range body.Variables {
+		vs[v.Name] = v
+	}
+	return vs
+}
+
+const testProgram = `10 DIM A(2): X=1
+20 FOR I=1 TO 2: A(I)=I*10: NEXT
+
+30 GOSUB 100: PRINT "BACK"
+40 CALL P(X)
+50 END
+100 X=X+1
+110 RETURN
+200 SUB P(N)
+210   N=N*10: PRINT N
+220 END SUB
+`
+
+func TestSession(t *testing.T) {
+	file := writeProgram(t, testProgram)
+	c := startServer(t)
+
+	var caps capabilities
+	c.success("initialize", map[string]string{"adapterID": "gobas"}, &caps)
+	if !caps.SupportsConfigurationDoneRequest || !caps.SupportsConditionalBreakpoints {
+		t.Fatalf("want configurationDone and conditional breakpoints, got %+v", caps)
+	}
+	c.success("launch", launchArguments{Program: file}, nil)
+	c.event("initialized", nil)
+
+	var bps breakpointsBody
+	c.success("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: file},
+		Breakpoints: []sourceBreakpoint{{Line: 3}, {Line: 10, Condition: "N>1"}, {Line: 99}},
+	}, &bps)
+	var verified []string
+	for _, bp := range bps.Breakpoints {
+		verified = append(verified, fmt.Sprintf("%d %t", bp.Line, bp.Verified))
+	}
+	if want := []string{"4 true", "10 true", "99 false"}; !reflect.DeepEqual(verified, want) {
+		t.Fatalf("want breakpoints %+v, got %+v", want, verified)
+	}
+	c.success("configurationDone", nil, nil)
+
+	var stopped stoppedBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || !reflect.DeepEqual(stopped.HitBreakpointIDs, []int{1}) {
+		t.Fatalf("want stop at breakpoint 1, got %+v", stopped)
+	}
+	var threads threadsBody
+	c.success("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != threadID {
+		t.Fatalf("want one thread, got %+v", threads)
+	}
+
+	var scopes scopesBody
+	c.success("scopes", map[string]int{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Globals" || scopes.Scopes[1].Name != "Arrays" {
+		t.Fatalf("want globals and arrays, got %+v", scopes.Scopes)
+	}
+	globals := c.variables(globalsRef)
+	if globals["X"].Value != "1" || globals["I"].Value != "3" {
+		t.Fatalf("want X=1 and I=3, got %+v", globals)
+	}
+	arrays := c.variables(arraysRef)
+	if a := arrays["A"]; a.Value != "A(2)" || a.VariablesReference == 0 {
+		t.Fatalf("want array A(2), got %+v", a)
+	}
+	elems := c.variables(arrays["A"].VariablesReference)
+	if elems["A(1)"].Value != "10" || elems["A(2)"].Value != "20" || len(elems) != 3 {
+		t.Fatalf("want the elements of A, got %+v", elems)
+	}
+
+	var result evaluateBody
+	c.success("evaluate", evaluateArguments{Expression: "X*2+A(2)"}, &result)
+	if result.Result != "22" {
+		t.Fatalf("want 22, got %q", result.Result)
+	}
+	if resp := c.request("evaluate", evaluateArguments{Expression: "X*"}); resp.Success {
+		t.Fatalf("want evaluate to fail")
+	}
+
+	c.success("stepIn", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	if lines := c.stackLines(); stopped.Reason != "step" || !reflect.DeepEqual(lines, []int{7, 4}) {
+		t.Fatalf("want step into GOSUB, got %s at %v", stopped.Reason, lines)
+	}
+	c.success("stepOut", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	if lines := c.stackLines(); !reflect.DeepEqual(lines, []int{4}) {
+		t.Fatalf("want step out to line 30, got %v", lines)
+	}
+
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	if lines := c.stackLines(); stopped.Reason != "breakpoint" || !reflect.DeepEqual(lines, []int{10, 5}) {
+		t.Fatalf("want stop at the conditional breakpoint in P, got %s at %v", stopped.Reason, lines)
+	}
+	c.success("scopes", map[string]int{"frameId": 1}, &scopes)
+	if scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("want locals, got %+v", scopes.Scopes)
+	}
+	if locals := c.variables(localsRef); locals["N"].Value != "2" {
+		t.Fatalf("want N=2, got %+v", locals)
+	}
+	if resp := c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: file},
+		Breakpoints: []sourceBreakpoint{{Line: 4, Condition: "X="}},
+	}); !resp.Success || !json.Valid(resp.Body) {
+		t.Fatalf("want setBreakpoints to succeed, got %q", resp.Message)
+	}
+
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+	var exited exitedBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	if exited.ExitCode != 0 {
+		t.Fatalf("want exit code 0, got %d", exited.ExitCode)
+	}
+	if want := "BACK\n 20 \n"; c.output != want {
+		t.Fatalf("want output %q, got %q", want, c.output)
+	}
+	if resp := c.request("stackTrace", nil); resp.Success {
+		t.Fatalf("want stackTrace to fail after the program ended")
+	}
+	c.disconnect()
+}
+
+func TestPauseAndDisconnect(t *testing.T) {
+	file := writeProgram(t, "10 I=I+1\n20 GOTO 10\n")
+	c := startServer(t)
+	c.success("initialize", nil, nil)
+	c.success("launch", launchArguments{Program: file, StopOnEntry: true}, nil)
+	c.success("configurationDone", nil, nil)
+
+	var stopped stoppedBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != "entry" {
+		t.Fatalf("want stop on entry, got %+v", stopped)
+	}
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+	c.success("pause", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	if stopped.Reason != "pause" {
+		t.Fatalf("want pause, got %+v", stopped)
+	}
+	c.success("continue", map[string]int{"threadId": threadID}, nil)
+	c.disconnect()
+	var exited exitedBody
+	c.event("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Fatalf("want exit code 0 after quitting, got %d", exited.ExitCode)
+	}
+}
+
+func TestInput(t *testing.T) {
+	c := startServer(t)
+	c.success("initialize", nil, nil)
+	c.success("launch", launchArguments{Program: writeProgram(t, "10 PRINT \"NAME\";\n20 INPUT N$\n")}, nil)
+	c.success("configurationDone", nil, nil)
+	var exited exitedBody
+	c.event("exited", &exited)
+	if exited.ExitCode != 1 {
+		t.Fatalf("want exit code 1, got %d", exited.ExitCode)
+	}
+	if want := "NAME? line 20: INPUT: input is not supported by the debug adapter\n"; c.output != want {
+		t.Fatalf("want output %q, got %q", want, c.output)
+	}
+	c.disconnect()
+}
+
+func TestLaunchError(t *testing.T) {
+	c := startServer(t)
+	c.success("initialize", nil, nil)
+	resp := c.request("launch", launchArguments{Program: writeProgram(t, "10 GOTO 20\n")})
+	if resp.Success || resp.Message != "in line 10: undefined line number 20" {
+		t.Fatalf("want launch to fail with the undefined line, got %q", resp.Message)
+	}
+	if resp := c.request("setBreakpoints", setBreakpointsArguments{}); resp.Success {
+		t.Fatalf("want setBreakpoints to fail without a program")
+	}
+	if resp := c.request("foo", nil); resp.Success || resp.Message != `unsupported command "foo"` {
+		t.Fatalf("want unsupported command, got %q", resp.Message)
+	}
+	c.disconnect()
+}
@@ -0,0 +1,274 @@
+package debug
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mazzegi/gobas"
+	"github.com/pkg/errors"
+)
+
+// ErrQuit is the error, a program fails with, if it is quit in the debugger
+var ErrQuit = errors.New("quit by debugger")
+
+// Action tells the debugger how to go on after it stopped
+type Action int
+
+const (
+	// Continue runs to the next breakpoint
+	Continue Action = iota
+	// Step stops at the next statement
+	Step
+	// Next stops at the next statement, which is not in a GOSUB or procedure called by the current one
+	Next
+	// Out stops at the next statement after returning from the current GOSUB or procedure
+	Out
+	// Quit aborts the program with ErrQuit
+	Quit
+)
+
+// Stop reasons
+const (
+	ReasonEntry      = "entry"
+	ReasonBreakpoint = "breakpoint"
+	ReasonStep       = "step"
+	ReasonPause      = "pause"
+)
+
+// Stop describes, where and why the debugger stopped
+type Stop struct {
+	Reason     string
+	Loc        gobas.Location
+	Breakpoint *Breakpoint
+	// Err is set, if the condition of the breakpoint failed to evaluate
+	Err error
+}
+
+// Handler is called, whenever the debugger stops. It may inspect the state and returns, how to go on.
+type Handler func(stop Stop) Action
+
+// Breakpoint stops the program before a statement of a line, or before the first one of the line, if StmtIdx
+// is negative. Returning or looping into the middle of the line doesn't stop at the first one. If it has a condition, it only stops, if the condition is true.
+type Breakpoint struct {
+	ID      int
+	Line    int
+	StmtIdx int
+	Cond    string
+	Hits    int
+}
+
+// Watch is an expression, which is evaluated whenever the debugger stops
+type Watch struct {
+	Expr  string
+	Value interface{}
+	Err   error
+}
+
+// Debugger stops a program at breakpoints and after steps. It hooks into the runtime loop of the state.
+// Breakpoints and watches may be changed and the program may be paused, while it runs.
+type Debugger struct {
+	mu          sync.Mutex
+	state       *gobas.State
+	handler     Handler
+	breakpoints []*Breakpoint
+	nextID      int
+	watches     []string
+	action      Action
+	depth       int
+	started     bool
+	paused      bool
+}
+
+// New creates a debugger for state. It stops before the first statement, if stopOnEntry is set.
+func New(state *gobas.State, handler Handler, stopOnEntry bool) *Debugger {
+	d := &Debugger{
+		state:   state,
+		handler: handler,
+		nextID:  1,
+		action:  Continue,
+	}
+	if stopOnEntry {
+		d.action = Step
+	}
+	state.SetHook(d.hook)
+	return d
+}
+
+func (d *Debugger) State() *gobas.State {
+	return d.state
+}
+
+// AddBreakpoint adds a breakpoint to the line num. The condition is checked to parse.
+func (d *Debugger) AddBreakpoint(num int, stmtIdx int, cond string) (*Breakpoint, error) {
+	if !d.hasLine(num) {
+		return nil, errors.Errorf("no such line %d", num)
+	}
+	if cond != "" {
+		if _, err := gobas.NewParser().ParseExpr(cond); err != nil {
+			return nil, errors.Wrap(err, "condition")
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bp := &Breakpoint{ID: d.nextID, Line: num, StmtIdx: stmtIdx, Cond: cond}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp, nil
+}
+
+func (d *Debugger) hasLine(num int) bool {
+	for _, l := range d.state.Lines() {
+		if l.Num() == num {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = nil
+}
+
+// Breakpoints returns the breakpoints sorted by their lines
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	bps := append([]*Breakpoint{}, d.breakpoints...)
+	d.mu.Unlock()
+	sort.SliceStable(bps, func(i, j int) bool {
+		return bps[i].Line < bps[j].Line
+	})
+	return bps
+}
+
+func (d *Debugger) AddWatch(expr string) error {
+	if _, err := gobas.NewParser().ParseExpr(expr); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.watches = append(d.watches, expr)
+	return nil
+}
+
+// RemoveWatch removes the watch at the 0-based index idx
+func (d *Debugger) RemoveWatch(idx int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if idx < 0 || idx >= len(d.watches) {
+		return false
+	}
+	d.watches = append(d.watches[:idx], d.watches[idx+1:]...)
+	return true
+}
+
+// Watches evaluates the watch expressions
+func (d *Debugger) Watches() []Watch {
+	d.mu.Lock()
+	exprs := append([]string{}, d.watches...)
+	d.mu.Unlock()
+	ws := make([]Watch, len(exprs))
+	for i, ex := range exprs {
+		v, err := d.state.Eval(ex)
+		ws[i] = Watch{Expr: ex, Value: v, Err: err}
+	}
+	return ws
+}
+
+// Pause stops the running program before its next statement
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = true
+}
+
+func (d *Debugger) hook(loc gobas.Location) error {
+	stop, ok := d.check(loc)
+	d.started = true
+	if !ok {
+		return nil
+	}
+	d.action = d.handler(stop)
+	d.depth = d.state.Depth()
+	if d.action == Quit {
+		return ErrQuit
+	}
+	return nil
+}
+
+// check tells, if the debugger stops at loc
+func (d *Debugger) check(loc gobas.Location) (Stop, bool) {
+	d.mu.Lock()
+	if d.paused {
+		d.paused = false
+		d.mu.Unlock()
+		return Stop{Reason: ReasonPause, Loc: loc}, true
+	}
+	var bps []*Breakpoint
+	for _, bp := range d.breakpoints {
+		stmtIdx := bp.StmtIdx
+		if stmtIdx < 0 {
+			stmtIdx = 0
+		}
+		if bp.Line == loc.Line && stmtIdx == loc.StmtIdx {
+			bps = append(bps, bp)
+		}
+	}
+	d.mu.Unlock()
+
+	// conditions may call FUNCTIONs, so they are evaluated without holding the lock
+	for _, bp := range bps {
+		stop := Stop{Reason: ReasonBreakpoint, Loc: loc, Breakpoint: bp}
+		if bp.Cond != "" {
+			v, err := d.state.Eval(bp.Cond)
+			if err != nil {
+				stop.Err = errors.Wrapf(err, "condition %q", bp.Cond)
+			} else if !truth(v) {
+				continue
+			}
+		}
+		d.mu.Lock()
+		bp.Hits++
+		d.mu.Unlock()
+		return stop, true
+	}
+	depth := d.state.Depth()
+	switch {
+	case d.action == Step,
+		d.action == Next && depth <= d.depth,
+		d.action == Out && depth < d.depth:
+		reason := ReasonStep
+		if !d.started {
+			reason = ReasonEntry
+		}
+		return Stop{Reason: reason, Loc: loc}, true
+	}
+	return Stop{}, false
+}
+
+// truth is the truth of a condition. Like in IF, strings are false.
+func truth(v interface{}) bool {
+	switch v := v.(type) {
+	case int16:
+		return v != 0
+	case float32:
+		return v != 0
+	case float64:
+		return v != 0
+	default:
+		return false
+	}
+}
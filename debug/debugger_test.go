@@ -0,0 +1,215 @@
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mazzegi/gobas"
+)
+
+const testProgram = `
+10 DIM A(3): X=1
+20 FOR I=1 TO 3: A(I)=I*I: NEXT
+30 GOSUB 100: PRINT "BACK"
+40 CALL P(X)
+50 END
+100 X=X+1
+110 RETURN
+200 SUB P(N)
+210   N=N*10
+220 END SUB
+`
+
+func parse(t *testing.T, src string) *gobas.State {
+	state, err := gobas.NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	state.SetStreams(gobas.Streams{Stdout: io.Discard})
+	return state
+}
+
+// script is a handler, which records the locations it stops at and goes on with the next action
+type script struct {
+	actions []Action
+	stops   []string
+}
+
+func (sc *script) handle(stop Stop) Action {
+	s := fmt.Sprintf("%s %d:%d", stop.Reason, stop.Loc.Line, stop.Loc.StmtIdx)
+	if stop.Err != nil {
+		s += " " + stop.Err.Error()
+	}
+	sc.stops = append(sc.stops, s)
+	if len(sc.actions) == 0 {
+		return Continue
+	}
+	a := sc.actions[0]
+	sc.actions = sc.actions[1:]
+	return a
+}
+
+func TestDebugger(t *testing.T) {
+	type bp struct {
+		line, stmtIdx int
+		cond          string
+	}
+	tests := []struct {
+		name        string
+		stopOnEntry bool
+		breakpoints []bp
+		actions     []Action
+		expect      []string
+	}{
+		{
+			name:        "step",
+			stopOnEntry: true,
+			actions:     []Action{Step, Step, Step},
+			expect:      []string{"entry 10:0", "step 10:1", "step 20:0", "step 20:1"},
+		},
+		{
+			name:        "line breakpoints stop when the line is entered",
+			breakpoints: []bp{{line: 20, stmtIdx: -1}, {line: 110, stmtIdx: -1}},
+			expect:      []string{"breakpoint 20:0", "breakpoint 110:0"},
+		},
+		{
+			name:        "statement breakpoints stop at every run of the statement",
+			breakpoints: []bp{{line: 20, stmtIdx: 2}},
+			expect:      []string{"breakpoint 20:2", "breakpoint 20:2", "breakpoint 20:2"},
+		},
+		{
+			name:        "conditional breakpoint",
+			breakpoints: []bp{{line: 20, stmtIdx: 1, cond: "I = 2"}, {line: 100, stmtIdx: -1, cond: "X$"}},
+			expect:      []string{"breakpoint 20:1"},
+		},
+		{
+			name:        "failing condition stops",
+			breakpoints: []bp{{line: 100, stmtIdx: -1, cond: "FNX(1)"}},
+			expect:      []string{`breakpoint 100:0 condition "FNX(1)": no such func "FNX"`},
+		},
+		{
+			name:        "next steps over GOSUBs and calls",
+			breakpoints: []bp{{line: 30, stmtIdx: -1}},
+			actions:     []Action{Next, Next, Next},
+			expect:      []string{"breakpoint 30:0", "step 30:1", "step 40:0", "step 50:0"},
+		},
+		{
+			name:        "out of a GOSUB and a call",
+			breakpoints: []bp{{line: 100, stmtIdx: -1}, {line: 210, stmtIdx: -1}},
+			actions:     []Action{Out, Continue, Out},
+			expect:      []string{"breakpoint 100:0", "step 30:1", "breakpoint 210:0", "step 50:0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := parse(t, testProgram)
+			sc := &script{actions: test.actions}
+			d := New(state, sc.handle, test.stopOnEntry)
+			for _, bp := range test.breakpoints {
+				if _, err := d.AddBreakpoint(bp.line, bp.stmtIdx, bp.cond); err != nil {
+					t.Fatalf("add breakpoint: %v", err)
+				}
+			}
+			exit, err := state.Run()
+			if err != nil || exit != gobas.ExitEnd {
+				t.Fatalf("want END, got %s, %v", exit, err)
+			}
+			if want, got := strings.Join(test.expect, "\n"), strings.Join(sc.stops, "\n"); got != want {
+				t.Fatalf("want stops\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestDebuggerInspect(t *testing.T) {
+	state := parse(t, testProgram)
+	var got []string
+	d := New(state, func(stop Stop) Action {
+		for _, v := range state.Variables() {
+			got = append(got, v.Name+"="+gobas.FormatValue(v.Value))
+		}
+		for _, f := range state.Frames() {
+			got = append(got, fmt.Sprintf("%s %d", FrameName(f), f.Line))
+		}
+		return Continue
+	}, false)
+	d.AddBreakpoint(20, 2, "I = 3")
+	d.AddBreakpoint(110, -1, "")
+	d.AddBreakpoint(220, -1, "")
+	if err := d.AddWatch("A(2) + X"); err != nil {
+		t.Fatalf("add watch: %v", err)
+	}
+	if _, err := state.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	expect := []string{
+		"I=3", "X=1", "FOR I 20",
+		"I=4", "X=2", "GOSUB 30",
+		"N=20", "CALL P 40",
+	}
+	if want, got := strings.Join(expect, " "), strings.Join(got, " "); got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	ws := d.Watches()
+	if len(ws) != 1 || ws[0].Err != nil || gobas.FormatValue(ws[0].Value) != "24" {
+		t.Fatalf("want watch A(2) + X = 24, got %v", ws)
+	}
+	arrays := state.Arrays()
+	if len(arrays) != 1 || arrays[0].Name != "A" || formatValues(arrays[0].Values) != "[0 1 4 9]" {
+		t.Fatalf("want array A, got %v", arrays)
+	}
+}
+
+func TestDebuggerFunctionInCondition(t *testing.T) {
+	state := parse(t, `
+10 X=2
+20 PRINT F(X)
+30 END
+40 FUNCTION F(N)
+50   F=N*N
+60 END FUNCTION
+`)
+	sc := &script{actions: []Action{Step}}
+	var d *Debugger
+	var ws []Watch
+	d = New(state, func(stop Stop) Action {
+		if ws == nil {
+			ws = d.Watches()
+		}
+		return sc.handle(stop)
+	}, false)
+	if _, err := d.AddBreakpoint(20, -1, "F(2) = 4"); err != nil {
+		t.Fatalf("add breakpoint: %v", err)
+	}
+	if err := d.AddWatch("F(X) + 1"); err != nil {
+		t.Fatalf("add watch: %v", err)
+	}
+	if _, err := state.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// the condition and the watches don't stop in the FUNCTION, the step into the call of the program does
+	if want, got := "breakpoint 20:0 step 50:0", strings.Join(sc.stops, " "); got != want {
+		t.Fatalf("want stops %q, got %q", want, got)
+	}
+	if len(ws) != 1 || ws[0].Err != nil || gobas.FormatValue(ws[0].Value) != "5" {
+		t.Fatalf("want watch F(X) + 1 = 5, got %v", ws)
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	state := parse(t, testProgram)
+	d := New(state, func(stop Stop) Action { return Quit }, true)
+	if _, err := d.AddBreakpoint(15, -1, ""); err == nil {
+		t.Fatalf("want error for breakpoint in undefined line")
+	}
+	if _, err := d.AddBreakpoint(10, -1, "X +"); err == nil {
+		t.Fatalf("want error for invalid condition")
+	}
+	exit, err := state.Run()
+	if exit != gobas.ExitError || !errors.Is(err, ErrQuit) {
+		t.Fatalf("want quit, got %s, %v", exit, err)
+	}
+}
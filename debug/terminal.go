@@ -0,0 +1,291 @@
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mazzegi/gobas"
+	"github.com/pkg/errors"
+)
+
+const terminalHelp = `commands:
+  b LINE[:STMT] [IF cond]  set a breakpoint, optionally on a statement (0-based) and with a condition
+  d ID                     delete a breakpoint
+  bl                       list the breakpoints
+  w EXPR                   watch an expression
+  uw N                     remove the watch N
+  p EXPR                   print the value of an expression
+  vars                     dump the variables of the current scope
+  globals                  dump the global variables
+  arrays                   dump the arrays
+  stack                    dump the FOR loops, GOSUBs and calls, the innermost first
+  loops                    dump the FOR loops with their limits and steps
+  l                        list the lines around the current one
+  s                        step to the next statement
+  n                        step over GOSUBs and calls
+  o                        step out of the current GOSUB or call
+  c                        continue to the next breakpoint
+  q                        quit the program
+  h                        show this help`
+
+// Terminal is a prompt to debug a program in a terminal. The program reads from the same input as the prompt.
+type Terminal struct {
+	in    *bufio.Reader
+	out   io.Writer
+	state *gobas.State
+	dbg   *Debugger
+}
+
+func NewTerminal(state *gobas.State, in io.Reader, out io.Writer) *Terminal {
+	t := &Terminal{
+		in:    bufio.NewReader(in),
+		out:   out,
+		state: state,
+	}
+	state.SetStreams(gobas.Streams{
+		Stdin:  t.in,
+		Stdout: out,
+		Stderr: out,
+	})
+	t.dbg = New(state, t.prompt, true)
+	return t
+}
+
+// Run runs the program, which stops before its first statement
+func (t *Terminal) Run() (gobas.Exit, error) {
+	fmt.Fprintln(t.out, `type "h" for help`)
+	exit, err := t.state.Run()
+	if err == nil {
+		fmt.Fprintf(t.out, "program ended: %s\n", exit)
+	}
+	return exit, err
+}
+
+func (t *Terminal) prompt(stop Stop) Action {
+	t.showStop(stop)
+	for {
+		fmt.Fprint(t.out, "(debug) ")
+		input, err := t.in.ReadString('\n')
+		if input == "" && err != nil {
+			fmt.Fprintln(t.out)
+			return Quit
+		}
+		action, resume := t.handle(strings.TrimSpace(input))
+		if resume {
+			return action
+		}
+	}
+}
+
+func (t *Terminal) showStop(stop Stop) {
+	switch {
+	case stop.Breakpoint != nil:
+		fmt.Fprintf(t.out, "breakpoint %d at %s\n", stop.Breakpoint.ID, formatLoc(stop.Loc))
+	default:
+		fmt.Fprintf(t.out, "stopped at %s\n", formatLoc(stop.Loc))
+	}
+	if stop.Err != nil {
+		fmt.Fprintf(t.out, "ERROR: %v\n", stop.Err)
+	}
+	t.list(stop.Loc.Line, 0)
+	for i, w := range t.dbg.Watches() {
+		fmt.Fprintf(t.out, "watch %d: %s = %s\n", i, w.Expr, formatResult(w.Value, w.Err))
+	}
+}
+
+func formatLoc(loc gobas.Location) string {
+	return fmt.Sprintf("line %d:%d (%s)", loc.Line, loc.StmtIdx, loc.Stmt)
+}
+
+// handle handles a command and tells, if the program resumes with the action
+func (t *Terminal) handle(input string) (Action, bool) {
+	cmd, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	switch strings.ToLower(cmd) {
+	case "":
+	case "s", "step":
+		return Step, true
+	case "n", "next":
+		return Next, true
+	case "o", "out":
+		return Out, true
+	case "c", "continue":
+		return Continue, true
+	case "q", "quit":
+		return Quit, true
+	case "b", "break":
+		t.addBreakpoint(arg)
+	case "d", "delete":
+		id, err := strconv.Atoi(arg)
+		if err != nil || !t.dbg.RemoveBreakpoint(id) {
+			t.errorf("no such breakpoint %q", arg)
+		}
+	case "bl", "breakpoints":
+		for _, bp := range t.dbg.Breakpoints() {
+			fmt.Fprintf(t.out, "%d: %s\n", bp.ID, formatBreakpoint(bp))
+		}
+	case "w", "watch":
+		if err := t.dbg.AddWatch(arg); err != nil {
+			t.errorf("%v", err)
+		}
+	case "uw", "unwatch":
+		n, err := strconv.Atoi(arg)
+		if err != nil || !t.dbg.RemoveWatch(n) {
+			t.errorf("no such watch %q", arg)
+		}
+	case "p", "print":
+		v, err := t.state.Eval(arg)
+		fmt.Fprintln(t.out, formatResult(v, err))
+	case "vars":
+		t.dumpVars(t.state.Variables())
+	case "globals":
+		t.dumpVars(t.state.Globals())
+	case "arrays":
+		for _, a := range t.state.Arrays() {
+			fmt.Fprintf(t.out, "%s(%s) = %s\n", a.Name, joinInts(a.Dims), formatValues(a.Values))
+		}
+	case "stack":
+		t.dumpStack()
+	case "loops":
+		t.dumpLoops()
+	case "l", "list":
+		t.list(t.state.Location().Line, 2)
+	case "h", "help":
+		fmt.Fprintln(t.out, terminalHelp)
+	default:
+		t.errorf("unknown command %q", cmd)
+	}
+	return Continue, false
+}
+
+func (t *Terminal) errorf(pattern string, args ...interface{}) {
+	fmt.Fprintf(t.out, "?"+pattern+"\n", args...)
+}
+
+// addBreakpoint adds a breakpoint like `20`, `20:1` or `20 IF I>3`
+func (t *Terminal) addBreakpoint(arg string) {
+	bp, err := parseBreakpoint(arg)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	bp, err = t.dbg.AddBreakpoint(bp.Line, bp.StmtIdx, bp.Cond)
+	if err != nil {
+		t.errorf("%v", err)
+		return
+	}
+	fmt.Fprintf(t.out, "breakpoint %d at %s\n", bp.ID, formatBreakpoint(bp))
+}
+
+func parseBreakpoint(arg string) (*Breakpoint, error) {
+	pos, cond, _ := strings.Cut(arg, " ")
+	cond = strings.TrimSpace(cond)
+	if cond != "" {
+		kw, rest, _ := strings.Cut(cond, " ")
+		if !strings.EqualFold(kw, "IF") {
+			return nil, errors.Errorf("expected IF, found %q", kw)
+		}
+		cond = strings.TrimSpace(rest)
+		if cond == "" {
+			return nil, errors.New("expected a condition after IF")
+		}
+	}
+	sline, sstmt, hasStmt := strings.Cut(pos, ":")
+	bp := &Breakpoint{StmtIdx: -1, Cond: cond}
+	var err error
+	bp.Line, err = strconv.Atoi(sline)
+	if err != nil {
+		return nil, errors.Errorf("invalid line number %q", sline)
+	}
+	if hasStmt {
+		bp.StmtIdx, err = strconv.Atoi(sstmt)
+		if err != nil || bp.StmtIdx < 0 {
+			return nil, errors.Errorf("invalid statement %q", sstmt)
+		}
+	}
+	return bp, nil
+}
+
+func formatBreakpoint(bp *Breakpoint) string {
+	s := fmt.Sprintf("line %d", bp.Line)
+	if bp.StmtIdx >= 0 {
+		s += fmt.Sprintf(":%d", bp.StmtIdx)
+	}
+	if bp.Cond != "" {
+		s += " if " + bp.Cond
+	}
+	return s
+}
+
+func (t *Terminal) dumpVars(vs []gobas.Variable) {
+	for _, v := range vs {
+		fmt.Fprintf(t.out, "%s = %s\n", v.Name, gobas.FormatValue(v.Value))
+	}
+}
+
+// dumpStack dumps the active FOR loops, GOSUBs and calls, the innermost first
+func (t *Terminal) dumpStack() {
+	frames := t.state.Frames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		fmt.Fprintf(t.out, "%s in line %d\n", FrameName(frames[i]), frames[i].Line)
+	}
+}
+
+func (t *Terminal) dumpLoops() {
+	for _, fl := range t.state.ForLoops() {
+		fmt.Fprintf(t.out, "FOR %s TO %s STEP %s in line %d\n", fl.Var, gobas.FormatValue(fl.To), gobas.FormatValue(fl.Step), fl.Line)
+	}
+}
+
+// FrameName names a frame like FOR I, GOSUB or CALL P
+func FrameName(f gobas.Frame) string {
+	if f.Name == "" {
+		return f.Kind
+	}
+	return f.Kind + " " + f.Name
+}
+
+// list lists the line num with context lines before and after it
+func (t *Terminal) list(num int, context int) {
+	lines := t.state.Lines()
+	for i, l := range lines {
+		if l.Num() != num {
+			continue
+		}
+		from, to := i-context, i+context+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(lines) {
+			to = len(lines)
+		}
+		gobas.List(t.out, lines[from:to])
+		return
+	}
+}
+
+func formatResult(v interface{}, err error) string {
+	if err != nil {
+		return "?" + err.Error()
+	}
+	return gobas.FormatValue(v)
+}
+
+func formatValues(vs []interface{}) string {
+	sl := make([]string, len(vs))
+	for i, v := range vs {
+		sl[i] = gobas.FormatValue(v)
+	}
+	return "[" + strings.Join(sl, " ") + "]"
+}
+
+func joinInts(ns []int) string {
+	sl := make([]string, len(ns))
+	for i, n := range ns {
+		sl[i] = strconv.Itoa(n)
+	}
+	return strings.Join(sl, ",")
+}
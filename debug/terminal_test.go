@@ -0,0 +1,65 @@
+package debug
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTerminal(t *testing.T) {
+	input := strings.Join([]string{
+		"b 20:1 IF I=2",
+		"b 30:9 IF",
+		"w X*2",
+		"c",
+		"p A(1)+I",
+		"vars",
+		"arrays",
+		"loops",
+		"stack",
+		"d 1",
+		"bl",
+		"b 110",
+		"c",
+		"stack",
+		"o",
+		"x",
+		"c",
+	}, "\n") + "\n"
+	out := &bytes.Buffer{}
+	state := parse(t, testProgram)
+	exit, err := NewTerminal(state, strings.NewReader(input), out).Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, want := range []string{
+		"stopped at line 10:0 (DIM)\n10 DIM A(3): X=1\n",
+		"breakpoint 1 at line 20:1 if I=2\n",
+		"?expected a condition after IF\n",
+		"breakpoint 1 at line 20:1 (ASSIGN_ARRAY)\n20 FOR I=1 TO 3: A(I)=I*I: NEXT\nwatch 0: X*2 = 2\n",
+		"(debug) 3\n",
+		"(debug) I = 2\nX = 1\n",
+		"(debug) A(3) = [0 1 0 0]\n",
+		"(debug) FOR I TO 3 STEP 1 in line 20\n(debug) FOR I in line 20\n",
+		"(debug) (debug) breakpoint 2 at line 110\n",
+		"(debug) GOSUB in line 30\n",
+		"stopped at line 30:1 (PRINT)\n",
+		"?unknown command \"x\"",
+		"BACK\n",
+		"program ended: END\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("want output containing %q, got\n%s", want, out.String())
+		}
+	}
+	if exit.String() != "END" {
+		t.Fatalf("want END, got %s", exit)
+	}
+
+	state = parse(t, testProgram)
+	_, err = NewTerminal(state, strings.NewReader("q\n"), &bytes.Buffer{}).Run()
+	if !errors.Is(err, ErrQuit) {
+		t.Fatalf("want quit, got %v", err)
+	}
+}
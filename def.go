@@ -0,0 +1,38 @@
+package gobas
+
+import (
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// define registers the user-defined function of a DEF statement. On each call the arguments are bound to the
+// parameters in a scope local to that call, all other variables resolve to the global ones.
+func (s *State) define(def DEF) {
+	active := false
+	s.funcs.AddFunc(def.Name, func(vs []interface{}) (interface{}, error) {
+		if active {
+			return nil, errors.Errorf("recursive call of %s", def.Name)
+		}
+		if len(vs) != len(def.Params) {
+			return nil, errors.Errorf("%s: expect %d args, got %d", def.Name, len(def.Params), len(vs))
+		}
+		scope := expr.NewScope(s.vars)
+		for i, param := range def.Params {
+			v, err := s.types.TypeOf(param).Convert(vs[i])
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: param %q", def.Name, param)
+			}
+			scope.Add(param, v)
+		}
+
+		active = true
+		defer func() {
+			active = false
+		}()
+		v, err := def.Expr.Eval(scope, s.funcs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", def.Name)
+		}
+		return s.types.TypeOf(def.Name).Convert(v)
+	})
+}
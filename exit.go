@@ -0,0 +1,61 @@
+package gobas
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Exit tells how a run of a program ended
+type Exit int
+
+const (
+	ExitError Exit = iota
+	ExitEnd
+	ExitStop
+	ExitLastLine
+)
+
+func (e Exit) String() string {
+	switch e {
+	case ExitError:
+		return "ERROR"
+	case ExitEnd:
+		return "END"
+	case ExitStop:
+		return "STOP"
+	case ExitLastLine:
+		return "beyond last line"
+	default:
+		return ""
+	}
+}
+
+// RuntimeError is returned by State.Run, if the execution of a statement failed
+type RuntimeError struct {
+	Line    int
+	StmtIdx int
+	Stmt    string
+	Err     error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line == immediateLineNum {
+		return fmt.Sprintf("%s: %v", e.Stmt, e.Err)
+	}
+	return fmt.Sprintf("line %d: %s: %v", e.Line, e.Stmt, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// StmtKind returns the kind of a parsed statement like LET, IFLN or ONGOSUB
+func StmtKind(stmt Stmt) string {
+	if stmt == nil {
+		return ""
+	}
+	if cj, ok := stmt.(condJump); ok {
+		stmt = cj.stmt
+	}
+	return reflect.TypeOf(stmt).Name()
+}
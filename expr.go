@@ -2,7 +2,6 @@ package gobas
 
 import (
 	"github.com/mazzegi/gobas/expr"
-	"github.com/pkg/errors"
 )
 
 func parseExpression(s string) (Expr, error) {
@@ -10,14 +9,14 @@ func parseExpression(s string) (Expr, error) {
 		return Expr{}, nil
 	}
 
-	stack, err := expr.NewParser(s).Parse()
+	tree, err := expr.NewParser(s).Parse()
 	if err != nil {
 		return Expr{}, err
 	}
 
 	ex := Expr{
-		Raw:   s,
-		Stack: stack,
+		Raw:  s,
+		Tree: tree,
 	}
 	return ex, nil
 }
@@ -30,27 +29,15 @@ func mustParseExpression(s string) Expr {
 	return ex
 }
 
-func parseExpressions(sl []string) ([]Expr, error) {
-	var es []Expr
-	for _, s := range sl {
-		e, err := parseExpression(s)
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse-expression %q", s)
-		}
-		es = append(es, e)
-	}
-	return es, nil
+type Expr struct {
+	Raw  string
+	Tree expr.Evaler
 }
 
-func mustParseExpressions(sl []string) []Expr {
-	es, err := parseExpressions(sl)
-	if err != nil {
-		panic(err)
-	}
-	return es
+func (e Expr) Eval(lu expr.Lookuper, funcs *expr.Funcs) (interface{}, error) {
+	return e.Tree.Eval(lu, funcs)
 }
 
-type Expr struct {
-	Raw   string
-	Stack *expr.Stack
+func (e Expr) EvalFloat(lu expr.Lookuper, funcs *expr.Funcs) (float64, error) {
+	return expr.EvalFloat(e.Tree, lu, funcs)
 }
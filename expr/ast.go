@@ -0,0 +1,173 @@
+package expr
+
+type Lookuper interface {
+	LookupVar(name string) (interface{}, error)
+	CanEvalFloat(name string) bool
+}
+
+type Op string
+
+const (
+	OpXOR    Op = "XOR"
+	OpOR     Op = "OR"
+	OpAND    Op = "AND"
+	OpNOT    Op = "NOT"
+	OpLs     Op = "LS"
+	OpGt     Op = "GT"
+	OpEq     Op = "EQ"
+	OpNotEq  Op = "NEQ"
+	OpLsEq   Op = "LSEQ"
+	OpGtEq   Op = "GTEQ"
+	OpPlus   Op = "PLUS"
+	OpMinus  Op = "MINUS"
+	OpIntDiv Op = "INTDIV"
+	OpMOD    Op = "MOD"
+	OpTimes  Op = "TIMES"
+	OpDiv    Op = "DIV"
+	OpNeg    Op = "NEG"
+	OpExp    Op = "EXP"
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpXOR:
+		return "XOR"
+	case OpOR:
+		return "OR"
+	case OpAND:
+		return "AND"
+	case OpNOT:
+		return "NOT"
+	case OpLs:
+		return "<"
+	case OpGt:
+		return ">"
+	case OpEq:
+		return "="
+	case OpNotEq:
+		return "<>"
+	case OpLsEq:
+		return "<="
+	case OpGtEq:
+		return ">="
+	case OpPlus:
+		return "+"
+	case OpMinus, OpNeg:
+		return "-"
+	case OpIntDiv:
+		return `\`
+	case OpMOD:
+		return "MOD"
+	case OpTimes:
+		return "*"
+	case OpDiv:
+		return "/"
+	case OpExp:
+		return "^"
+	default:
+		return ""
+	}
+}
+
+// Rank is the precedence of an operator. Operators with a higher rank bind stronger.
+func (op Op) Rank() int {
+	switch op {
+	case OpXOR:
+		return 1
+	case OpOR:
+		return 2
+	case OpAND:
+		return 3
+	case OpNOT:
+		return 4
+	case OpLs, OpGt, OpEq, OpNotEq, OpLsEq, OpGtEq:
+		return 5
+	case OpPlus, OpMinus:
+		return 6
+	case OpIntDiv:
+		return 7
+	case OpMOD:
+		return 8
+	case OpTimes, OpDiv:
+		return 9
+	case OpNeg:
+		return 10
+	case OpExp:
+		return 11
+	default:
+		return 0
+	}
+}
+
+func (op Op) IsRelational() bool {
+	return op.Rank() == OpEq.Rank()
+}
+
+// Evaler is a node of the typed expression tree, the parser produces
+type Evaler interface {
+	Eval(lu Lookuper, funcs *Funcs) (interface{}, error)
+	CanEvalFloat(lu Lookuper, funcs *Funcs) bool
+	String() string
+}
+
+func EvalFloat(ev Evaler, lu Lookuper, funcs *Funcs) (float64, error) {
+	v, err := ev.Eval(lu, funcs)
+	if err != nil {
+		return 0, err
+	}
+	return ConvertToFloat(v)
+}
+
+// UnaryEvaler is a negation or a NOT
+type UnaryEvaler struct {
+	Op Op
+	X  Evaler
+}
+
+func (e UnaryEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
+	v, err := e.X.Eval(lu, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return UnaryOp(e.Op, v)
+}
+
+func (e UnaryEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
+	return true
+}
+
+func (e UnaryEvaler) String() string {
+	if e.Op == OpNOT {
+		return "(NOT " + e.X.String() + ")"
+	}
+	return "(" + e.Op.String() + e.X.String() + ")"
+}
+
+type BinaryEvaler struct {
+	Op Op
+	X  Evaler
+	Y  Evaler
+}
+
+func (e BinaryEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
+	x, err := e.X.Eval(lu, funcs)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.Y.Eval(lu, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return BinaryOp(e.Op, x, y)
+}
+
+func (e BinaryEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
+	if e.Op == OpPlus {
+		return e.X.CanEvalFloat(lu, funcs)
+	}
+	return true
+}
+
+func (e BinaryEvaler) String() string {
+	return "(" + e.X.String() + " " + e.Op.String() + " " + e.Y.String() + ")"
+}
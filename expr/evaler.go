@@ -1,6 +1,12 @@
 package expr
 
-import "constraints"
+import (
+	"constraints"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 type Number interface {
 	constraints.Float | constraints.Integer
@@ -18,33 +24,31 @@ func (e NumberEvaler[T]) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
 	return T(e.V), nil
 }
 
-func (e NumberEvaler[T]) Push(op Op, ev Evaler) Evaler {
-	s := NewStack(op, e)
-	s.Push(op, ev)
-	return s
-}
-
 func (e NumberEvaler[T]) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
 	return true
 }
 
-//
+func (e NumberEvaler[T]) String() string {
+	if f, ok := any(e.V).(float32); ok {
+		return strconv.FormatFloat(float64(f), 'g', -1, 32)
+	}
+	return strconv.FormatFloat(float64(e.V), 'g', -1, 64)
+}
+
 type StringEvaler string
 
 func (e StringEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
 	return string(e), nil
 }
 
-func (e StringEvaler) Push(op Op, ev Evaler) Evaler {
-	s := NewStack(op, e)
-	s.Push(op, ev)
-	return s
-}
-
 func (e StringEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
 	return false
 }
 
+func (e StringEvaler) String() string {
+	return `"` + string(e) + `"`
+}
+
 //
 
 type VarEvaler string
@@ -53,16 +57,14 @@ func (e VarEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
 	return lu.LookupVar(string(e))
 }
 
-func (e VarEvaler) Push(op Op, ev Evaler) Evaler {
-	s := NewStack(op, e)
-	s.Push(op, ev)
-	return s
-}
-
 func (e VarEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
 	return lu.CanEvalFloat(string(e))
 }
 
+func (e VarEvaler) String() string {
+	return string(e)
+}
+
 //
 
 type FuncEvaler struct {
@@ -74,46 +76,31 @@ func (e FuncEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
 	return funcs.Eval(e.Name, lu, e.Args)
 }
 
-func (e FuncEvaler) Push(op Op, ev Evaler) Evaler {
-	s := NewStack(op, e)
-	s.Push(op, ev)
-	return s
-}
 func (e FuncEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
 	return funcs.CanEvalFloat(e.Name)
 }
 
-//
-
-func MakeFloatFuncEvaler(ev Evaler, fnc func(v float64) float64) FloatFuncEvaler {
-	return FloatFuncEvaler{
-		ev:  ev,
-		fnc: fnc,
+func (e FuncEvaler) String() string {
+	args := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		args[i] = a.String()
 	}
+	return e.Name + "(" + strings.Join(args, ",") + ")"
 }
 
-type FloatFuncEvaler struct {
-	ev  Evaler
-	fnc func(v float64) float64
-}
+//
 
-func (e FloatFuncEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
-	v, err := e.ev.Eval(lu, funcs)
-	if err != nil {
-		return nil, err
-	}
-	f, err := ConvertToFloat(v)
-	if err != nil {
-		return nil, err
-	}
-	return e.fnc(f), nil
+// ArrayRefEvaler is a whole array like A(), which is passed as argument. It has no value on its own.
+type ArrayRefEvaler string
+
+func (e ArrayRefEvaler) Eval(lu Lookuper, funcs *Funcs) (interface{}, error) {
+	return nil, errors.Errorf("array %s() used as a value", string(e))
 }
 
-func (e FloatFuncEvaler) Push(op Op, ev Evaler) Evaler {
-	s := NewStack(op, e)
-	s.Push(op, ev)
-	return s
+func (e ArrayRefEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
+	return false
 }
-func (e FloatFuncEvaler) CanEvalFloat(lu Lookuper, funcs *Funcs) bool {
-	return true
+
+func (e ArrayRefEvaler) String() string {
+	return string(e) + "()"
 }
@@ -1,18 +1,13 @@
 package expr
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
 	"testing"
 )
 
-func dumpStack(s *Stack) string {
-	b, _ := json.MarshalIndent(s, "", "  ")
-	return string(b)
-}
-
 func floatsEqual(f1, f2 float64) bool {
 	return math.Abs(f1-f2) < 1e-6
 }
@@ -120,6 +115,14 @@ func TestFloatExpr(t *testing.T) {
 			failParse: false,
 			expect:    47,
 		},
+		{
+			in:     "-3",
+			expect: -3,
+		},
+		{
+			in:     "-x2+5",
+			expect: 3,
+		},
 	}
 
 	skipNonExclusive := false
@@ -149,7 +152,7 @@ func TestFloatExpr(t *testing.T) {
 						t.Fatalf("expect NO convert-float error, but got %v", err)
 					}
 					if !floatsEqual(f, test.expect) {
-						t.Fatalf("expect %f, got %f\n%s", test.expect, f, dumpStack(s))
+						t.Fatalf("expect %f, got %f\n%s", test.expect, f, s)
 						//t.Fatalf("expect %f, got %f", test.expect, f)
 					}
 				}
@@ -321,9 +324,9 @@ func TestBoolExpr(t *testing.T) {
 					if err != nil {
 						t.Fatalf("expect NO convert-float error, but got %v", err)
 					}
-					b := f > 0
+					b := Truth(f)
 					if b != test.expect {
-						t.Fatalf("expect %t, got %t (float=%f)\n%s", test.expect, b, f, dumpStack(s))
+						t.Fatalf("expect %t, got %t (float=%f)\n%s", test.expect, b, f, s)
 						//t.Fatalf("expect %t, got %t", test.expect, b)
 					}
 				}
@@ -331,3 +334,247 @@ func TestBoolExpr(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTree(t *testing.T) {
+	funcs := setupFuncs()
+	vars := NewVars()
+	vars.Add("x1", 1)
+	vars.Add("x2", 2)
+	vars.Add("x44", 44)
+	vars.Add("A$", "ABC")
+
+	tests := []struct {
+		in         string
+		expectTree string
+		expect     interface{}
+	}{
+		// the cases of TestFloatExpr, TestStringExpr and TestBoolExpr
+		{in: "min(  (2+ 1) *4, 5.67)", expectTree: "min(((2 + 1) * 4),5.67)", expect: 5.67},
+		{in: "min(  (2+ 1) *4, x2)", expectTree: "min(((2 + 1) * 4),x2)", expect: 2.0},
+		{in: "(2+1)*4", expectTree: "((2 + 1) * 4)", expect: 12.0},
+		{in: "(1+1)*4+2*3", expectTree: "(((1 + 1) * 4) + (2 * 3))", expect: 14.0},
+		{in: "(sqrt(4)+1+1)*4+2*3", expectTree: "((((sqrt(4) + 1) + 1) * 4) + (2 * 3))", expect: 22.0},
+		{in: "1+2*3", expectTree: "(1 + (2 * 3))", expect: 7.0},
+		{in: "1+2*3/3^(1+(2+5))", expectTree: "(1 + ((2 * 3) / (3 ^ (1 + (2 + 5)))))", expect: 1.000914},
+		{in: "sqrt(4)-1+2*3", expectTree: "((sqrt(4) - 1) + (2 * 3))", expect: 7.0},
+		{in: "((sqrt(4)+1)+1)*4+2*3", expectTree: "((((sqrt(4) + 1) + 1) * 4) + (2 * 3))", expect: 22.0},
+		{in: "x1 + x2 + x44", expectTree: "((x1 + x2) + x44)", expect: 47.0},
+		{in: "-3", expectTree: "(-3)", expect: -3.0},
+		{in: "-x2+5", expectTree: "((-x2) + 5)", expect: 3.0},
+		{in: `"THE DEALER (COMPUTER) DEALS"`, expectTree: `"THE DEALER (COMPUTER) DEALS"`, expect: "THE DEALER (COMPUTER) DEALS"},
+		{in: `mid( trim("  hammer    "), 1, 2)`, expectTree: `mid(trim("  hammer    "),1,2)`, expect: "am"},
+		{in: "2>1", expectTree: "(2 > 1)", expect: -1.0},
+		{in: "4<=4", expectTree: "(4 <= 4)", expect: -1.0},
+		{in: "13<17 AND 432>399", expectTree: "((13 < 17) AND (432 > 399))", expect: -1.0},
+		{in: "13> 17 OR 432<=399", expectTree: "((13 > 17) OR (432 <= 399))", expect: 0.0},
+		{in: "2 >= (1.3+0.8)", expectTree: "(2 >= (1.3 + 0.8))", expect: 0.0},
+
+		// precedence
+		{in: "8/2/2", expectTree: "((8 / 2) / 2)", expect: 2.0},
+		{in: "10-2-3", expectTree: "((10 - 2) - 3)", expect: 5.0},
+		{in: "-2^2", expectTree: "(-(2 ^ 2))", expect: -4.0},
+		{in: "2^-1", expectTree: "(2 ^ (-1))", expect: 0.5},
+		{in: "2*-3", expectTree: "(2 * (-3))", expect: -6.0},
+		{in: "x2-1=1", expectTree: "((x2 - 1) = 1)", expect: -1.0},
+		{in: "1+x1*2>2 AND x2", expectTree: "(((1 + (x1 * 2)) > 2) AND x2)", expect: 2.0},
+		{in: "7 MOD 4*2", expectTree: "(7 MOD (4 * 2))", expect: 7.0},
+		{in: `7\2*3`, expectTree: `(7 \ (2 * 3))`, expect: 1.0},
+		{in: `10\3 MOD 2`, expectTree: `(10 \ (3 MOD 2))`, expect: 10.0},
+		{in: `-7\2`, expectTree: `((-7) \ 2)`, expect: -3.0},
+		{in: "-7 MOD 3", expectTree: "((-7) MOD 3)", expect: -1.0},
+		{in: "NOT x1=2", expectTree: "(NOT (x1 = 2))", expect: -1.0},
+		{in: "NOT 0 AND 5", expectTree: "((NOT 0) AND 5)", expect: 5.0},
+		{in: "NOT x1", expectTree: "(NOT x1)", expect: -2.0},
+		{in: "1 OR 2 XOR 3", expectTree: "((1 OR 2) XOR 3)", expect: 0.0},
+		{in: "x1 OR x2 AND 0", expectTree: "(x1 OR (x2 AND 0))", expect: 1.0},
+		{in: `A$+"D"="ABCD"`, expectTree: `((A$ + "D") = "ABCD")`, expect: -1.0},
+		{in: `"B">A$`, expectTree: `("B" > A$)`, expect: -1.0},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ev, err := NewParser(test.in).Parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if ev.String() != test.expectTree {
+				t.Fatalf("want tree %s, got %s", test.expectTree, ev.String())
+			}
+			v, err := ev.Eval(vars, funcs)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			switch expect := test.expect.(type) {
+			case float64:
+				f, err := ConvertToFloat(v)
+				if err != nil || !floatsEqual(f, expect) {
+					t.Fatalf("want %f, got %v", expect, v)
+				}
+			default:
+				if v != test.expect {
+					t.Fatalf("want %v, got %v", test.expect, v)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEvalErrors(t *testing.T) {
+	funcs := setupFuncs()
+	vars := NewVars()
+	vars.Add("A$", "ABC")
+	vars.Add("M%", int16(-32768))
+
+	parseErrors := []string{"", "1+", "(1+2", "1 2", "2*)", `"ABC`, "1 # 2", "min(1,", "32768%", "1E39"}
+	for _, in := range parseErrors {
+		t.Run("parse "+in, func(t *testing.T) {
+			_, err := NewParser(in).Parse()
+			if err == nil {
+				t.Fatalf("want parse error, got none")
+			}
+		})
+	}
+
+	evalErrors := map[string]error{
+		"1/0":           ErrDivisionByZero,
+		`5\0`:           ErrDivisionByZero,
+		"5 MOD 0":       ErrDivisionByZero,
+		`A$*2`:          ErrTypeMismatch,
+		`A$+1`:          ErrTypeMismatch,
+		`-A$`:           ErrTypeMismatch,
+		`A$<1`:          ErrTypeMismatch,
+		"40000 AND 1":   ErrOverflow,
+		"20000%+20000%": ErrOverflow,
+		"-M%":           ErrOverflow,
+		"10^400":        ErrOverflow,
+		"1E38*10":       ErrOverflow,
+		"(-8)^(1/3)":    ErrIllegalFunctionCall,
+	}
+	for in, expect := range evalErrors {
+		t.Run("eval "+in, func(t *testing.T) {
+			ev, err := NewParser(in).Parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			_, err = ev.Eval(vars, funcs)
+			if !errors.Is(err, expect) {
+				t.Fatalf("want error %q, got %v", expect, err)
+			}
+		})
+	}
+}
+
+func TestRefFunc(t *testing.T) {
+	funcs := setupFuncs()
+	vars := NewVars()
+	vars.Add("X", 3.0)
+	funcs.AddRefFunc("ref", func(lu Lookuper, args []Evaler) (interface{}, error) {
+		var names []string
+		for _, arg := range args {
+			names = append(names, arg.String())
+		}
+		return strings.Join(names, "|"), nil
+	})
+
+	ev, err := NewParser("ref(X, A( ), X+1)").Parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if ev.String() != "ref(X,A(),(X + 1))" {
+		t.Fatalf("want tree ref(X,A(),(X + 1)), got %s", ev.String())
+	}
+	v, err := ev.Eval(vars, funcs)
+	if err != nil {
+		t.Fatalf("eval: %v", err)
+	}
+	if v != "X|A()|(X + 1)" {
+		t.Fatalf("want X|A()|(X + 1), got %v", v)
+	}
+
+	ev, err = NewParser("A()+1").Parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	_, err = ev.Eval(vars, funcs)
+	if err == nil {
+		t.Fatalf("want error for an array used as a value, got none")
+	}
+}
+
+func TestTypedEval(t *testing.T) {
+	funcs := setupFuncs()
+	vars := NewVars()
+	vars.Add("I%", int16(7))
+	vars.Add("S", float32(0.5))
+	vars.Add("D#", float64(0.25))
+	vars.Add("A$", "ABC")
+
+	tests := []struct {
+		in     string
+		expect interface{}
+	}{
+		{in: "1", expect: float32(1)},
+		{in: "0.1", expect: float32(0.1)},
+		{in: "12345678", expect: float64(12345678)},
+		{in: "1.5#", expect: float64(1.5)},
+		{in: "1D2", expect: float64(100)},
+		{in: "2.5%", expect: int16(3)},
+		{in: "I%+1%", expect: int16(8)},
+		{in: "I%*2%", expect: int16(14)},
+		{in: "I%/2%", expect: float32(3.5)},
+		{in: `I%\2`, expect: int16(3)},
+		{in: "I%+S", expect: float32(7.5)},
+		{in: "S+D#", expect: float64(0.75)},
+		{in: "-I%", expect: int16(-7)},
+		{in: "I%>1", expect: int16(-1)},
+		{in: "NOT S", expect: int16(-2)},
+		{in: `A$="ABC"`, expect: int16(-1)},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ev, err := NewParser(test.in).Parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			v, err := ev.Eval(vars, funcs)
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if v != test.expect {
+				t.Fatalf("want %v (%T), got %v (%T)", test.expect, test.expect, v, v)
+			}
+		})
+	}
+}
+
+func TestTypeConvert(t *testing.T) {
+	tests := []struct {
+		typ       Type
+		in        interface{}
+		expect    interface{}
+		expectErr error
+	}{
+		{typ: TypeInteger, in: float32(2.5), expect: int16(3)},
+		{typ: TypeInteger, in: float64(-32768), expect: int16(-32768)},
+		{typ: TypeInteger, in: float64(32768), expectErr: ErrOverflow},
+		{typ: TypeSingle, in: int16(3), expect: float32(3)},
+		{typ: TypeDouble, in: float32(0.5), expect: float64(0.5)},
+		{typ: TypeString, in: "X", expect: "X"},
+		{typ: TypeString, in: float32(1), expectErr: ErrTypeMismatch},
+		{typ: TypeSingle, in: "1", expectErr: ErrTypeMismatch},
+		{typ: TypeSingle, in: math.Inf(1), expectErr: ErrOverflow},
+		{typ: TypeDouble, in: math.Inf(-1), expectErr: ErrOverflow},
+		{typ: TypeDouble, in: math.NaN(), expectErr: ErrIllegalFunctionCall},
+	}
+	for _, test := range tests {
+		v, err := test.typ.Convert(test.in)
+		if test.expectErr != nil {
+			if !errors.Is(err, test.expectErr) {
+				t.Fatalf("%s(%v): want error %v, got %v", test.typ, test.in, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil || v != test.expect {
+			t.Fatalf("%s(%v): want %v (%T), got %v (%T), %v", test.typ, test.in, test.expect, test.expect, v, v, err)
+		}
+	}
+}
@@ -9,6 +9,9 @@ import (
 type Func func([]interface{}) (interface{}, error)
 type FloatFunc func([]interface{}) (float64, error)
 
+// RefFunc is called with the unevaluated arguments, so it can pass variables and arrays by reference
+type RefFunc func(lu Lookuper, args []Evaler) (interface{}, error)
+
 func scanArg(v interface{}, arg interface{}) error {
 	rvarg := reflect.ValueOf(arg)
 	if rvarg.Kind() != reflect.Pointer {
@@ -51,12 +54,14 @@ func NewFuncs() *Funcs {
 	return &Funcs{
 		funcs:      map[string]Func{},
 		floatFuncs: map[string]FloatFunc{},
+		refFuncs:   map[string]RefFunc{},
 	}
 }
 
 type Funcs struct {
 	funcs      map[string]Func
 	floatFuncs map[string]FloatFunc
+	refFuncs   map[string]RefFunc
 }
 
 func (fs *Funcs) AddFunc(name string, fnc Func) {
@@ -67,7 +72,14 @@ func (fs *Funcs) AddFloatFunc(name string, fnc FloatFunc) {
 	fs.floatFuncs[name] = fnc
 }
 
+func (fs *Funcs) AddRefFunc(name string, fnc RefFunc) {
+	fs.refFuncs[name] = fnc
+}
+
 func (fs *Funcs) Eval(name string, lu Lookuper, evs []Evaler) (interface{}, error) {
+	if rfnc, ok := fs.refFuncs[name]; ok {
+		return rfnc(lu, evs)
+	}
 	var vs []interface{}
 	for _, ev := range evs {
 		v, err := ev.Eval(lu, fs)
@@ -76,7 +88,11 @@ func (fs *Funcs) Eval(name string, lu Lookuper, evs []Evaler) (interface{}, erro
 		}
 		vs = append(vs, v)
 	}
+	return fs.Call(name, vs...)
+}
 
+// Call calls the function name with already evaluated arguments
+func (fs *Funcs) Call(name string, vs ...interface{}) (interface{}, error) {
 	if ffnc, ok := fs.floatFuncs[name]; ok {
 		return ffnc(vs)
 	}
@@ -100,5 +116,8 @@ func (fs *Funcs) Contains(name string) bool {
 	if _, ok := fs.funcs[name]; ok {
 		return true
 	}
+	if _, ok := fs.refFuncs[name]; ok {
+		return true
+	}
 	return false
 }
@@ -1,20 +1,13 @@
 package expr
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// func NewParser(expr string, funcs *Funcs) *Parser {
-// 	return &Parser{
-// 		expression: expr,
-// 		pos:        0,
-// 		funcs:      funcs,
-// 	}
-// }
-
 func NewParser(expr string) *Parser {
 	return &Parser{
 		expression: expr,
@@ -22,274 +15,425 @@ func NewParser(expr string) *Parser {
 	}
 }
 
+// Parser is a precedence climbing (Pratt) parser, which turns an expression into a tree of Evalers
 type Parser struct {
 	expression string
 	pos        int
-	//funcs      *Funcs
+	tok        token
+}
+
+type tokenKind int
+
+const (
+	tokEnd tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEnd:
+		return "end of expression"
+	case tokString:
+		return `"` + t.text + `"`
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
 }
 
 const (
-	plus   = "+"
-	minus  = "-"
-	times  = "*"
-	div    = "/"
-	exp    = "^"
 	bopen  = "("
 	bclose = ")"
-	ls     = "<"
-	gt     = ">"
-	eq     = "="
-	neq    = "<>"
-	lseq   = "<="
-	gteq   = ">="
-	and    = "AND "
-	or     = "OR "
+	comma  = ","
 )
 
-var ops = []string{
-	lseq,
-	gteq,
-	neq,
-	and,
-	or,
-	plus,
-	minus,
-	times,
-	div,
-	exp,
-	bopen,
-	bclose,
-	ls,
-	gt,
-	eq,
+// ops are sorted, so that the longer operators match first
+var ops = []string{"<=", ">=", "<>", "+", "-", "*", "/", `\`, "^", bopen, bclose, comma, "<", ">", "="}
+
+var binaryOps = map[string]Op{
+	"XOR": OpXOR,
+	"OR":  OpOR,
+	"AND": OpAND,
+	"<":   OpLs,
+	">":   OpGt,
+	"=":   OpEq,
+	"<>":  OpNotEq,
+	"<=":  OpLsEq,
+	">=":  OpGtEq,
+	"+":   OpPlus,
+	"-":   OpMinus,
+	`\`:   OpIntDiv,
+	"MOD": OpMOD,
+	"*":   OpTimes,
+	"/":   OpDiv,
+	"^":   OpExp,
+}
+
+// keywordOps are operators, which are scanned like identifiers
+var keywordOps = map[string]bool{
+	"AND": true,
+	"MOD": true,
+	"NOT": true,
+	"OR":  true,
+	"XOR": true,
+}
+
+func (p *Parser) Parse() (Evaler, error) {
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEnd {
+		return nil, errors.Errorf("empty expression")
+	}
+	ev, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEnd {
+		return nil, p.unexpected()
+	}
+	return ev, nil
+}
+
+// PosError is a syntax error at the byte offset Pos of the expression
+type PosError struct {
+	Pos int
+	Msg string
+}
+
+func (e *PosError) Error() string {
+	return fmt.Sprintf("%s at %d", e.Msg, e.Pos)
 }
 
-func isOneOf(s string, sl []string) bool {
-	for _, cs := range sl {
-		if s == cs {
-			return true
+func posErrorf(pos int, format string, args ...interface{}) error {
+	return &PosError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *Parser) unexpected() error {
+	return posErrorf(p.tok.pos, "unexpected %s", p.tok)
+}
+
+// parseExpr parses operands joined by binary operators with a rank of at least minRank.
+// All binary operators are left associative.
+func (p *Parser) parseExpr(minRank int) (Evaler, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp()
+		if !ok || op.Rank() < minRank {
+			return x, nil
 		}
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		y, err := p.parseExpr(op.Rank() + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = BinaryEvaler{Op: op, X: x, Y: y}
 	}
-	return false
 }
 
-func (p *Parser) Parse() (*Stack, error) {
-	var curr string
-	//var lastOp byte
-	var lastOp string
-
-	//vars := NewVars()
-	var stack *Stack
-	push := func(ev Evaler) error {
-		defer func() {
-			curr = ""
-			lastOp = ""
-		}()
-		if stack == nil {
-			if evStack, ok := ev.(*Stack); ok {
-				stack = evStack
-			} else {
-				stack = NewStack(OpPlus, ev)
+func (p *Parser) binaryOp() (Op, bool) {
+	if p.tok.kind != tokOp {
+		return "", false
+	}
+	op, ok := binaryOps[p.tok.text]
+	return op, ok
+}
+
+// parseUnary parses an operand, which may be prefixed with - (binding weaker than ^), + or NOT
+// (binding weaker than the relational operators)
+func (p *Parser) parseUnary() (Evaler, error) {
+	if p.tok.kind == tokOp {
+		var op Op
+		switch p.tok.text {
+		case "-":
+			op = OpNeg
+		case "NOT":
+			op = OpNOT
+		case "+":
+			err := p.advance()
+			if err != nil {
+				return nil, err
 			}
-			//TODO: Handle minus as first op
-
-			// switch lastOp {
-			// case minus:
-			// 	// if !ev.CanEvalFloat(vars, p.funcs) {
-			// 	// 	return errors.Errorf("cannot eval float")
-			// 	// }
-			// 	//TODO: Handle minus
-			// 	stack = NewStack(OpPlus, ev)
-			// 	return nil
-			// case times, div, exp:
-			// 	return errors.Errorf("invalid operator at beginning of expr")
-			// }
-			// stack = NewStack(OpPlus, ev)
-			return nil
-		}
-		if lastOp == "" {
-			return errors.Errorf("missing operator")
+			return p.parseExpr(OpNeg.Rank() + 1)
 		}
-		// if !ev.CanEvalFloat(vars, p.funcs) {
-		// 	return errors.Errorf("cannot eval float")
-		// }
-
-		switch lastOp {
-		case plus:
-			stack.Push(OpPlus, ev)
-		case minus:
-			stack.Push(OpPlus, MakeFloatFuncEvaler(ev, func(f float64) float64 { return -f }))
-		case times:
-			stack.Push(OpTimes, ev)
-		case div:
-			stack.Push(OpTimes, MakeFloatFuncEvaler(ev, func(f float64) float64 { return -1.0 / f }))
-		case exp:
-			stack.Push(OpExp, ev)
-		case ls:
-			stack.Push(OpLs, ev)
-		case gt:
-			stack.Push(OpGt, ev)
-		case eq:
-			stack.Push(OpEq, ev)
-		case neq:
-			stack.Push(OpNotEq, ev)
-		case lseq:
-			stack.Push(OpLsEq, ev)
-		case gteq:
-			stack.Push(OpGtEq, ev)
-		case and:
-			stack.Push(OpAND, ev)
-		case or:
-			stack.Push(OpOR, ev)
+		if op != "" {
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+			x, err := p.parseExpr(op.Rank() + 1)
+			if err != nil {
+				return nil, err
+			}
+			return UnaryEvaler{Op: op, X: x}, nil
 		}
-		return nil
 	}
+	return p.parsePrimary()
+}
 
-	peekOp := func() (string, bool) {
-		for _, op := range ops {
-			if strings.HasPrefix(p.expression[p.pos:], op) {
-				return op, true
+func (p *Parser) parsePrimary() (Evaler, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		ev, err := ParseNumber(tok.text)
+		if err != nil {
+			return nil, posErrorf(tok.pos, "invalid number %q", tok.text)
+		}
+		return ev, p.advance()
+	case tokString:
+		return StringEvaler(tok.text), p.advance()
+	case tokIdent:
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokOp || p.tok.text != bopen {
+			return VarEvaler(tok.text), nil
+		}
+		if p.peekClose() {
+			err := p.advance()
+			if err != nil {
+				return nil, err
 			}
+			return ArrayRefEvaler(tok.text), p.advance()
+		}
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return FuncEvaler{
+			Name: tok.text,
+			Args: args,
+		}, nil
+	case tokOp:
+		if tok.text == bopen {
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+			ev, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return ev, p.expectClose(tok)
 		}
-		return "", false
 	}
+	return nil, p.unexpected()
+}
 
-	pushCurr := func() error {
-		curr = strings.TrimSpace(curr)
-		if curr == "" {
-			return nil
+// parseArgs parses the bracketed arguments of a function call or array index
+func (p *Parser) parseArgs() ([]Evaler, error) {
+	open := p.tok
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+	var args []Evaler
+	for {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
 		}
-		var ev Evaler
-
-		if strings.HasPrefix(curr, `"`) && strings.HasSuffix(curr, `"`) {
-			curr = strings.TrimPrefix(curr, `"`)
-			curr = strings.TrimSuffix(curr, `"`)
-			ev = StringEvaler(curr)
-		} else if f, err := strconv.ParseFloat(curr, 10); err == nil {
-			ev = MakeNumberEvaler(f)
-		} else {
-			ev = VarEvaler(curr)
+		args = append(args, arg)
+		if p.tok.kind != tokOp || p.tok.text != comma {
+			return args, p.expectClose(open)
+		}
+		err = p.advance()
+		if err != nil {
+			return nil, err
 		}
+	}
+}
+
+// peekClose tells, if the next token behind the current one is a closing brace
+func (p *Parser) peekClose() bool {
+	rest := strings.TrimLeft(p.expression[p.pos:], " \t")
+	return strings.HasPrefix(rest, bclose)
+}
 
-		return push(ev)
+func (p *Parser) expectClose(open token) error {
+	if p.tok.kind != tokOp || p.tok.text != bclose {
+		if p.tok.kind == tokEnd {
+			return posErrorf(open.pos, "no closing brace found for open brace")
+		}
+		return p.unexpected()
 	}
+	return p.advance()
+}
 
-	var inQuotes bool
-	for p.pos < len(p.expression) {
-		r := p.expression[p.pos]
-		//switch r {
-		switch {
-		case r == '"':
-			if !inQuotes {
-				inQuotes = true
-			} else {
-				inQuotes = false
-			}
-			curr += string(r)
+// advance scans the next token
+func (p *Parser) advance() error {
+	s := p.expression
+	for p.pos < len(s) && (s[p.pos] == ' ' || s[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(s) {
+		p.tok = token{kind: tokEnd, pos: start}
+		return nil
+	}
+
+	c := s[p.pos]
+	switch {
+	case c == '"':
+		end := strings.IndexByte(s[p.pos+1:], '"')
+		if end < 0 {
+			return posErrorf(start, "missing closing quote for string")
+		}
+		p.tok = token{kind: tokString, text: s[p.pos+1 : p.pos+1+end], pos: start}
+		p.pos += end + 2
+	case isDigit(c) || c == '.':
+		for p.pos < len(s) && (isDigit(s[p.pos]) || s[p.pos] == '.') {
 			p.pos++
-		case !inQuotes && string(r) == bopen:
-			ic, ok := findClosingBraceIdx(p.expression[p.pos+1:])
-			if !ok {
-				return nil, errors.Errorf("no closing brace found for open brace at %d", p.pos)
+		}
+		if p.pos < len(s) && strings.IndexByte("EeDd", s[p.pos]) >= 0 {
+			exp := p.pos + 1
+			if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+				exp++
 			}
-			bexpr := p.expression[p.pos+1 : p.pos+1+ic]
-			curr = strings.TrimSpace(curr)
-			if curr != "" {
-				// curr should be a function name
-				sl := splitArgs(bexpr)
-				args := []Evaler{}
-				for _, s := range sl {
-					vp, err := NewParser(s).Parse()
-					if err != nil {
-						return nil, err
-					}
-					args = append(args, vp)
-				}
-				push(FuncEvaler{
-					Name: curr,
-					Args: args,
-				})
-			} else {
-				vp, err := NewParser(bexpr).Parse()
-				if err != nil {
-					return nil, err
+			if exp < len(s) && isDigit(s[exp]) {
+				p.pos = exp
+				for p.pos < len(s) && isDigit(s[p.pos]) {
+					p.pos++
 				}
-				vp.Encapsulate()
-				push(vp)
 			}
-			p.pos = p.pos + 1 + ic + 1
-		case !inQuotes && string(r) == bclose:
-			return nil, errors.Errorf("unexpected closing brace at %d", p.pos)
-		// case isOneOf(string(r), []string{plus, minus, times, div, exp}):
-		// 	err := pushCurr()
-		// 	if err != nil {
-		// 		return nil, err
-		// 	}
-		// 	lastOp = string(r)
-		// 	p.pos++
-		case inQuotes:
-			curr += string(r)
+		}
+		if p.pos < len(s) && strings.IndexByte("%!#", s[p.pos]) >= 0 {
 			p.pos++
-		default:
-			if op, ok := peekOp(); ok {
-				err := pushCurr()
-				if err != nil {
-					return nil, err
-				}
-				lastOp = op
+		}
+		p.tok = token{kind: tokNumber, text: s[start:p.pos], pos: start}
+	case isLetter(c):
+		for p.pos < len(s) && (isLetter(s[p.pos]) || isDigit(s[p.pos]) || s[p.pos] == '_') {
+			p.pos++
+		}
+		if p.pos < len(s) && strings.IndexByte("$%!#", s[p.pos]) >= 0 {
+			p.pos++
+		}
+		text := s[start:p.pos]
+		if keywordOps[text] {
+			p.tok = token{kind: tokOp, text: text, pos: start}
+		} else {
+			p.tok = token{kind: tokIdent, text: text, pos: start}
+		}
+	default:
+		for _, op := range ops {
+			if strings.HasPrefix(s[p.pos:], op) {
+				p.tok = token{kind: tokOp, text: op, pos: start}
 				p.pos += len(op)
-			} else {
-				curr += string(r)
-				p.pos++
+				return nil
 			}
 		}
+		return posErrorf(start, "unexpected character %q", c)
+	}
+	return nil
+}
+
+// ParseNumber parses a numeric constant. Like in Microsoft BASIC constants are single precision, unless
+// they have more than 7 digits, a D exponent or a type suffix.
+func ParseNumber(text string) (Evaler, error) {
+	t, hasSuffix := TypeOfSuffix(text)
+	if hasSuffix {
+		text = text[:len(text)-1]
+	} else {
+		t = TypeSingle
+		if strings.ContainsAny(text, "Dd") || significantDigits(text) > 7 {
+			t = TypeDouble
+		}
 	}
-	err := pushCurr()
+	f, err := ParseFloat(text)
 	if err != nil {
 		return nil, err
 	}
-	if stack == nil {
-		return nil, errors.Errorf("no elements on the eval-stack")
+	v, err := t.Number(f)
+	if err != nil {
+		return nil, err
 	}
+	switch v := v.(type) {
+	case int16:
+		return MakeNumberEvaler(v), nil
+	case float32:
+		return MakeNumberEvaler(v), nil
+	case float64:
+		return MakeNumberEvaler(v), nil
+	default:
+		return nil, ErrTypeMismatch
+	}
+}
 
-	return stack, nil
+// ParseFloat parses a number in the syntax of BASIC, which is an optional sign, digits with an optional decimal
+// point and an optional E or D exponent. Numbers too large for a double are an overflow.
+func ParseFloat(text string) (float64, error) {
+	if !isNumber(text) {
+		return 0, errors.Errorf("invalid number %q", text)
+	}
+	f, err := strconv.ParseFloat(strings.NewReplacer("D", "E", "d", "E").Replace(text), 64)
+	if err != nil {
+		return 0, ErrOverflow
+	}
+	return f, nil
 }
 
-func findClosingBraceIdx(s string) (int, bool) {
-	nopen := 0
-	for i := 0; i < len(s); i++ {
-		switch string(s[i]) {
-		case bopen:
-			nopen++
-		case bclose:
-			if nopen == 0 {
-				return i, true
-			}
-			nopen--
+func isNumber(text string) bool {
+	i := 0
+	skipSign := func() {
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+	}
+	skipDigits := func() int {
+		start := i
+		for i < len(text) && isDigit(text[i]) {
+			i++
+		}
+		return i - start
+	}
+	skipSign()
+	digits := skipDigits()
+	if i < len(text) && text[i] == '.' {
+		i++
+		digits += skipDigits()
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < len(text) && strings.IndexByte("EeDd", text[i]) >= 0 {
+		i++
+		skipSign()
+		if skipDigits() == 0 {
+			return false
 		}
 	}
-	return -1, false
+	return i == len(text)
 }
 
-func splitArgs(expr string) []string {
-	args := []string{}
-	curr := ""
-	nopen := 0
-	for i := 0; i < len(expr); i++ {
-		switch string(expr[i]) {
-		case bopen:
-			nopen++
-		case bclose:
-			nopen--
-		case ",":
-			if nopen == 0 {
-				args = append(args, curr)
-				curr = ""
-				continue
-			}
-		}
-		curr += string(expr[i])
+func significantDigits(text string) int {
+	mantissa, _, _ := strings.Cut(strings.ToUpper(text), "E")
+	digits := strings.TrimLeft(strings.Replace(mantissa, ".", "", 1), "0")
+	if strings.Contains(mantissa, ".") {
+		digits = strings.TrimRight(digits, "0")
 	}
-	args = append(args, curr)
-	return args
+	return len(digits)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
@@ -0,0 +1,216 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// The errors of Microsoft BASIC, a program may run into while evaluating expressions
+var (
+	ErrTypeMismatch   = errors.New("Type mismatch")
+	ErrOverflow       = errors.New("Overflow")
+	ErrDivisionByZero = errors.New("Division by zero")
+	// ErrIllegalFunctionCall is returned for arguments out of the domain of a function like SQR(-1)
+	ErrIllegalFunctionCall = errors.New("Illegal function call")
+)
+
+// Type is the type of a value. Like in Microsoft BASIC numbers are INTEGER (int16), SINGLE (float32)
+// or DOUBLE (float64) precision.
+type Type int
+
+const (
+	TypeSingle Type = iota
+	TypeInteger
+	TypeDouble
+	TypeString
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeSingle:
+		return "SINGLE"
+	case TypeInteger:
+		return "INTEGER"
+	case TypeDouble:
+		return "DOUBLE"
+	case TypeString:
+		return "STRING"
+	default:
+		return ""
+	}
+}
+
+// Suffix is the type character of variables and constants of type t
+func (t Type) Suffix() string {
+	switch t {
+	case TypeSingle:
+		return "!"
+	case TypeInteger:
+		return "%"
+	case TypeDouble:
+		return "#"
+	case TypeString:
+		return "$"
+	default:
+		return ""
+	}
+}
+
+// TypeOfSuffix returns the type, a variable name or constant declares with its last character
+func TypeOfSuffix(s string) (Type, bool) {
+	if s == "" {
+		return 0, false
+	}
+	switch s[len(s)-1] {
+	case '!':
+		return TypeSingle, true
+	case '%':
+		return TypeInteger, true
+	case '#':
+		return TypeDouble, true
+	case '$':
+		return TypeString, true
+	default:
+		return 0, false
+	}
+}
+
+// TypeOf returns the type of a value
+func TypeOf(v interface{}) Type {
+	switch v.(type) {
+	case string:
+		return TypeString
+	case float32:
+		return TypeSingle
+	case int16, int, int32, int64:
+		return TypeInteger
+	default:
+		return TypeDouble
+	}
+}
+
+func (t Type) Zero() interface{} {
+	switch t {
+	case TypeInteger:
+		return int16(0)
+	case TypeDouble:
+		return float64(0)
+	case TypeString:
+		return ""
+	default:
+		return float32(0)
+	}
+}
+
+// precision orders the numeric types
+func (t Type) precision() int {
+	switch t {
+	case TypeInteger:
+		return 0
+	case TypeSingle:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Number converts f to a number of type t. Integers are rounded and must fit into 16 bits.
+// Infinite numbers overflow and NaN is the result of an illegal function call, BASIC has neither of them.
+func (t Type) Number(f float64) (interface{}, error) {
+	switch t {
+	case TypeInteger:
+		n, err := ToInt(f)
+		if err != nil {
+			return nil, err
+		}
+		return int16(n), nil
+	case TypeSingle:
+		if err := CheckFinite(f); err != nil {
+			return nil, err
+		}
+		if math.Abs(f) > math.MaxFloat32 {
+			return nil, ErrOverflow
+		}
+		return float32(f), nil
+	case TypeDouble:
+		if err := CheckFinite(f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, ErrTypeMismatch
+	}
+}
+
+// CheckFinite returns ErrOverflow for an infinite f and ErrIllegalFunctionCall for NaN
+func CheckFinite(f float64) error {
+	switch {
+	case math.IsNaN(f):
+		return ErrIllegalFunctionCall
+	case math.IsInf(f, 0):
+		return ErrOverflow
+	default:
+		return nil
+	}
+}
+
+// Convert converts v to type t, like an assignment to a variable of type t does
+func (t Type) Convert(v interface{}) (interface{}, error) {
+	str, isStr := v.(string)
+	if isStr != (t == TypeString) {
+		return nil, ErrTypeMismatch
+	}
+	if isStr {
+		return str, nil
+	}
+	f, err := ConvertToFloat(v)
+	if err != nil {
+		return nil, err
+	}
+	return t.Number(f)
+}
+
+// ResultType is the type of the result of op applied on operands of the types t1 and t2.
+// Arithmetic is done with the precision of the more precise operand, / and ^ at least with single precision.
+// Comparisons, \, MOD and the logical operators result in integers.
+func ResultType(op Op, t1, t2 Type) (Type, error) {
+	if (t1 == TypeString) != (t2 == TypeString) {
+		return 0, ErrTypeMismatch
+	}
+	if t1 == TypeString {
+		switch {
+		case op == OpPlus:
+			return TypeString, nil
+		case op.IsRelational():
+			return TypeInteger, nil
+		default:
+			return 0, ErrTypeMismatch
+		}
+	}
+	switch op {
+	case OpDiv, OpExp:
+		if t1 == TypeDouble || t2 == TypeDouble {
+			return TypeDouble, nil
+		}
+		return TypeSingle, nil
+	case OpPlus, OpMinus, OpTimes:
+		if t2.precision() > t1.precision() {
+			return t2, nil
+		}
+		return t1, nil
+	default:
+		return TypeInteger, nil
+	}
+}
+
+// UnaryResultType is the type of the result of op applied on an operand of type t
+func UnaryResultType(op Op, t Type) (Type, error) {
+	if t == TypeString {
+		return 0, ErrTypeMismatch
+	}
+	if op == OpNOT {
+		return TypeInteger, nil
+	}
+	return t, nil
+}
@@ -2,6 +2,7 @@ package expr
 
 import (
 	"constraints"
+	"math"
 	"reflect"
 
 	"github.com/pkg/errors"
@@ -18,11 +19,6 @@ func ConvertToFloat(v interface{}) (float64, error) {
 	return rv.Convert(typeOfFloat64).Interface().(float64), nil
 }
 
-func canConvertToFloat(v interface{}) bool {
-	rv := reflect.ValueOf(v)
-	return rv.CanConvert(typeOfFloat64)
-}
-
 func ConvertToString(v interface{}) (string, error) {
 	rv := reflect.ValueOf(v)
 	if !rv.CanConvert(typeOfString) {
@@ -31,141 +27,154 @@ func ConvertToString(v interface{}) (string, error) {
 	return rv.Convert(typeOfString).Interface().(string), nil
 }
 
-func canConvertToString(v interface{}) bool {
-	rv := reflect.ValueOf(v)
-	return rv.CanConvert(typeOfString)
-}
+// True is the value of a true condition. Like in Microsoft BASIC it has all bits set, so that AND, OR and NOT
+// work for conditions and bit masks alike.
+const True = -1
 
-//
-func lessFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 < t2 {
-		return 1
+func BoolToFloat(b bool) float64 {
+	if b {
+		return True
 	}
 	return 0
 }
 
-func greaterFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 > t2 {
-		return 1
+func BoolToInt(b bool) int16 {
+	if b {
+		return True
 	}
 	return 0
 }
 
-func equalFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 == t2 {
-		return 1
-	}
-	return 0
+// Truth tells if a value is a true condition
+func Truth(f float64) bool {
+	return f != 0
 }
 
-func notEqualFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 != t2 {
-		return 1
+// ToInt converts the operand of an integer operator like MOD or AND to a 16-bit integer
+func ToInt(f float64) (int64, error) {
+	n := math.Round(f)
+	if n < math.MinInt16 || n > math.MaxInt16 {
+		return 0, ErrOverflow
 	}
-	return 0
+	return int64(n), nil
 }
 
-func lessEqualFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 <= t2 {
-		return 1
-	}
-	return 0
-}
-
-func greaterEqualFloat[T constraints.Ordered](t1, t2 T) float64 {
-	if t1 >= t2 {
-		return 1
-	}
-	return 0
-}
-
-//
-func floatOp(v1, v2 interface{}, op func(f1, f2 float64) float64) (interface{}, error) {
-	f1, err := ConvertToFloat(v1)
+func UnaryOp(op Op, v interface{}) (interface{}, error) {
+	t, err := UnaryResultType(op, TypeOf(v))
 	if err != nil {
 		return nil, err
 	}
-	f2, err := ConvertToFloat(v2)
+	f, err := ConvertToFloat(v)
 	if err != nil {
 		return nil, err
 	}
-	return op(f1, f2), nil
+	switch op {
+	case OpNeg:
+		return t.Number(-f)
+	case OpNOT:
+		n, err := ToInt(f)
+		if err != nil {
+			return nil, err
+		}
+		return int16(^n), nil
+	default:
+		return nil, errors.Errorf("invalid unary operator %q", op)
+	}
 }
 
-func stringToFloatOp(v1, v2 interface{}, op func(f1, f2 string) float64) (interface{}, error) {
-	f1, err := ConvertToString(v1)
+// BinaryOp applies op on two values. The type of the result is given by ResultType.
+func BinaryOp(op Op, v1, v2 interface{}) (interface{}, error) {
+	t, err := ResultType(op, TypeOf(v1), TypeOf(v2))
 	if err != nil {
 		return nil, err
 	}
-	f2, err := ConvertToString(v2)
-	if err != nil {
-		return nil, err
+	if s1, ok := v1.(string); ok {
+		s2 := v2.(string)
+		if op == OpPlus {
+			return s1 + s2, nil
+		}
+		return BoolToInt(compare(op, s1, s2)), nil
 	}
-	return op(f1, f2), nil
-}
 
-func stringOp(v1, v2 interface{}, op func(f1, f2 string) string) (interface{}, error) {
-	f1, err := ConvertToString(v1)
+	f1, err := ConvertToFloat(v1)
 	if err != nil {
 		return nil, err
 	}
-	f2, err := ConvertToString(v2)
+	f2, err := ConvertToFloat(v2)
 	if err != nil {
 		return nil, err
 	}
-	return op(f1, f2), nil
-}
-
-func less(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, lessFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, lessFloat[string])
-	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
-}
-
-func greater(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, greaterFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, greaterFloat[string])
+	if op.IsRelational() {
+		return BoolToInt(compare(op, f1, f2)), nil
 	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
-}
-
-func equal(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, equalFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, equalFloat[string])
+	f, err := FloatOp(op, f1, f2)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
+	return t.Number(f)
 }
 
-func notEqual(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, notEqualFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, notEqualFloat[string])
+// FloatOp applies an arithmetic or logical operator on numbers
+func FloatOp(op Op, f1, f2 float64) (float64, error) {
+	switch op {
+	case OpPlus:
+		return f1 + f2, nil
+	case OpMinus:
+		return f1 - f2, nil
+	case OpTimes:
+		return f1 * f2, nil
+	case OpDiv:
+		if f2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return f1 / f2, nil
+	case OpExp:
+		return math.Pow(f1, f2), nil
 	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
-}
 
-func lessEqual(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, lessEqualFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, lessEqualFloat[string])
+	n1, err := ToInt(f1)
+	if err != nil {
+		return 0, err
 	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
-}
-
-func greaterEqual(v1, v2 interface{}) (interface{}, error) {
-	if canConvertToFloat(v1) {
-		return floatOp(v1, v2, greaterEqualFloat[float64])
-	} else if canConvertToString(v1) {
-		return stringToFloatOp(v1, v2, greaterEqualFloat[string])
+	n2, err := ToInt(f2)
+	if err != nil {
+		return 0, err
+	}
+	switch op {
+	case OpIntDiv, OpMOD:
+		if n2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if op == OpIntDiv {
+			return float64(n1 / n2), nil
+		}
+		return float64(n1 % n2), nil
+	case OpAND:
+		return float64(n1 & n2), nil
+	case OpOR:
+		return float64(n1 | n2), nil
+	case OpXOR:
+		return float64(n1 ^ n2), nil
+	default:
+		return 0, errors.Errorf("invalid operator %q", op)
+	}
+}
+
+func compare[T constraints.Ordered](op Op, t1, t2 T) bool {
+	switch op {
+	case OpLs:
+		return t1 < t2
+	case OpGt:
+		return t1 > t2
+	case OpEq:
+		return t1 == t2
+	case OpNotEq:
+		return t1 != t2
+	case OpLsEq:
+		return t1 <= t2
+	case OpGtEq:
+		return t1 >= t2
+	default:
+		return false
 	}
-	return nil, errors.Errorf("incompatible types %T, %T", v1, v2)
 }
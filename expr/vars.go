@@ -1,6 +1,10 @@
 package expr
 
-import "github.com/pkg/errors"
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
 
 func NewVars() *Vars {
 	return &Vars{
@@ -24,6 +28,16 @@ func (vs *Vars) LookupVar(name string) (interface{}, error) {
 	return v, nil
 }
 
+// Names returns the sorted names of the variables
+func (vs *Vars) Names() []string {
+	names := make([]string, 0, len(vs.vars))
+	for name := range vs.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (vs *Vars) CanEvalFloat(name string) bool {
 	_, ok := vs.vars[name]
 	if !ok {
@@ -32,3 +46,34 @@ func (vs *Vars) CanEvalFloat(name string) bool {
 	//return canConvertToFloat(v)
 	return true
 }
+
+// Scope holds local variables and resolves all other variables with its parent
+func NewScope(parent Lookuper) *Scope {
+	return &Scope{
+		vars:   NewVars(),
+		parent: parent,
+	}
+}
+
+type Scope struct {
+	vars   *Vars
+	parent Lookuper
+}
+
+func (sc *Scope) Add(name string, value interface{}) {
+	sc.vars.Add(name, value)
+}
+
+func (sc *Scope) LookupVar(name string) (interface{}, error) {
+	if v, ok := sc.vars.vars[name]; ok {
+		return v, nil
+	}
+	return sc.parent.LookupVar(name)
+}
+
+func (sc *Scope) CanEvalFloat(name string) bool {
+	if sc.vars.CanEvalFloat(name) {
+		return true
+	}
+	return sc.parent.CanEvalFloat(name)
+}
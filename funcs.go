@@ -1,11 +1,10 @@
 package gobas
 
 import (
-	"fmt"
 	"math"
 	"math/rand"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/mazzegi/gobas/expr"
 	"github.com/pkg/errors"
@@ -13,25 +12,38 @@ import (
 
 func BuiltinFuncs() *expr.Funcs {
 	fs := expr.NewFuncs()
+	addNum := func(name string, fnc expr.FloatFunc) {
+		fs.AddFunc(name, func(vs []interface{}) (interface{}, error) {
+			f, err := fnc(vs)
+			if err != nil {
+				return nil, err
+			}
+			types := make([]expr.Type, len(vs))
+			for i, v := range vs {
+				types[i] = expr.TypeOf(v)
+			}
+			return FuncType(name, types).Number(f)
+		})
+	}
 
-	fs.AddFloatFunc("ABS", func(vs []interface{}) (float64, error) {
+	addNum("ABS", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		return math.Abs(a), nil
 	})
-	fs.AddFunc("ASC", func(vs []interface{}) (interface{}, error) {
+	addNum("ASC", func(vs []interface{}) (float64, error) {
 		var a string
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		if a == "" {
-			return nil, errors.Errorf("ASC on empty string")
+			return 0, errors.Errorf("ASC on empty string")
 		}
-		return int(a[0]), nil
+		return float64(a[0]), nil
 	})
-	fs.AddFloatFunc("ATN", func(vs []interface{}) (float64, error) {
+	addNum("ATN", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
@@ -45,21 +57,21 @@ func BuiltinFuncs() *expr.Funcs {
 		}
 		return string([]byte{byte(a)}), nil
 	})
-	fs.AddFloatFunc("COS", func(vs []interface{}) (float64, error) {
+	addNum("COS", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		return math.Cos(a), nil
 	})
-	fs.AddFloatFunc("EXP", func(vs []interface{}) (float64, error) {
+	addNum("EXP", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		return math.Exp(a), nil
 	})
-	fs.AddFloatFunc("INT", func(vs []interface{}) (float64, error) {
+	addNum("INT", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
@@ -83,18 +95,21 @@ func BuiltinFuncs() *expr.Funcs {
 		}
 		return s[:num], nil
 	})
-	fs.AddFunc("LEN", func(vs []interface{}) (interface{}, error) {
+	addNum("LEN", func(vs []interface{}) (float64, error) {
 		var s string
 		if err := expr.ScanArgs(vs, &s); err != nil {
 			return 0, err
 		}
-		return len(s), nil
+		return float64(len(s)), nil
 	})
-	fs.AddFloatFunc("LOG", func(vs []interface{}) (float64, error) {
+	addNum("LOG", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
+		if a <= 0 {
+			return 0, expr.ErrIllegalFunctionCall
+		}
 		return math.Log(a), nil
 	})
 	fs.AddFunc("MID$", func(vs []interface{}) (interface{}, error) {
@@ -113,8 +128,24 @@ func BuiltinFuncs() *expr.Funcs {
 		}
 		return s[idx:toIdx], nil
 	})
-	fs.AddFloatFunc("RND", func(vs []interface{}) (float64, error) {
-		return rand.Float64(), nil
+	// like in Microsoft BASIC a negative argument reseeds the generator, zero repeats the last number
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var lastRnd float32
+	addNum("RND", func(vs []interface{}) (float64, error) {
+		var a float64 = 1
+		if len(vs) > 0 {
+			if err := expr.ScanArgs(vs, &a); err != nil {
+				return 0, err
+			}
+		}
+		switch {
+		case a < 0:
+			rnd.Seed(int64(a))
+		case a == 0:
+			return float64(lastRnd), nil
+		}
+		lastRnd = rnd.Float32()
+		return float64(lastRnd), nil
 	})
 	fs.AddFunc("RIGHT$", func(vs []interface{}) (interface{}, error) {
 		var s string
@@ -129,7 +160,7 @@ func BuiltinFuncs() *expr.Funcs {
 
 		return s[fromIdx:], nil
 	})
-	fs.AddFloatFunc("SGN", func(vs []interface{}) (float64, error) {
+	addNum("SGN", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
@@ -143,42 +174,40 @@ func BuiltinFuncs() *expr.Funcs {
 			return 1, nil
 		}
 	})
-	fs.AddFloatFunc("SIN", func(vs []interface{}) (float64, error) {
+	addNum("SIN", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		return math.Sin(a), nil
 	})
-	fs.AddFloatFunc("SQR", func(vs []interface{}) (float64, error) {
+	addNum("SQR", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
+		if a < 0 {
+			return 0, expr.ErrIllegalFunctionCall
+		}
 		return math.Sqrt(a), nil
 	})
 	fs.AddFunc("STR$", func(vs []interface{}) (interface{}, error) {
-		var a float64
-		if err := expr.ScanArgs(vs, &a); err != nil {
-			return 0, err
+		if len(vs) != 1 {
+			return nil, errors.Errorf("expect 1 args, got %d", len(vs))
 		}
-		return fmt.Sprintf("%f", a), nil
-	})
-	fs.AddFunc("TAB", func(vs []interface{}) (interface{}, error) {
-		var a int
-		if err := expr.ScanArgs(vs, &a); err != nil {
-			return 0, err
+		if _, ok := vs[0].(string); ok {
+			return nil, expr.ErrTypeMismatch
 		}
-		return strings.Repeat(" ", a), nil
+		return FormatNumber(vs[0]), nil
 	})
-	fs.AddFloatFunc("TAN", func(vs []interface{}) (float64, error) {
+	addNum("TAN", func(vs []interface{}) (float64, error) {
 		var a float64
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
 		}
 		return math.Tan(a), nil
 	})
-	fs.AddFloatFunc("VAL", func(vs []interface{}) (float64, error) {
+	addNum("VAL", func(vs []interface{}) (float64, error) {
 		var a string
 		if err := expr.ScanArgs(vs, &a); err != nil {
 			return 0, err
@@ -192,3 +221,25 @@ func BuiltinFuncs() *expr.Funcs {
 
 	return fs
 }
+
+// FuncType is the type of the result of the builtin function name, called with arguments of the given types.
+// Like in Microsoft BASIC numeric functions are single precision, unless their argument is double precision.
+func FuncType(name string, args []expr.Type) expr.Type {
+	switch name {
+	case "ASC", "LEN", "SGN":
+		return expr.TypeInteger
+	case "ABS", "INT":
+		if len(args) > 0 && args[0] != expr.TypeString {
+			return args[0]
+		}
+	case "RND", "VAL":
+		return expr.TypeSingle
+	}
+	if IsString(name) {
+		return expr.TypeString
+	}
+	if len(args) > 0 && args[0] == expr.TypeDouble {
+		return expr.TypeDouble
+	}
+	return expr.TypeSingle
+}
@@ -2,6 +2,7 @@ package gobas
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mazzegi/gobas/testutil"
@@ -50,10 +51,107 @@ func TestInput(t *testing.T) {
 
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("test #%02d", i), func(t *testing.T) {
-			res := mustParseInput(test.raw)
-			if !inputsEqual(test.expect, res) {
+			stmts, err := NewParser().ParseStmts("INPUT" + test.raw)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			res, ok := stmts[0].(INPUT)
+			if !ok || !inputsEqual(test.expect, res) {
 				t.Fatalf("want %v, got %v", test.expect, res)
 			}
 		})
 	}
 }
+
+func TestRunInput(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		input      string
+		expect     string
+		expectExit Exit
+	}{
+		{
+			name:       "prompt with semicolon",
+			src:        `10 INPUT "NAME";A$: PRINT A$`,
+			input:      "HELLO WORLD\n",
+			expect:     "NAME? HELLO WORLD\n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "prompt with comma",
+			src:        `10 INPUT "NAME",A$: PRINT A$`,
+			input:      "BOB\n",
+			expect:     "NAMEBOB\n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "quoted strings",
+			src:        `10 INPUT A$,B$,C: PRINT A$;"|";B$;"|";C`,
+			input:      ` "A, B" ,  C D  ,` + "\n",
+			expect:     "? A, B|C D| 0 \n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "redo from start",
+			src:        `10 INPUT "N";N%,X: PRINT N%;X`,
+			input:      "A,1\n40000,2\n7,2.5\n",
+			expect:     "N? ?Redo from start\nN? ?Redo from start\nN?  7  2.5 \n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "redo on numbers not in BASIC syntax",
+			src:        `10 INPUT X: PRINT X`,
+			input:      "inf\nNaN\n0x1p3\n1_0\n-1.5D1\n",
+			expect:     "? ?Redo from start\n? ?Redo from start\n? ?Redo from start\n? ?Redo from start\n? -15 \n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "more values",
+			src:        `10 DIM A(2): INPUT A(1),A(2): PRINT A(1)+A(2)`,
+			input:      "1\n2\n",
+			expect:     "? ??  3 \n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "extra ignored",
+			src:        `10 INPUT A: PRINT A`,
+			input:      "1,2\n",
+			expect:     "? ?Extra ignored\n 1 \n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "line input",
+			src:        `10 LINE INPUT "> ";L$: PRINT "[";L$;"]"`,
+			input:      ` "A", B ` + "\n",
+			expect:     `> [ "A", B ]` + "\n",
+			expectExit: ExitLastLine,
+		},
+		{
+			name:       "end of input",
+			src:        "10 INPUT A\n20 PRINT A: GOTO 10",
+			input:      "1\n2",
+			expect:     "?  1 \n?  2 \n? ",
+			expectExit: ExitEnd,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, exit, err := runProgram(t, test.src, test.input)
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+			if exit != test.expectExit {
+				t.Fatalf("want exit %v, got %v", test.expectExit, exit)
+			}
+		})
+	}
+
+	_, _, err := runProgram(t, `10 LINE INPUT A`, "1\n")
+	if err == nil || !strings.Contains(err.Error(), "Type mismatch") {
+		t.Fatalf("want type mismatch, got %v", err)
+	}
+}
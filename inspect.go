@@ -0,0 +1,239 @@
+package gobas
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Hook is called before each statement a program runs. If it returns an error, the program fails with it.
+type Hook func(loc Location) error
+
+// SetHook sets the hook, which debuggers use to stop a running program and to inspect it
+func (s *State) SetHook(h Hook) {
+	s.hook = h
+}
+
+// Location is a statement of the program. StmtIdx counts the statements in the branches of single-line IFs
+// like RuntimeError does.
+type Location struct {
+	Line    int
+	StmtIdx int
+	Stmt    string
+	// SourceLine is the 1-based line of the source
+	SourceLine int
+}
+
+func (s *State) location(line Line, stmtIdx int) Location {
+	loc := Location{
+		Line:       line.num,
+		StmtIdx:    stmtIdx,
+		SourceLine: line.SourceLine(),
+	}
+	if stmtIdx < len(line.code) {
+		loc.Stmt = StmtKind(line.code[stmtIdx])
+	}
+	return loc
+}
+
+// Location returns the statement, the program runs next
+func (s *State) Location() Location {
+	line, _ := s.line(s.currIdx)
+	return s.location(line, s.stmtIdx)
+}
+
+// Depth is the number of active GOSUBs and calls of procedures
+func (s *State) Depth() int {
+	return len(s.gosubStack) + len(s.frames)
+}
+
+// Variable is a variable with its value
+type Variable struct {
+	Name  string
+	Value interface{}
+}
+
+// Variables returns the variables of the current scope, which have been assigned, sorted by their names.
+// Inside of a procedure these are its local, STATIC and SHARED variables and its parameters.
+func (s *State) Variables() []Variable {
+	f := s.frame()
+	if f == nil {
+		return s.Globals()
+	}
+	names := map[string]bool{}
+	for _, name := range f.vars.Names() {
+		names[name] = true
+	}
+	for _, name := range f.proc.statics.Names() {
+		names[name] = f.static[name]
+	}
+	for name := range f.refs {
+		names[name] = true
+	}
+	for name := range f.shared {
+		names[name] = true
+	}
+	var vs []Variable
+	for name, ok := range names {
+		if !ok {
+			continue
+		}
+		v, _ := f.LookupVar(name)
+		vs = append(vs, Variable{Name: name, Value: v})
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].Name < vs[j].Name })
+	return vs
+}
+
+// Globals returns the global variables, which have been assigned, sorted by their names
+func (s *State) Globals() []Variable {
+	if s.globals.Vars == nil {
+		return nil
+	}
+	var vs []Variable
+	for _, name := range s.globals.Names() {
+		v, _ := s.globals.LookupVar(name)
+		vs = append(vs, Variable{Name: name, Value: v})
+	}
+	return vs
+}
+
+// ArrayValue is an array with its elements
+type ArrayValue struct {
+	Name string
+	// Dims are the upper bounds of the dimensions
+	Dims []int
+	// Values are the elements in row-major order
+	Values []interface{}
+}
+
+// Arrays returns the arrays of the current scope sorted by their names
+func (s *State) Arrays() []ArrayValue {
+	names := map[string]bool{}
+	for name := range s.arrays {
+		names[name] = true
+	}
+	if f := s.frame(); f != nil {
+		for name := range f.arrays {
+			names[name] = true
+		}
+	}
+	var avs []ArrayValue
+	for name := range names {
+		a, ok := s.array(name)
+		if !ok {
+			continue
+		}
+		av := ArrayValue{Name: name}
+		switch a := a.(type) {
+		case *Array[string]:
+			av.Dims, av.Values = a.Dims(), a.Values()
+		case *Array[int16]:
+			av.Dims, av.Values = a.Dims(), a.Values()
+		case *Array[float32]:
+			av.Dims, av.Values = a.Dims(), a.Values()
+		case *Array[float64]:
+			av.Dims, av.Values = a.Dims(), a.Values()
+		}
+		avs = append(avs, av)
+	}
+	sort.Slice(avs, func(i, j int) bool { return avs[i].Name < avs[j].Name })
+	return avs
+}
+
+// ForLoop is an active FOR loop
+type ForLoop struct {
+	// Line is the line of the FOR
+	Line int
+	Var  string
+	To   float64
+	Step float64
+}
+
+// ForLoops returns the active FOR loops, the innermost last
+func (s *State) ForLoops() []ForLoop {
+	var fls []ForLoop
+	for _, fs := range s.forStates {
+		line, _ := s.line(fs.lineIdx)
+		fls = append(fls, ForLoop{Line: line.num, Var: fs.varName, To: fs.toValue, Step: fs.step})
+	}
+	return fls
+}
+
+// Eval evaluates a BASIC expression with the variables of the current scope
+func (s *State) Eval(src string) (interface{}, error) {
+	if s.vars == nil {
+		return nil, errors.New("program is not running")
+	}
+	ex, err := NewParser().ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	// the procedures called by src run without the hook, so debuggers don't stop in them
+	hook := s.hook
+	s.hook = nil
+	defer func() {
+		s.hook = hook
+	}()
+	return ex.Eval(s.vars, s.funcs)
+}
+
+// Frame is an active FOR loop, GOSUB or call of a procedure
+type Frame struct {
+	// Kind is FOR, GOSUB or CALL
+	Kind string
+	// Name is the variable of a FOR or the name of the called procedure
+	Name string
+	// Line is the line of the FOR, the GOSUB or the call
+	Line       int
+	SourceLine int
+}
+
+// Frames returns the active FOR loops, GOSUBs and calls in the order they were entered, the innermost last
+func (s *State) Frames() []Frame {
+	type entry struct {
+		frame Frame
+		// idx is the index in the stack of its kind, forDepth and gosubDepth the depths of the other stacks,
+		// when it was entered
+		idx, forDepth, gosubDepth int
+	}
+	var es []entry
+	for i, fs := range s.forStates {
+		line, _ := s.line(fs.lineIdx)
+		es = append(es, entry{frame: Frame{Kind: "FOR", Name: fs.varName, Line: line.num, SourceLine: line.SourceLine()}, idx: i})
+	}
+	for i, rp := range s.gosubStack {
+		line, _ := s.line(rp.lineIdx)
+		es = append(es, entry{frame: Frame{Kind: "GOSUB", Line: line.num, SourceLine: line.SourceLine()}, idx: i, forDepth: rp.forDepth})
+	}
+	for i, f := range s.frames {
+		line, _ := s.line(f.ret.lineIdx)
+		es = append(es, entry{
+			frame:      Frame{Kind: "CALL", Name: f.proc.name, Line: line.num, SourceLine: line.SourceLine()},
+			idx:        i,
+			forDepth:   f.ret.forDepth,
+			gosubDepth: f.ret.gosubDepth,
+		})
+	}
+	// before tells, if a was entered before b
+	before := func(a, b entry) bool {
+		switch {
+		case a.frame.Kind == b.frame.Kind:
+			return a.idx < b.idx
+		case a.frame.Kind == "FOR":
+			return a.idx < b.forDepth
+		case b.frame.Kind == "FOR":
+			return a.forDepth <= b.idx
+		case a.frame.Kind == "GOSUB":
+			return a.idx < b.gosubDepth
+		default:
+			return a.gosubDepth <= b.idx
+		}
+	}
+	sort.SliceStable(es, func(i, j int) bool { return before(es[i], es[j]) })
+	fs := make([]Frame, len(es))
+	for i, e := range es {
+		fs[i] = e.frame
+	}
+	return fs
+}
@@ -0,0 +1,88 @@
+package gobas
+
+import "fmt"
+
+// lineIndex maps line numbers to the indexes of their lines. Of duplicate line numbers the first one is indexed.
+type lineIndex map[int]int
+
+func indexLines(lines []Line) lineIndex {
+	idx := make(lineIndex, len(lines))
+	for i, l := range lines {
+		if _, ok := idx[l.num]; !ok {
+			idx[l.num] = i
+		}
+	}
+	return idx
+}
+
+// lookup returns the index of the line num or -1, if there is no such line
+func (idx lineIndex) lookup(num int) int {
+	if i, ok := idx[num]; ok {
+		return i
+	}
+	return -1
+}
+
+// Link checks the line numbers of lines, which must be unique and ascending, and the targets of GOTO, GOSUB,
+// IF ... THEN and ON ... GOTO/GOSUB. Unlike running the program, which fails when a jump to an undefined line
+// is taken, it reports all undefined targets at once.
+func Link(lines []Line) error {
+	return lineErrors(linkLines(lines))
+}
+
+func linkLines(lines []Line) []lineError {
+	var errs []lineError
+	first := map[int]Line{}
+	for i, l := range lines {
+		if other, ok := first[l.num]; ok {
+			errs = append(errs, lineError{
+				lineNum: l.num,
+				pos:     BlockPos{LineIdx: i},
+				msg:     fmt.Sprintf("duplicate line number, first defined in source line %d", other.SourceLine()),
+			})
+			continue
+		}
+		first[l.num] = l
+		if i > 0 && l.num < lines[i-1].num {
+			errs = append(errs, lineError{
+				lineNum: l.num,
+				pos:     BlockPos{LineIdx: i},
+				msg:     fmt.Sprintf("line number out of order, follows line %d", lines[i-1].num),
+			})
+		}
+	}
+	for i, l := range lines {
+		for j, stmt := range flatten(l.stmts) {
+			for _, num := range jumpTargets(stmt) {
+				if _, ok := first[num]; !ok {
+					errs = append(errs, lineError{
+						lineNum: l.num,
+						pos:     BlockPos{LineIdx: i, StmtIdx: j},
+						msg:     fmt.Sprintf("undefined line number %d", num),
+					})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// jumpTargets returns the line numbers, which a statement of the flattened code may jump to
+func jumpTargets(stmt Stmt) []int {
+	switch stmt := stmt.(type) {
+	case GOSUB:
+		return []int{stmt.Line}
+	case GOTO:
+		return []int{stmt.Line}
+	case IFLN:
+		return []int{stmt.Line}
+	case IFELSELN:
+		return []int{stmt.Line, stmt.ElseLine}
+	case ONGOSUB:
+		return stmt.Lines
+	case ONGOTO:
+		return stmt.Lines
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package gobas
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name: "undefined targets",
+			src: `
+				10 GOTO 100
+				20 IF A THEN 30 ELSE 110
+				30 ON A GOSUB 10,120,130: IF A THEN GOSUB 140
+				40 IF A THEN 50`,
+			expect: []string{
+				"in line 10: undefined line number 100",
+				"in line 20: undefined line number 110",
+				"in line 30: undefined line number 120",
+				"in line 30: undefined line number 130",
+				"in line 30: undefined line number 140",
+				"in line 40: undefined line number 50",
+			},
+		},
+		{
+			name:   "duplicate line numbers",
+			src:    "10 PRINT\n20 PRINT\n20 PRINT\n30 GOTO 20",
+			expect: []string{"in line 20: duplicate line number, first defined in source line 2"},
+		},
+		{
+			name:   "line numbers out of order",
+			src:    "10 PRINT\n30 PRINT\n20 GOTO 40",
+			expect: []string{"in line 20: line number out of order, follows line 30", "in line 20: undefined line number 40"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewParser().Parse(strings.NewReader(test.src))
+			if err == nil {
+				t.Fatalf("want error, got NO error")
+			}
+			if want := strings.Join(test.expect, "\n"); err.Error() != want {
+				t.Fatalf("want %q, got %q", want, err.Error())
+			}
+		})
+	}
+
+	_, err := NewParser().Parse(strings.NewReader("  GOTO done\n  PRINT\ndone:\n  END"))
+	if err != nil {
+		t.Fatalf("want labels to link, got %v", err)
+	}
+}
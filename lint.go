@@ -0,0 +1,859 @@
+package gobas
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// Diagnostic is a problem, Lint found in a program
+type Diagnostic struct {
+	// Line is the 1-based line of the source and Col the 1-based column of the statement in it
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	LineNum int    `json:"lineNum"`
+	Check   string `json:"check"`
+	Msg     string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Col, d.Msg)
+}
+
+// The checks of Lint
+const (
+	CheckUnreachable  = "unreachable"
+	CheckUnassigned   = "unassigned"
+	CheckArray        = "array"
+	CheckNext         = "next"
+	CheckReturn       = "return"
+	CheckData         = "data"
+	CheckTypes        = "types"
+	CheckJumpIntoLoop = "jump-into-loop"
+	// CheckLink are duplicate or unordered line numbers and jumps to undefined lines
+	CheckLink = "link"
+	// CheckBlocks are unbalanced blocks, procedures defined twice and jumps across the bounds of procedures
+	CheckBlocks = "blocks"
+	// CheckSyntax are the errors parsing a line
+	CheckSyntax = "syntax"
+)
+
+// implicitBound is the upper bound of arrays, which are used without DIM in Microsoft BASIC
+const implicitBound = 10
+
+// Lint checks the parsed lines of a program for likely errors, which don't prevent it from running:
+// unreachable lines, variables which are never assigned, arrays without DIM or with constant indexes out of
+// their bounds, NEXT without FOR, RETURN without GOSUB, READ without DATA, type mismatches and jumps into
+// the body of a FOR loop. The lines don't need to be linked, the errors of linking them and resolving their
+// blocks are reported as well. The diagnostics are sorted by their position.
+func Lint(lines []Line) []Diagnostic {
+	l := &linter{
+		lines:    lines,
+		code:     make([][]Stmt, len(lines)),
+		cols:     make([][]int, len(lines)),
+		idx:      indexLines(lines),
+		types:    DeclaredTypes(lines),
+		builtins: BuiltinFuncs(),
+		arrays:   map[string][]int{},
+		fns:      map[string]bool{},
+	}
+	for i, line := range lines {
+		// the statements of the branches of an IF have the column of the IF
+		for j, stmt := range line.stmts {
+			for _, cs := range flatten([]Stmt{stmt}) {
+				l.code[i] = append(l.code[i], cs)
+				l.cols[i] = append(l.cols[i], line.Col(j))
+			}
+		}
+	}
+	l.declare()
+	for _, e := range linkLines(lines) {
+		l.report(e.pos, CheckLink, e.msg)
+	}
+	blocks := Blocks{}
+	errs := blocks.resolveLines(lines, 0)
+	_, procErrs := collectProcs(lines)
+	for _, e := range append(errs, procErrs...) {
+		l.report(e.pos, CheckBlocks, e.msg)
+	}
+	if len(errs) == 0 {
+		l.blocks = blocks
+		l.checkFlow()
+	}
+	l.checkVars()
+	l.checkArrays()
+	l.checkNext()
+	l.checkData()
+	l.checkTypes()
+	l.checkJumpsIntoLoops()
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		if l.diags[i].Line != l.diags[j].Line {
+			return l.diags[i].Line < l.diags[j].Line
+		}
+		return l.diags[i].Col < l.diags[j].Col
+	})
+	return l.diags
+}
+
+// SyntaxDiagnostics returns the errors of parsing a program as diagnostics. Errors, which aren't located in
+// a line, have the line 0.
+func SyntaxDiagnostics(err error) []Diagnostic {
+	errs := []error{err}
+	if perrs, ok := err.(ParseErrors); ok {
+		errs = perrs
+	}
+	var diags []Diagnostic
+	for _, err := range errs {
+		d := Diagnostic{Check: CheckSyntax, Msg: err.Error()}
+		var lerr *LineError
+		if errors.As(err, &lerr) {
+			d.Line, d.LineNum, d.Msg = lerr.SourceLine, lerr.Line, lerr.Err.Error()
+		}
+		var serr *SyntaxError
+		if errors.As(err, &serr) {
+			d.Col, d.Msg = serr.Col, serr.Msg
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+type linter struct {
+	lines    []Line
+	code     [][]Stmt
+	cols     [][]int
+	idx      lineIndex
+	blocks   Blocks
+	types    DefTypes
+	builtins *expr.Funcs
+	// arrays are the DIMmed arrays and array parameters with their constant bounds, if they are known
+	arrays map[string][]int
+	// fns are the DEF FNs and FUNCTIONs
+	fns   map[string]bool
+	diags []Diagnostic
+}
+
+func (l *linter) report(pos BlockPos, check string, pattern string, args ...interface{}) {
+	line := l.lines[pos.LineIdx]
+	col := 0
+	if pos.StmtIdx < len(l.cols[pos.LineIdx]) {
+		col = l.cols[pos.LineIdx][pos.StmtIdx]
+	}
+	l.diags = append(l.diags, Diagnostic{
+		Line:    line.SourceLine(),
+		Col:     col,
+		LineNum: line.num,
+		Check:   check,
+		Msg:     fmt.Sprintf(pattern, args...),
+	})
+}
+
+func (l *linter) each(fn func(pos BlockPos, stmt Stmt)) {
+	for i, code := range l.code {
+		for j, stmt := range code {
+			fn(BlockPos{LineIdx: i, StmtIdx: j}, stmt)
+		}
+	}
+}
+
+func (l *linter) declare() {
+	l.each(func(pos BlockPos, stmt Stmt) {
+		switch stmt := stmt.(type) {
+		case DIM:
+			for _, ad := range stmt.Arrays {
+				var bounds []int
+				for _, d := range ad.Dimensions {
+					n, ok := constIndex(d.Tree)
+					if !ok {
+						bounds = nil
+						break
+					}
+					bounds = append(bounds, n)
+				}
+				if old, ok := l.arrays[ad.Var]; ok && !sameBounds(old, bounds) {
+					bounds = nil
+				}
+				l.arrays[ad.Var] = bounds
+			}
+		case SUB:
+			l.declareArrayParams(stmt.Params)
+		case FUNCTION:
+			l.fns[stmt.Name] = true
+			l.declareArrayParams(stmt.Params)
+		case SHARED:
+			l.declareArrayParams(stmt.Vars)
+		case DEF:
+			l.fns[stmt.Name] = true
+		}
+	})
+}
+
+func (l *linter) declareArrayParams(params []Param) {
+	for _, p := range params {
+		if _, ok := l.arrays[p.Name]; p.Array && !ok {
+			l.arrays[p.Name] = nil
+		}
+	}
+}
+
+func sameBounds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// constIndex returns the value of a constant array index
+func constIndex(ev expr.Evaler) (int, bool) {
+	switch ev := ev.(type) {
+	case expr.NumberEvaler[int16]:
+		return int(ev.V), true
+	case expr.NumberEvaler[float32]:
+		return int(ev.V), true
+	case expr.NumberEvaler[float64]:
+		return int(ev.V), true
+	default:
+		return 0, false
+	}
+}
+
+// exprs returns the expressions, a statement evaluates. The expression of a DEF is evaluated by its calls.
+func exprs(stmt Stmt) []Expr {
+	switch stmt := stmt.(type) {
+	case DIM:
+		var es []Expr
+		for _, ad := range stmt.Arrays {
+			es = append(es, ad.Dimensions...)
+		}
+		return es
+	case FOR:
+		return []Expr{stmt.Initial, stmt.To, stmt.Step}
+	case IFLN:
+		return []Expr{stmt.Expr}
+	case IFELSELN:
+		return []Expr{stmt.Expr}
+	case condJump:
+		return []Expr{stmt.expr}
+	case IFBLOCK:
+		return []Expr{stmt.Expr}
+	case ELSEIF:
+		return []Expr{stmt.Expr}
+	case WHILE:
+		return []Expr{stmt.Expr}
+	case DO:
+		if stmt.Cond != nil {
+			return []Expr{stmt.Cond.Expr}
+		}
+	case LOOP:
+		if stmt.Cond != nil {
+			return []Expr{stmt.Cond.Expr}
+		}
+	case CALL:
+		return stmt.Args
+	case LET:
+		return []Expr{stmt.Expr}
+	case ASSIGN:
+		return []Expr{stmt.Expr}
+	case ASSIGN_ARRAY:
+		return append([]Expr{stmt.Expr}, stmt.Array.Dimensions...)
+	case ONGOSUB:
+		return []Expr{stmt.Expr}
+	case ONGOTO:
+		return []Expr{stmt.Expr}
+	case PRINT:
+		return printExprs(stmt.Items)
+	case PRINTUSING:
+		return append([]Expr{stmt.Format}, printExprs(stmt.Items)...)
+	case INPUT:
+		return refIndexes(stmt.Vars)
+	case LINEINPUT:
+		return refIndexes([]string{stmt.Var})
+	case READ:
+		return refIndexes(stmt.Vars)
+	}
+	return nil
+}
+
+func printExprs(items []printItem) []Expr {
+	var es []Expr
+	for _, pi := range items {
+		switch pi := pi.(type) {
+		case Expr:
+			es = append(es, pi)
+		case PrintTab:
+			es = append(es, pi.Expr)
+		case PrintSpc:
+			es = append(es, pi.Expr)
+		}
+	}
+	return es
+}
+
+func refIndexes(refs []string) []Expr {
+	var es []Expr
+	for _, ref := range refs {
+		if isArray(ref) {
+			es = append(es, mustParseArray(ref).Dimensions...)
+		}
+	}
+	return es
+}
+
+// walk calls fn for ev and all of its subexpressions
+func walk(ev expr.Evaler, fn func(ev expr.Evaler)) {
+	fn(ev)
+	switch ev := ev.(type) {
+	case expr.UnaryEvaler:
+		walk(ev.X, fn)
+	case expr.BinaryEvaler:
+		walk(ev.X, fn)
+		walk(ev.Y, fn)
+	case expr.FuncEvaler:
+		for _, arg := range ev.Args {
+			walk(arg, fn)
+		}
+	}
+}
+
+// checkFlow follows all jumps from the start of the program and the starts of the procedures and reports the
+// lines, which are never reached. Without following GOSUBs, no RETURN must be reached.
+func (l *linter) checkFlow() {
+	roots := []BlockPos{l.norm(BlockPos{})}
+	l.each(func(pos BlockPos, stmt Stmt) {
+		switch stmt.(type) {
+		case SUB, FUNCTION:
+			roots = append(roots, l.behind(pos))
+		}
+	})
+	reached := l.reach(roots, true)
+	for i, code := range l.code {
+		if !executable(code) {
+			continue
+		}
+		var ok bool
+		for j := range code {
+			ok = ok || reached[BlockPos{LineIdx: i, StmtIdx: j}]
+		}
+		if !ok {
+			l.report(BlockPos{LineIdx: i}, CheckUnreachable, "line %d is unreachable", l.lines[i].num)
+		}
+	}
+
+	reached = l.reach(roots[:1], false)
+	l.each(func(pos BlockPos, stmt Stmt) {
+		if _, ok := stmt.(RETURN); ok && reached[pos] {
+			l.report(pos, CheckReturn, "RETURN is reachable without GOSUB")
+		}
+	})
+}
+
+// executable tells, if code has statements besides REM, DATA, DEFINT etc. and the starts and ends of IFs and
+// procedures, which don't need to be reached
+func executable(code []Stmt) bool {
+	for _, stmt := range code {
+		switch stmt.(type) {
+		case REM, DATA, DEFTYPE, ENDIF, SUB, FUNCTION, ENDSUB, ENDFUNCTION:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) reach(roots []BlockPos, gosubs bool) map[BlockPos]bool {
+	reached := map[BlockPos]bool{}
+	todo := roots
+	for len(todo) > 0 {
+		pos := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if pos.LineIdx >= len(l.code) || reached[pos] {
+			continue
+		}
+		reached[pos] = true
+		todo = append(todo, l.successors(pos, gosubs)...)
+	}
+	return reached
+}
+
+// successors returns the statements, which may run after the one at pos
+func (l *linter) successors(pos BlockPos, gosubs bool) []BlockPos {
+	next := l.behind(pos)
+	switch stmt := l.code[pos.LineIdx][pos.StmtIdx].(type) {
+	case GOTO:
+		return l.toLines(stmt.Line)
+	case GOSUB:
+		if gosubs {
+			return append(l.toLines(stmt.Line), next)
+		}
+	case IFLN:
+		return append(l.toLines(stmt.Line), next)
+	case IFELSELN:
+		return l.toLines(stmt.Line, stmt.ElseLine)
+	case condJump:
+		return []BlockPos{next, l.norm(BlockPos{LineIdx: pos.LineIdx, StmtIdx: stmt.to})}
+	case jump:
+		return []BlockPos{l.norm(BlockPos{LineIdx: pos.LineIdx, StmtIdx: stmt.to})}
+	case ONGOTO:
+		return append(l.toLines(stmt.Lines...), next)
+	case ONGOSUB:
+		if gosubs {
+			return append(l.toLines(stmt.Lines...), next)
+		}
+	case RETURN, END, ENDSUB, EXITSUB, ENDFUNCTION, EXITFUNCTION:
+		return nil
+	case SUB, FUNCTION, EXITDO:
+		return []BlockPos{l.behind(l.blocks[pos])}
+	case WHILE:
+		return []BlockPos{next, l.behind(l.blocks[pos])}
+	case WEND:
+		return []BlockPos{l.blocks[pos]}
+	case DO:
+		if stmt.Cond != nil {
+			return []BlockPos{next, l.behind(l.blocks[pos])}
+		}
+	case LOOP:
+		if stmt.Cond != nil {
+			return []BlockPos{next, l.blocks[pos]}
+		}
+		return []BlockPos{l.blocks[pos]}
+	case IFBLOCK, ELSEIF, ELSE:
+		// coming from the clause before, ELSEIF and ELSE jump behind the END IF. Both ways are followed.
+		return []BlockPos{next, l.nextClause(pos)}
+	}
+	return []BlockPos{next}
+}
+
+func (l *linter) nextClause(pos BlockPos) BlockPos {
+	next := l.blocks[pos]
+	if _, ok := l.code[next.LineIdx][next.StmtIdx].(ENDIF); ok {
+		return l.behind(next)
+	}
+	return next
+}
+
+func (l *linter) toLines(nums ...int) []BlockPos {
+	var ps []BlockPos
+	for _, num := range nums {
+		if idx := l.idx.lookup(num); idx >= 0 {
+			ps = append(ps, l.norm(BlockPos{LineIdx: idx}))
+		}
+	}
+	return ps
+}
+
+func (l *linter) behind(pos BlockPos) BlockPos {
+	return l.norm(BlockPos{LineIdx: pos.LineIdx, StmtIdx: pos.StmtIdx + 1})
+}
+
+// norm moves pos to the next statement of the program, if it is behind the end of its line
+func (l *linter) norm(pos BlockPos) BlockPos {
+	for pos.LineIdx < len(l.code) && pos.StmtIdx >= len(l.code[pos.LineIdx]) {
+		pos = BlockPos{LineIdx: pos.LineIdx + 1}
+	}
+	return pos
+}
+
+// checkVars reports variables, which are read but never assigned. Variables passed to SUBs and FUNCTIONs
+// may be assigned by them.
+func (l *linter) checkVars() {
+	assigned := map[string]bool{}
+	assignRefs := func(refs ...string) {
+		for _, ref := range refs {
+			if !isArray(ref) {
+				assigned[ref] = true
+			}
+		}
+	}
+	assignArgs := func(args ...expr.Evaler) {
+		for _, arg := range args {
+			if v, ok := arg.(expr.VarEvaler); ok {
+				assigned[string(v)] = true
+			}
+		}
+	}
+	l.each(func(pos BlockPos, stmt Stmt) {
+		switch stmt := stmt.(type) {
+		case LET:
+			assigned[stmt.Var] = true
+		case ASSIGN:
+			assigned[stmt.Var] = true
+		case FOR:
+			assigned[stmt.Var] = true
+		case INPUT:
+			assignRefs(stmt.Vars...)
+		case LINEINPUT:
+			assignRefs(stmt.Var)
+		case READ:
+			assignRefs(stmt.Vars...)
+		case SUB:
+			for _, p := range stmt.Params {
+				assigned[p.Name] = true
+			}
+		case FUNCTION:
+			for _, p := range stmt.Params {
+				assigned[p.Name] = true
+			}
+		case CALL:
+			for _, arg := range stmt.Args {
+				assignArgs(arg.Tree)
+			}
+		}
+		for _, e := range l.stmtExprs(stmt) {
+			walk(e.Tree, func(ev expr.Evaler) {
+				if fe, ok := ev.(expr.FuncEvaler); ok && l.fns[fe.Name] {
+					assignArgs(fe.Args...)
+				}
+			})
+		}
+	})
+
+	reported := map[string]bool{}
+	l.each(func(pos BlockPos, stmt Stmt) {
+		var params []string
+		if def, ok := stmt.(DEF); ok {
+			params = def.Params
+		}
+		for _, e := range l.stmtExprs(stmt) {
+			walk(e.Tree, func(ev expr.Evaler) {
+				v, ok := ev.(expr.VarEvaler)
+				name := string(v)
+				if !ok || assigned[name] || reported[name] || contains(params, name) {
+					return
+				}
+				reported[name] = true
+				l.report(pos, CheckUnassigned, "variable %s is read but never assigned", name)
+			})
+		}
+	})
+}
+
+// stmtExprs returns the expressions of stmt including the one of a DEF
+func (l *linter) stmtExprs(stmt Stmt) []Expr {
+	if def, ok := stmt.(DEF); ok {
+		return []Expr{def.Expr}
+	}
+	return exprs(stmt)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArrays reports arrays, which are used without DIM, and constant indexes out of the bounds of arrays
+func (l *linter) checkArrays() {
+	reported := map[string]bool{}
+	// indexes are nil for whole arrays like A()
+	use := func(pos BlockPos, name string, indexes []expr.Evaler) {
+		bounds, ok := l.arrays[name]
+		if !ok {
+			if reported[name] {
+				return
+			}
+			reported[name] = true
+			for _, ev := range indexes {
+				if n, ok := constIndex(ev); ok && n > implicitBound {
+					l.report(pos, CheckArray, "array %s is used without DIM and index %d is past the implicit bound of %d", name, n, implicitBound)
+					return
+				}
+			}
+			l.report(pos, CheckArray, "array %s is used without DIM", name)
+			return
+		}
+		if len(bounds) == 0 || indexes == nil {
+			return
+		}
+		if len(indexes) != len(bounds) {
+			l.report(pos, CheckArray, "array %s has %d dimensions, got %d indexes", name, len(bounds), len(indexes))
+			return
+		}
+		for i, ev := range indexes {
+			if n, ok := constIndex(ev); ok && n > bounds[i] {
+				l.report(pos, CheckArray, "index %d is out of the bounds of %s(%s)", n, name, joinInts(bounds))
+			}
+		}
+	}
+	l.each(func(pos BlockPos, stmt Stmt) {
+		var refs []ArrayDef
+		switch stmt := stmt.(type) {
+		case ASSIGN_ARRAY:
+			refs = append(refs, stmt.Array)
+		case INPUT:
+			refs = arrayRefs(stmt.Vars)
+		case LINEINPUT:
+			refs = arrayRefs([]string{stmt.Var})
+		case READ:
+			refs = arrayRefs(stmt.Vars)
+		}
+		for _, ad := range refs {
+			var indexes []expr.Evaler
+			for _, d := range ad.Dimensions {
+				indexes = append(indexes, d.Tree)
+			}
+			use(pos, ad.Var, indexes)
+		}
+		for _, e := range l.stmtExprs(stmt) {
+			walk(e.Tree, func(ev expr.Evaler) {
+				switch ev := ev.(type) {
+				case expr.FuncEvaler:
+					if _, ok := l.arrays[ev.Name]; ok || !(l.fns[ev.Name] || l.builtins.Contains(ev.Name)) {
+						use(pos, ev.Name, ev.Args)
+					}
+				case expr.ArrayRefEvaler:
+					use(pos, string(ev), nil)
+				}
+			})
+		}
+	})
+}
+
+func arrayRefs(refs []string) []ArrayDef {
+	var ads []ArrayDef
+	for _, ref := range refs {
+		if isArray(ref) {
+			ads = append(ads, mustParseArray(ref))
+		}
+	}
+	return ads
+}
+
+func joinInts(ns []int) string {
+	sl := make([]string, len(ns))
+	for i, n := range ns {
+		sl[i] = fmt.Sprint(n)
+	}
+	return strings.Join(sl, ",")
+}
+
+// checkNext reports NEXTs, which have no FOR of their variable before them
+func (l *linter) checkNext() {
+	fors := map[string]bool{}
+	var anyFor bool
+	l.each(func(pos BlockPos, stmt Stmt) {
+		switch stmt := stmt.(type) {
+		case FOR:
+			fors[stmt.Var] = true
+			anyFor = true
+		case NEXT:
+			if len(stmt.Vars) == 0 && !anyFor {
+				l.report(pos, CheckNext, "NEXT without FOR")
+			}
+			for _, v := range stmt.Vars {
+				if !fors[v] {
+					l.report(pos, CheckNext, "NEXT %s without FOR", v)
+				}
+			}
+		}
+	})
+}
+
+// checkData reports READs of programs without DATA
+func (l *linter) checkData() {
+	var reads []BlockPos
+	var hasData bool
+	l.each(func(pos BlockPos, stmt Stmt) {
+		switch stmt.(type) {
+		case READ:
+			reads = append(reads, pos)
+		case DATA:
+			hasData = true
+		}
+	})
+	if hasData {
+		return
+	}
+	for _, pos := range reads {
+		l.report(pos, CheckData, "READ without DATA")
+	}
+}
+
+// checkTypes reports mixing strings and numbers, which fails with a type mismatch
+func (l *linter) checkTypes() {
+	l.each(func(pos BlockPos, stmt Stmt) {
+		mismatch := func(e Expr) {
+			l.report(pos, CheckTypes, "type mismatch in %q", e.Raw)
+		}
+		// typed checks the types of all expressions and returns, if e is a string
+		typed := func(e Expr) (bool, bool) {
+			t, ok := l.exprType(e.Tree)
+			if !ok {
+				mismatch(e)
+			}
+			return t == expr.TypeString, ok
+		}
+		numbers := func(es ...Expr) {
+			for _, e := range es {
+				if isStr, ok := typed(e); ok && isStr {
+					l.report(pos, CheckTypes, "type mismatch: %q is a string, expected a number", e.Raw)
+				}
+			}
+		}
+		assign := func(name string, e Expr) {
+			isStr, ok := typed(e)
+			switch {
+			case !ok:
+			case isStr && l.types.TypeOf(name) != expr.TypeString:
+				l.report(pos, CheckTypes, "type mismatch: assigning a string to %s", name)
+			case !isStr && l.types.TypeOf(name) == expr.TypeString:
+				l.report(pos, CheckTypes, "type mismatch: assigning a number to %s", name)
+			}
+		}
+		switch stmt := stmt.(type) {
+		case LET:
+			assign(stmt.Var, stmt.Expr)
+		case ASSIGN:
+			assign(stmt.Var, stmt.Expr)
+		case ASSIGN_ARRAY:
+			numbers(stmt.Array.Dimensions...)
+			assign(stmt.Array.Var, stmt.Expr)
+		case DEF:
+			assign(stmt.Name, stmt.Expr)
+		case FOR:
+			if l.types.TypeOf(stmt.Var) == expr.TypeString {
+				l.report(pos, CheckTypes, "type mismatch: FOR variable %s is a string", stmt.Var)
+			}
+			numbers(stmt.Initial, stmt.To, stmt.Step)
+		case DIM, ONGOTO, ONGOSUB, INPUT, LINEINPUT, READ:
+			numbers(exprs(stmt)...)
+		case PRINT:
+			for _, pi := range stmt.Items {
+				switch pi := pi.(type) {
+				case Expr:
+					typed(pi)
+				case PrintTab:
+					numbers(pi.Expr)
+				case PrintSpc:
+					numbers(pi.Expr)
+				}
+			}
+		case PRINTUSING:
+			if isStr, ok := typed(stmt.Format); ok && !isStr {
+				l.report(pos, CheckTypes, "type mismatch: format %q is a number, expected a string", stmt.Format.Raw)
+			}
+			for _, e := range printExprs(stmt.Items) {
+				typed(e)
+			}
+		default:
+			for _, e := range exprs(stmt) {
+				typed(e)
+			}
+		}
+	})
+}
+
+// exprType returns the type of an expression, if all of its operators and array indexes have operands
+// of valid types
+func (l *linter) exprType(ev expr.Evaler) (expr.Type, bool) {
+	switch ev := ev.(type) {
+	case expr.NumberEvaler[int16]:
+		return expr.TypeInteger, true
+	case expr.NumberEvaler[float32]:
+		return expr.TypeSingle, true
+	case expr.NumberEvaler[float64]:
+		return expr.TypeDouble, true
+	case expr.StringEvaler:
+		return expr.TypeString, true
+	case expr.VarEvaler:
+		return l.types.TypeOf(string(ev)), true
+	case expr.UnaryEvaler:
+		xt, ok := l.exprType(ev.X)
+		if !ok {
+			return xt, false
+		}
+		t, err := expr.UnaryResultType(ev.Op, xt)
+		return t, err == nil
+	case expr.BinaryEvaler:
+		xt, xok := l.exprType(ev.X)
+		yt, yok := l.exprType(ev.Y)
+		if !xok || !yok {
+			return xt, false
+		}
+		t, err := expr.ResultType(ev.Op, xt, yt)
+		return t, err == nil
+	case expr.FuncEvaler:
+		var argTypes []expr.Type
+		ok := true
+		for _, arg := range ev.Args {
+			t, argOk := l.exprType(arg)
+			argTypes = append(argTypes, t)
+			ok = ok && argOk
+		}
+		if _, isArray := l.arrays[ev.Name]; isArray || l.fns[ev.Name] {
+			if isArray {
+				for _, t := range argTypes {
+					ok = ok && t != expr.TypeString
+				}
+			}
+			return l.types.TypeOf(ev.Name), ok
+		}
+		return FuncType(ev.Name, argTypes), ok
+	case expr.ArrayRefEvaler:
+		return l.types.TypeOf(string(ev)), true
+	default:
+		return expr.TypeSingle, true
+	}
+}
+
+// checkJumpsIntoLoops reports jumps from outside of the body of a FOR loop into it. The body spans the
+// lines behind the FOR up to the NEXT, which closes it.
+func (l *linter) checkJumpsIntoLoops() {
+	type loop struct {
+		v        string
+		from, to int
+	}
+	var open, loops []loop
+	for i, line := range l.lines {
+		for _, stmt := range line.stmts {
+			switch stmt := stmt.(type) {
+			case FOR:
+				open = append(open, loop{v: stmt.Var, from: i})
+			case NEXT:
+				vars := stmt.Vars
+				if len(vars) == 0 {
+					vars = []string{""}
+				}
+				for _, v := range vars {
+					for k := len(open) - 1; k >= 0; k-- {
+						if v == "" || open[k].v == v {
+							lp := open[k]
+							lp.to = i
+							loops = append(loops, lp)
+							open = open[:k]
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+
+	l.each(func(pos BlockPos, stmt Stmt) {
+		var targets []int
+		switch stmt := stmt.(type) {
+		case GOTO, IFLN, IFELSELN, ONGOTO:
+			targets = jumpTargets(stmt)
+		}
+		for _, num := range targets {
+			idx := l.idx.lookup(num)
+			for _, lp := range loops {
+				inBody := idx > lp.from && idx <= lp.to
+				outside := pos.LineIdx < lp.from || pos.LineIdx > lp.to
+				if inBody && outside {
+					l.report(pos, CheckJumpIntoLoop, "jump to line %d into the body of FOR %s in line %d", num, lp.v, l.lines[lp.from].num)
+				}
+			}
+		}
+	})
+}
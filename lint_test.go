@@ -0,0 +1,217 @@
+package gobas
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name: "unreachable lines",
+			src: `
+				10 GOTO 40
+				20 PRINT "DEAD": REM
+				30 REM ONLY A COMMENT
+				40 IF A=1 THEN 60 ELSE 70
+				50 PRINT "DEAD"
+				60 END
+				70 STOP: A=1: GOTO 40`,
+			expect: []string{
+				"3:8: line 20 is unreachable",
+				"6:8: line 50 is unreachable",
+			},
+		},
+		{
+			name: "unreachable blocks",
+			src: `
+				10 DO
+				20   EXIT DO
+				30   PRINT "DEAD"
+				40 LOOP
+				50 WHILE 1: WEND
+				60 CALL P
+				70 SUB P
+				80   PRINT "CALLED": EXIT SUB
+				90   PRINT "DEAD"
+				100 END SUB`,
+			expect: []string{
+				"4:10: line 30 is unreachable",
+				"5:8: line 40 is unreachable",
+				"10:10: line 90 is unreachable",
+			},
+		},
+		{
+			name: "procedures after the end",
+			src: `
+				10 CALL P(1): PRINT F(2)
+				20 END
+				30 SUB P(N)
+				40   PRINT N
+				50 END SUB
+				60 FUNCTION F(N)
+				70   F = N: EXIT FUNCTION
+				80   PRINT "DEAD"
+				90 END FUNCTION`,
+			expect: []string{"9:10: line 80 is unreachable"},
+		},
+		{
+			name: "link errors",
+			src: `
+				10 IF X=1 THEN 99
+				20 ON X GOTO 10, 77
+				20 X=1
+				15 END`,
+			expect: []string{
+				"2:8: undefined line number 99",
+				"3:8: undefined line number 77",
+				"4:8: duplicate line number, first defined in source line 3",
+				"5:8: line number out of order, follows line 20",
+			},
+		},
+		{
+			name: "block errors",
+			src: `
+				10 WEND
+				20 SUB P
+				30   GOTO 60
+				40 END SUB
+				50 SUB P: END SUB
+				60 END`,
+			expect: []string{
+				"2:8: WEND without WHILE",
+				"4:10: jump to line 60 leaves SUB P",
+				"6:8: SUB P is already defined in line 20",
+			},
+		},
+		{
+			name: "return without gosub",
+			src: `
+				10 GOSUB 100
+				20 ON X GOSUB 200
+				100 X=1
+				110 RETURN
+				200 RETURN`,
+			expect: []string{"5:9: RETURN is reachable without GOSUB"},
+		},
+		{
+			name: "variables read but never assigned",
+			src: `
+				10 DEF FNA(X)=X*Y: READ R: INPUT I$
+				20 PRINT FNA(1);Z;Z;R;I$: CALL P(V)
+				30 DATA 1
+				40 SUB P(N): PRINT N: END SUB`,
+			expect: []string{
+				"2:8: variable Y is read but never assigned",
+				"3:8: variable Z is read but never assigned",
+			},
+		},
+		{
+			name: "arrays",
+			src: `
+				10 DIM A(5), M(2,2), D(N): N=3
+				20 A(5)=1: PRINT A(6); M(1,3); M(1); D(100)
+				30 B(1)=1: PRINT B(2): READ C$(11)
+				40 CALL P(A()): PRINT LEN("X")
+				50 DATA X
+				60 SUB P(V()): PRINT V(20): END SUB`,
+			expect: []string{
+				"3:16: index 6 is out of the bounds of A(5)",
+				"3:16: index 3 is out of the bounds of M(2,2)",
+				"3:16: array M has 2 dimensions, got 1 indexes",
+				"4:8: array B is used without DIM",
+				"4:28: array C$ is used without DIM and index 11 is past the implicit bound of 10",
+			},
+		},
+		{
+			name: "next without for",
+			src: `
+				10 NEXT
+				20 FOR I=1 TO 2: IF I=1 THEN NEXT I
+				30 NEXT I, J`,
+			expect: []string{
+				"2:8: NEXT without FOR",
+				"4:8: NEXT J without FOR",
+			},
+		},
+		{
+			name:   "read without data",
+			src:    "10 READ A, B: PRINT A, B",
+			expect: []string{"1:4: READ without DATA"},
+		},
+		{
+			name: "type mismatches",
+			src: `
+				10 A$=1: B=A$: C$="X"+1
+				20 PRINT "N"; -A$; LEN(A$)+1; TAB(A$)
+				30 FOR I$=1 TO "X": NEXT
+				40 DEF FNA(X)="A": ON A$ GOTO 10
+				50 IF A$ THEN 10
+				60 PRINT USING 1; 2`,
+			expect: []string{
+				`2:8: type mismatch: assigning a number to A$`,
+				`2:14: type mismatch: assigning a string to B`,
+				`2:20: type mismatch in "\"X\"+1"`,
+				`3:8: type mismatch in "-A$"`,
+				`3:8: type mismatch: "A$" is a string, expected a number`,
+				`4:8: type mismatch: FOR variable I$ is a string`,
+				`4:8: type mismatch: "\"X\"" is a string, expected a number`,
+				`5:8: type mismatch: assigning a string to FNA`,
+				`5:24: type mismatch: "A$" is a string, expected a number`,
+				`7:8: type mismatch: format "1" is a number, expected a string`,
+			},
+		},
+		{
+			name: "jumps into a for body",
+			src: `
+				10 FOR I=1 TO 3
+				20   PRINT I
+				30   IF I=2 THEN 50
+				40 NEXT I
+				50 IF I>3 THEN 20 ELSE 60
+				60 ON I GOTO 10, 40
+				70 FOR J=1 TO 2: GOTO 70: NEXT`,
+			expect: []string{
+				"6:8: jump to line 20 into the body of FOR I in line 10",
+				"7:8: jump to line 40 into the body of FOR I in line 10",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := NewParser().ParseLines(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			var got []string
+			for _, d := range Lint(lines) {
+				got = append(got, d.String())
+			}
+			if want, got := strings.Join(test.expect, "\n"), strings.Join(got, "\n"); got != want {
+				t.Fatalf("want\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestSyntaxDiagnostics(t *testing.T) {
+	_, err := NewParser().ParseLines(strings.NewReader("10 PRINT (\n20 X=1\n30 GOTO nowhere"))
+	if err == nil {
+		t.Fatalf("want parse errors")
+	}
+	var got []string
+	for _, d := range SyntaxDiagnostics(err) {
+		got = append(got, d.String()+" "+d.Check)
+	}
+	want := []string{
+		`1:11: expected ")", found end of line syntax`,
+		`3:9: undefined label "nowhere" syntax`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
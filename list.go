@@ -0,0 +1,259 @@
+package gobas
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// List writes the program in its canonical form, one numbered line per source line
+func List(w io.Writer, lines []Line) error {
+	for _, l := range lines {
+		_, err := fmt.Fprintln(w, l.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Line) String() string {
+	s := strconv.Itoa(l.num) + " "
+	if l.label != "" {
+		s += l.label + ":"
+		if len(l.stmts) == 0 {
+			return s
+		}
+		s += " "
+	}
+	return s + formatStmts(l.stmts)
+}
+
+func formatStmts(stmts []Stmt) string {
+	sl := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		sl[i] = FormatStmt(stmt)
+	}
+	return strings.Join(sl, ": ")
+}
+
+// FormatStmt returns the source form of a single statement
+func FormatStmt(stmt Stmt) string {
+	switch stmt := stmt.(type) {
+	case DATA:
+		sl := make([]string, len(stmt.Consts))
+		for i, c := range stmt.Consts {
+			sl[i] = strings.TrimSpace(c)
+		}
+		return "DATA " + strings.Join(sl, ",")
+	case DEF:
+		s := "DEF " + stmt.Name
+		if len(stmt.Params) > 0 {
+			s += "(" + strings.Join(stmt.Params, ",") + ")"
+		}
+		return s + "=" + formatExpr(stmt.Expr)
+	case DEFTYPE:
+		sl := make([]string, len(stmt.Letters))
+		for i, lr := range stmt.Letters {
+			sl[i] = string(lr.From)
+			if lr.To != lr.From {
+				sl[i] += "-" + string(lr.To)
+			}
+		}
+		return defTypeKeyword(stmt.Type) + " " + strings.Join(sl, ",")
+	case DIM:
+		sl := make([]string, len(stmt.Arrays))
+		for i, ad := range stmt.Arrays {
+			sl[i] = formatArrayDef(ad)
+		}
+		return "DIM " + strings.Join(sl, ",")
+	case END:
+		return "END"
+	case FOR:
+		s := fmt.Sprintf("FOR %s=%s TO %s", stmt.Var, formatExpr(stmt.Initial), formatExpr(stmt.To))
+		if step := formatExpr(stmt.Step); step != "1" {
+			s += " STEP " + step
+		}
+		return s
+	case GOSUB:
+		return fmt.Sprintf("GOSUB %d", stmt.Line)
+	case GOTO:
+		return fmt.Sprintf("GOTO %d", stmt.Line)
+	case IFLN:
+		return fmt.Sprintf("IF %s THEN %d", formatExpr(stmt.Expr), stmt.Line)
+	case IFELSELN:
+		return fmt.Sprintf("IF %s THEN %d ELSE %d", formatExpr(stmt.Expr), stmt.Line, stmt.ElseLine)
+	case IFSTMT:
+		return fmt.Sprintf("IF %s THEN %s", formatExpr(stmt.Expr), formatStmts(stmt.Stmts))
+	case IFELSESTMT:
+		return fmt.Sprintf("IF %s THEN %s ELSE %s", formatExpr(stmt.Expr), formatStmts(stmt.Stmts), formatStmts(stmt.ElseStmts))
+	case IFBLOCK:
+		return fmt.Sprintf("IF %s THEN", formatExpr(stmt.Expr))
+	case ELSEIF:
+		return fmt.Sprintf("ELSEIF %s THEN", formatExpr(stmt.Expr))
+	case ELSE:
+		return "ELSE"
+	case ENDIF:
+		return "END IF"
+	case WHILE:
+		return "WHILE " + formatExpr(stmt.Expr)
+	case WEND:
+		return "WEND"
+	case DO:
+		return "DO" + formatLoopCond(stmt.Cond)
+	case LOOP:
+		return "LOOP" + formatLoopCond(stmt.Cond)
+	case EXITDO:
+		return "EXIT DO"
+	case INPUT:
+		s := "INPUT "
+		if stmt.Msg != "" || stmt.Semicolon {
+			s += strconv.Quote(stmt.Msg)
+			if stmt.Semicolon {
+				s += ";"
+			} else {
+				s += ","
+			}
+		}
+		return s + strings.Join(stmt.Vars, ",")
+	case LINEINPUT:
+		s := "LINE INPUT "
+		if stmt.Msg != "" {
+			s += strconv.Quote(stmt.Msg) + ";"
+		}
+		return s + stmt.Var
+	case LET:
+		return "LET " + stmt.Var + "=" + formatExpr(stmt.Expr)
+	case NEXT:
+		if len(stmt.Vars) == 0 {
+			return "NEXT"
+		}
+		return "NEXT " + strings.Join(stmt.Vars, ",")
+	case ONGOSUB:
+		return "ON " + formatExpr(stmt.Expr) + " GOSUB " + formatLines(stmt.Lines)
+	case ONGOTO:
+		return "ON " + formatExpr(stmt.Expr) + " GOTO " + formatLines(stmt.Lines)
+	case PRINT:
+		if len(stmt.Items) == 0 {
+			return "PRINT"
+		}
+		return "PRINT " + formatPrintItems(stmt.Items)
+	case PRINTUSING:
+		return "PRINT USING " + formatExpr(stmt.Format) + ";" + formatPrintItems(stmt.Items)
+	case READ:
+		return "READ " + strings.Join(stmt.Vars, ",")
+	case REM:
+		return "REM" + stmt.What
+	case RESTORE:
+		return "RESTORE"
+	case TRON:
+		return "TRON"
+	case TROFF:
+		return "TROFF"
+	case SUB:
+		return "SUB " + stmt.Name + formatParams(stmt.Params)
+	case FUNCTION:
+		return "FUNCTION " + stmt.Name + formatParams(stmt.Params)
+	case ENDSUB:
+		return "END SUB"
+	case ENDFUNCTION:
+		return "END FUNCTION"
+	case EXITSUB:
+		return "EXIT SUB"
+	case EXITFUNCTION:
+		return "EXIT FUNCTION"
+	case CALL:
+		s := "CALL " + stmt.Name
+		if len(stmt.Args) > 0 {
+			sl := make([]string, len(stmt.Args))
+			for i, arg := range stmt.Args {
+				sl[i] = formatExpr(arg)
+			}
+			s += "(" + strings.Join(sl, ",") + ")"
+		}
+		return s
+	case SHARED:
+		return "SHARED " + formatParamList(stmt.Vars)
+	case STATIC:
+		return "STATIC " + strings.Join(stmt.Vars, ",")
+	case RETURN:
+		return "RETURN"
+	case STOP:
+		return "STOP"
+	case ASSIGN:
+		return stmt.Var + "=" + formatExpr(stmt.Expr)
+	case ASSIGN_ARRAY:
+		return formatArrayDef(stmt.Array) + "=" + formatExpr(stmt.Expr)
+	default:
+		return fmt.Sprintf("%T", stmt)
+	}
+}
+
+func formatPrintItems(items []printItem) string {
+	var sb strings.Builder
+	for _, item := range items {
+		switch item := item.(type) {
+		case Expr:
+			sb.WriteString(formatExpr(item))
+		case PrintSemicolon:
+			sb.WriteString(";")
+		case PrintComma:
+			sb.WriteString(",")
+		case PrintTab:
+			sb.WriteString("TAB(" + formatExpr(item.Expr) + ")")
+		case PrintSpc:
+			sb.WriteString("SPC(" + formatExpr(item.Expr) + ")")
+		}
+	}
+	return sb.String()
+}
+
+func formatExpr(ex Expr) string {
+	return strings.TrimSpace(ex.Raw)
+}
+
+func formatArrayDef(ad ArrayDef) string {
+	sl := make([]string, len(ad.Dimensions))
+	for i, d := range ad.Dimensions {
+		sl[i] = formatExpr(d)
+	}
+	return ad.Var + "(" + strings.Join(sl, ",") + ")"
+}
+
+func formatLines(nums []int) string {
+	sl := make([]string, len(nums))
+	for i, n := range nums {
+		sl[i] = strconv.Itoa(n)
+	}
+	return strings.Join(sl, ",")
+}
+
+func formatParams(params []Param) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return "(" + formatParamList(params) + ")"
+}
+
+func formatParamList(params []Param) string {
+	sl := make([]string, len(params))
+	for i, p := range params {
+		sl[i] = p.Name
+		if p.Array {
+			sl[i] += "()"
+		}
+	}
+	return strings.Join(sl, ",")
+}
+
+func formatLoopCond(cond *LoopCond) string {
+	switch {
+	case cond == nil:
+		return ""
+	case cond.Until:
+		return " UNTIL " + formatExpr(cond.Expr)
+	default:
+		return " WHILE " + formatExpr(cond.Expr)
+	}
+}
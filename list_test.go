@@ -0,0 +1,126 @@
+package gobas
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	src := `
+		10 REM  A LISTING
+		20 DIM A(3), B$(2,2)
+		30 DEF FNA(X,Y) = X*Y
+		40 INPUT "VALUE"; V
+		50 FOR I = 1 TO 3 STEP 2:A(I)=FNA(I,V):NEXT I
+		60 IF V>1 THEN PRINT "BIG";V, ELSE PRINT "SMALL"
+		70 ON V GOSUB 100, 110
+		80 DATA 1, "TWO"
+		90 END
+		100 X = 1: RETURN
+		110 RETURN
+		120 DEFINT I-K, N: DEFSTR S
+		130 PRINT USING "##.#"; V, A(1);
+		140 INPUT "NO QUESTION MARK", S1: LINE INPUT "LINE"; S2
+		150 WHILE V<3: V=V+1: WEND
+		160 IF V THEN
+		170 ELSEIF V=2 THEN
+		180 ELSE
+		190 END IF
+		200 DO UNTIL V: EXIT DO: LOOP WHILE V
+		210 CALL P(V, A()): CALL Q
+		220 SUB P(N, X())
+		230 SHARED S, B$(): STATIC K
+		240 END SUB
+		250 FUNCTION F$(N): F$ = STR$(N): END FUNCTION
+	`
+	expect := strings.Join([]string{
+		`10 REM  A LISTING`,
+		`20 DIM A(3),B$(2,2)`,
+		`30 DEF FNA(X,Y)=X*Y`,
+		`40 INPUT "VALUE";V`,
+		`50 FOR I=1 TO 3 STEP 2: A(I)=FNA(I,V): NEXT I`,
+		`60 IF V>1 THEN PRINT "BIG";V, ELSE PRINT "SMALL"`,
+		`70 ON V GOSUB 100,110`,
+		`80 DATA 1,"TWO"`,
+		`90 END`,
+		`100 X=1: RETURN`,
+		`110 RETURN`,
+		`120 DEFINT I-K,N: DEFSTR S`,
+		`130 PRINT USING "##.#";V,A(1);`,
+		`140 INPUT "NO QUESTION MARK",S1: LINE INPUT "LINE";S2`,
+		`150 WHILE V<3: V=V+1: WEND`,
+		`160 IF V THEN`,
+		`170 ELSEIF V=2 THEN`,
+		`180 ELSE`,
+		`190 END IF`,
+		`200 DO UNTIL V: EXIT DO: LOOP WHILE V`,
+		`210 CALL P(V,A()): CALL Q`,
+		`220 SUB P(N,X())`,
+		`230 SHARED S,B$(): STATIC K`,
+		`240 END SUB`,
+		`250 FUNCTION F$(N): F$=STR$(N): END FUNCTION`,
+	}, "\n") + "\n"
+
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	err = List(buf, state.Lines())
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if buf.String() != expect {
+		t.Fatalf("want\n%s\ngot\n%s", expect, buf.String())
+	}
+}
+
+func TestListRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("samples/*.bas")
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			state, err := NewParser().ParseFile(file)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			first := &bytes.Buffer{}
+			List(first, state.Lines())
+
+			state, err = NewParser().Parse(bytes.NewReader(first.Bytes()))
+			if err != nil {
+				t.Fatalf("parse listing: %v", err)
+			}
+			second := &bytes.Buffer{}
+			List(second, state.Lines())
+			if first.String() != second.String() {
+				t.Fatalf("listing changed after re-parse:\n%s\n---\n%s", first.String(), second.String())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	src := `
+		10 PRINT "OK"
+		20 FOO BAR
+		30 X=(
+		40 GOTO 10
+	`
+	_, err := NewParser().Parse(strings.NewReader(src))
+	var perrs ParseErrors
+	if !errors.As(err, &perrs) {
+		t.Fatalf("want ParseErrors, got %v", err)
+	}
+	if len(perrs) != 2 {
+		t.Fatalf("want 2 errors, got %d: %v", len(perrs), perrs)
+	}
+	if !strings.Contains(perrs[0].Error(), "line 20") || !strings.Contains(perrs[1].Error(), "line 30") {
+		t.Fatalf("unexpected errors: %v", perrs)
+	}
+}
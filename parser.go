@@ -1,303 +1,946 @@
 package gobas
 
 import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/mazzegi/gobas/lex"
+	"github.com/mazzegi/gobas/expr"
 	"github.com/pkg/errors"
 )
 
 func NewParser() *Parser {
-	p := &Parser{}
-	p.init()
-	return p
+	return &Parser{}
 }
 
+// Parser turns source lines into statements. Each line is split into tokens, which are parsed by recursive descent.
 type Parser struct {
-	lexer *lex.Set
+	mode SourceMode
+}
+
+// SetSourceMode sets, if the lines of sources are numbered. By default it is detected by the first line.
+func (p *Parser) SetSourceMode(mode SourceMode) {
+	p.mode = mode
 }
 
 func (p *Parser) ParseFile(fileName string) (*State, error) {
-	rls, err := rawReadFile(fileName)
+	rls, err := rawReadFile(fileName, p.mode)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseRawLines(rls)
+}
+
+func (p *Parser) Parse(r io.Reader) (*State, error) {
+	rls, err := rawRead(r, p.mode)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseRawLines(rls)
+}
+
+// ParseLines parses the lines of a program without linking them, so its jumps to undefined lines or unbalanced
+// blocks are not reported
+func (p *Parser) ParseLines(r io.Reader) ([]Line, error) {
+	rls, err := rawRead(r, p.mode)
 	if err != nil {
 		return nil, err
 	}
-	state := &State{}
-	for _, rl := range rls {
-		lineStmts, err := p.parseLine(rl)
+	return p.parseLines(rls)
+}
+
+func (p *Parser) parseRawLines(rls []rawLine) (*State, error) {
+	lines, err := p.parseLines(rls)
+	if err != nil {
+		return nil, err
+	}
+	if err := Link(lines); err != nil {
+		return nil, err
+	}
+	s := NewState(lines)
+	if s.blocksErr != nil {
+		return nil, s.blocksErr
+	}
+	return s, nil
+}
+
+// parseLines parses the lines in two passes. The first one tokenizes them and collects the labels,
+// which GOTO, GOSUB etc. may jump to, the second one parses the statements.
+func (p *Parser) parseLines(rls []rawLine) ([]Line, error) {
+	var errs ParseErrors
+	lineToks := make([][]token, len(rls))
+	labels := map[string]int{}
+	for i, rl := range rls {
+		toks, err := tokenize(rl.text, rl.col)
 		if err != nil {
-			return nil, err
-		}
-		state.lines = append(state.lines, Line{
-			num:   rl.num,
-			stmts: lineStmts,
-		})
-	}
-	return state, nil
-}
-
-// private stuff
-
-const (
-	KeyDATA        = "DATA"
-	KeyDEF         = "DEF"
-	KeyDIM         = "DIM"
-	KeyEND         = "END"
-	KeyFOR         = "FOR"
-	KeyFOR_STEP    = "FOR_STEP"
-	KeyGOSUB       = "GOSUB"
-	KeyGOTO        = "GOTO"
-	KeyIFLN        = "IFLN"
-	KeyIFELSELN    = "IFELSELN"
-	KeyIFSTMT      = "IFSTMT"
-	KeyIFELSESTMT  = "IFELSESTMT"
-	KeyINPUT       = "INPUT"
-	KeyLET         = "LET"
-	KeyNEXT        = "NEXT"
-	KeyNEXT_EMPTY  = "NEXT_EMPTY"
-	KeyON_GOSUB    = "ON_GOSUB"
-	KeyON_GOTO     = "ON_GOTO"
-	KeyPRINT       = "PRINT"
-	KeyPRINT_EMPTY = "PRINT_EMPTY"
-	KeyREAD        = "READ"
-	KeyREM         = "REM"
-	KeyREM_EMPTY   = "REM_EMPTY"
-	KeyRESTORE     = "RESTORE"
-	KeyRETURN      = "RETURN"
-	KeySTOP        = "STOP"
-	KeyASSIGN      = "ASSIGN"
-)
+			errs = append(errs, rl.wrap(err))
+			continue
+		}
+		if label, _ := cutLabel(toks); label != "" {
+			if num, ok := labels[label]; ok {
+				errs = append(errs, rl.wrap(errors.Errorf("label %q is already defined in line %d", label, num)))
+				continue
+			}
+			labels[label] = rl.num
+		}
+		lineToks[i] = toks
+	}
 
-func (p *Parser) init() {
-	p.lexer = lex.NewSet()
-	p.lexer.MustAdd(KeyDATA, "DATA {raw:string}")
-	p.lexer.MustAdd(KeyDEF, "DEF {fnc:string}={expr:string}")
-	p.lexer.MustAdd(KeyDIM, "DIM {arrayexprs:[]string?sep=,}")
-	p.lexer.MustAdd(KeyEND, "END")
-	p.lexer.MustAdd(KeyFOR_STEP, "FOR {var:string}={iexpr:string} TO {toexpr:string} STEP {stepexpr:string}")
-	p.lexer.MustAdd(KeyFOR, "FOR {var:string}={iexpr:string} TO {toexpr:string}")
-	p.lexer.MustAdd(KeyGOSUB, "GOSUB {line:int}")
-	p.lexer.MustAdd(KeyGOTO, "GOTO {line:int}")
-	p.lexer.MustAdd(KeyIFELSELN, "IF {condexpr:string} THEN {line:int} ELSE {elseline:int}")
-	p.lexer.MustAdd(KeyIFELSESTMT, "IF {condexpr:string} THEN {stmts:string} ELSE {elsestmts:string}")
-	p.lexer.MustAdd(KeyIFLN, "IF {condexpr:string} THEN {line:int}")
-	p.lexer.MustAdd(KeyIFSTMT, "IF {condexpr:string} THEN {stmts:string}")
-	p.lexer.MustAdd(KeyINPUT, "INPUT{raw:string}")
-	p.lexer.MustAdd(KeyLET, "LET {var:string}={expr:string}")
-	p.lexer.MustAdd(KeyNEXT, "NEXT {var:string}")
-	p.lexer.MustAdd(KeyNEXT_EMPTY, "NEXT")
-	p.lexer.MustAdd(KeyON_GOSUB, "ON {expr:string} GOSUB {lines:[]int}")
-	p.lexer.MustAdd(KeyON_GOTO, "ON {expr:string} GOTO {lines:[]int}")
-	p.lexer.MustAdd(KeyPRINT, "PRINT{raw:string}")
-	p.lexer.MustAdd(KeyPRINT_EMPTY, "PRINT")
-	p.lexer.MustAdd(KeyREAD, "READ {vars:[]string?sep=,}")
-	p.lexer.MustAdd(KeyREM, "REM{expr:string}")
-	p.lexer.MustAdd(KeyREM_EMPTY, "REM")
-	p.lexer.MustAdd(KeyRESTORE, "RESTORE")
-	p.lexer.MustAdd(KeyRETURN, "RETURN")
-	p.lexer.MustAdd(KeySTOP, "STOP")
-	p.lexer.MustAdd(KeyASSIGN, "{var:string}={expr:string}")
-}
-
-func (p *Parser) parseLine(rl rawLine) (stmts []Stmt, err error) {
-	if strings.HasPrefix(strings.TrimSpace(rl.text), "REM") {
-		return []Stmt{
-			REM{What: rl.text},
-		}, nil
+	var lines []Line
+	for i, rl := range rls {
+		if lineToks[i] == nil {
+			continue
+		}
+		line, err := parseLineTokens(rl, lineToks[i], labels)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return lines, nil
+}
+
+// ParseLine parses a single numbered line like `10 PRINT "HELLO"`
+func (p *Parser) ParseLine(text string) (Line, error) {
+	rl, err := parseRawLine(0, trimWhite(text))
+	if err != nil {
+		return Line{}, err
+	}
+	toks, err := tokenize(rl.text, rl.col)
+	if err != nil {
+		return Line{}, rl.wrap(err)
+	}
+	return parseLineTokens(rl, toks, nil)
+}
+
+// ParseStmts parses unnumbered statements, like they are typed in immediate mode
+func (p *Parser) ParseStmts(text string) (stmts []Stmt, err error) {
+	toks, err := tokenize(text, 0)
+	if err != nil {
+		return nil, err
+	}
+	stmts, _, err = parseTokens(toks, nil)
+	return stmts, err
+}
+
+// ParseExpr parses a single expression, like debuggers evaluate them
+func (p *Parser) ParseExpr(text string) (ex Expr, err error) {
+	toks, err := tokenize(text, 0)
+	if err != nil {
+		return Expr{}, err
 	}
 	defer func() {
 		if r := recover(); r != nil {
-			err = errors.Errorf("in line %d (src = %d): %v", rl.num, rl.sourceLine+1, r)
+			serr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
 		}
 	}()
-	stmts = p.mustParseStmts(rl.text)
-	return
+	sp := &stmtParser{toks: toks}
+	ex = sp.parseExpr()
+	if tok := sp.peek(); tok.kind != tokEOF {
+		panic(expectedError(tok, "end of expression"))
+	}
+	return ex, nil
+}
+
+// ParseErrors holds the errors of all lines which failed to parse
+type ParseErrors []error
+
+func (es ParseErrors) Error() string {
+	sl := make([]string, len(es))
+	for i, err := range es {
+		sl[i] = err.Error()
+	}
+	return strings.Join(sl, "\n")
+}
+
+func (es ParseErrors) Unwrap() []error {
+	return es
+}
+
+func parseLineTokens(rl rawLine, toks []token, labels map[string]int) (Line, error) {
+	label, toks := cutLabel(toks)
+	stmts, cols, err := parseTokens(toks, labels)
+	if err != nil {
+		return Line{}, rl.wrap(err)
+	}
+	return Line{
+		num:     rl.num,
+		label:   label,
+		srcLine: rl.sourceLine,
+		stmts:   stmts,
+		cols:    cols,
+	}, nil
 }
 
-func (p *Parser) mustParseStmts(s string) []Stmt {
+// LineError is an error parsing a line. SourceLine is the 1-based line of the source.
+type LineError struct {
+	Line       int
+	SourceLine int
+	Err        error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("in line %d (src = %d): %v", e.Line, e.SourceLine, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+func (rl rawLine) wrap(err error) error {
+	return &LineError{Line: rl.num, SourceLine: rl.sourceLine + 1, Err: err}
+}
+
+// cutLabel cuts the definition of a label like `loop:` from the start of a line
+func cutLabel(toks []token) (string, []token) {
+	if len(toks) < 2 || toks[0].kind != tokIdent || !toks[1].is(tokOp, ":") {
+		return "", toks
+	}
+	return toks[0].text, toks[2:]
+}
+
+// parseTokens parses all statements of a line. The stmtParser reports syntax errors by panicking with a *SyntaxError.
+// The columns of the statements are returned along with them.
+func parseTokens(toks []token, labels map[string]int) (stmts []Stmt, cols []int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
+		}
+	}()
+	sp := &stmtParser{toks: toks, labels: labels}
+	stmts = sp.parseStmts(false)
+	if tok := sp.peek(); tok.kind != tokEOF {
+		panic(expectedError(tok, `":"`, "end of line"))
+	}
+	return stmts, sp.cols, nil
+}
+
+type stmtParser struct {
+	toks     []token
+	pos      int
+	branches int
+	labels   map[string]int
+	// cols are the columns of the statements of the line. Statements in the branches of an IF have none.
+	cols []int
+}
+
+func (sp *stmtParser) peek() token {
+	return sp.toks[sp.pos]
+}
+
+func (sp *stmtParser) next() token {
+	tok := sp.toks[sp.pos]
+	if tok.kind != tokEOF {
+		sp.pos++
+	}
+	return tok
+}
+
+func (sp *stmtParser) fail(expected ...string) {
+	panic(expectedError(sp.peek(), expected...))
+}
+
+// accept consumes the next token, if it is the keyword or operator text
+func (sp *stmtParser) accept(text string) bool {
+	tok := sp.peek()
+	if (tok.kind == tokKeyword || tok.kind == tokOp) && tok.text == text {
+		sp.pos++
+		return true
+	}
+	return false
+}
+
+func (sp *stmtParser) expect(text string) {
+	if !sp.accept(text) {
+		sp.fail(quoteExpected(text))
+	}
+}
+
+func quoteExpected(text string) string {
+	if isLetter(text[0]) {
+		return text
+	}
+	return strconv.Quote(text)
+}
+
+func (sp *stmtParser) atStmtEnd() bool {
+	tok := sp.peek()
+	return tok.kind == tokEOF || tok.is(tokOp, ":") || tok.is(tokKeyword, "ELSE")
+}
+
+// parseStmts parses statements separated by colons. In the branches of an IF (inIf), they end with an ELSE.
+// Otherwise an ELSE must start a statement, where it is the ELSE of a block IF.
+func (sp *stmtParser) parseStmts(inIf bool) []Stmt {
 	var stmts []Stmt
-	stmtsRaw := splitOutsideQuotes(s, StmtSep)
-	for _, stmtRaw := range stmtsRaw {
-		stmtRaw = trimWhite(stmtRaw)
-		if stmtRaw == "" {
+	for {
+		tok := sp.peek()
+		switch {
+		case tok.kind == tokEOF:
+			return stmts
+		case tok.is(tokKeyword, "ELSE") && inIf:
+			return stmts
+		case tok.is(tokOp, ":"):
+			sp.next()
 			continue
 		}
-		stmt := p.mustParseStmt(stmtRaw)
-		stmts = append(stmts, stmt)
+		if !inIf {
+			sp.cols = append(sp.cols, tok.col)
+		}
+		stmts = append(stmts, sp.parseStmt())
+		if !sp.atStmtEnd() || (!inIf && sp.peek().is(tokKeyword, "ELSE")) {
+			sp.fail(`":"`, "end of line")
+		}
 	}
-	return stmts
 }
 
-func (p *Parser) mustParseStmt(stmtRaw string) Stmt {
-	ps, key, err := p.lexer.Eval(stmtRaw)
-	if err != nil {
-		panic(errors.Wrapf(err, "eval stmt %q", stmtRaw))
+func (sp *stmtParser) parseStmt() Stmt {
+	tok := sp.peek()
+	if tok.kind == tokIdent {
+		return sp.parseAssign()
 	}
-
-	switch key {
-	case KeyDATA:
+	if tok.kind != tokKeyword {
+		sp.fail("statement")
+	}
+	sp.next()
+	switch tok.text {
+	case "CALL":
+		call := CALL{Name: sp.parseIdent()}
+		if sp.accept("(") {
+			for {
+				call.Args = append(call.Args, sp.parseExpr())
+				if !sp.accept(",") {
+					break
+				}
+			}
+			sp.expect(")")
+		}
+		return call
+	case "DATA":
+		consts := splitOutsideQuotes(sp.next().text, ',')
+		for i, c := range consts {
+			consts[i] = trimWhite(c)
+		}
 		return DATA{
-			Consts: splitOutsideQuotes(lex.MustParam[string](ps, "raw"), ','),
+			Consts: consts,
 		}
-	case KeyDEF:
-		return DEF{
-			Name: lex.MustParam[string](ps, "fnc"),
-			Expr: mustParseExpression(lex.MustParam[string](ps, "expr")),
+	case "DEF":
+		return sp.parseDef()
+	case "DEFINT", "DEFSNG", "DEFDBL", "DEFSTR":
+		return sp.parseDefType(defTypeKeywords[tok.text])
+	case "DIM":
+		dim := DIM{}
+		for {
+			name := sp.parseIdent()
+			dim.Arrays = append(dim.Arrays, ArrayDef{
+				Var:        name,
+				Dimensions: sp.parseIndexes(),
+			})
+			if !sp.accept(",") {
+				return dim
+			}
 		}
-	case KeyDIM:
-		return DIM{
-			Arrays: mustParseArrays(lex.MustParam[[]string](ps, "arrayexprs")),
+	case "DO":
+		return DO{Cond: sp.parseLoopCond()}
+	case "ELSE":
+		return ELSE{}
+	case "ELSEIF":
+		stmt := ELSEIF{Expr: sp.parseExpr()}
+		sp.expect("THEN")
+		return stmt
+	case "END":
+		switch {
+		case sp.accept("IF"):
+			return ENDIF{}
+		case sp.accept("SUB"):
+			return ENDSUB{}
+		case sp.accept("FUNCTION"):
+			return ENDFUNCTION{}
 		}
-	case KeyEND:
 		return END{}
-	case KeyFOR:
-		return FOR{
-			Var:     lex.MustParam[string](ps, "var"),
-			Initial: mustParseExpression(lex.MustParam[string](ps, "iexpr")),
-			To:      mustParseExpression(lex.MustParam[string](ps, "toexpr")),
-			Step:    mustParseExpression("1"),
-		}
-	case KeyFOR_STEP:
-		return FOR{
-			Var:     lex.MustParam[string](ps, "var"),
-			Initial: mustParseExpression(lex.MustParam[string](ps, "iexpr")),
-			To:      mustParseExpression(lex.MustParam[string](ps, "toexpr")),
-			Step:    mustParseExpression(lex.MustParam[string](ps, "stepexpr")),
-		}
-	case KeyGOSUB:
+	case "EXIT":
+		switch {
+		case sp.accept("DO"):
+			return EXITDO{}
+		case sp.accept("SUB"):
+			return EXITSUB{}
+		case sp.accept("FUNCTION"):
+			return EXITFUNCTION{}
+		}
+		sp.fail("DO", "SUB", "FUNCTION")
+		return nil
+	case "FOR":
+		return sp.parseFor()
+	case "FUNCTION":
+		return FUNCTION{Name: sp.parseIdent(), Params: sp.parseParams()}
+	case "GOSUB":
 		return GOSUB{
-			Line: lex.MustParam[int](ps, "line"),
+			Line: sp.parseLineNum(),
 		}
-	case KeyGOTO:
+	case "GOTO":
 		return GOTO{
-			Line: lex.MustParam[int](ps, "line"),
-		}
-	case KeyIFLN:
-		return IFLN{
-			Expr: mustParseExpression(lex.MustParam[string](ps, "condexpr")),
-			Line: lex.MustParam[int](ps, "line"),
-		}
-	case KeyIFELSELN:
-		return IFELSELN{
-			Expr:     mustParseExpression(lex.MustParam[string](ps, "condexpr")),
-			Line:     lex.MustParam[int](ps, "line"),
-			ElseLine: lex.MustParam[int](ps, "elseline"),
-		}
-	case KeyIFSTMT:
-		return IFSTMT{
-			Expr:  mustParseExpression(lex.MustParam[string](ps, "condexpr")),
-			Stmts: p.mustParseStmts(lex.MustParam[string](ps, "stmts")),
-		}
-	case KeyIFELSESTMT:
-		return IFELSESTMT{
-			Expr:      mustParseExpression(lex.MustParam[string](ps, "condexpr")),
-			Stmts:     p.mustParseStmts(lex.MustParam[string](ps, "stmts")),
-			ElseStmts: p.mustParseStmts(lex.MustParam[string](ps, "elsestmts")),
-		}
-	case KeyINPUT:
-		return mustParseInput(lex.MustParam[string](ps, "raw"))
-	case KeyLET:
-		return LET{
-			Var:  lex.MustParam[string](ps, "var"),
-			Expr: mustParseExpression(lex.MustParam[string](ps, "expr")),
-		}
-	case KeyNEXT:
-		return NEXT{
-			Var: lex.MustParam[string](ps, "var"),
-		}
-	case KeyNEXT_EMPTY:
-		return NEXT{}
-	case KeyON_GOSUB:
-		return ONGOSUB{
-			Expr:  mustParseExpression(lex.MustParam[string](ps, "expr")),
-			Lines: lex.MustParam[[]int](ps, "lines"),
-		}
-	case KeyON_GOTO:
-		return ONGOTO{
-			Expr:  mustParseExpression(lex.MustParam[string](ps, "expr")),
-			Lines: lex.MustParam[[]int](ps, "lines"),
-		}
-	case KeyPRINT:
-		return mustParsePrint(lex.MustParam[string](ps, "raw"))
-	case KeyPRINT_EMPTY:
-		return PRINT{}
-	case KeyREAD:
+			Line: sp.parseLineNum(),
+		}
+	case "IF":
+		return sp.parseIf()
+	case "INPUT":
+		return sp.parseInput()
+	case "LINE":
+		sp.expect("INPUT")
+		inp := LINEINPUT{}
+		if tok := sp.peek(); tok.kind == tokString {
+			sp.next()
+			inp.Msg = tok.text
+			sp.expect(";")
+		}
+		inp.Var = sp.parseVarRefs()[0]
+		return inp
+	case "LET":
+		stmt := sp.parseAssign()
+		if assign, ok := stmt.(ASSIGN); ok {
+			return LET(assign)
+		}
+		return stmt
+	case "LOOP":
+		return LOOP{Cond: sp.parseLoopCond()}
+	case "NEXT":
+		next := NEXT{}
+		if sp.atStmtEnd() {
+			return next
+		}
+		for {
+			next.Vars = append(next.Vars, sp.parseIdent())
+			if !sp.accept(",") {
+				return next
+			}
+		}
+	case "ON":
+		ex := sp.parseExpr()
+		var isGosub bool
+		switch {
+		case sp.accept("GOSUB"):
+			isGosub = true
+		case sp.accept("GOTO"):
+		default:
+			sp.fail("GOTO", "GOSUB")
+		}
+		var lines []int
+		for {
+			lines = append(lines, sp.parseLineNum())
+			if !sp.accept(",") {
+				break
+			}
+		}
+		if isGosub {
+			return ONGOSUB{Expr: ex, Lines: lines}
+		}
+		return ONGOTO{Expr: ex, Lines: lines}
+	case "PRINT":
+		return sp.parsePrint()
+	case "READ":
 		return READ{
-			Vars: lex.MustParam[[]string](ps, "vars"),
+			Vars: sp.parseVarRefs(),
+		}
+	case "REM":
+		return REM{
+			What: sp.next().text,
 		}
-	case KeyRESTORE:
+	case "RESTORE":
 		return RESTORE{}
-	case KeyRETURN:
+	case "RETURN":
 		return RETURN{}
-	case KeySTOP:
+	case "SHARED":
+		var vars []Param
+		for {
+			vars = append(vars, sp.parseParam())
+			if !sp.accept(",") {
+				return SHARED{Vars: vars}
+			}
+		}
+	case "STATIC":
+		var vars []string
+		for {
+			vars = append(vars, sp.parseIdent())
+			if !sp.accept(",") {
+				return STATIC{Vars: vars}
+			}
+		}
+	case "STOP":
 		return STOP{}
-	case KeyASSIGN:
-		varName := lex.MustParam[string](ps, "var")
-		if isArray(varName) {
-			return ASSIGN_ARRAY{
-				Array: mustParseArray(varName),
-				Expr:  mustParseExpression(lex.MustParam[string](ps, "expr")),
+	case "SUB":
+		return SUB{Name: sp.parseIdent(), Params: sp.parseParams()}
+	case "TROFF":
+		return TROFF{}
+	case "TRON":
+		return TRON{}
+	case "WEND":
+		return WEND{}
+	case "WHILE":
+		return WHILE{Expr: sp.parseExpr()}
+	default:
+		sp.pos--
+		sp.fail("statement")
+		return nil
+	}
+}
+
+func (sp *stmtParser) parseIdent() string {
+	tok := sp.peek()
+	if tok.kind != tokIdent {
+		sp.fail("variable")
+	}
+	sp.next()
+	return tok.text
+}
+
+// parseParams parses the optional bracketed parameters of a SUB or FUNCTION
+func (sp *stmtParser) parseParams() []Param {
+	if !sp.accept("(") {
+		return nil
+	}
+	var params []Param
+	for {
+		params = append(params, sp.parseParam())
+		if !sp.accept(",") {
+			break
+		}
+	}
+	sp.expect(")")
+	return params
+}
+
+// parseParam parses a variable or a whole array like A()
+func (sp *stmtParser) parseParam() Param {
+	param := Param{Name: sp.parseIdent()}
+	if sp.accept("(") {
+		sp.expect(")")
+		param.Array = true
+	}
+	return param
+}
+
+func (sp *stmtParser) isLabel(tok token) bool {
+	_, ok := sp.labels[tok.text]
+	return tok.kind == tokIdent && ok
+}
+
+// parseLineNum parses a line number or a label, which stands for the number of its line
+func (sp *stmtParser) parseLineNum() int {
+	tok := sp.peek()
+	if tok.kind == tokIdent {
+		num, ok := sp.labels[tok.text]
+		if !ok {
+			panic(&SyntaxError{Col: tok.col, Msg: fmt.Sprintf("undefined label %q", tok.text)})
+		}
+		sp.next()
+		return num
+	}
+	n, err := strconv.Atoi(tok.text)
+	if tok.kind != tokNumber || err != nil {
+		sp.fail("line number")
+	}
+	sp.next()
+	return n
+}
+
+// parseIndexes parses the bracketed, comma separated indexes of an array
+func (sp *stmtParser) parseIndexes() []Expr {
+	sp.expect("(")
+	var exprs []Expr
+	for {
+		exprs = append(exprs, sp.parseExpr())
+		if !sp.accept(",") {
+			break
+		}
+	}
+	sp.expect(")")
+	return exprs
+}
+
+func (sp *stmtParser) parseAssign() Stmt {
+	name := sp.parseIdent()
+	if sp.peek().is(tokOp, "(") {
+		indexes := sp.parseIndexes()
+		sp.expect("=")
+		return ASSIGN_ARRAY{
+			Array: ArrayDef{
+				Var:        name,
+				Dimensions: indexes,
+			},
+			Expr: sp.parseExpr(),
+		}
+	}
+	sp.expect("=")
+	return ASSIGN{
+		Var:  name,
+		Expr: sp.parseExpr(),
+	}
+}
+
+// parseVarRefs parses a list of variables or array elements, like they are used by READ and INPUT
+func (sp *stmtParser) parseVarRefs() []string {
+	var refs []string
+	for {
+		start := sp.pos
+		sp.parseIdent()
+		if sp.peek().is(tokOp, "(") {
+			sp.parseIndexes()
+		}
+		refs = append(refs, joinTokens(sp.toks[start:sp.pos]))
+		if !sp.accept(",") {
+			return refs
+		}
+	}
+}
+
+func (sp *stmtParser) parseDef() DEF {
+	tok := sp.peek()
+	name := sp.parseIdent()
+	if !strings.HasPrefix(name, "FN") || len(name) < 3 {
+		panic(&SyntaxError{Col: tok.col, Msg: "invalid function name " + strconv.Quote(name)})
+	}
+	def := DEF{
+		Name: name,
+	}
+	if sp.accept("(") {
+		for {
+			def.Params = append(def.Params, sp.parseIdent())
+			if !sp.accept(",") {
+				break
 			}
-		} else {
-			return ASSIGN{
-				Var:  lex.MustParam[string](ps, "var"),
-				Expr: mustParseExpression(lex.MustParam[string](ps, "expr")),
+		}
+		sp.expect(")")
+	}
+	sp.expect("=")
+	def.Expr = sp.parseExpr()
+	return def
+}
+
+// parseDefType parses the letters and letter ranges like A-C of a DEFINT, DEFSNG, DEFDBL or DEFSTR
+func (sp *stmtParser) parseDefType(t expr.Type) DEFTYPE {
+	stmt := DEFTYPE{
+		Type: t,
+	}
+	for {
+		lr := LetterRange{
+			From: sp.parseLetter(),
+		}
+		lr.To = lr.From
+		if sp.accept("-") {
+			tok := sp.peek()
+			lr.To = sp.parseLetter()
+			if lr.To < lr.From {
+				panic(&SyntaxError{Col: tok.col, Msg: "invalid letter range " + string(lr.From) + "-" + string(lr.To)})
 			}
 		}
-	case KeyREM:
-		return REM{
-			What: lex.MustParam[string](ps, "expr"),
+		stmt.Letters = append(stmt.Letters, lr)
+		if !sp.accept(",") {
+			return stmt
 		}
-	case KeyREM_EMPTY:
-		return REM{}
+	}
+}
+
+func (sp *stmtParser) parseLetter() byte {
+	tok := sp.peek()
+	if tok.kind != tokIdent || len(tok.text) != 1 || !isLetter(tok.text[0]) {
+		sp.fail("letter")
+	}
+	sp.next()
+	return strings.ToUpper(tok.text)[0]
+}
+
+func (sp *stmtParser) parseFor() FOR {
+	stmt := FOR{
+		Var: sp.parseIdent(),
+	}
+	sp.expect("=")
+	stmt.Initial = sp.parseExpr()
+	sp.expect("TO")
+	stmt.To = sp.parseExpr()
+	if sp.accept("STEP") {
+		stmt.Step = sp.parseExpr()
+	} else {
+		stmt.Step = mustParseExpression("1")
+	}
+	return stmt
+}
+
+// parseLoopCond parses the optional WHILE or UNTIL condition of DO and LOOP
+func (sp *stmtParser) parseLoopCond() *LoopCond {
+	switch {
+	case sp.accept("WHILE"):
+		return &LoopCond{Expr: sp.parseExpr()}
+	case sp.accept("UNTIL"):
+		return &LoopCond{Until: true, Expr: sp.parseExpr()}
 	default:
-		panic(errors.Errorf("unknown key %q", key))
+		return nil
 	}
 }
 
-func mustParseArrays(sl []string) []ArrayDef {
-	var as []ArrayDef
+// parseIf parses IF ... THEN ... ELSE .... All statements up to the ELSE or the end of the line
+// belong to the THEN branch. If the line ends with the THEN, it starts a block IF.
+func (sp *stmtParser) parseIf() Stmt {
+	cond := sp.parseExpr()
+	var stmts []Stmt
+	var line int
+	switch {
+	case sp.accept("GOTO"):
+		line = sp.parseLineNum()
+	case sp.accept("THEN"):
+		if sp.branches == 0 && sp.peek().kind == tokEOF {
+			return IFBLOCK{Expr: cond}
+		}
+		stmts, line = sp.parseBranch()
+	default:
+		sp.fail("THEN", "GOTO")
+	}
+	if !sp.accept("ELSE") {
+		if stmts == nil {
+			return IFLN{Expr: cond, Line: line}
+		}
+		return IFSTMT{Expr: cond, Stmts: stmts}
+	}
+	elseStmts, elseLine := sp.parseBranch()
+	switch {
+	case stmts == nil && elseStmts == nil:
+		return IFELSELN{Expr: cond, Line: line, ElseLine: elseLine}
+	case stmts == nil:
+		stmts = []Stmt{GOTO{Line: line}}
+	case elseStmts == nil:
+		elseStmts = []Stmt{GOTO{Line: elseLine}}
+	}
+	return IFELSESTMT{Expr: cond, Stmts: stmts, ElseStmts: elseStmts}
+}
+
+// parseBranch parses the statements of a THEN or ELSE branch, or the line number it jumps to
+func (sp *stmtParser) parseBranch() ([]Stmt, int) {
+	if tok := sp.peek(); tok.kind == tokNumber || sp.isLabel(tok) {
+		return nil, sp.parseLineNum()
+	}
+	sp.branches++
+	stmts := sp.parseStmts(true)
+	sp.branches--
+	if len(stmts) == 0 {
+		sp.fail("statement", "line number")
+	}
+	return stmts, 0
+}
+
+func (sp *stmtParser) parseInput() INPUT {
+	inp := INPUT{}
+	if tok := sp.peek(); tok.kind == tokString {
+		sp.next()
+		inp.Msg = tok.text
+		switch {
+		case sp.accept(";"):
+			inp.Semicolon = true
+		case sp.accept(","):
+		}
+	}
+	inp.Vars = sp.parseVarRefs()
+	return inp
+}
+
+func (sp *stmtParser) parsePrint() Stmt {
+	if sp.accept("USING") {
+		return sp.parsePrintUsing()
+	}
+	start := sp.pos
+	print := PRINT{}
+	for !sp.atStmtEnd() {
+		switch {
+		case sp.accept(";"):
+			print.Items = append(print.Items, PrintSemicolon{})
+		case sp.accept(","):
+			print.Items = append(print.Items, PrintComma{})
+		case sp.atPrintFunc("TAB"):
+			print.Items = append(print.Items, PrintTab{Expr: sp.parsePrintFuncArg()})
+		case sp.atPrintFunc("SPC"):
+			print.Items = append(print.Items, PrintSpc{Expr: sp.parsePrintFuncArg()})
+		default:
+			print.Items = append(print.Items, sp.parseExpr())
+		}
+	}
+	if len(print.Items) > 0 {
+		print.Raw = joinTokens(sp.toks[start:sp.pos])
+	}
+	return print
+}
+
+func (sp *stmtParser) parsePrintUsing() PRINTUSING {
+	stmt := PRINTUSING{
+		Format: sp.parseExpr(),
+	}
+	sp.expect(";")
+	for !sp.atStmtEnd() {
+		switch {
+		case sp.accept(";"):
+			stmt.Items = append(stmt.Items, PrintSemicolon{})
+		case sp.accept(","):
+			stmt.Items = append(stmt.Items, PrintComma{})
+		default:
+			stmt.Items = append(stmt.Items, sp.parseExpr())
+		}
+	}
+	return stmt
+}
+
+// atPrintFunc tells if the next tokens are a call of TAB or SPC, which are only allowed in PRINT
+func (sp *stmtParser) atPrintFunc(name string) bool {
+	return sp.peek().is(tokIdent, name) && sp.toks[sp.pos+1].is(tokOp, "(")
+}
+
+func (sp *stmtParser) parsePrintFuncArg() Expr {
+	sp.next()
+	sp.expect("(")
+	ex := sp.parseExpr()
+	sp.expect(")")
+	return ex
+}
 
-	p, err := lex.ParsePattern("{var:string}({dimexprs:[]string?sep=,})")
+// parseExpr collects the tokens of an expression and parses them with the expression parser. The expression
+// ends at the end of the statement, at any keyword which is not an operator and at a comma, semicolon or
+// closing bracket outside of brackets. Two adjacent operands (like in PRINT "A"B) end it as well.
+func (sp *stmtParser) parseExpr() Expr {
+	start := sp.pos
+	depth := 0
+loop:
+	for {
+		tok := sp.peek()
+		switch tok.kind {
+		case tokEOF, tokRaw:
+			break loop
+		case tokKeyword:
+			if !exprKeywords[tok.text] {
+				break loop
+			}
+		case tokOp:
+			switch tok.text {
+			case ":":
+				break loop
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			case ",", ";":
+				if depth == 0 {
+					break loop
+				}
+			}
+		default:
+			if depth == 0 && sp.pos > start && endsOperand(sp.toks[sp.pos-1]) {
+				break loop
+			}
+		}
+		sp.next()
+	}
+	if sp.pos == start {
+		sp.fail("expression")
+	}
+	if depth > 0 {
+		sp.fail(`")"`)
+	}
+	toks := sp.toks[start:sp.pos]
+	raw, offsets := joinTokenOffsets(toks)
+	tree, err := expr.NewParser(raw).Parse()
 	if err != nil {
-		panic(err)
+		col := toks[0].col
+		var perr *expr.PosError
+		if errors.As(err, &perr) {
+			col = exprCol(toks, offsets, perr.Pos)
+		}
+		panic(&SyntaxError{Col: col, Msg: errors.Wrapf(err, "expression %q", raw).Error()})
 	}
-	for _, s := range sl {
-		ps, err := p.Eval(s)
-		if err != nil {
-			panic(err)
+	return Expr{
+		Raw:  raw,
+		Tree: tree,
+	}
+}
+
+func endsOperand(tok token) bool {
+	switch tok.kind {
+	case tokIdent, tokNumber, tokString:
+		return true
+	case tokOp:
+		return tok.text == ")"
+	}
+	return false
+}
+
+// joinTokens returns the source form of tokens. Operator keywords are separated by spaces.
+func joinTokens(toks []token) string {
+	raw, _ := joinTokenOffsets(toks)
+	return raw
+}
+
+// joinTokenOffsets is joinTokens, which also returns the offsets of the tokens in the source form
+func joinTokenOffsets(toks []token) (string, []int) {
+	var sb strings.Builder
+	offsets := make([]int, len(toks))
+	for i, tok := range toks {
+		if tok.kind == tokKeyword && sb.Len() > 0 && !strings.HasSuffix(sb.String(), " ") {
+			sb.WriteString(" ")
+		}
+		offsets[i] = sb.Len()
+		sb.WriteString(sourceForm(tok))
+		if tok.kind == tokKeyword {
+			sb.WriteString(" ")
 		}
-		exprs := mustParseExpressions(lex.MustParam[[]string](ps, "dimexprs"))
-		as = append(as, ArrayDef{
-			Var:        lex.MustParam[string](ps, "var"),
-			Dimensions: exprs,
-		})
 	}
+	return strings.TrimSpace(sb.String()), offsets
+}
 
-	return as
+func sourceForm(tok token) string {
+	if tok.kind == tokString {
+		return `"` + tok.text + `"`
+	}
+	return tok.text
+}
+
+// exprCol translates the offset pos in the source form of toks to the column in the line
+func exprCol(toks []token, offsets []int, pos int) int {
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > pos }) - 1
+	if i < 0 {
+		return toks[0].col
+	}
+	if d := pos - offsets[i]; d < len(sourceForm(toks[i])) {
+		return toks[i].col + d
+	}
+	return toks[i].col + len(sourceForm(toks[i]))
 }
 
 func mustParseArray(s string) ArrayDef {
-	p, err := lex.ParsePattern("{var:string}({dimexprs:[]string?sep=,})")
+	toks, err := tokenize(s, 0)
 	if err != nil {
 		panic(err)
 	}
-	ps, err := p.Eval(s)
-	if err != nil {
-		panic(err)
+	sp := &stmtParser{toks: toks}
+	ad := ArrayDef{
+		Var:        sp.parseIdent(),
+		Dimensions: sp.parseIndexes(),
 	}
-	exprs := mustParseExpressions(lex.MustParam[[]string](ps, "dimexprs"))
-	return ArrayDef{
-		Var:        lex.MustParam[string](ps, "var"),
-		Dimensions: exprs,
+	if tok := sp.peek(); tok.kind != tokEOF {
+		panic(expectedError(tok, "end of line"))
 	}
+	return ad
 }
 
 func isArray(s string) bool {
-	p, err := lex.ParsePattern("{var:string}({dimexprs:[]string?sep=,})")
-	if err != nil {
-		panic(err)
-	}
-	_, err = p.Eval(s)
-	return err == nil
+	return strings.HasSuffix(strings.TrimSpace(s), ")")
 }
@@ -0,0 +1,218 @@
+package gobas
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect []string
+	}{
+		{
+			in:     `IFX>5THEN100`,
+			expect: []string{"IF", "X", ">", "5", "THEN", "100"},
+		},
+		{
+			in:     `FORI=1TO9STEP.5`,
+			expect: []string{"FOR", "I", "=", "1", "TO", "9", "STEP", ".5"},
+		},
+		{
+			in:     `A$="X:Y":B%=1E3+2E`,
+			expect: []string{"A$", "=", `"X:Y"`, ":", "B%", "=", "1E3", "+", "2", "E"},
+		},
+		{
+			in:     `IF A=<B OR C><D THEN 10ELSE20`,
+			expect: []string{"IF", "A", "<=", "B", "OR", "C", "<>", "D", "THEN", "10", "ELSE", "20"},
+		},
+		{
+			in:     `DATA 1,"A:B",C:REM IT'S: ALL REM`,
+			expect: []string{"DATA", `" 1,\"A:B\",C"`, ":", "REM", `" IT'S: ALL REM"`},
+		},
+		{
+			in:     `DEFINTI-N:X#=1.5#+D!*1D3`,
+			expect: []string{"DEFINT", "I", "-", "N", ":", "X#", "=", "1.5#", "+", "D!", "*", "1D3"},
+		},
+		{
+			in:     `?"HI"`,
+			expect: []string{"PRINT", `"HI"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			toks, err := tokenize(test.in, 0)
+			if err != nil {
+				t.Fatalf("tokenize: %v", err)
+			}
+			var got []string
+			for _, tok := range toks {
+				switch tok.kind {
+				case tokEOF:
+				case tokString:
+					got = append(got, tok.String())
+				case tokRaw:
+					got = append(got, tok.String())
+				default:
+					got = append(got, tok.text)
+				}
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Fatalf("want %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestParseStmts(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{in: `IFX>5THEN100`, expect: `IF X>5 THEN 100`},
+		{in: `IFX>5GOTO100ELSE200`, expect: `IF X>5 THEN 100 ELSE 200`},
+		{in: `FORI=1TO9STEP2:NEXTI`, expect: `FOR I=1 TO 9 STEP 2: NEXT I`},
+		{in: `IF A THEN B=1:C=2 ELSE D=3:E=4`, expect: `IF A THEN B=1: C=2 ELSE D=3: E=4`},
+		{in: `IF A THEN IF B THEN 10 ELSE 20`, expect: `IF A THEN IF B THEN 10 ELSE 20`},
+		{in: `IF A THEN 10 ELSE PRINT "NO"`, expect: `IF A THEN GOTO 10 ELSE PRINT "NO"`},
+		{in: `PRINT "A;B"X;LEFT$("X;Y",1),`, expect: `PRINT "A;B"X;LEFT$("X;Y",1),`},
+		{in: `IFXANDYTHENPRINT"OK"`, expect: `IF X AND Y THEN PRINT "OK"`},
+		{in: `ONXGOSUB10,20`, expect: `ON X GOSUB 10,20`},
+		{in: `LETA(I,J)=5`, expect: `A(I,J)=5`},
+		{in: `READ A,B$(I+1):INPUT"N";N`, expect: `READ A,B$(I+1): INPUT "N";N`},
+		{in: `DEFFNA(X,Y)=X*Y:DIMA(3),B(2,2)`, expect: `DEF FNA(X,Y)=X*Y: DIM A(3),B(2,2)`},
+		{in: `WHILEX<3:X=X+1:WEND`, expect: `WHILE X<3: X=X+1: WEND`},
+		{in: `DOUNTILX>3:IFXTHENEXITDO`, expect: `DO UNTIL X>3: IF X THEN EXIT DO`},
+		{in: `DO:LOOPWHILEX`, expect: `DO: LOOP WHILE X`},
+		{in: `IF X THEN`, expect: `IF X THEN`},
+		{in: `ELSEIFX=1THEN:ELSE:ENDIF`, expect: `ELSEIF X=1 THEN: ELSE: END IF`},
+		{in: `SUBP(A,B$()):SHAREDX,C():STATICN`, expect: `SUB P(A,B$()): SHARED X,C(): STATIC N`},
+		{in: `CALLP(X+1,B$()):CALLQ`, expect: `CALL P(X+1,B$()): CALL Q`},
+		{in: `FUNCTIONF$(N):F$=STR$(N):EXITFUNCTION:ENDFUNCTION`, expect: `FUNCTION F$(N): F$=STR$(N): EXIT FUNCTION: END FUNCTION`},
+		{in: `EXITSUB:ENDSUB`, expect: `EXIT SUB: END SUB`},
+		// the keywords Microsoft BASIC doesn't have may start variable names
+		{in: `DOWN=5:LOOPS=1:CALLS=DOWN`, expect: `DOWN=5: LOOPS=1: CALLS=DOWN`},
+		{in: `LINES(2)=3: SUBS$="X": EXITS=LOOPX+FUNCTIONS`, expect: `LINES(2)=3: SUBS$="X": EXITS=LOOPX+FUNCTIONS`},
+		{in: `IF X THEN SHARED=1 ELSE STATICS=2`, expect: `IF X THEN SHARED=1 ELSE STATICS=2`},
+		{in: `PRINT DOWN;LOOPS;CALLS;LINES(1);UNTILX`, expect: `PRINT DOWN;LOOPS;CALLS;LINES(1);UNTILX`},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			stmts, err := NewParser().ParseStmts(test.in)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := formatStmts(stmts); got != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		in     string
+		col    int
+		expect string
+	}{
+		{in: `FOR I=1 9`, col: 9, expect: `expected TO, found "9"`},
+		{in: `IF A THEN IF X THEN`, col: 20, expect: `expected statement or line number, found end of line`},
+		{in: `IF X PRINT`, col: 6, expect: `expected THEN or GOTO, found "PRINT"`},
+		{in: `GOTO X`, col: 6, expect: `undefined label "X"`},
+		{in: `GOSUB "X"`, col: 7, expect: `expected line number, found "X"`},
+		{in: `PRINT (1`, col: 9, expect: `expected ")", found end of line`},
+		{in: `A=1 B=2`, col: 5, expect: `expected ":" or end of line, found "B"`},
+		{in: `PRINT ELSE`, col: 7, expect: `expected ":" or end of line, found "ELSE"`},
+		{in: `ELSE PRINT`, col: 6, expect: `expected ":" or end of line, found "PRINT"`},
+		{in: `ELSEIF X PRINT`, col: 10, expect: `expected THEN, found "PRINT"`},
+		{in: `EXIT FOR`, col: 6, expect: `expected DO or SUB or FUNCTION, found "FOR"`},
+		{in: `SUB P(A(1))`, col: 9, expect: `expected ")", found "1"`},
+		{in: `THEN`, col: 1, expect: `expected statement, found "THEN"`},
+		{in: `ON X GOSIB 10`, col: 6, expect: `expected GOTO or GOSUB, found "GOSIB"`},
+		{in: `X=1@`, col: 4, expect: `unexpected character '@'`},
+		{in: `DEFINT AB`, col: 8, expect: `expected letter, found "AB"`},
+		{in: `DEFSTR X-C`, col: 10, expect: `invalid letter range X-C`},
+		{in: `PRINT 1+*2`, col: 9, expect: `expression "1+*2": unexpected "*" at 2`},
+		{in: `IF A AND OR B THEN 10`, col: 10, expect: `expression "A AND OR B": unexpected "OR" at 6`},
+		{in: `X="A"+1.2.3`, col: 7, expect: `expression "\"A\"+1.2.3": invalid number "1.2.3" at 4`},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			_, err := NewParser().ParseStmts(test.in)
+			var serr *SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("want syntax error, got %v", err)
+			}
+			if serr.Col != test.col || serr.Msg != test.expect {
+				t.Fatalf("want col %d: %q, got col %d: %q", test.col, test.expect, serr.Col, serr.Msg)
+			}
+		})
+	}
+}
+
+func TestParseLineColumns(t *testing.T) {
+	_, err := NewParser().Parse(strings.NewReader("100 FORI=1TO"))
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("want syntax error, got %v", err)
+	}
+	if serr.Col != 13 {
+		t.Fatalf("want col 13, got %d", serr.Col)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	src := `
+		REM COUNTS TO 3
+		  I = 0
+		loop:
+		  I = I + 1: GOSUB show
+		  IF I < 3 THEN loop ELSE done
+
+		show: PRINT I;
+		  RETURN
+		done:
+		  ON 1 GOTO finish
+		finish: PRINT "DONE"
+	`
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	state.SetStreams(Streams{Stdout: stdout})
+	_, err = state.Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := " 1  2  3 DONE\n"; stdout.String() != want {
+		t.Fatalf("want stdout %q, got %q", want, stdout.String())
+	}
+
+	buf := &bytes.Buffer{}
+	List(buf, state.Lines()[1:4])
+	expect := "3 I=0\n4 loop:\n5 I=I+1: GOSUB 8\n"
+	if buf.String() != expect {
+		t.Fatalf("want listing %q, got %q", expect, buf.String())
+	}
+	if line := state.Lines()[5]; line.Label() != "show" || line.SourceLine() != 8 {
+		t.Fatalf("want label show in source line 8, got %q in %d", line.Label(), line.SourceLine())
+	}
+
+	_, err = NewParser().Parse(strings.NewReader("a: PRINT\n\nGOTO b\na: END"))
+	want := "in line 4 (src = 4): label \"a\" is already defined in line 1\n" +
+		"in line 3 (src = 3): syntax error at column 6: undefined label \"b\""
+	if err == nil || err.Error() != want {
+		t.Fatalf("want error %q, got %v", want, err)
+	}
+
+	p := NewParser()
+	p.SetSourceMode(SourceUnnumbered)
+	_, err = p.Parse(strings.NewReader("10 PRINT"))
+	if err == nil || !strings.Contains(err.Error(), `expected statement, found "10"`) {
+		t.Fatalf("want error on line number in unnumbered source, got %v", err)
+	}
+}
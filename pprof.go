@@ -0,0 +1,160 @@
+package gobas
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WritePprof writes the profile in the format of pprof, so `go tool pprof` reports it. Each line of the program
+// is a function named like "line 100" in the source file, its stack are the active FOR loops, GOSUBs and calls.
+// The samples are the number of statements executed and their time excluding the calls made by them.
+func (p *Profile) WritePprof(w io.Writer, file string) error {
+	strs := map[string]int{}
+	var table []string
+	str := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return int64(i)
+		}
+		strs[s] = len(table)
+		table = append(table, s)
+		return int64(len(table) - 1)
+	}
+	str("")
+
+	pb := &protobuf{}
+	valueType := func(tag int, typ, unit string) {
+		vt := &protobuf{}
+		vt.int64(1, str(typ))
+		vt.int64(2, str(unit))
+		pb.message(tag, vt)
+	}
+	// profile.proto: sample_type = 1, sample = 2, location = 4, function = 5, string_table = 6,
+	// duration_nanos = 10, period_type = 11, period = 12
+	valueType(1, "samples", "count")
+	valueType(1, "time", "nanoseconds")
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	used := map[int]bool{}
+	var total int64
+	for _, key := range keys {
+		smp := p.samples[key]
+		ids := make([]uint64, len(smp.stack))
+		for i, idx := range smp.stack {
+			ids[i] = uint64(idx + 1)
+			used[idx] = true
+		}
+		sm := &protobuf{}
+		sm.packedUint64(1, ids)
+		sm.packedInt64(2, []int64{smp.count, int64(smp.time)})
+		pb.message(2, sm)
+		total += int64(smp.time)
+	}
+
+	var idxs []int
+	for idx := range used {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		ln := &protobuf{}
+		ln.uint64(1, uint64(idx+1))
+		ln.int64(2, int64(p.lines[idx].SourceLine))
+		loc := &protobuf{}
+		loc.uint64(1, uint64(idx+1))
+		loc.message(4, ln)
+		pb.message(4, loc)
+	}
+	for _, idx := range idxs {
+		name := str("line " + strconv.Itoa(p.lines[idx].Line))
+		fn := &protobuf{}
+		fn.uint64(1, uint64(idx+1))
+		fn.int64(2, name)
+		fn.int64(3, name)
+		fn.int64(4, str(file))
+		fn.int64(5, int64(p.lines[idx].SourceLine))
+		pb.message(5, fn)
+	}
+	// the strings are used above, so the table is complete only now
+	periodType := &protobuf{}
+	periodType.int64(1, str("time"))
+	periodType.int64(2, str("nanoseconds"))
+	for _, s := range table {
+		pb.string(6, s)
+	}
+	pb.int64(10, total)
+	pb.message(11, periodType)
+	pb.int64(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pb.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protobuf encodes the fields of a protocol buffer message
+type protobuf struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) key(tag int, wire int) {
+	b.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	b.key(tag, wireVarint)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protobuf) bytes(tag int, bs []byte) {
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(bs)))
+	b.data = append(b.data, bs...)
+}
+
+func (b *protobuf) string(tag int, s string) {
+	b.bytes(tag, []byte(s))
+}
+
+func (b *protobuf) message(tag int, m *protobuf) {
+	b.bytes(tag, m.data)
+}
+
+func (b *protobuf) packedUint64(tag int, xs []uint64) {
+	p := &protobuf{}
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(tag, p.data)
+}
+
+func (b *protobuf) packedInt64(tag int, xs []int64) {
+	p := &protobuf{}
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytes(tag, p.data)
+}
@@ -1,7 +1,5 @@
 package gobas
 
-import "strings"
-
 /*
 Quote from https://www.c64-wiki.de/wiki/PRINT
 
@@ -22,63 +20,373 @@ Folgende Eigenschaften und Besonderheiten weist PRINT auf:
 * Mittels BASIC-Befehl CMD kann die Bildschirmausgabe mit PRINT auch auf ein anderes Gerät umgeleitet werden.
 */
 
-//TODO: Print!
+// Unlike the C64, Microsoft BASIC on the PC has print zones of 14 columns and prints numbers with
+// 7 (single) or 16 (double) significant digits.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
 
 type printItem interface{}
 
-type printSemicolon struct{}
-type printComma struct{}
+type PrintSemicolon struct{}
+type PrintComma struct{}
+
+// PrintTab moves the cursor to the 1-based column of Expr
+type PrintTab struct {
+	Expr Expr
+}
+
+// PrintSpc prints Expr spaces
+type PrintSpc struct {
+	Expr Expr
+}
+
+// FormatNumber formats a number like STR$ does: with a leading space instead of a plus sign, without a
+// leading zero (.5) and in scientific notation (1E+10), if it doesn't fit into the significant digits.
+func FormatNumber(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case int16:
+		s = strconv.Itoa(int(v))
+	case float32:
+		s = formatFloat(float64(v), 7, "E")
+	default:
+		f, _ := expr.ConvertToFloat(v)
+		s = formatFloat(f, 16, "D")
+	}
+	if !strings.HasPrefix(s, "-") {
+		s = " " + s
+	}
+	return s
+}
+
+// FormatValue formats a value exactly, like the debugger and traces show it. Strings are quoted.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatFloat formats f with up to digits significant digits. expChar separates mantissa and exponent in the
+// scientific notation, which is used for numbers below .01 and for numbers with more than digits integer digits.
+func formatFloat(f float64, digits int, expChar string) string {
+	if f == 0 {
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	ds, exp := significand(f, digits)
+	ds = strings.TrimRight(ds, "0")
 
-func mustParsePrint(raw string) PRINT {
-	print := PRINT{}
-	var curr string
-	flush := func() {
-		curr = strings.TrimSpace(curr)
-		if curr == "" {
-			return
+	if exp < -2 || exp >= digits {
+		s := ds[:1]
+		if len(ds) > 1 {
+			s += "." + ds[1:]
 		}
-		expr := mustParseExpression(curr)
-		print.Items = append(print.Items, expr)
-		curr = ""
-	}
-
-	var inQuotes bool
-	var bcount int
-	for _, r := range raw {
-		if r == '"' {
-			if !inQuotes {
-				flush()
-				inQuotes = true
-			} else {
-				inQuotes = false
-				curr += string(r)
-				flush()
-				continue
+		return sign + s + formatExponent(expChar, exp)
+	}
+	if exp < 0 {
+		return sign + "." + strings.Repeat("0", -exp-1) + ds
+	}
+	if len(ds) <= exp+1 {
+		return sign + ds + strings.Repeat("0", exp+1-len(ds))
+	}
+	return sign + ds[:exp+1] + "." + ds[exp+1:]
+}
+
+// significand rounds the positive f to digits significant digits. It returns the digits and the decimal
+// exponent of the first one.
+func significand(f float64, digits int) (string, int) {
+	mant, exps, _ := strings.Cut(strconv.FormatFloat(f, 'e', digits-1, 64), "e")
+	exp, _ := strconv.Atoi(exps)
+	return strings.Replace(mant, ".", "", 1), exp
+}
+
+func formatExponent(expChar string, exp int) string {
+	esign := "+"
+	if exp < 0 {
+		esign = "-"
+		exp = -exp
+	}
+	return expChar + esign + padLeft(strconv.Itoa(exp), 2, '0')
+}
+
+func padLeft(s string, n int, c byte) string {
+	if len(s) >= n {
+		return s
+	}
+	return strings.Repeat(string(c), n-len(s)) + s
+}
+
+// usingField is a field of a PRINT USING format. String fields are !, & and \  \ (kind '!', '&' and '\'),
+// all others are numeric (kind '#').
+type usingField struct {
+	kind   byte
+	width  int
+	digits int
+	// decimals is the number of digits right of the decimal point or -1 without a decimal point
+	decimals  int
+	comma     bool
+	plus      bool
+	trailPlus bool
+	trailMin  bool
+	asterisk  bool
+	dollar    bool
+	exp       bool
+}
+
+// FormatUsing formats values with the fields of format like PRINT USING. If there are more values than fields,
+// the format starts over. Numbers, which don't fit into their field, are printed with a leading %.
+func FormatUsing(format string, values []interface{}) (string, error) {
+	var sb strings.Builder
+	pos := 0
+	fields := 0
+	for _, v := range values {
+		field, next, ok := nextUsingField(format, pos, &sb)
+		if !ok {
+			if fields == 0 {
+				return "", errors.Errorf("Illegal function call: no field in format %q", format)
 			}
+			field, next, _ = nextUsingField(format, 0, &sb)
+		}
+		fields++
+		s, err := field.format(v)
+		if err != nil {
+			return "", err
 		}
-		if inQuotes {
-			curr += string(r)
+		sb.WriteString(s)
+		pos = next
+	}
+	// the literals up to the next field are printed as well
+	nextUsingField(format, pos, &sb)
+	return sb.String(), nil
+}
+
+// nextUsingField scans format from pos on for the next field. The literals in front of it are written to lit.
+func nextUsingField(format string, pos int, lit *strings.Builder) (usingField, int, bool) {
+	for pos < len(format) {
+		c := format[pos]
+		switch c {
+		case '_':
+			if pos+1 < len(format) {
+				lit.WriteByte(format[pos+1])
+			}
+			pos += 2
 			continue
+		case '!', '&':
+			return usingField{kind: c}, pos + 1, true
+		case '\\':
+			end := pos + 1
+			for end < len(format) && format[end] == ' ' {
+				end++
+			}
+			if end < len(format) && format[end] == '\\' {
+				return usingField{kind: c, width: end - pos + 1}, end + 1, true
+			}
+		default:
+			if field, next, ok := scanNumericField(format, pos); ok {
+				return field, next, true
+			}
 		}
-		if r == '(' {
-			bcount++
+		lit.WriteByte(c)
+		pos++
+	}
+	return usingField{}, pos, false
+}
+
+func scanNumericField(format string, pos int) (usingField, int, bool) {
+	f := usingField{kind: '#', decimals: -1}
+	i := pos
+	if format[i] == '+' {
+		f.plus = true
+		i++
+	}
+	rest := format[i:]
+	switch {
+	case strings.HasPrefix(rest, "**$"):
+		f.asterisk, f.dollar, f.digits = true, true, 2
+		i += 3
+	case strings.HasPrefix(rest, "**"):
+		f.asterisk, f.digits = true, 2
+		i += 2
+	case strings.HasPrefix(rest, "$$"):
+		f.dollar, f.digits = true, 1
+		i += 2
+	case strings.HasPrefix(rest, "#"), strings.HasPrefix(rest, ".#"):
+	default:
+		return f, pos, false
+	}
+	for i < len(format) && (format[i] == '#' || (format[i] == ',' && f.decimals < 0)) {
+		if format[i] == ',' {
+			f.comma = true
 		}
-		if r == ')' {
-			bcount--
+		f.digits++
+		i++
+	}
+	if i < len(format) && format[i] == '.' {
+		f.decimals = 0
+		i++
+		for i < len(format) && format[i] == '#' {
+			f.decimals++
+			i++
 		}
-		if bcount == 0 && r == ';' {
-			flush()
-			print.Items = append(print.Items, printSemicolon{})
-			continue
+	}
+	if strings.HasPrefix(format[i:], "^^^^") {
+		f.exp = true
+		i += 4
+	}
+	if !f.plus && i < len(format) {
+		switch format[i] {
+		case '+':
+			f.trailPlus = true
+			i++
+		case '-':
+			f.trailMin = true
+			i++
 		}
-		if bcount == 0 && r == ',' {
-			flush()
-			print.Items = append(print.Items, printComma{})
-			continue
+	}
+	return f, i, true
+}
+
+func (f usingField) format(v interface{}) (string, error) {
+	str, isStr := v.(string)
+	if isStr != (f.kind != '#') {
+		return "", expr.ErrTypeMismatch
+	}
+	switch f.kind {
+	case '!':
+		if str == "" {
+			return " ", nil
+		}
+		return str[:1], nil
+	case '&':
+		return str, nil
+	case '\\':
+		if len(str) >= f.width {
+			return str[:f.width], nil
 		}
-		curr += string(r)
+		return str + strings.Repeat(" ", f.width-len(str)), nil
 	}
-	flush()
 
-	return print
+	n, err := expr.ConvertToFloat(v)
+	if err != nil {
+		return "", err
+	}
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	var intPart, fracPart string
+	if f.exp {
+		intPart, fracPart = f.formatExp(n)
+	} else {
+		decimals := f.decimals
+		if decimals < 0 {
+			decimals = 0
+		}
+		// like Microsoft BASIC, halves are rounded away from zero
+		scale := math.Pow(10, float64(decimals))
+		intPart, fracPart, _ = strings.Cut(strconv.FormatFloat(math.Round(n*scale)/scale, 'f', decimals, 64), ".")
+		if f.comma {
+			intPart = insertCommas(intPart)
+		}
+	}
+
+	var sign, trail string
+	switch {
+	case f.plus && neg:
+		sign = "-"
+	case f.plus:
+		sign = "+"
+	case f.trailPlus && neg:
+		trail = "-"
+	case f.trailPlus:
+		trail = "+"
+	case f.trailMin && neg:
+		trail = "-"
+	case f.trailMin:
+		trail = " "
+	case neg:
+		sign = "-"
+	}
+	dollar := ""
+	width := f.digits
+	if f.dollar {
+		dollar = "$"
+		width++
+	}
+	if f.plus {
+		width++
+	}
+	body := sign + dollar + intPart
+	if intPart == "0" && f.decimals > 0 && len(body) > width {
+		body = sign + dollar
+	}
+	if f.decimals >= 0 {
+		fracPart = "." + fracPart
+	}
+	if len(body) > width {
+		return "%" + body + fracPart + trail, nil
+	}
+	fill := " "
+	if f.asterisk {
+		fill = "*"
+	}
+	return strings.Repeat(fill, width-len(body)) + body + fracPart + trail, nil
+}
+
+// formatExp formats the positive n in scientific notation, so that it fills the digit positions of f.
+// Without an explicit sign one digit position is reserved for the sign.
+func (f usingField) formatExp(n float64) (string, string) {
+	intDigits := f.digits
+	if !f.plus && !f.trailPlus && !f.trailMin && intDigits > 0 {
+		intDigits--
+	}
+	decimals := f.decimals
+	if decimals < 0 {
+		decimals = 0
+	}
+	digits := intDigits + decimals
+	if digits == 0 {
+		digits = 1
+	}
+	ds, exp := strings.Repeat("0", digits), 0
+	if n != 0 {
+		ds, exp = significand(n, digits)
+		exp -= intDigits - 1
+	}
+	intPart, fracPart := ds[:intDigits], ds[intDigits:]
+	if intDigits == 0 && n != 0 {
+		intPart = ""
+	}
+	return intPart, fracPart + formatExponent("E", exp)
+}
+
+// insertCommas groups the digits of an integer by thousands
+func insertCommas(digits string) string {
+	var sb strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
 }
@@ -0,0 +1,204 @@
+package gobas
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		in     interface{}
+		expect string
+	}{
+		{in: int16(0), expect: " 0"},
+		{in: int16(-12), expect: "-12"},
+		{in: float32(0.5), expect: " .5"},
+		{in: float32(-0.5), expect: "-.5"},
+		{in: float32(1.0 / 3), expect: " .3333333"},
+		{in: float32(2.0 / 3), expect: " .6666667"},
+		{in: float32(123.456), expect: " 123.456"},
+		{in: float32(1234567), expect: " 1234567"},
+		{in: float32(12345678), expect: " 1.234568E+07"},
+		{in: float32(1e10), expect: " 1E+10"},
+		{in: float32(0.01), expect: " .01"},
+		{in: float32(0.001), expect: " 1E-03"},
+		{in: float32(-1.5e-5), expect: "-1.5E-05"},
+		{in: float64(1.0 / 3), expect: " .3333333333333333"},
+		{in: float64(1e20), expect: " 1D+20"},
+		{in: float64(100), expect: " 100"},
+	}
+	for _, test := range tests {
+		if got := FormatNumber(test.in); got != test.expect {
+			t.Fatalf("%v (%T): want %q, got %q", test.in, test.in, test.expect, got)
+		}
+	}
+}
+
+func TestRunPrint(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		expect    string
+		expectErr string
+	}{
+		{
+			name:   "numbers and strings",
+			src:    `10 PRINT 1;-2;"A";3.5;"B"`,
+			expect: " 1 -2 A 3.5 B\n",
+		},
+		{
+			name:   "comma zones",
+			src:    `10 PRINT "A","B";1,-2`,
+			expect: "A             B 1           -2 \n",
+		},
+		{
+			name:   "comma beyond the last zone",
+			src:    `10 PRINT 1,2,3,4,5,6`,
+			expect: " 1             2             3             4             5 \n 6 \n",
+		},
+		{
+			name:   "trailing comma and semicolon",
+			src:    "10 PRINT \"A\",\n20 PRINT \"B\";\n30 PRINT \"C\"",
+			expect: "A             BC\n",
+		},
+		{
+			name:   "tab",
+			src:    `10 PRINT "AB";TAB(5);"C";TAB(2);"D"`,
+			expect: "AB  C\n D\n",
+		},
+		{
+			name:   "spc",
+			src:    `10 PRINT "A";SPC(3);"B";SPC(2)`,
+			expect: "A   B  ",
+		},
+		{
+			name:   "tab without semicolons",
+			src:    `10 PRINT TAB(3)"X"`,
+			expect: "  X\n",
+		},
+		{
+			name:   "str$",
+			src:    `10 PRINT STR$(5);STR$(-.25);"|"`,
+			expect: " 5-.25|\n",
+		},
+		{
+			name:   "print using",
+			src:    "10 A=3.14159: N$=\"PI\"\n20 PRINT USING \"& = ##.##\";N$,A\n30 PRINT USING \"[###]\";1,22;\n40 PRINT \"|\"",
+			expect: "PI =  3.14\n[  1][ 22]|\n",
+		},
+		{
+			name:      "print using with a numeric format",
+			src:       `10 PRINT USING 5;1`,
+			expectErr: "Type mismatch",
+		},
+		{
+			name:      "tab outside of print",
+			src:       `10 A$=TAB(5)`,
+			expectErr: `no such func "TAB"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("want error %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestFormatUsing(t *testing.T) {
+	tests := []struct {
+		format string
+		values []interface{}
+		expect string
+	}{
+		{format: "###.##", values: []interface{}{float32(3.14159)}, expect: "  3.14"},
+		{format: "##.##", values: []interface{}{float32(0.5)}, expect: " 0.50"},
+		{format: "#.##", values: []interface{}{float32(-0.5)}, expect: "-.50"},
+		{format: "###", values: []interface{}{int16(-12)}, expect: "-12"},
+		{format: "##", values: []interface{}{float32(123)}, expect: "%123"},
+		{format: "##.##", values: []interface{}{float32(123.456)}, expect: "%123.46"},
+		{format: "+##.#", values: []interface{}{float32(1.25)}, expect: " +1.3"},
+		{format: "+#.#", values: []interface{}{float32(-1.25)}, expect: "-1.3"},
+		{format: "##.#-", values: []interface{}{float32(-1.5), float32(1.5)}, expect: " 1.5- 1.5 "},
+		{format: "##.#+", values: []interface{}{float32(-1.5), float32(1.5)}, expect: " 1.5- 1.5+"},
+		{format: "**##.#", values: []interface{}{float32(1.5)}, expect: "***1.5"},
+		{format: "$$##.##", values: []interface{}{float32(12.5)}, expect: " $12.50"},
+		{format: "**$##.##", values: []interface{}{float32(12.5)}, expect: "**$12.50"},
+		{format: "##,######", values: []interface{}{float64(1234567)}, expect: "1,234,567"},
+		{format: "##.##^^^^", values: []interface{}{float32(234.56)}, expect: " 2.35E+02"},
+		{format: "+.##^^^^", values: []interface{}{float32(-0.000123)}, expect: "-.12E-03"},
+		{format: "!", values: []interface{}{"HELLO"}, expect: "H"},
+		{format: `\  \`, values: []interface{}{"HELLO"}, expect: "HELL"},
+		{format: `\    \|`, values: []interface{}{"HI"}, expect: "HI    |"},
+		{format: "&!", values: []interface{}{"AB", "CD"}, expect: "ABC"},
+		{format: "[##] ", values: []interface{}{int16(1), int16(2)}, expect: "[ 1] [ 2] "},
+		{format: "_#: ##", values: []interface{}{int16(7)}, expect: "#:  7"},
+		{format: "## DM", values: []interface{}{int16(7)}, expect: " 7 DM"},
+	}
+	for _, test := range tests {
+		got, err := FormatUsing(test.format, test.values)
+		if err != nil {
+			t.Fatalf("%q %v: want NO error, got %v", test.format, test.values, err)
+		}
+		if got != test.expect {
+			t.Fatalf("%q %v: want %q, got %q", test.format, test.values, test.expect, got)
+		}
+	}
+
+	_, err := FormatUsing("##", []interface{}{"A"})
+	if err == nil || !strings.Contains(err.Error(), "Type mismatch") {
+		t.Fatalf("want type mismatch, got %v", err)
+	}
+	_, err = FormatUsing("NONE", []interface{}{int16(1)})
+	if err == nil || !strings.Contains(err.Error(), "Illegal function call") {
+		t.Fatalf("want illegal function call, got %v", err)
+	}
+}
+
+// sampleInputs are the inputs of the samples, which read INPUT. When the input is used up, INPUT ends a program.
+var sampleInputs = map[string]string{
+	"001_aceyducey.bas": "10\n0\n50\n100\n",
+	"002_amazing.bas":   "6,4\n",
+}
+
+// TestSampleTranscripts runs the samples with a seeded RND and compares their output with the transcripts in
+// testfiles/transcripts. The output of INPUT is in the transcripts, the input isn't, as it isn't echoed.
+func TestSampleTranscripts(t *testing.T) {
+	files, err := filepath.Glob("samples/*.bas")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no samples found: %v", err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read %q: %v", file, err)
+			}
+			want, err := os.ReadFile(filepath.Join("testfiles", "transcripts", strings.TrimSuffix(name, ".bas")+".txt"))
+			if err != nil {
+				t.Fatalf("read transcript: %v", err)
+			}
+			out, _, err := runProgram(t, "1 X=RND(-7)\n"+string(src), sampleInputs[name])
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if out != string(want) {
+				t.Fatalf("want output\n%s\ngot\n%s", want, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package gobas
+
+import (
+	"fmt"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// ErrParamType is returned, if a variable or array is passed by reference to a parameter of another type
+var ErrParamType = errors.New("Parameter type mismatch")
+
+// procedure is a SUB or FUNCTION of the program
+type procedure struct {
+	name   string
+	params []Param
+	isFunc bool
+	// pos is the position of the SUB or FUNCTION statement
+	pos     BlockPos
+	lineNum int
+	// statics are the STATIC variables, which keep their values between calls
+	statics *expr.Vars
+}
+
+func (p *procedure) kind() string {
+	if p.isFunc {
+		return "FUNCTION"
+	}
+	return "SUB"
+}
+
+// collectProcs collects the SUBs and FUNCTIONs of lines. Like DATA they are defined before the program runs.
+func collectProcs(lines []Line) (map[string]*procedure, []lineError) {
+	procs := map[string]*procedure{}
+	var errs []lineError
+	for i, line := range lines {
+		for j, stmt := range flatten(line.stmts) {
+			var proc *procedure
+			switch stmt := stmt.(type) {
+			case SUB:
+				proc = &procedure{name: stmt.Name, params: stmt.Params}
+			case FUNCTION:
+				proc = &procedure{name: stmt.Name, params: stmt.Params, isFunc: true}
+			default:
+				continue
+			}
+			if other, ok := procs[proc.name]; ok {
+				errs = append(errs, lineError{
+					lineNum: line.num,
+					pos:     BlockPos{LineIdx: i, StmtIdx: j},
+					msg:     fmt.Sprintf("%s %s is already defined in line %d", proc.kind(), proc.name, other.lineNum),
+				})
+				continue
+			}
+			proc.pos = BlockPos{LineIdx: i, StmtIdx: j}
+			proc.lineNum = line.num
+			procs[proc.name] = proc
+		}
+	}
+	return procs, errs
+}
+
+// defineProcs resets the STATIC variables of the procedures and registers the FUNCTIONs, which are called
+// with their unevaluated arguments to pass them by reference
+func (s *State) defineProcs() {
+	for _, proc := range s.procs {
+		proc.statics = expr.NewVars()
+		if !proc.isFunc {
+			continue
+		}
+		proc := proc
+		s.funcs.AddRefFunc(proc.name, func(lu expr.Lookuper, args []expr.Evaler) (interface{}, error) {
+			return s.callFunction(proc, lu, args)
+		})
+	}
+}
+
+// frame is the scope of a procedure call. Its local variables are not visible outside of the call.
+// Parameters passed by reference resolve to the variables of the caller, SHARED variables to the global ones
+// and STATIC variables to the ones of the procedure.
+type frame struct {
+	proc         *procedure
+	vars         *expr.Vars
+	refs         map[string]varRef
+	arrays       map[string]any
+	shared       map[string]bool
+	sharedArrays map[string]bool
+	static       map[string]bool
+	globals      variables
+	types        *DefTypes
+	ret          returnPos
+	// nested is set for the calls of FUNCTIONs, which run nested in the evaluation of an expression
+	nested bool
+}
+
+// varRef is a variable or an array element passed by reference
+type varRef struct {
+	get func() (interface{}, error)
+	set func(v interface{}) error
+}
+
+func (f *frame) LookupVar(name string) (interface{}, error) {
+	if ref, ok := f.refs[name]; ok {
+		return ref.get()
+	}
+	if f.shared[name] {
+		return f.globals.LookupVar(name)
+	}
+	if v, err := f.local(name).LookupVar(name); err == nil {
+		return v, nil
+	}
+	return f.types.TypeOf(name).Zero(), nil
+}
+
+func (f *frame) CanEvalFloat(name string) bool {
+	return f.types.TypeOf(name) != expr.TypeString
+}
+
+func (f *frame) set(name string, v interface{}) error {
+	if ref, ok := f.refs[name]; ok {
+		return ref.set(v)
+	}
+	if f.shared[name] {
+		return f.globals.set(name, v)
+	}
+	f.local(name).Add(name, v)
+	return nil
+}
+
+func (f *frame) local(name string) *expr.Vars {
+	if f.static[name] {
+		return f.proc.statics
+	}
+	return f.vars
+}
+
+func (f *frame) share(v Param) {
+	if v.Array {
+		f.sharedArrays[v.Name] = true
+		return
+	}
+	f.shared[v.Name] = true
+}
+
+func (s *State) frame() *frame {
+	if len(s.frames) == 0 {
+		return nil
+	}
+	return s.frames[len(s.frames)-1]
+}
+
+// procFrame returns the frame of the current call, which stmt must be used in
+func (s *State) procFrame(stmt string) (*frame, error) {
+	f := s.frame()
+	if f == nil {
+		return nil, errors.Errorf("%s outside of SUB or FUNCTION", stmt)
+	}
+	return f, nil
+}
+
+func (s *State) inFunction() bool {
+	for _, f := range s.frames {
+		if f.nested {
+			return true
+		}
+	}
+	return false
+}
+
+// callerGosubDepth is the depth of the GOSUB stack, when the current procedure was called
+func (s *State) callerGosubDepth() int {
+	if f := s.frame(); f != nil {
+		return f.ret.gosubDepth
+	}
+	return 0
+}
+
+// newFrame binds the arguments, evaluated in the scope lu of the caller, to the parameters of proc
+func (s *State) newFrame(proc *procedure, lu expr.Lookuper, args []expr.Evaler) (*frame, error) {
+	if len(args) != len(proc.params) {
+		return nil, errors.Errorf("%s: expect %d args, got %d", proc.name, len(proc.params), len(args))
+	}
+	f := &frame{
+		proc:         proc,
+		vars:         expr.NewVars(),
+		refs:         map[string]varRef{},
+		arrays:       map[string]any{},
+		shared:       map[string]bool{},
+		sharedArrays: map[string]bool{},
+		static:       map[string]bool{},
+		globals:      s.globals,
+		types:        &s.types,
+	}
+	for i, param := range proc.params {
+		err := s.bind(f, param, lu, args[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: param %q", proc.name, param.Name)
+		}
+	}
+	return f, nil
+}
+
+// bind binds arg to param. Arrays, variables and array elements are passed by reference, if they have the
+// type of the parameter.
+func (s *State) bind(f *frame, param Param, lu expr.Lookuper, arg expr.Evaler) error {
+	ptype := s.types.TypeOf(param.Name)
+	if param.Array {
+		ar, ok := arg.(expr.ArrayRefEvaler)
+		if !ok {
+			return errors.Errorf("expect an array, got %s", arg.String())
+		}
+		a, ok := s.array(string(ar))
+		if !ok {
+			return errors.Errorf("no such array %q", string(ar))
+		}
+		if s.types.TypeOf(string(ar)) != ptype {
+			return ErrParamType
+		}
+		f.arrays[param.Name] = a
+		s.addArrayFunc(param.Name)
+		return nil
+	}
+
+	ref, name, ok, err := s.ref(lu, arg)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if s.types.TypeOf(name) != ptype {
+			return ErrParamType
+		}
+		f.refs[param.Name] = ref
+		return nil
+	}
+	v, err := arg.Eval(lu, s.funcs)
+	if err != nil {
+		return err
+	}
+	v, err = ptype.Convert(v)
+	if err != nil {
+		return err
+	}
+	f.vars.Add(param.Name, v)
+	return nil
+}
+
+// ref returns the reference to the variable or array element arg with its name. Other expressions, and
+// variables of scopes which can't be assigned, are not passed by reference.
+func (s *State) ref(lu expr.Lookuper, arg expr.Evaler) (varRef, string, bool, error) {
+	switch arg := arg.(type) {
+	case expr.VarEvaler:
+		sc, ok := lu.(scope)
+		if !ok {
+			return varRef{}, "", false, nil
+		}
+		name := string(arg)
+		return varRef{
+			get: func() (interface{}, error) { return sc.LookupVar(name) },
+			set: func(v interface{}) error { return sc.set(name, v) },
+		}, name, true, nil
+	case expr.FuncEvaler:
+		a, ok := s.array(arg.Name)
+		if !ok {
+			return varRef{}, "", false, nil
+		}
+		cs := make([]int, len(arg.Args))
+		for i, ev := range arg.Args {
+			f, err := expr.EvalFloat(ev, lu, s.funcs)
+			if err != nil {
+				return varRef{}, "", false, err
+			}
+			cs[i] = int(f)
+		}
+		return varRef{
+			get: func() (interface{}, error) { return getElement(a, cs) },
+			set: func(v interface{}) error { return setElement(a, cs, v) },
+		}, arg.Name, true, nil
+	default:
+		return varRef{}, "", false, nil
+	}
+}
+
+// enter pushes the frame of a call and continues with the first statement of the procedure
+func (s *State) enter(f *frame) error {
+	if len(s.frames) >= s.maxGosubDepth {
+		return errors.Errorf("stack overflow: more than %d nested calls", s.maxGosubDepth)
+	}
+	f.ret.forDepth = len(s.forStates)
+	f.ret.gosubDepth = len(s.gosubStack)
+	s.frames = append(s.frames, f)
+	s.vars = f
+	s.jumpBehind(f.proc.pos)
+	return nil
+}
+
+// leave pops the frame of the current call, which must be one of a FUNCTION (isFunc) or a SUB,
+// and returns to the caller
+func (s *State) leave(isFunc bool) error {
+	f := s.frame()
+	if f == nil || f.proc.isFunc != isFunc {
+		kind := "SUB"
+		if isFunc {
+			kind = "FUNCTION"
+		}
+		return errors.Errorf("return from %s outside of a call", kind)
+	}
+	s.pop(f)
+	s.jump(f.ret.lineIdx, f.ret.stmtIdx)
+	return nil
+}
+
+// pop removes the frame f and the frames of the calls made from it and restores the scope of the caller
+func (s *State) pop(f *frame) {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		if s.frames[i] == f {
+			s.frames = s.frames[:i]
+			break
+		}
+	}
+	if len(s.forStates) > f.ret.forDepth {
+		s.forStates = s.forStates[:f.ret.forDepth]
+	}
+	if len(s.gosubStack) > f.ret.gosubDepth {
+		s.gosubStack = s.gosubStack[:f.ret.gosubDepth]
+	}
+	if caller := s.frame(); caller != nil {
+		s.vars = caller
+	} else {
+		s.vars = s.globals
+	}
+}
+
+func (s *State) call(stmt CALL) error {
+	proc, ok := s.procs[stmt.Name]
+	if !ok || proc.isFunc {
+		return errors.Errorf("no such SUB %q", stmt.Name)
+	}
+	args := make([]expr.Evaler, len(stmt.Args))
+	for i, arg := range stmt.Args {
+		args[i] = arg.Tree
+	}
+	f, err := s.newFrame(proc, s.vars, args)
+	if err != nil {
+		return err
+	}
+	f.ret = returnPos{lineIdx: s.currIdx, stmtIdx: s.stmtIdx + 1}
+	return s.enter(f)
+}
+
+// callFunction runs the FUNCTION proc nested in the evaluation of an expression and returns the value,
+// which was assigned to its name
+func (s *State) callFunction(proc *procedure, lu expr.Lookuper, args []expr.Evaler) (interface{}, error) {
+	f, err := s.newFrame(proc, lu, args)
+	if err != nil {
+		return nil, err
+	}
+	f.nested = true
+	currIdx, stmtIdx, jumped := s.currIdx, s.stmtIdx, s.jumped
+	f.ret = returnPos{lineIdx: currIdx, stmtIdx: stmtIdx}
+	err = s.enter(f)
+	if err != nil {
+		return nil, err
+	}
+	depth := len(s.frames)
+	_, err = s.runCalls(depth)
+	switch {
+	case err != nil:
+		// the error is a RuntimeError of the statement, which failed. The statements calling the function
+		// pass it on unchanged.
+		s.pop(f)
+		s.currIdx, s.stmtIdx, s.jumped = currIdx, stmtIdx, jumped
+		return nil, err
+	case s.halted:
+		return f.types.TypeOf(proc.name).Zero(), nil
+	case len(s.frames) >= depth:
+		return nil, errors.Errorf("%s: missing END FUNCTION", proc.name)
+	}
+	s.currIdx, s.stmtIdx, s.jumped = currIdx, stmtIdx, jumped
+	return f.LookupVar(proc.name)
+}
@@ -0,0 +1,282 @@
+package gobas
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunProcs(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{
+			name: "call with local variables",
+			src: `
+				10 X=1: Y=2
+				20 CALL P(5)
+				30 PRINT X;Y
+				40 END
+				100 SUB P(X)
+				110   Y=X*2: PRINT X;Y
+				120 END SUB`,
+			expect: " 5  10 \n 1  2 \n",
+		},
+		{
+			name: "variables are passed by reference",
+			src: `
+				10 DIM B(2): A=1: B(2)=3
+				20 CALL INC(A): CALL INC(B(2)): CALL INC(A+1)
+				30 PRINT A;B(2)
+				40 SUB INC(N)
+				50   N=N+1
+				60 END SUB`,
+			expect: " 2  4 \n",
+		},
+		{
+			name: "arrays are passed by reference",
+			src: `
+				10 DIM A(3)
+				20 CALL FILL(A(), 3)
+				30 PRINT A(1);A(2);A(3)
+				40 SUB FILL(V(), N)
+				50   FOR I=1 TO N: V(I)=I*I: NEXT
+				60 END SUB`,
+			expect: " 1  4  9 \n",
+		},
+		{
+			name: "local arrays",
+			src: `
+				10 DIM A(2): A(1)=7
+				20 CALL P
+				30 PRINT A(1)
+				40 SUB P
+				50   DIM A(2): A(1)=8: PRINT A(1);
+				60 END SUB`,
+			expect: " 8  7 \n",
+		},
+		{
+			name: "shared",
+			src: `
+				10 DIM A(2): X=1
+				20 CALL P
+				30 PRINT X;A(1)
+				40 SUB P
+				50   SHARED X, A()
+				60   X=X+1: A(1)=5
+				70 END SUB`,
+			expect: " 2  5 \n",
+		},
+		{
+			name: "static",
+			src: `
+				10 FOR I=1 TO 3: CALL COUNT: NEXT
+				20 SUB COUNT
+				30   STATIC N
+				40   N=N+1: M=M+1: PRINT N;M;
+				50 END SUB`,
+			expect: " 1  1  2  1  3  1 ",
+		},
+		{
+			name: "exit sub",
+			src: `
+				10 CALL P(1): CALL P(0)
+				20 SUB P(X)
+				30   IF X THEN EXIT SUB
+				40   PRINT "ZERO"
+				50 END SUB`,
+			expect: "ZERO\n",
+		},
+		{
+			name: "recursive function",
+			src: `
+				10 PRINT FACT(5); FACT(1)+1
+				20 FUNCTION FACT(N)
+				30   IF N<=1 THEN FACT=1: EXIT FUNCTION
+				40   FACT=N*FACT(N-1)
+				50 END FUNCTION`,
+			expect: " 120  2 \n",
+		},
+		{
+			name: "string function in conditions",
+			src: `
+				10 FOR I=1 TO 3
+				20   IF PAD$(I)="  2" THEN PRINT "TWO" ELSE PRINT PAD$(I)
+				30 NEXT
+				40 FUNCTION PAD$(N)
+				50   PAD$=RIGHT$("  "+STR$(N),3)
+				60 END FUNCTION`,
+			expect: "  1\nTWO\n  3\n",
+		},
+		{
+			name: "recursive sub",
+			src: `
+				10 CALL HANOI(2, "A", "C", "B")
+				20 SUB HANOI(N, F$, T$, V$)
+				30   IF N=0 THEN EXIT SUB
+				40   CALL HANOI(N-1, F$, V$, T$)
+				50   PRINT F$;T$;" ";
+				60   CALL HANOI(N-1, V$, T$, F$)
+				70 END SUB`,
+			expect: "AB AC BC ",
+		},
+		{
+			name: "gosub inside a sub",
+			src: `
+				10 CALL P: PRINT "BACK"
+				20 SUB P
+				30   GOSUB 50: EXIT SUB
+				50   PRINT "SUB";: RETURN
+				60 END SUB`,
+			expect: "SUBBACK\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestRunProcErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{
+			name:   "parameter type mismatch",
+			src:    "10 CALL P(A$)\n20 SUB P(N)\n30 END SUB",
+			expect: `param "N": Parameter type mismatch`,
+		},
+		{
+			name:   "wrong number of args",
+			src:    "10 CALL P(1, 2)\n20 SUB P(N)\n30 END SUB",
+			expect: "P: expect 1 args, got 2",
+		},
+		{
+			name:   "no such sub",
+			src:    "10 CALL Q",
+			expect: `no such SUB "Q"`,
+		},
+		{
+			name:   "endless recursion",
+			src:    "10 CALL P\n20 SUB P\n30 CALL P\n40 END SUB",
+			expect: "stack overflow",
+		},
+		{
+			name:   "shared outside of a sub",
+			src:    "10 SHARED X",
+			expect: "SHARED outside of SUB or FUNCTION",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, exit, err := runProgram(t, test.src, "")
+			if err == nil || exit != ExitError {
+				t.Fatalf("want error, got exit %v", exit)
+			}
+			if !strings.Contains(err.Error(), test.expect) {
+				t.Fatalf("want error containing %q, got %q", test.expect, err.Error())
+			}
+		})
+	}
+
+	_, _, err := runProgram(t, "10 CALL P(A$)\n20 SUB P(N)\n30 END SUB", "")
+	if !errors.Is(err, ErrParamType) {
+		t.Fatalf("want ErrParamType, got %v", err)
+	}
+}
+
+func TestFunctionError(t *testing.T) {
+	src := "10 X=1: PRINT DEPTH(1)\n20 FUNCTION DEPTH(D)\n30   X=D: DEPTH = DEPTH(D+1)\n40 END FUNCTION"
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	state.SetStreams(Streams{Stdout: &bytes.Buffer{}})
+	_, err = state.Run()
+	want := `line 30: ASSIGN: eval "DEPTH(D+1)": stack overflow: more than 1024 nested calls`
+	if err == nil || err.Error() != want {
+		t.Fatalf("want %q, got %v", want, err)
+	}
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || rerr.Line != 30 || rerr.StmtIdx != 1 {
+		t.Fatalf("want the error located in line 30, got %#v", err)
+	}
+	if frames := state.Frames(); len(frames) != 0 {
+		t.Fatalf("want no frames after the error, got %+v", frames)
+	}
+	if x, _ := state.vars.LookupVar("X"); FormatValue(x) != "1" {
+		t.Fatalf("want the global X=1, got %v", x)
+	}
+}
+
+func TestUnbalancedProcs(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name:   "missing end sub",
+			src:    "10 SUB P\n20 PRINT",
+			expect: []string{"in line 10: SUB without END SUB"},
+		},
+		{
+			name:   "sub inside of a block",
+			src:    "10 WHILE 1\n20 SUB P\n30 END SUB\n40 WEND",
+			expect: []string{"in line 20: SUB inside of WHILE"},
+		},
+		{
+			name:   "exit do across a function",
+			src:    "10 DO\n20 FUNCTION F\n30 EXIT DO\n40 END FUNCTION\n50 LOOP",
+			expect: []string{"in line 20: FUNCTION inside of DO", "in line 30: EXIT DO outside of DO"},
+		},
+		{
+			name:   "mismatched end",
+			src:    "10 SUB P\n20 END FUNCTION\n30 EXIT FUNCTION",
+			expect: []string{"in line 10: SUB without END SUB", "in line 20: END FUNCTION without FUNCTION", "in line 30: EXIT FUNCTION outside of FUNCTION"},
+		},
+		{
+			name:   "duplicate definition",
+			src:    "10 SUB P\n20 END SUB\n30 FUNCTION P\n40 END FUNCTION",
+			expect: []string{"in line 30: FUNCTION P is already defined in line 10"},
+		},
+		{
+			name:   "goto out of a sub",
+			src:    "10 CALL S\n20 PRINT X\n30 SUB S\n40 X=5: GOTO 20\n50 END SUB",
+			expect: []string{"in line 40: jump to line 20 leaves SUB S"},
+		},
+		{
+			name:   "gosub into a function",
+			src:    "10 GOSUB 40: ON X GOTO 10, 50\n20 END\n30 FUNCTION F\n40 F=1\n50 END FUNCTION",
+			expect: []string{"in line 10: jump to line 40 enters FUNCTION F", "in line 10: jump to line 50 enters FUNCTION F"},
+		},
+		{
+			name:   "goto from a sub into a function",
+			src:    "10 SUB S\n20 IF X THEN 50 ELSE 20\n30 END SUB\n40 FUNCTION F\n50 F=1\n60 END FUNCTION",
+			expect: []string{"in line 20: jump to line 50 leaves SUB S"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewParser().Parse(strings.NewReader(test.src))
+			if err == nil {
+				t.Fatalf("want error, got NO error")
+			}
+			if want := strings.Join(test.expect, "\n"); err.Error() != want {
+				t.Fatalf("want %q, got %q", want, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package gobas
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile records how often and how long the lines and statements of a program executed. A State fills it,
+// once it is set with SetProfile.
+type Profile struct {
+	prog    []Line
+	lines   []LineProfile
+	loops   map[int]*LoopProfile
+	samples map[string]*sample
+	active  []activeStmt
+	lastIdx int
+	// returned is set by RETURN, which continues its GOSUB line instead of entering it again
+	returned bool
+}
+
+// LineProfile are the executions of a line
+type LineProfile struct {
+	Line       int
+	SourceLine int
+	// Count is how often the line was entered, by starting at its first statement or from another line,
+	// but not by returning from a GOSUB or a call
+	Count int64
+	// Time is the cumulative time of its statements including the procedures and functions they called
+	Time time.Duration
+	// Evals is the number of expressions evaluated by its statements
+	Evals int64
+	// Gosubs is how often the line was the target of a GOSUB or ON GOSUB
+	Gosubs int64
+	// Stmts are the statements of the line, counting the statements in the branches of single-line IFs
+	Stmts []StmtProfile
+}
+
+type StmtProfile struct {
+	Kind  string
+	Count int64
+	Time  time.Duration
+}
+
+// LoopProfile is a FOR loop with the time spent in its body including all GOSUBs and calls made from there
+type LoopProfile struct {
+	Line       int
+	Var        string
+	Iterations int64
+	Time       time.Duration
+}
+
+// sample is the self time of the statements executed with the same stack
+type sample struct {
+	// stack are the line indexes of the statement and the active FOR loops, GOSUBs and calls, the innermost first
+	stack []int
+	count int64
+	time  time.Duration
+}
+
+type activeStmt struct {
+	lineIdx int
+	stmtIdx int
+	stack   []int
+	loops   []int
+	start   time.Time
+	// nested is the time of the statements executed by calls from this one
+	nested time.Duration
+}
+
+func NewProfile() *Profile {
+	return &Profile{
+		loops:   map[int]*LoopProfile{},
+		samples: map[string]*sample{},
+		lastIdx: -1,
+	}
+}
+
+// SetProfile records the executions of the next runs in p. A nil p turns profiling off.
+func (s *State) SetProfile(p *Profile) {
+	s.profile = p
+	if p == nil || p.lines != nil {
+		return
+	}
+	p.prog = s.lines
+	p.lines = make([]LineProfile, len(s.lines))
+	for i, line := range s.lines {
+		lp := LineProfile{Line: line.num, SourceLine: line.SourceLine(), Stmts: make([]StmtProfile, len(line.code))}
+		for j, stmt := range line.code {
+			lp.Stmts[j].Kind = StmtKind(stmt)
+		}
+		p.lines[i] = lp
+	}
+}
+
+func (p *Profile) begin(s *State, stmtIdx int) {
+	lineIdx := s.currIdx
+	if lineIdx >= len(p.lines) {
+		// statements in immediate mode aren't profiled
+		p.active = append(p.active, activeStmt{lineIdx: -1, start: time.Now()})
+		return
+	}
+	lp := &p.lines[lineIdx]
+	if stmtIdx == 0 || (lineIdx != p.lastIdx && !p.returned) {
+		lp.Count++
+	}
+	p.lastIdx = lineIdx
+	p.returned = false
+	lp.Stmts[stmtIdx].Count++
+
+	as := activeStmt{lineIdx: lineIdx, stmtIdx: stmtIdx, stack: []int{lineIdx}}
+	frames := s.Frames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		idx := s.lineIdx.lookup(frames[i].Line)
+		if idx < 0 {
+			continue
+		}
+		as.stack = append(as.stack, idx)
+		if frames[i].Kind == "FOR" && !containsInt(as.loops, idx) {
+			as.loops = append(as.loops, idx)
+		}
+	}
+	as.start = time.Now()
+	p.active = append(p.active, as)
+}
+
+func (p *Profile) end() {
+	if len(p.active) == 0 {
+		return
+	}
+	as := p.active[len(p.active)-1]
+	p.active = p.active[:len(p.active)-1]
+	d := time.Since(as.start)
+	if len(p.active) > 0 {
+		p.active[len(p.active)-1].nested += d
+	}
+	if as.lineIdx < 0 {
+		return
+	}
+	p.lastIdx = as.lineIdx
+	lp := &p.lines[as.lineIdx]
+	p.returned = lp.Stmts[as.stmtIdx].Kind == "RETURN"
+	lp.Time += d
+	lp.Stmts[as.stmtIdx].Time += d
+
+	self := d - as.nested
+	key := stackKey(as.stack)
+	smp, ok := p.samples[key]
+	if !ok {
+		smp = &sample{stack: as.stack}
+		p.samples[key] = smp
+	}
+	smp.count++
+	smp.time += self
+	for _, idx := range as.loops {
+		if lp, ok := p.loops[idx]; ok {
+			lp.Time += self
+		}
+	}
+}
+
+func stackKey(stack []int) string {
+	sl := make([]string, len(stack))
+	for i, idx := range stack {
+		sl[i] = strconv.Itoa(idx)
+	}
+	return strings.Join(sl, ",")
+}
+
+func containsInt(ns []int, n int) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// eval counts an evaluated expression for the statement being executed
+func (p *Profile) eval() {
+	if len(p.active) == 0 {
+		return
+	}
+	if idx := p.active[len(p.active)-1].lineIdx; idx >= 0 {
+		p.lines[idx].Evals++
+	}
+}
+
+func (p *Profile) gosub(lineIdx int) {
+	if lineIdx < len(p.lines) {
+		p.lines[lineIdx].Gosubs++
+	}
+}
+
+// iterate counts an iteration of the FOR loop in the line at lineIdx, which is started by FOR or by NEXT
+func (p *Profile) iterate(lineIdx int, varName string) {
+	if lineIdx >= len(p.lines) {
+		return
+	}
+	lp, ok := p.loops[lineIdx]
+	if !ok {
+		lp = &LoopProfile{Line: p.lines[lineIdx].Line, Var: varName}
+		p.loops[lineIdx] = lp
+	}
+	lp.Iterations++
+}
+
+// Lines returns the profiles of all lines of the program
+func (p *Profile) Lines() []LineProfile {
+	return append([]LineProfile{}, p.lines...)
+}
+
+// Loops returns the profiles of the FOR loops, which executed, the one with the most time first
+func (p *Profile) Loops() []LoopProfile {
+	var lps []LoopProfile
+	for _, lp := range p.loops {
+		lps = append(lps, *lp)
+	}
+	sort.Slice(lps, func(i, j int) bool {
+		if lps[i].Time != lps[j].Time {
+			return lps[i].Time > lps[j].Time
+		}
+		return lps[i].Line < lps[j].Line
+	})
+	return lps
+}
+
+// WriteListing writes the program annotated with the count, time, evaluations and GOSUBs of each line.
+// The hot loops, at most n, follow the listing.
+func (p *Profile) WriteListing(w io.Writer, n int) error {
+	_, err := fmt.Fprintf(w, "%10s %12s %10s %8s  %s\n", "COUNT", "TIME", "EVALS", "GOSUBS", "LINE")
+	if err != nil {
+		return err
+	}
+	for i, line := range p.prog {
+		lp := p.lines[i]
+		_, err := fmt.Fprintf(w, "%10d %12s %10d %8d  %s\n", lp.Count, formatDuration(lp.Time), lp.Evals, lp.Gosubs, line)
+		if err != nil {
+			return err
+		}
+	}
+	loops := p.Loops()
+	if len(loops) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "\nhot loops:\n%10s %12s  %s\n", "ITERATIONS", "TIME", "LOOP"); err != nil {
+		return err
+	}
+	for i, lp := range loops {
+		if i >= n {
+			break
+		}
+		_, err := fmt.Fprintf(w, "%10d %12s  FOR %s in line %d\n", lp.Iterations, formatDuration(lp.Time), lp.Var, lp.Line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return d.String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
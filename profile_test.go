@@ -0,0 +1,118 @@
+package gobas
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileProgram = `
+10 FOR I=1 TO 3
+20   GOSUB 100: X=X+I
+30 NEXT
+40 PRINT X
+50 END
+100 Y=Y+1: RETURN`
+
+func runProfiled(t *testing.T, src string) *Profile {
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	state.SetStreams(Streams{Stdout: &bytes.Buffer{}})
+	prof := NewProfile()
+	state.SetProfile(prof)
+	if _, err := state.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return prof
+}
+
+func TestProfile(t *testing.T) {
+	prof := runProfiled(t, profileProgram)
+	type counts struct {
+		line, count, evals, gosubs int
+	}
+	var got []counts
+	for _, lp := range prof.Lines() {
+		got = append(got, counts{lp.Line, int(lp.Count), int(lp.Evals), int(lp.Gosubs)})
+		if lp.Count > 0 && lp.Time <= 0 {
+			t.Fatalf("want the time of line %d, got %v", lp.Line, lp.Time)
+		}
+	}
+	want := []counts{{10, 1, 3, 0}, {20, 3, 3, 0}, {30, 3, 0, 0}, {40, 1, 1, 0}, {50, 1, 0, 0}, {100, 3, 3, 3}}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+	if stmts := prof.Lines()[1].Stmts; len(stmts) != 2 || stmts[0].Kind != "GOSUB" || stmts[0].Count != 3 || stmts[1].Count != 3 {
+		t.Fatalf("want the statements of line 20, got %+v", stmts)
+	}
+	loops := prof.Loops()
+	if len(loops) != 1 || loops[0].Line != 10 || loops[0].Var != "I" || loops[0].Iterations != 3 || loops[0].Time <= 0 {
+		t.Fatalf("want the loop in line 10, got %+v", loops)
+	}
+
+	out := &bytes.Buffer{}
+	if err := prof.WriteListing(out, 10); err != nil {
+		t.Fatalf("listing: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if fields := strings.Fields(lines[2]); len(fields) < 6 || fields[0] != "3" || fields[2] != "3" || fields[3] != "0" || fields[4] != "20" {
+		t.Fatalf("want the annotated line 20, got %q", lines[2])
+	}
+	if !strings.Contains(out.String(), "hot loops:") || !strings.Contains(out.String(), "FOR I in line 10") {
+		t.Fatalf("want the hot loops, got\n%s", out.String())
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	prof := runProfiled(t, profileProgram)
+	file := filepath.Join(t.TempDir(), "prog.bas")
+	buf := &bytes.Buffer{}
+	if err := prof.WritePprof(buf, file); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	for _, s := range []string{"line 100", "nanoseconds", file} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Fatalf("want the string %q in the profile", s)
+		}
+	}
+
+	if testing.Short() {
+		t.Skip("skip go tool pprof in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain found")
+	}
+	pprofFile := filepath.Join(t.TempDir(), "prof.pprof")
+	if err := os.WriteFile(pprofFile, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	out, err := exec.Command("go", "tool", "pprof", "-top", "-sample_index=samples", pprofFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pprof: %v\n%s", err, out)
+	}
+	for _, s := range []string{"line 20", "line 100"} {
+		if !strings.Contains(string(out), s) {
+			t.Fatalf("want %q in the report of pprof, got\n%s", s, out)
+		}
+	}
+}
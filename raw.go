@@ -10,13 +10,40 @@ import (
 	"github.com/pkg/errors"
 )
 
+// SourceMode tells, if the lines of a source start with line numbers
+type SourceMode int
+
+const (
+	// SourceAuto detects the mode by the first line, which is numbered or not
+	SourceAuto SourceMode = iota
+	SourceNumbered
+	// SourceUnnumbered lines are numbered by their position in the source. They are jumped to by labels.
+	SourceUnnumbered
+)
+
+// ParseSourceMode parses the name of a source mode: auto, numbered or unnumbered
+func ParseSourceMode(s string) (SourceMode, error) {
+	switch s {
+	case "auto":
+		return SourceAuto, nil
+	case "numbered":
+		return SourceNumbered, nil
+	case "unnumbered":
+		return SourceUnnumbered, nil
+	default:
+		return 0, errors.Errorf("invalid source mode %q", s)
+	}
+}
+
 type rawLine struct {
 	sourceLine int
 	num        int
 	text       string
+	// col is the 0-based column, the text starts at in the source line
+	col int
 }
 
-func rawRead(r io.Reader) ([]rawLine, error) {
+func rawRead(r io.Reader, mode SourceMode) ([]rawLine, error) {
 	var rls []rawLine
 	scanner := bufio.NewScanner(r)
 	var lno int = -1
@@ -26,28 +53,49 @@ func rawRead(r io.Reader) ([]rawLine, error) {
 		if ln == "" {
 			continue
 		}
-		snum, text, ok := strings.Cut(ln, " ")
-		if !ok {
-			return nil, errors.Errorf("invalid input-line %d (no line-number separator): %q", lno, ln)
+		if mode == SourceAuto {
+			mode = SourceUnnumbered
+			if isDigit(ln[0]) {
+				mode = SourceNumbered
+			}
 		}
-		num, err := strconv.ParseUint(snum, 10, 32)
+		indent := strings.Index(scanner.Text(), ln)
+		if mode == SourceUnnumbered {
+			rls = append(rls, rawLine{sourceLine: lno, num: lno + 1, text: ln, col: indent})
+			continue
+		}
+		rl, err := parseRawLine(lno, ln)
 		if err != nil {
-			return nil, errors.Wrapf(err, "scanning line-number in input-line %d", lno)
+			return nil, err
 		}
-		rls = append(rls, rawLine{
-			sourceLine: lno,
-			num:        int(num),
-			text:       trimWhite(text),
-		})
+		rl.col += indent
+		rls = append(rls, rl)
 	}
 	return rls, nil
 }
 
-func rawReadFile(file string) ([]rawLine, error) {
+func parseRawLine(lno int, ln string) (rawLine, error) {
+	snum, text, ok := strings.Cut(ln, " ")
+	if !ok {
+		return rawLine{}, errors.Errorf("invalid input-line %d (no line-number separator): %q", lno, ln)
+	}
+	num, err := strconv.ParseUint(snum, 10, 32)
+	if err != nil {
+		return rawLine{}, errors.Wrapf(err, "scanning line-number in input-line %d", lno)
+	}
+	return rawLine{
+		sourceLine: lno,
+		num:        int(num),
+		text:       trimWhite(text),
+		col:        len(ln) - len(strings.TrimLeft(text, " \t")),
+	}, nil
+}
+
+func rawReadFile(file string, mode SourceMode) ([]rawLine, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, errors.Wrapf(err, "open file %q", file)
 	}
 	defer f.Close()
-	return rawRead(f)
+	return rawRead(f, mode)
 }
@@ -10,6 +10,7 @@ import (
 type rawReadTestCase struct {
 	name      string
 	in        string
+	mode      SourceMode
 	expectErr bool
 	expectOut []rawLine
 }
@@ -24,9 +25,9 @@ var rawReadTestCases = []rawReadTestCase{
 		`,
 		expectErr: false,
 		expectOut: []rawLine{
-			{1, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`},
-			{2, 130, `PRINT`},
-			{3, 140, `GOTO 260`},
+			{1, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`, 7},
+			{2, 130, `PRINT`, 7},
+			{3, 140, `GOTO 260`, 7},
 		},
 	},
 	{
@@ -38,9 +39,9 @@ var rawReadTestCases = []rawReadTestCase{
 		`,
 		expectErr: false,
 		expectOut: []rawLine{
-			{1, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`},
-			{2, 130, `PRINT`},
-			{3, 140, `GOTO 260`},
+			{1, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`, 7},
+			{2, 130, `PRINT`, 7},
+			{3, 140, `GOTO 260`, 11},
 		},
 	},
 	{
@@ -54,9 +55,9 @@ var rawReadTestCases = []rawReadTestCase{
 		`,
 		expectErr: false,
 		expectOut: []rawLine{
-			{2, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`},
-			{3, 130, `PRINT`},
-			{5, 140, `GOTO 260`},
+			{2, 120, `PRINT "YOU NOW HAVE ";Q;" DOLLARS."`, 7},
+			{3, 130, `PRINT`, 7},
+			{5, 140, `GOTO 260`, 7},
 		},
 	},
 	{
@@ -79,13 +80,49 @@ var rawReadTestCases = []rawReadTestCase{
 		expectErr: true,
 		expectOut: []rawLine{},
 	},
+	{
+		name: "unnumbered",
+		in: `
+			start:
+			  PRINT "HELLO"
+
+			GOTO start
+		`,
+		expectErr: false,
+		expectOut: []rawLine{
+			{1, 2, `start:`, 3},
+			{2, 3, `PRINT "HELLO"`, 5},
+			{4, 5, `GOTO start`, 3},
+		},
+	},
+	{
+		name: "forced numbered",
+		in: `
+			start:
+			10 GOTO start
+		`,
+		mode:      SourceNumbered,
+		expectErr: true,
+		expectOut: []rawLine{},
+	},
+	{
+		name: "forced unnumbered",
+		in: `
+			10 PRINT
+		`,
+		mode:      SourceUnnumbered,
+		expectErr: false,
+		expectOut: []rawLine{
+			{1, 2, `10 PRINT`, 3},
+		},
+	},
 }
 
 func TestRawRead(t *testing.T) {
 	for _, test := range rawReadTestCases {
 		t.Run(test.name, func(t *testing.T) {
 			buf := bytes.NewBufferString(test.in)
-			rls, err := rawRead(buf)
+			rls, err := rawRead(buf, test.mode)
 			if err != nil {
 				if !test.expectErr {
 					t.Fatalf("want NO error, got %v", err)
@@ -0,0 +1,69 @@
+package gobas
+
+// Renumber renumbers lines starting with start in increments of step and rewrites the targets
+// of GOTO, GOSUB, IF ... THEN and ON ... GOTO/GOSUB. Targets of lines which don't exist are kept.
+func Renumber(lines []Line, start, step int) []Line {
+	nums := map[int]int{}
+	for i, l := range lines {
+		nums[l.num] = start + i*step
+	}
+	renum := func(num int) int {
+		if n, ok := nums[num]; ok {
+			return n
+		}
+		return num
+	}
+
+	renumbered := make([]Line, len(lines))
+	for i, l := range lines {
+		l.num = renum(l.num)
+		l.stmts = renumberStmts(l.stmts, renum)
+		renumbered[i] = l
+	}
+	return renumbered
+}
+
+func renumberStmts(stmts []Stmt, renum func(int) int) []Stmt {
+	renumbered := make([]Stmt, len(stmts))
+	for i, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case GOSUB:
+			stmt.Line = renum(stmt.Line)
+			renumbered[i] = stmt
+		case GOTO:
+			stmt.Line = renum(stmt.Line)
+			renumbered[i] = stmt
+		case IFLN:
+			stmt.Line = renum(stmt.Line)
+			renumbered[i] = stmt
+		case IFELSELN:
+			stmt.Line = renum(stmt.Line)
+			stmt.ElseLine = renum(stmt.ElseLine)
+			renumbered[i] = stmt
+		case IFSTMT:
+			stmt.Stmts = renumberStmts(stmt.Stmts, renum)
+			renumbered[i] = stmt
+		case IFELSESTMT:
+			stmt.Stmts = renumberStmts(stmt.Stmts, renum)
+			stmt.ElseStmts = renumberStmts(stmt.ElseStmts, renum)
+			renumbered[i] = stmt
+		case ONGOSUB:
+			stmt.Lines = renumberLines(stmt.Lines, renum)
+			renumbered[i] = stmt
+		case ONGOTO:
+			stmt.Lines = renumberLines(stmt.Lines, renum)
+			renumbered[i] = stmt
+		default:
+			renumbered[i] = stmt
+		}
+	}
+	return renumbered
+}
+
+func renumberLines(nums []int, renum func(int) int) []int {
+	renumbered := make([]int, len(nums))
+	for i, n := range nums {
+		renumbered[i] = renum(n)
+	}
+	return renumbered
+}
@@ -0,0 +1,30 @@
+package gobas
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenumber(t *testing.T) {
+	src := `
+		5 GOSUB 17
+		7 IF A=1 THEN 5 ELSE 9
+		9 IF A=2 THEN GOTO 5
+		12 ON A GOTO 5,7,999
+		17 RETURN
+	`
+	expect := strings.Join([]string{
+		`100 GOSUB 140`,
+		`110 IF A=1 THEN 100 ELSE 120`,
+		`120 IF A=2 THEN GOTO 100`,
+		`130 ON A GOTO 100,110,999`,
+		`140 RETURN`,
+	}, "\n") + "\n"
+
+	buf := &bytes.Buffer{}
+	List(buf, Renumber(parseLines(t, src), 100, 10))
+	if buf.String() != expect {
+		t.Fatalf("want\n%s\ngot\n%s", expect, buf.String())
+	}
+}
@@ -0,0 +1,307 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mazzegi/gobas"
+	"github.com/pkg/errors"
+)
+
+// REPL is the classic BASIC immediate mode. Numbered lines are added to the program, commands like LIST or RUN
+// act on the program and everything else is executed right away.
+type REPL struct {
+	in     *bufio.Reader
+	out    io.Writer
+	parser *gobas.Parser
+	lines  []gobas.Line
+	state  *gobas.State
+	// tron keeps TRON on for the states of edited programs
+	tron bool
+}
+
+func New(in io.Reader, out io.Writer) *REPL {
+	return &REPL{
+		in:     bufio.NewReader(in),
+		out:    out,
+		parser: gobas.NewParser(),
+	}
+}
+
+// Run reads and handles input lines until the input is exhausted
+func (r *REPL) Run() error {
+	r.ok()
+	for {
+		input, err := r.in.ReadString('\n')
+		if input != "" {
+			r.Handle(input)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Handle handles a single input line
+func (r *REPL) Handle(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+	if unicode.IsDigit(rune(input[0])) {
+		err := r.edit(input)
+		if err != nil {
+			r.fail(err)
+		}
+		return
+	}
+
+	word, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	var err error
+	switch strings.ToUpper(word) {
+	case "LIST":
+		err = r.list(arg)
+	case "RUN":
+		err = r.run()
+	case "NEW":
+		r.lines = nil
+		r.state = nil
+		r.tron = false
+	case "DELETE":
+		err = r.delete(arg)
+	case "RENUM":
+		err = r.renum(arg)
+	case "LOAD":
+		err = r.load(arg)
+	case "SAVE":
+		err = r.save(arg)
+	case "CONT":
+		err = r.cont()
+	default:
+		err = r.exec(input)
+	}
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	r.ok()
+}
+
+func (r *REPL) ok() {
+	fmt.Fprintln(r.out, "Ok")
+}
+
+func (r *REPL) fail(err error) {
+	fmt.Fprintf(r.out, "?%v\n", err)
+}
+
+// edit adds, replaces or - if there is only a line number - deletes a program line
+func (r *REPL) edit(input string) error {
+	if num, err := strconv.Atoi(input); err == nil {
+		r.removeLines(num, num)
+		return nil
+	}
+	line, err := r.parser.ParseLine(input)
+	if err != nil {
+		return err
+	}
+	r.removeLines(line.Num(), line.Num())
+	r.lines = append(r.lines, line)
+	sort.Slice(r.lines, func(i, j int) bool {
+		return r.lines[i].Num() < r.lines[j].Num()
+	})
+	r.state = nil
+	return nil
+}
+
+func (r *REPL) removeLines(from, to int) {
+	var kept []gobas.Line
+	for _, l := range r.lines {
+		if l.Num() < from || l.Num() > to {
+			kept = append(kept, l)
+		}
+	}
+	r.lines = kept
+	r.state = nil
+}
+
+// newState creates a state for the current program, which shares the input with the REPL
+func (r *REPL) newState() *gobas.State {
+	lines := make([]gobas.Line, len(r.lines))
+	copy(lines, r.lines)
+	state := gobas.NewState(lines)
+	state.SetStreams(gobas.Streams{
+		Stdin:  r.in,
+		Stdout: r.out,
+		Stderr: r.out,
+	})
+	state.SetTron(r.tron)
+	return state
+}
+
+func (r *REPL) list(arg string) error {
+	from, to, err := parseRange(arg)
+	if err != nil {
+		return err
+	}
+	var lines []gobas.Line
+	for _, l := range r.lines {
+		if l.Num() >= from && l.Num() <= to {
+			lines = append(lines, l)
+		}
+	}
+	return gobas.List(r.out, lines)
+}
+
+func (r *REPL) run() error {
+	if err := gobas.Link(r.lines); err != nil {
+		return err
+	}
+	r.state = r.newState()
+	return r.report(r.state.Run())
+}
+
+func (r *REPL) cont() error {
+	if r.state == nil {
+		return errors.Errorf("can't continue")
+	}
+	return r.report(r.state.Continue())
+}
+
+func (r *REPL) exec(input string) error {
+	stmts, err := r.parser.ParseStmts(input)
+	if err != nil {
+		return err
+	}
+	if r.state == nil {
+		r.state = r.newState()
+	}
+	return r.report(r.state.Exec(stmts))
+}
+
+func (r *REPL) report(exit gobas.Exit, err error) error {
+	r.tron = r.state.Tron()
+	if err != nil {
+		return err
+	}
+	if exit == gobas.ExitStop {
+		fmt.Fprintln(r.out, "Break")
+	}
+	return nil
+}
+
+func (r *REPL) delete(arg string) error {
+	if arg == "" {
+		return errors.Errorf("DELETE needs a line range")
+	}
+	from, to, err := parseRange(arg)
+	if err != nil {
+		return err
+	}
+	r.removeLines(from, to)
+	return nil
+}
+
+func (r *REPL) renum(arg string) error {
+	start, step := 10, 10
+	if arg != "" {
+		startRaw, stepRaw, hasStep := strings.Cut(arg, ",")
+		var err error
+		start, err = strconv.Atoi(strings.TrimSpace(startRaw))
+		if err != nil {
+			return errors.Errorf("invalid start line %q", startRaw)
+		}
+		if hasStep {
+			step, err = strconv.Atoi(strings.TrimSpace(stepRaw))
+			if err != nil || step <= 0 {
+				return errors.Errorf("invalid increment %q", stepRaw)
+			}
+		}
+	}
+	r.lines = gobas.Renumber(r.lines, start, step)
+	r.state = nil
+	return nil
+}
+
+func (r *REPL) load(arg string) error {
+	file, err := fileName(arg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "open file %q", file)
+	}
+	defer f.Close()
+	// like edited programs, loaded ones are linked when they run, so undefined jump targets can be fixed
+	lines, err := r.parser.ParseLines(f)
+	if err != nil {
+		return err
+	}
+	r.lines = lines
+	r.state = nil
+	return nil
+}
+
+func (r *REPL) save(arg string) error {
+	file, err := fileName(arg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return errors.Wrapf(err, "create file %q", file)
+	}
+	defer f.Close()
+	return gobas.List(f, r.lines)
+}
+
+func fileName(arg string) (string, error) {
+	name := strings.Trim(arg, `"`)
+	if name == "" {
+		return "", errors.Errorf("missing file name")
+	}
+	return name, nil
+}
+
+// parseRange parses line ranges like "", "10", "10-50", "-50" and "10-"
+func parseRange(s string) (from, to int, err error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return 0, math.MaxInt, nil
+	}
+	parseNum := func(raw string, def int) (int, error) {
+		if raw == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, errors.Errorf("invalid line number %q", raw)
+		}
+		return n, nil
+	}
+	fromRaw, toRaw, isRange := strings.Cut(s, "-")
+	from, err = parseNum(fromRaw, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return from, from, nil
+	}
+	to, err = parseNum(toRaw, math.MaxInt)
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
@@ -0,0 +1,113 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runSession(t *testing.T, input string) string {
+	out := &bytes.Buffer{}
+	err := New(strings.NewReader(input), out).Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return out.String()
+}
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []string
+		expect []string
+	}{
+		{
+			name:   "immediate",
+			input:  []string{`A=20: PRINT A+1`, `PRINT A`},
+			expect: []string{"Ok", " 21 ", "Ok", " 20 ", "Ok"},
+		},
+		{
+			name:   "edit and list",
+			input:  []string{`20 PRINT "B"`, `10 PRINT "A"`, `30 PRINT "C"`, `20 PRINT "BB"`, `30`, `LIST`, `LIST 20`, `LIST -10`},
+			expect: []string{"Ok", `10 PRINT "A"`, `20 PRINT "BB"`, "Ok", `20 PRINT "BB"`, "Ok", `10 PRINT "A"`, "Ok"},
+		},
+		{
+			name:   "run and continue",
+			input:  []string{`10 A=1`, `20 STOP`, `30 PRINT A`, `RUN`, `A=5`, `CONT`, `CONT`},
+			expect: []string{"Ok", "Break", "Ok", "Ok", " 5 ", "Ok", "?can't continue"},
+		},
+		{
+			name:   "edit prevents continue",
+			input:  []string{`10 STOP`, `RUN`, `20 END`, `CONT`},
+			expect: []string{"Ok", "Break", "Ok", "?can't continue"},
+		},
+		{
+			name:   "tron stays on for the next run",
+			input:  []string{`TRON`, `10 PRINT "A"`, `RUN`, `TROFF`, `RUN`},
+			expect: []string{"Ok", "Ok", `[10]A`, "Ok", "Ok", "A", "Ok"},
+		},
+		{
+			name:   "input shares the console",
+			input:  []string{`10 INPUT A`, `20 PRINT A*2`, `RUN`, `21`, `PRINT A`},
+			expect: []string{"Ok", "?  42 ", "Ok", " 21 ", "Ok"},
+		},
+		{
+			name:   "delete renum and new",
+			input:  []string{`10 GOTO 30`, `20 END`, `30 GOTO 10`, `40 END`, `DELETE 20`, `RENUM 100,5`, `LIST`, `NEW`, `LIST`},
+			expect: []string{"Ok", "Ok", "Ok", "100 GOTO 105", "105 GOTO 100", "110 END", "Ok", "Ok", "Ok"},
+		},
+		{
+			name:   "errors",
+			input:  []string{`10 X=(`, `DELETE`, `LIST A`, `GOTO 10`},
+			expect: []string{"Ok", `?in line 10 (src = 1): syntax error at column 7: expected ")", found end of line`, "?DELETE needs a line range", `?invalid line number "A"`, "?GOTO: no such line 10"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := runSession(t, strings.Join(test.input, "\n")+"\n")
+			expect := strings.Join(test.expect, "\n") + "\n"
+			if out != expect {
+				t.Fatalf("want\n%s\ngot\n%s", expect, out)
+			}
+		})
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "prog.bas")
+	out := runSession(t, strings.Join([]string{
+		`10 PRINT "SAVED"`,
+		`SAVE "` + file + `"`,
+		`NEW`,
+		`LOAD "` + file + `"`,
+		`RUN`,
+	}, "\n"))
+	if want := "Ok\nOk\nOk\nOk\nSAVED\nOk\n"; out != want {
+		t.Fatalf("want %q, got %q", want, out)
+	}
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := "10 PRINT \"SAVED\"\n"; string(bs) != want {
+		t.Fatalf("want saved %q, got %q", want, string(bs))
+	}
+}
+
+func TestLoadUnlinked(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "prog.bas")
+	if err := os.WriteFile(file, []byte("10 GOTO 30\n20 END\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out := runSession(t, strings.Join([]string{
+		`LOAD "` + file + `"`,
+		`RUN`,
+		`30 PRINT "FIXED"`,
+		`RUN`,
+	}, "\n"))
+	if want := "Ok\nOk\n?in line 10: undefined line number 30\nFIXED\nOk\n"; out != want {
+		t.Fatalf("want %q, got %q", want, out)
+	}
+}
@@ -2,8 +2,9 @@ package gobas
 
 import (
 	"fmt"
+	"io"
 	"math"
-	"strconv"
+	"os"
 	"strings"
 
 	"github.com/mazzegi/gobas/expr"
@@ -12,46 +13,190 @@ import (
 
 type Line struct {
 	num   int
-	stmts []Stmt
+	label string
+	// srcLine is the 0-based line of the source, the line was parsed from
+	srcLine int
+	stmts   []Stmt
+	// cols are the 1-based columns of the stmts in the source line
+	cols []int
+	code []Stmt
 }
 
 type State struct {
-	currIdx int
-	lines   []Line
+	currIdx   int
+	stmtIdx   int
+	lines     []Line
+	lineIdx   lineIndex
+	blocks    Blocks
+	blocksErr error
+	console   *Console
+	stdout    io.Writer
+	stderr    io.Writer
+
+	types         DefTypes
+	globals       variables
+	vars          scope
+	funcs         *expr.Funcs
+	arrays        map[string]any
+	forStates     []forState
+	data          *Data
+	gosubStack    []returnPos
+	procs         map[string]*procedure
+	frames        []*frame
+	maxGosubDepth int
+	zoneWidth     int
+	jumped        bool
+	halted        bool
+	stopped       bool
+	exit          Exit
+	immediate     *Line
+	hook          Hook
+	tron          bool
+	trace         *tracer
+	profile       *Profile
+	cover         *coverRun
 }
 
-func (s *State) findLineIdx(num int) int {
-	for i, l := range s.lines {
-		if l.num == num {
-			return i
-		}
+const DefaultMaxGosubDepth = 1024
+
+const immediateLineNum = -1
+
+type returnPos struct {
+	lineIdx    int
+	stmtIdx    int
+	forDepth   int
+	gosubDepth int
+}
+
+// Streams are the input and output streams a program runs against. Nil streams default to os.Stdin, os.Stdout and os.Stderr.
+// Stderr gets the diagnostics of the interpreter like the trace, runtime errors are returned.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func NewState(lines []Line) *State {
+	for i := range lines {
+		lines[i].code = flatten(lines[i].stmts)
 	}
-	return -1
+	s := &State{
+		lines:         lines,
+		lineIdx:       indexLines(lines),
+		maxGosubDepth: DefaultMaxGosubDepth,
+		zoneWidth:     DefaultZoneWidth,
+	}
+	s.blocks, s.blocksErr = ResolveBlocks(lines)
+	if s.blocksErr == nil {
+		var errs []lineError
+		s.procs, errs = collectProcs(lines)
+		s.blocksErr = lineErrors(errs)
+	}
+	s.SetStreams(Streams{})
+	return s
 }
 
-func (s *State) Out(v interface{}) {
-	fmt.Print(v)
+// SetMaxGosubDepth sets the maximum number of nested GOSUBs and of nested calls, before the program fails
+// with a stack overflow
+func (s *State) SetMaxGosubDepth(depth int) {
+	s.maxGosubDepth = depth
 }
 
-func (s *State) Outln(v interface{}) {
-	fmt.Println(v)
+// SetTron turns the tracing of line numbers on or off like TRON and TROFF do
+func (s *State) SetTron(on bool) {
+	s.tron = on
+}
+
+// SetZoneWidth sets the width of the print zones, a comma in PRINT advances to. It must be positive.
+func (s *State) SetZoneWidth(n int) {
+	s.zoneWidth = n
+	s.console.SetZoneWidth(n)
+}
+
+func (s *State) Tron() bool {
+	return s.tron
 }
 
-func (s *State) Errorf(pattern string, args ...interface{}) {
-	fmt.Printf("ERROR: "+pattern+"\n", args...)
+func (st Streams) withDefaults() Streams {
+	if st.Stdin == nil {
+		st.Stdin = os.Stdin
+	}
+	if st.Stdout == nil {
+		st.Stdout = os.Stdout
+	}
+	if st.Stderr == nil {
+		st.Stderr = os.Stderr
+	}
+	return st
+}
+
+func (s *State) SetStreams(st Streams) {
+	st = st.withDefaults()
+	s.console = NewConsole(st.Stdin, st.Stdout)
+	s.console.SetZoneWidth(s.zoneWidth)
+	s.stdout = st.Stdout
+	s.stderr = st.Stderr
+}
+
+// Stderr returns the stream for diagnostics
+func (s *State) Stderr() io.Writer {
+	return s.stderr
+}
+
+func (s *State) Lines() []Line {
+	return s.lines
+}
+
+func (l Line) Num() int {
+	return l.num
+}
+
+func (l Line) Stmts() []Stmt {
+	return l.stmts
+}
+
+// Label returns the label, which is defined at the start of the line
+func (l Line) Label() string {
+	return l.label
+}
+
+// Col returns the 1-based column of the statement at stmtIdx in the source line. Statements in the branches
+// of an IF have the column of the IF.
+func (l Line) Col(stmtIdx int) int {
+	if stmtIdx < len(l.cols) {
+		return l.cols[stmtIdx]
+	}
+	return 0
+}
+
+// SourceLine returns the 1-based line of the source, the line was parsed from
+func (l Line) SourceLine() int {
+	return l.srcLine + 1
+}
+
+func (s *State) findLineIdx(num int) int {
+	return s.lineIdx.lookup(num)
+}
+
+func (s *State) Out(v interface{}) {
+	fmt.Fprint(s.stdout, v)
+}
+
+func (s *State) Outln(v interface{}) {
+	fmt.Fprintln(s.stdout, v)
 }
 
 func (s *State) Outfln(pattern string, args ...interface{}) {
-	fmt.Printf(pattern+"\n", args...)
+	fmt.Fprintf(s.stdout, pattern+"\n", args...)
 }
 
 func (s *State) Outf(pattern string, args ...interface{}) {
-	fmt.Printf(pattern, args...)
+	fmt.Fprintf(s.stdout, pattern, args...)
 }
 
 func (s *State) boolVal(v interface{}) bool {
 	f, _ := expr.ConvertToFloat(v)
-	return f > 0
+	return expr.Truth(f)
 }
 
 type forState struct {
@@ -82,440 +227,793 @@ func (d *Data) Read() (interface{}, error) {
 	return v, nil
 }
 
+// ReadFor reads the next value and converts it to the type t of the variable varName
+func (d *Data) ReadFor(varName string, t expr.Type) (interface{}, error) {
+	v, err := d.Read()
+	if err != nil {
+		return nil, err
+	}
+	if t == expr.TypeString {
+		return expr.ConvertToString(v)
+	}
+	if str, ok := v.(string); ok {
+		f, err := expr.ParseFloat(trimWhite(str))
+		if err == expr.ErrOverflow {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.Errorf("syntax error in DATA: cannot read %q into %q", str, varName)
+		}
+		v = f
+	}
+	return t.Convert(v)
+}
+
 func (d *Data) Restore() {
 	d.pos = 0
 }
 
-func (s *State) Run() {
-	vars := expr.NewVars()
-	funcs := BuiltinFuncs()
-	arrays := map[string]any{}
-	forStates := []forState{}
-	data := &Data{}
+func (s *State) Run() (Exit, error) {
+	if s.blocksErr != nil {
+		return ExitError, s.blocksErr
+	}
+	s.reset()
+	return s.resume()
+}
+
+// Continue resumes a program after the STOP it was halted with
+func (s *State) Continue() (Exit, error) {
+	if !s.stopped || s.inFunction() {
+		return ExitError, errors.Errorf("can't continue")
+	}
+	s.stmtIdx++
+	return s.resume()
+}
+
+// Exec executes statements in immediate mode against the variables of the last run.
+// If the statements jump into the program, it runs from there on. Otherwise a stopped program can still be continued.
+func (s *State) Exec(stmts []Stmt) (Exit, error) {
+	if s.globals.Vars == nil {
+		s.reset()
+	}
+	if s.blocksErr != nil {
+		return ExitError, s.blocksErr
+	}
+	s.immediate = &Line{
+		num:   immediateLineNum,
+		stmts: stmts,
+		code:  flatten(stmts),
+	}
+	blocks := Blocks{}
+	err := blocks.resolve([]Line{*s.immediate}, len(s.lines))
+	if err != nil {
+		return ExitError, err
+	}
+	for pos, to := range blocks {
+		s.blocks[pos] = to
+	}
+	defer func() {
+		s.immediate = nil
+		for pos := range blocks {
+			delete(s.blocks, pos)
+		}
+	}()
+	currIdx, stmtIdx, stopped := s.currIdx, s.stmtIdx, s.stopped
+	s.currIdx = len(s.lines)
+	s.stmtIdx = 0
+	exit, err := s.resume()
+	if s.currIdx == len(s.lines) {
+		s.currIdx, s.stmtIdx, s.stopped = currIdx, stmtIdx, stopped
+	}
+	return exit, err
+}
+
+func (s *State) resume() (Exit, error) {
+	s.halted = false
+	exit, err := s.run()
+	s.stopped = err == nil && exit == ExitStop
+	return exit, err
+}
+
+func (s *State) reset() {
+	s.currIdx = 0
+	s.stmtIdx = 0
+	s.types = DeclaredTypes(s.lines)
+	s.globals = variables{
+		Vars:  expr.NewVars(),
+		types: &s.types,
+	}
+	s.vars = s.globals
+	s.funcs = BuiltinFuncs()
+	s.arrays = map[string]any{}
+	s.forStates = []forState{}
+	s.data = &Data{}
+	s.gosubStack = []returnPos{}
+	s.frames = nil
+	s.halted = false
+	s.defineProcs()
 
 	for _, line := range s.lines {
 		for _, stmt := range line.stmts {
 			if dataStmt, ok := stmt.(DATA); ok {
 				for _, c := range dataStmt.Consts {
 					c = strings.Trim(c, `"`)
-					data.Add(c)
+					s.data.Add(c)
 				}
 			}
 		}
 	}
+}
 
-	findForState := func(varName string) (forState, bool) {
-		if len(forStates) == 0 {
-			return forState{}, false
+func (s *State) run() (Exit, error) {
+	return s.runCalls(0)
+}
+
+// runCalls runs the program, until it halts or returns from the call at the given depth of the call stack
+func (s *State) runCalls(depth int) (Exit, error) {
+	for {
+		line, ok := s.line(s.currIdx)
+		if !ok {
+			return ExitLastLine, nil
 		}
-		if varName == "" {
-			return forStates[len(forStates)-1], true
+		if s.stmtIdx >= len(line.code) {
+			// neither the last line nor the immediate line (behind it) fall through
+			if s.currIdx >= len(s.lines)-1 {
+				return ExitLastLine, nil
+			}
+			s.currIdx++
+			s.stmtIdx = 0
+			continue
 		}
 
-		for i := len(forStates) - 1; i >= 0; i-- {
-			if forStates[i].varName == varName {
-				return forStates[i], true
+		stmtIdx := s.stmtIdx
+		stmt := line.code[stmtIdx]
+		if s.tron && stmtIdx == 0 && line.num != immediateLineNum {
+			s.console.Print(fmt.Sprintf("[%d]", line.num))
+		}
+		if s.hook != nil {
+			err := s.hook(s.location(line, stmtIdx))
+			if err != nil {
+				return ExitError, &RuntimeError{
+					Line:    line.num,
+					StmtIdx: stmtIdx,
+					Stmt:    StmtKind(stmt),
+					Err:     err,
+				}
 			}
 		}
-		return forState{}, false
+		s.jumped = false
+		if s.trace != nil {
+			s.trace.begin(line.num, stmtIdx, stmt)
+		}
+		if s.profile != nil {
+			s.profile.begin(s, stmtIdx)
+		}
+		if stmtIdx == 0 {
+			s.coverLine()
+		}
+		err := s.exec(stmt)
+		if s.profile != nil {
+			s.profile.end()
+		}
+		if s.trace != nil {
+			s.trace.end(err)
+		}
+		if err != nil {
+			// an error in a FUNCTION called by the statement is already located
+			var rerr *RuntimeError
+			if errors.As(err, &rerr) {
+				return ExitError, rerr
+			}
+			return ExitError, &RuntimeError{
+				Line:    line.num,
+				StmtIdx: stmtIdx,
+				Stmt:    StmtKind(stmt),
+				Err:     err,
+			}
+		}
+		if s.halted {
+			return s.exit, nil
+		}
+		if len(s.frames) < depth {
+			return ExitLastLine, nil
+		}
+		if !s.jumped {
+			s.stmtIdx++
+		}
 	}
+}
 
-	var gosubFromIdx int = -1
-	var firstStmtIdx int = 0
-	var extraStmts []Stmt
+// line returns the line at idx. The statements executed in immediate mode are the line right behind the program.
+func (s *State) line(idx int) (Line, bool) {
+	switch {
+	case idx < len(s.lines):
+		return s.lines[idx], true
+	case idx == len(s.lines) && s.immediate != nil:
+		return *s.immediate, true
+	default:
+		return Line{}, false
+	}
+}
 
-mainloop:
-	for {
-		if s.currIdx >= len(s.lines) {
-			s.Outln("beyond last line")
-			return
-		}
-
-		line := s.lines[s.currIdx]
-
-		for stmtIdx := firstStmtIdx; stmtIdx < len(line.stmts); stmtIdx++ {
-			stmt := line.stmts[stmtIdx]
-			switch stmt := stmt.(type) {
-			case DEF:
-				//TODO
-			case DIM:
-				for _, ad := range stmt.Arrays {
-					var dims []int
-					for _, dim := range ad.Dimensions {
-						f, err := dim.Stack.EvalFloat(vars, funcs)
-						if err != nil {
-							s.Errorf("line %d: DIM: eval-float: %v", line.num, err)
-							return
-						}
-						dims = append(dims, int(f))
-					}
-
-					if IsString(ad.Var) {
-						a := NewArray[string](dims)
-						funcs.AddFunc(ad.Var, func(vs []interface{}) (interface{}, error) {
-							cs := make([]int, len(vs))
-							args := make([]interface{}, len(vs))
-							for i := 0; i < len(vs); i++ {
-								args[i] = &(cs[i])
-							}
-							if err := expr.ScanArgs(vs, args...); err != nil {
-								return 0, err
-							}
-							return a.Get(cs)
-						})
-						arrays[ad.Var] = a
-					} else {
-						a := NewArray[float64](dims)
-						funcs.AddFloatFunc(ad.Var, func(vs []interface{}) (float64, error) {
-							cs := make([]int, len(vs))
-							args := make([]interface{}, len(vs))
-							for i := 0; i < len(vs); i++ {
-								args[i] = &(cs[i])
-							}
-							if err := expr.ScanArgs(vs, args...); err != nil {
-								return 0, err
-							}
-							return a.Get(cs)
-						})
-						arrays[ad.Var] = a
-					}
-				}
-			case END:
-				s.Outln("END")
-				return
-			case FOR:
-				iv, err := stmt.Initial.Stack.EvalFloat(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: FOR: eval initial: %v", line.num, err)
-					return
-				}
-				to, err := stmt.To.Stack.EvalFloat(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: FOR: eval to: %v", line.num, err)
-					return
-				}
-				step, err := stmt.Step.Stack.EvalFloat(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: FOR: eval step: %v", line.num, err)
-					return
-				}
+func (s *State) jump(lineIdx int, stmtIdx int) {
+	s.currIdx = lineIdx
+	s.stmtIdx = stmtIdx
+	s.jumped = true
+}
 
-				vars.Add(stmt.Var, iv)
-				forStates = append(forStates, forState{
-					lineIdx: s.currIdx,
-					stmtIdx: stmtIdx,
-					varName: stmt.Var,
-					toValue: to,
-					step:    step,
-				})
-
-			case NEXT:
-				fs, ok := findForState(stmt.Var)
-				if !ok {
-					s.Errorf("line %d: NEXT: found no corresponding for-state", line.num)
-					return
-				}
-				v, err := vars.LookupVar(fs.varName)
-				if err != nil {
-					s.Errorf("line %d: NEXT: found no var %q", line.num, fs.varName)
-				}
-				f, err := expr.ConvertToFloat(v)
-				if err != nil {
-					s.Errorf("line %d: NEXT: eval var value %q: %v", line.num, fs.varName, err)
-				}
-				f++
-				if f <= fs.toValue {
-					vars.Add(fs.varName, f)
-					s.currIdx = fs.lineIdx
-					firstStmtIdx = fs.stmtIdx + 1
-					continue mainloop
-				}
-				//just go on
+func (s *State) jumpToLine(num int) error {
+	idx := s.findLineIdx(num)
+	if idx < 0 {
+		return errors.Errorf("no such line %d", num)
+	}
+	s.jump(idx, 0)
+	return nil
+}
 
-			case GOSUB:
-				nextIdx := s.findLineIdx(stmt.Line)
-				if nextIdx < 0 {
-					s.Errorf("line %d: GOSUB: no such line %d", line.num, stmt.Line)
-					return
-				}
-				gosubFromIdx = s.currIdx
-				s.currIdx = nextIdx
-				continue mainloop
-			case GOTO:
-				nextIdx := s.findLineIdx(stmt.Line)
-				if nextIdx < 0 {
-					s.Errorf("line %d: GOTO: no such line %d", line.num, stmt.Line)
-					return
-				}
-				s.currIdx = nextIdx
-				continue mainloop
-			case IFLN:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: IF eval %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				if s.boolVal(val) {
-					nextIdx := s.findLineIdx(stmt.Line)
-					if nextIdx < 0 {
-						s.Errorf("line %d: IFLN: no such line %d", line.num, stmt.Line)
-						return
-					}
-					s.currIdx = nextIdx
-					continue mainloop
-				}
-			case IFELSELN:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: eval %q", line.num, stmt.Expr.Raw)
-					return
-				}
-				var nextIdx int
-				if s.boolVal(val) {
-					nextIdx = s.findLineIdx(stmt.Line)
-				} else {
-					nextIdx = s.findLineIdx(stmt.ElseLine)
-				}
-				if nextIdx < 0 {
-					s.Errorf("line %d: IFLN: no such line %d", line.num, stmt.Line)
-					return
-				}
-				s.currIdx = nextIdx
-				continue mainloop
-			case IFSTMT:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: IF eval %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				if s.boolVal(val) {
-					extraStmts = append(extraStmts, stmt.Stmts)
-				}
-			case IFELSESTMT:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: eval %q", line.num, stmt.Expr.Raw)
-					return
-				}
-				if s.boolVal(val) {
-					extraStmts = append(extraStmts, stmt.Stmts)
-				} else {
-					extraStmts = append(extraStmts, stmt.ElseStmts)
-				}
-			case INPUT:
-				s.Outf("%s? ", stmt.Msg)
-			inputouter:
-				for {
-					var in string
-					fmt.Scanln(&in)
-					sl := splitOutsideQuotes(in, ',')
-					if len(sl) != len(stmt.Vars) {
-						s.Outfln("invalid input count %d: need %d", len(sl), len(stmt.Vars))
-						continue
-					}
-					for i, vn := range stmt.Vars {
-						if IsString(vn) {
-							vars.Add(vn, sl[i])
-						} else {
-							f, err := strconv.ParseFloat(sl[i], 64)
-							if err != nil {
-								s.Outfln("cannot parse %q as float", sl[i])
-								continue inputouter
-							}
-							vars.Add(vn, f)
-						}
-					}
-					break
-				}
+func (s *State) halt(exit Exit) {
+	s.halted = true
+	s.exit = exit
+}
 
-			case LET:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
-				if err != nil {
-					s.Errorf("line %d: LET eval %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				vars.Add(stmt.Var, val)
-			case ONGOSUB:
-				val, err := stmt.Expr.Stack.EvalFloat(vars, funcs)
+// findForState returns the index of the innermost loop on the for-stack with the given variable.
+// An empty varName denotes the innermost loop at all.
+func (s *State) findForState(varName string) (int, bool) {
+	if len(s.forStates) == 0 {
+		return -1, false
+	}
+	if varName == "" {
+		return len(s.forStates) - 1, true
+	}
+
+	for i := len(s.forStates) - 1; i >= 0; i-- {
+		if s.forStates[i].varName == varName {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// next advances the loop of varName. If the loop continues, it jumps to the statement after the FOR, otherwise
+// the loop (and all inner loops) are popped from the for-stack.
+func (s *State) next(varName string) (bool, error) {
+	idx, ok := s.findForState(varName)
+	if !ok {
+		return false, errors.Errorf("NEXT without FOR")
+	}
+	fs := s.forStates[idx]
+	f, err := s.floatVar(fs.varName)
+	if err != nil {
+		return false, err
+	}
+	err = s.setVar(fs.varName, f+fs.step)
+	if err != nil {
+		return false, err
+	}
+	// the loop ends by the value of the variable, which may be less precise than the float
+	f, err = s.floatVar(fs.varName)
+	if err != nil {
+		return false, err
+	}
+
+	var done bool
+	if fs.step >= 0 {
+		done = f > fs.toValue
+	} else {
+		done = f < fs.toValue
+	}
+	if done {
+		s.forStates = s.forStates[:idx]
+		return false, nil
+	}
+	s.forStates = s.forStates[:idx+1]
+	s.jump(fs.lineIdx, fs.stmtIdx+1)
+	if s.profile != nil {
+		s.profile.iterate(fs.lineIdx, fs.varName)
+	}
+	return true, nil
+}
+
+func (s *State) gosub(num int) error {
+	if len(s.gosubStack) >= s.maxGosubDepth {
+		return errors.Errorf("stack overflow: more than %d nested GOSUBs", s.maxGosubDepth)
+	}
+	from := returnPos{
+		lineIdx:  s.currIdx,
+		stmtIdx:  s.stmtIdx + 1,
+		forDepth: len(s.forStates),
+	}
+	err := s.jumpToLine(num)
+	if err != nil {
+		return err
+	}
+	s.gosubStack = append(s.gosubStack, from)
+	if s.profile != nil {
+		s.profile.gosub(s.currIdx)
+	}
+	return nil
+}
+
+func (s *State) exec(stmt Stmt) error {
+	switch stmt := stmt.(type) {
+	case DEF:
+		s.define(stmt)
+	case DEFTYPE:
+		s.types.Declare(stmt)
+	case DIM:
+		for _, ad := range stmt.Arrays {
+			err := s.dim(ad)
+			if err != nil {
+				return err
+			}
+		}
+	case END:
+		s.halt(ExitEnd)
+	case WHILE, WEND, DO, LOOP, EXITDO, IFBLOCK, ELSEIF, ELSE:
+		return s.execBlock(stmt)
+	case ENDIF:
+	case SUB, FUNCTION:
+		// the definition is only run by calls
+		s.jumpBehind(s.blocks[s.blockPos()])
+	case CALL:
+		return s.call(stmt)
+	case ENDSUB, EXITSUB:
+		return s.leave(false)
+	case ENDFUNCTION, EXITFUNCTION:
+		return s.leave(true)
+	case SHARED:
+		f, err := s.procFrame("SHARED")
+		if err != nil {
+			return err
+		}
+		for _, v := range stmt.Vars {
+			f.share(v)
+		}
+	case STATIC:
+		f, err := s.procFrame("STATIC")
+		if err != nil {
+			return err
+		}
+		for _, name := range stmt.Vars {
+			f.static[name] = true
+		}
+	case FOR:
+		iv, err := s.evalFloat(stmt.Initial)
+		if err != nil {
+			return errors.Wrap(err, "eval initial")
+		}
+		to, err := s.evalFloat(stmt.To)
+		if err != nil {
+			return errors.Wrap(err, "eval to")
+		}
+		step, err := s.evalFloat(stmt.Step)
+		if err != nil {
+			return errors.Wrap(err, "eval step")
+		}
+
+		// like in Microsoft BASIC the body is executed at least once and
+		// a FOR on a variable that is already looping, replaces that loop
+		err = s.setVar(stmt.Var, iv)
+		if err != nil {
+			return err
+		}
+		if idx, ok := s.findForState(stmt.Var); ok {
+			s.forStates = s.forStates[:idx]
+		}
+		s.forStates = append(s.forStates, forState{
+			lineIdx: s.currIdx,
+			stmtIdx: s.stmtIdx,
+			varName: stmt.Var,
+			toValue: to,
+			step:    step,
+		})
+		if s.profile != nil {
+			s.profile.iterate(s.currIdx, stmt.Var)
+		}
+	case NEXT:
+		if len(stmt.Vars) == 0 {
+			_, err := s.next("")
+			return err
+		}
+		for _, varName := range stmt.Vars {
+			loops, err := s.next(varName)
+			if err != nil {
+				return err
+			}
+			if loops {
+				return nil
+			}
+		}
+	case GOSUB:
+		return s.gosub(stmt.Line)
+	case GOTO:
+		return s.jumpToLine(stmt.Line)
+	case IFLN:
+		val, err := s.eval(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Expr.Raw)
+		}
+		ok := s.boolVal(val)
+		s.coverBranch(ok)
+		if ok {
+			return s.jumpToLine(stmt.Line)
+		}
+	case IFELSELN:
+		val, err := s.eval(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Expr.Raw)
+		}
+		ok := s.boolVal(val)
+		s.coverBranch(ok)
+		if ok {
+			return s.jumpToLine(stmt.Line)
+		}
+		return s.jumpToLine(stmt.ElseLine)
+	case condJump:
+		val, err := s.eval(stmt.expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.expr.Raw)
+		}
+		ok := s.boolVal(val)
+		s.coverBranch(ok)
+		if !ok {
+			s.jump(s.currIdx, stmt.to)
+		}
+	case jump:
+		s.jump(s.currIdx, stmt.to)
+	case INPUT:
+		types := make([]expr.Type, len(stmt.Vars))
+		for i, vn := range stmt.Vars {
+			types[i] = s.types.TypeOf(vn)
+		}
+		vs, err := s.console.Input(stmt.Prompt(), types)
+		if err == io.EOF {
+			s.halt(ExitEnd)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i, vn := range stmt.Vars {
+			err := s.setRef(vn, vs[i])
+			if err != nil {
+				return err
+			}
+		}
+	case LINEINPUT:
+		line, err := s.console.LineInput(stmt.Msg)
+		if err == io.EOF {
+			s.halt(ExitEnd)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return s.setRef(stmt.Var, line)
+	case LET:
+		val, err := s.eval(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Expr.Raw)
+		}
+		return s.setVar(stmt.Var, val)
+	case ONGOSUB:
+		val, err := s.evalFloat(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval-float %q", stmt.Expr.Raw)
+		}
+		ix := int(val) - 1
+		if ix < 0 || ix >= len(stmt.Lines) {
+			return errors.Errorf("invalid index %d", ix+1)
+		}
+		return s.gosub(stmt.Lines[ix])
+	case ONGOTO:
+		val, err := s.evalFloat(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval-float %q", stmt.Expr.Raw)
+		}
+		ix := int(math.Round(val)) - 1
+		if ix < 0 || ix >= len(stmt.Lines) {
+			return errors.Errorf("invalid index %d", ix+1)
+		}
+		return s.jumpToLine(stmt.Lines[ix])
+	case PRINT:
+		// a trailing comma, semicolon, TAB or SPC suppresses the newline
+		noNewline := false
+		for _, pi := range stmt.Items {
+			noNewline = false
+			switch pi := pi.(type) {
+			case Expr:
+				val, err := s.eval(pi)
 				if err != nil {
-					s.Errorf("line %d: ONGOSUB eval-float %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				ix := int(val) - 1
-				if ix < 0 || ix >= len(stmt.Lines) {
-					s.Errorf("line %d: ONGOSUB invalid index %d", line.num, ix+1)
-					return
-				}
-				ln := stmt.Lines[ix]
-				nextIdx := s.findLineIdx(ln)
-				if nextIdx < 0 {
-					s.Errorf("line %d: ONGOSUB: no such line %d", line.num, ln)
-					return
-				}
-				gosubFromIdx = s.currIdx
-				s.currIdx = nextIdx
-				continue mainloop
-			case ONGOTO:
-				val, err := stmt.Expr.Stack.EvalFloat(vars, funcs)
+					return errors.Wrapf(err, "eval %q", pi.Raw)
+				}
+				s.console.Print(val)
+			case PrintComma:
+				s.console.PrintComma()
+				noNewline = true
+			case PrintTab:
+				n, err := s.printFuncArg(pi.Expr)
 				if err != nil {
-					s.Errorf("line %d: ONGOTO eval-float %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				ix := int(math.Round(val)) - 1
-				if ix < 0 || ix >= len(stmt.Lines) {
-					s.Errorf("line %d: ONGOTO invalid index %d", line.num, ix+1)
-					return
-				}
-				ln := stmt.Lines[ix]
-				nextIdx := s.findLineIdx(ln)
-				if nextIdx < 0 {
-					s.Errorf("line %d: ONGOTO: no such line %d", line.num, ln)
-					return
-				}
-				s.currIdx = nextIdx
-				continue mainloop
-			case PRINT:
-				lastSemicolon := false
-				for _, pi := range stmt.Items {
-					lastSemicolon = false
-					switch pi := pi.(type) {
-					case Expr:
-						val, err := pi.Stack.Eval(vars, funcs)
-						if err != nil {
-							s.Errorf("line %d: eval %q", line.num, pi.Raw)
-							return
-						}
-						s.Out(val)
-					case printComma:
-						s.Out("\t")
-					case printSemicolon:
-						lastSemicolon = true
-					}
+					return err
 				}
-				if !lastSemicolon {
-					s.Out("\n")
-				}
-			case READ:
-				for _, varName := range stmt.Vars {
-					dv, err := data.Read()
-					if err != nil {
-						s.Errorf("line %d: READ : %v", line.num, err)
-						return
-					}
-					if !isArray(varName) {
-						vars.Add(varName, dv)
-						continue
-					}
-
-					ad := mustParseArray(varName)
-					va, ok := arrays[ad.Var]
-					if !ok {
-						s.Errorf("line %d: READ no such array %q", line.num, ad.Var)
-						return
-					}
-
-					var cs []int
-					for _, dim := range ad.Dimensions {
-						f, err := dim.Stack.EvalFloat(vars, funcs)
-						if err != nil {
-							s.Errorf("line %d: READ: eval-float: %v", line.num, err)
-							return
-						}
-						cs = append(cs, int(f))
-					}
-					switch a := va.(type) {
-					case *Array[string]:
-						str, err := expr.ConvertToString(dv)
-						if err != nil {
-							s.Errorf("line %d: cannot convert %T to string", line.num, dv)
-							return
-						}
-						err = a.Set(cs, str)
-						if err != nil {
-							s.Errorf("line %d: set array %v: %v", line.num, cs, err)
-							return
-						}
-					case *Array[float64]:
-						f, err := expr.ConvertToFloat(dv)
-						if err != nil {
-							s.Errorf("line %d: cannot convert %T to string", line.num, dv)
-							return
-						}
-						err = a.Set(cs, f)
-						if err != nil {
-							s.Errorf("line %d: set array %v: %v", line.num, cs, err)
-							return
-						}
-					}
-				}
-			case REM:
-			case RESTORE:
-				data.Restore()
-			case RETURN:
-				if gosubFromIdx < 0 {
-					s.Errorf("line %d: RETURN without GOSUB", line.num)
-					return
-				}
-				s.currIdx = gosubFromIdx + 1
-				gosubFromIdx = -1
-				continue mainloop
-			case STOP:
-				s.Outln("STOP")
-				return
-			case ASSIGN:
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
+				s.console.Tab(n)
+				noNewline = true
+			case PrintSpc:
+				n, err := s.printFuncArg(pi.Expr)
 				if err != nil {
-					s.Errorf("line %d: eval %q: %v", line.num, stmt.Expr.Raw, err)
-					return
-				}
-				vars.Add(stmt.Var, val)
-			case ASSIGN_ARRAY:
-				va, ok := arrays[stmt.Array.Var]
-				if !ok {
-					s.Errorf("line %d: no such array %q", line.num, stmt.Array.Var)
-					return
+					return err
 				}
-				val, err := stmt.Expr.Stack.Eval(vars, funcs)
+				s.console.Spc(n)
+				noNewline = true
+			case PrintSemicolon:
+				noNewline = true
+			}
+		}
+		if !noNewline {
+			s.console.Println()
+		}
+	case PRINTUSING:
+		format, err := s.eval(stmt.Format)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Format.Raw)
+		}
+		fs, ok := format.(string)
+		if !ok {
+			return expr.ErrTypeMismatch
+		}
+		var vals []interface{}
+		noNewline := false
+		for _, pi := range stmt.Items {
+			noNewline = true
+			if e, ok := pi.(Expr); ok {
+				val, err := s.eval(e)
 				if err != nil {
-					s.Errorf("line %d: eval %q", line.num, stmt.Expr.Raw)
-					return
-				}
-				var cs []int
-				for _, dim := range stmt.Array.Dimensions {
-					f, err := dim.Stack.EvalFloat(vars, funcs)
-					if err != nil {
-						s.Errorf("line %d: DIM: eval-float: %v", line.num, err)
-						return
-					}
-					cs = append(cs, int(f))
-				}
-				switch a := va.(type) {
-				case *Array[string]:
-					str, err := expr.ConvertToString(val)
-					if err != nil {
-						s.Errorf("line %d: cannot convert %T to string", line.num, val)
-						return
-					}
-					err = a.Set(cs, str)
-					if err != nil {
-						s.Errorf("line %d: set array %v: %v", line.num, cs, err)
-						return
-					}
-				case *Array[float64]:
-					f, err := expr.ConvertToFloat(val)
-					if err != nil {
-						s.Errorf("line %d: cannot convert %T to string", line.num, val)
-						return
-					}
-					err = a.Set(cs, f)
-					if err != nil {
-						s.Errorf("line %d: set array %v: %v", line.num, cs, err)
-						return
-					}
+					return errors.Wrapf(err, "eval %q", e.Raw)
 				}
+				vals = append(vals, val)
+				noNewline = false
 			}
 		}
-		s.currIdx++
-		firstStmtIdx = 0
+		out, err := FormatUsing(fs, vals)
+		if err != nil {
+			return err
+		}
+		s.console.Print(out)
+		if !noNewline {
+			s.console.Println()
+		}
+	case READ:
+		for _, ref := range stmt.Vars {
+			dv, err := s.data.ReadFor(ref, s.types.TypeOf(ref))
+			if err != nil {
+				return err
+			}
+			err = s.setRef(ref, dv)
+			if err != nil {
+				return err
+			}
+		}
+	case REM:
+	case RESTORE:
+		s.data.Restore()
+	case RETURN:
+		// a procedure returns only from the GOSUBs it made itself
+		if len(s.gosubStack) <= s.callerGosubDepth() {
+			return errors.Errorf("RETURN without GOSUB")
+		}
+		from := s.gosubStack[len(s.gosubStack)-1]
+		s.gosubStack = s.gosubStack[:len(s.gosubStack)-1]
+		if len(s.forStates) > from.forDepth {
+			s.forStates = s.forStates[:from.forDepth]
+		}
+		s.jump(from.lineIdx, from.stmtIdx)
+	case STOP:
+		s.halt(ExitStop)
+	case TRON:
+		s.tron = true
+	case TROFF:
+		s.tron = false
+	case ASSIGN:
+		val, err := s.eval(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Expr.Raw)
+		}
+		return s.setVar(stmt.Var, val)
+	case ASSIGN_ARRAY:
+		val, err := s.eval(stmt.Expr)
+		if err != nil {
+			return errors.Wrapf(err, "eval %q", stmt.Expr.Raw)
+		}
+		return s.setArray(stmt.Array, val)
+	}
+	return nil
+}
+
+func (s *State) dim(ad ArrayDef) error {
+	dims, err := s.evalIndexes(ad.Dimensions)
+	if err != nil {
+		return err
+	}
+
+	var a any
+	switch s.types.TypeOf(ad.Var) {
+	case expr.TypeString:
+		a = NewArray[string](dims)
+	case expr.TypeInteger:
+		a = NewArray[int16](dims)
+	case expr.TypeDouble:
+		a = NewArray[float64](dims)
+	default:
+		a = NewArray[float32](dims)
+	}
+	if f := s.frame(); f != nil && !f.sharedArrays[ad.Var] {
+		f.arrays[ad.Var] = a
+	} else {
+		s.arrays[ad.Var] = a
+	}
+	s.addArrayFunc(ad.Var)
+	return nil
+}
+
+// addArrayFunc lets the elements of the array name be read like function calls. The array is resolved on each
+// call, so procedures see their local arrays.
+func (s *State) addArrayFunc(name string) {
+	s.funcs.AddFunc(name, func(vs []interface{}) (interface{}, error) {
+		a, ok := s.array(name)
+		if !ok {
+			return nil, errors.Errorf("no such array %q", name)
+		}
+		cs := make([]int, len(vs))
+		args := make([]interface{}, len(vs))
+		for i := 0; i < len(vs); i++ {
+			args[i] = &(cs[i])
+		}
+		if err := expr.ScanArgs(vs, args...); err != nil {
+			return nil, err
+		}
+		return getElement(a, cs)
+	})
+}
+
+// array returns the array name of the current procedure or, outside of procedures and if it is SHARED,
+// the global one
+func (s *State) array(name string) (any, bool) {
+	if f := s.frame(); f != nil {
+		if a, ok := f.arrays[name]; ok {
+			return a, true
+		}
+		if !f.sharedArrays[name] {
+			return nil, false
+		}
+	}
+	a, ok := s.arrays[name]
+	return a, ok
+}
+
+func getElement(va any, cs []int) (interface{}, error) {
+	switch a := va.(type) {
+	case *Array[string]:
+		return a.Get(cs)
+	case *Array[int16]:
+		return a.Get(cs)
+	case *Array[float32]:
+		return a.Get(cs)
+	case *Array[float64]:
+		return a.Get(cs)
+	default:
+		return nil, errors.Errorf("invalid array %T", va)
+	}
+}
+
+// setElement sets the element of an array to tv, which has the type of the array already
+func setElement(va any, cs []int, tv interface{}) error {
+	var err error
+	switch a := va.(type) {
+	case *Array[string]:
+		err = a.Set(cs, tv.(string))
+	case *Array[int16]:
+		err = a.Set(cs, tv.(int16))
+	case *Array[float32]:
+		err = a.Set(cs, tv.(float32))
+	case *Array[float64]:
+		err = a.Set(cs, tv.(float64))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "set array %v", cs)
+	}
+	return nil
+}
+
+// eval evaluates e in the current scope. The value is traced.
+func (s *State) eval(e Expr) (interface{}, error) {
+	v, err := e.Eval(s.vars, s.funcs)
+	if s.profile != nil {
+		s.profile.eval()
+	}
+	if err == nil && s.trace != nil {
+		s.trace.value(v)
+	}
+	return v, err
+}
+
+func (s *State) evalFloat(e Expr) (float64, error) {
+	f, err := e.EvalFloat(s.vars, s.funcs)
+	if s.profile != nil {
+		s.profile.eval()
+	}
+	if err == nil && s.trace != nil {
+		s.trace.value(f)
+	}
+	return f, err
+}
+
+func (s *State) printFuncArg(e Expr) (int, error) {
+	f, err := s.evalFloat(e)
+	if err != nil {
+		return 0, errors.Wrapf(err, "eval-float %q", e.Raw)
+	}
+	n, err := expr.ToInt(f)
+	return int(n), err
+}
+
+func (s *State) evalIndexes(exprs []Expr) ([]int, error) {
+	var cs []int
+	for _, e := range exprs {
+		f, err := s.evalFloat(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "eval-float %q", e.Raw)
+		}
+		cs = append(cs, int(f))
+	}
+	return cs, nil
+}
+
+func (s *State) setArray(ad ArrayDef, val interface{}) error {
+	va, ok := s.array(ad.Var)
+	if !ok {
+		return errors.Errorf("no such array %q", ad.Var)
+	}
+	cs, err := s.evalIndexes(ad.Dimensions)
+	if err != nil {
+		return err
+	}
+	tv, err := s.types.TypeOf(ad.Var).Convert(val)
+	if err != nil {
+		return err
+	}
+	err = setElement(va, cs, tv)
+	if err == nil && s.trace != nil {
+		s.trace.write(elementName(ad.Var, cs), tv)
+	}
+	return err
+}
+
+// setVar assigns val to the variable name. Numbers are converted to the type of the variable, assigning
+// a string to a numeric variable or vice versa is a type mismatch.
+func (s *State) setVar(name string, val interface{}) error {
+	tv, err := s.types.TypeOf(name).Convert(val)
+	if err != nil {
+		return err
+	}
+	err = s.vars.set(name, tv)
+	if err == nil && s.trace != nil {
+		s.trace.write(name, tv)
+	}
+	return err
+}
+
+// setRef assigns val to a variable or an array element, like READ and INPUT reference them
+func (s *State) setRef(ref string, val interface{}) error {
+	if isArray(ref) {
+		return s.setArray(mustParseArray(ref), val)
+	}
+	return s.setVar(ref, val)
+}
+
+func (s *State) floatVar(name string) (float64, error) {
+	v, err := s.vars.LookupVar(name)
+	if err != nil {
+		return 0, err
 	}
+	return expr.ConvertToFloat(v)
 }
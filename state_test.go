@@ -0,0 +1,599 @@
+package gobas
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func runProgram(t *testing.T, src string, input string) (string, Exit, error) {
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	state.SetStreams(Streams{
+		Stdin:  strings.NewReader(input),
+		Stdout: stdout,
+	})
+	exit, err := state.Run()
+	return stdout.String(), exit, err
+}
+
+// parseLines parses the lines of src one by one like the REPL does, without linking them
+func parseLines(t *testing.T, src string) []Line {
+	var lines []Line
+	for _, text := range strings.Split(src, "\n") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		line, err := NewParser().ParseLine(text)
+		if err != nil {
+			t.Fatalf("parse line: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRunStreams(t *testing.T) {
+	src := `
+		10 INPUT "NAME";A$
+		20 PRINT "HELLO ";A$
+	`
+	out, _, err := runProgram(t, src, "BOB\n")
+	if err != nil {
+		t.Fatalf("want NO error, got %v", err)
+	}
+	if want := "NAME? HELLO BOB\n"; out != want {
+		t.Fatalf("want stdout %q, got %q", want, out)
+	}
+}
+
+// zoneProgram prints into the print zones. A comma starts a new line, if no complete zone is left in the 80 columns.
+const zoneProgram = `10 PRINT 1,"ABCDEFGHIJK",-2.5,"X"
+20 PRINT ,"A",,,,,,"B","C"`
+
+func TestRunZoneWidth(t *testing.T) {
+	tests := []struct {
+		width  int
+		expect string
+	}{
+		{
+			width:  DefaultZoneWidth,
+			expect: " 1            ABCDEFGHIJK   -2.5          X\n              A" + strings.Repeat(" ", 41) + "\n" + strings.Repeat(" ", 28) + "B             C\n",
+		},
+		{
+			width:  10,
+			expect: " 1        ABCDEFGHIJK         -2.5      X\n          A" + strings.Repeat(" ", 59) + "B\nC\n",
+		},
+	}
+	for _, test := range tests {
+		state, err := NewParser().Parse(strings.NewReader(zoneProgram))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		// the zone width is kept, when the streams change
+		state.SetZoneWidth(test.width)
+		out := &bytes.Buffer{}
+		state.SetStreams(Streams{Stdout: out})
+		if _, err := state.Run(); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if out.String() != test.expect {
+			t.Fatalf("width %d: want %q, got %q", test.width, test.expect, out.String())
+		}
+	}
+}
+
+func TestRunExit(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect Exit
+	}{
+		{
+			name:   "end",
+			src:    "10 PRINT 1\n20 END\n30 PRINT 2",
+			expect: ExitEnd,
+		},
+		{
+			name:   "stop",
+			src:    "10 PRINT 1:STOP\n20 PRINT 2",
+			expect: ExitStop,
+		},
+		{
+			name:   "beyond last line",
+			src:    "10 PRINT 1\n20 PRINT 2",
+			expect: ExitLastLine,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, exit, err := runProgram(t, test.src, "")
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if exit != test.expect {
+				t.Fatalf("want exit %s, got %s", test.expect, exit)
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	src := `
+		10 PRINT "A"
+		20 PRINT "B": GOTO 100
+	`
+	state := NewState(parseLines(t, src))
+	state.SetStreams(Streams{Stdout: io.Discard})
+	exit, err := state.Run()
+	if exit != ExitError {
+		t.Fatalf("want exit %s, got %s", ExitError, exit)
+	}
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("want a runtime error, got %v", err)
+	}
+	if rerr.Line != 20 || rerr.StmtIdx != 1 || rerr.Stmt != "GOTO" {
+		t.Fatalf("want error in line 20, stmt #1 (GOTO), got %v", rerr)
+	}
+	if want := "line 20: GOTO: no such line 100"; rerr.Error() != want {
+		t.Fatalf("want %q, got %q", want, rerr.Error())
+	}
+}
+
+func TestRunGosub(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{
+			name: "resume mid-line",
+			src: `
+				10 GOSUB 100: PRINT "B": END
+				100 PRINT "A"
+				110 RETURN
+			`,
+			expect: "A\nB\n",
+		},
+		{
+			name: "nested",
+			src: `
+				10 GOSUB 100: PRINT "D": END
+				100 PRINT "A": GOSUB 200: PRINT "C"
+				110 RETURN
+				200 PRINT "B": RETURN
+			`,
+			expect: "A\nB\nC\nD\n",
+		},
+		{
+			name: "resume in THEN branch",
+			src: `
+				10 X=1
+				20 IF X=1 THEN GOSUB 100: PRINT "B"
+				30 END
+				100 PRINT "A": RETURN
+			`,
+			expect: "A\nB\n",
+		},
+		{
+			name: "on gosub",
+			src: `
+				10 X=2
+				20 ON X GOSUB 100,200: PRINT "C": END
+				100 PRINT "A": RETURN
+				200 PRINT "B": RETURN
+			`,
+			expect: "B\nC\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestRunGosubErrors(t *testing.T) {
+	_, _, err := runProgram(t, "10 GOSUB 10", "")
+	if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Fatalf("want stack overflow, got %v", err)
+	}
+	_, _, err = runProgram(t, "10 RETURN", "")
+	if err == nil || !strings.Contains(err.Error(), "RETURN without GOSUB") {
+		t.Fatalf("want RETURN without GOSUB, got %v", err)
+	}
+}
+
+func TestRunForNext(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		expect    string
+		expectErr string
+	}{
+		{
+			name:   "step 1",
+			src:    `10 FOR I=1 TO 3: PRINT I;: NEXT I`,
+			expect: " 1  2  3 ",
+		},
+		{
+			name:   "negative step",
+			src:    `10 FOR I=10 TO 1 STEP -3: PRINT I;: NEXT`,
+			expect: " 10  7  4  1 ",
+		},
+		{
+			name:   "fractional step",
+			src:    `10 FOR I=0 TO 1 STEP 0.5: PRINT I;",";: NEXT I`,
+			expect: " 0 , .5 , 1 ,",
+		},
+		{
+			name:   "executed once",
+			src:    `10 FOR I=5 TO 1: PRINT I;: NEXT I: PRINT I`,
+			expect: " 5  6 \n",
+		},
+		{
+			name: "nested with multiple next vars",
+			src: `
+				10 FOR I=1 TO 2: FOR J=1 TO 2
+				20 PRINT I;J;",";
+				30 NEXT J,I
+			`,
+			expect: " 1  1 , 1  2 , 2  1 , 2  2 ,",
+		},
+		{
+			name: "re-enter loop with same var",
+			src: `
+				10 N=0
+				20 FOR I=1 TO 2
+				30 N=N+1: IF N<4 THEN 20
+				40 NEXT I
+				50 PRINT N: FOR J=1 TO 2: NEXT I
+			`,
+			expect:    " 5 \n",
+			expectErr: "NEXT without FOR",
+		},
+		{
+			name: "return unwinds loops",
+			src: `
+				10 FOR I=1 TO 2: GOSUB 100: NEXT: END
+				100 FOR J=1 TO 5: RETURN
+			`,
+			expect: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("want error %q, got %v", test.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestRunDefFn(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		expect    string
+		expectErr string
+	}{
+		{
+			name: "simple",
+			src: `
+				10 DEF FNA(X)=X*X+1
+				20 PRINT FNA(3)
+			`,
+			expect: " 10 \n",
+		},
+		{
+			name: "param scope",
+			src: `
+				10 X=5: Y=1
+				20 DEF FNB(X)=X+Y
+				30 PRINT FNB(2);X
+			`,
+			expect: " 3  5 \n",
+		},
+		{
+			name: "string",
+			src: `
+				10 DEF FNS$(A$,B$)=A$+B$+"!"
+				20 R$=FNS$("HI ","THERE"): PRINT R$
+			`,
+			expect: "HI THERE!\n",
+		},
+		{
+			name: "nested",
+			src: `
+				10 DEF FNA(X)=X+1
+				20 DEF FNB(X)=FNA(X)*2
+				30 PRINT FNB(1)
+			`,
+			expect: " 4 \n",
+		},
+		{
+			name:      "recursive",
+			src:       `10 DEF FNR(X)=FNR(X): PRINT FNR(1)`,
+			expectErr: "recursive call of FNR",
+		},
+		{
+			name:      "type mismatch",
+			src:       `10 DEF FNA(X)=X: Y=FNA("A")`,
+			expectErr: "Type mismatch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, "")
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("want error %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestRunTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		input     string
+		expect    string
+		expectErr string
+	}{
+		{
+			name:   "integer rounds",
+			src:    `10 A%=2.5: B%=-1.5: C%=7/2: PRINT A%;B%;C%`,
+			expect: " 3 -2  4 \n",
+		},
+		{
+			name:   "single and double",
+			src:    `10 A=1/3: B#=1/3#: C!=B#: PRINT A;B#;C!`,
+			expect: " .3333333  .3333333333333333  .3333333 \n",
+		},
+		{
+			name:   "unassigned",
+			src:    `10 PRINT A;B%;C#;"<";D$;">"`,
+			expect: " 0  0  0 <>\n",
+		},
+		{
+			name: "defint",
+			src: `
+				10 DEFINT I-K: DEFSTR S
+				20 I=3.7: J=I/2: S="X": Z=I/2
+				30 PRINT I;J;S;Z
+			`,
+			expect: " 4  2 X 2 \n",
+		},
+		{
+			name: "suffix takes precedence",
+			src: `
+				10 DEFSTR A
+				20 A="X": A!=1.5
+				30 PRINT A;A!
+			`,
+			expect: "X 1.5 \n",
+		},
+		{
+			name: "typed arrays",
+			src: `
+				10 DEFINT A: DIM A(2),B$(1),C#(1)
+				20 A(1)=1.6: B$(1)="S": C#(1)=1/3#
+				30 PRINT A(1);B$(1);C#(1)
+			`,
+			expect: " 2 S .3333333333333333 \n",
+		},
+		{
+			name: "read",
+			src: `
+				10 DIM N%(1)
+				20 READ A%,N%(1),S$
+				30 PRINT A%;N%(1);S$
+				40 DATA 1.4,2.6,HELLO
+			`,
+			expect: " 1  3 HELLO\n",
+		},
+		{
+			name:   "input",
+			src:    `10 INPUT A%,B$: PRINT A%;B$`,
+			input:  "3.5,X\n",
+			expect: "?  4 X\n",
+		},
+		{
+			name:   "for integer",
+			src:    `10 FOR I%=1 TO 2 STEP 0.5: PRINT I%;: NEXT`,
+			expect: " 1  2 ",
+		},
+		{
+			name:      "integer overflow",
+			src:       `10 A%=32767: A%=A%+1`,
+			expectErr: "Overflow",
+		},
+		{
+			name:      "integer arithmetic overflow",
+			src:       `10 A%=200: B%=A%*A%`,
+			expectErr: "Overflow",
+		},
+		{
+			name:      "single overflow",
+			src:       `10 PRINT 10^400`,
+			expectErr: "Overflow",
+		},
+		{
+			name:      "square root of a negative number",
+			src:       `10 PRINT SQR(-1)`,
+			expectErr: "Illegal function call",
+		},
+		{
+			name:      "logarithm of zero",
+			src:       `10 PRINT LOG(0)`,
+			expectErr: "Illegal function call",
+		},
+		{
+			name:      "assign string to number",
+			src:       `10 A="X"`,
+			expectErr: "Type mismatch",
+		},
+		{
+			name:      "assign number to string",
+			src:       `10 DEFSTR S: S=1`,
+			expectErr: "Type mismatch",
+		},
+		{
+			name:      "array type mismatch",
+			src:       `10 DIM A%(1): A%(1)="X"`,
+			expectErr: "Type mismatch",
+		},
+		{
+			name:      "read type mismatch",
+			src:       `10 READ A%: DATA X`,
+			expectErr: "syntax error in DATA",
+		},
+		{
+			name:      "read infinity",
+			src:       `10 READ A: DATA INF`,
+			expectErr: "syntax error in DATA",
+		},
+		{
+			name:      "read hex float",
+			src:       `10 READ A: DATA 0x1p3`,
+			expectErr: "syntax error in DATA",
+		},
+		{
+			name:      "read overflow",
+			src:       `10 READ A#: DATA 1E400`,
+			expectErr: "Overflow",
+		},
+		{
+			name:   "read numbers",
+			src:    `10 READ A, B#, C: PRINT A; B#; C: DATA -.5, 1.5D2, +3E-1`,
+			expect: "-.5  150  .3 \n",
+		},
+		{
+			name:      "for on string",
+			src:       `10 FOR A$=1 TO 2: NEXT`,
+			expectErr: "Type mismatch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, _, err := runProgram(t, test.src, test.input)
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("want error %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want NO error, got %v", err)
+			}
+			if out != test.expect {
+				t.Fatalf("want %q, got %q", test.expect, out)
+			}
+		})
+	}
+}
+
+func TestContinueAndExec(t *testing.T) {
+	src := `
+		10 A=1
+		20 STOP
+		30 PRINT A
+		40 END
+		100 PRINT "SUB": RETURN
+	`
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	state.SetStreams(Streams{Stdout: stdout})
+
+	_, err = state.Continue()
+	if err == nil {
+		t.Fatalf("want error on continue before run, got none")
+	}
+	exit, err := state.Run()
+	if err != nil || exit != ExitStop {
+		t.Fatalf("want STOP, got %s, %v", exit, err)
+	}
+
+	stmts, err := NewParser().ParseStmts("A=A+41: GOSUB 100")
+	if err != nil {
+		t.Fatalf("parse stmts: %v", err)
+	}
+	exit, err = state.Exec(stmts)
+	if err != nil || exit != ExitLastLine {
+		t.Fatalf("want immediate line to end, got %s, %v", exit, err)
+	}
+
+	exit, err = state.Continue()
+	if err != nil || exit != ExitEnd {
+		t.Fatalf("want END, got %s, %v", exit, err)
+	}
+	if want := "SUB\n 42 \n"; stdout.String() != want {
+		t.Fatalf("want stdout %q, got %q", want, stdout.String())
+	}
+	_, err = state.Continue()
+	if err == nil {
+		t.Fatalf("want error on continue after END, got none")
+	}
+}
+
+func TestExecJumpIntoProgram(t *testing.T) {
+	src := `
+		10 PRINT "TEN"
+		20 PRINT "TWENTY"
+	`
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	state.SetStreams(Streams{Stdout: stdout})
+	stmts, err := NewParser().ParseStmts("GOTO 20")
+	if err != nil {
+		t.Fatalf("parse stmts: %v", err)
+	}
+	exit, err := state.Exec(stmts)
+	if err != nil || exit != ExitLastLine {
+		t.Fatalf("want last line, got %s, %v", exit, err)
+	}
+	if want := "TWENTY\n"; stdout.String() != want {
+		t.Fatalf("want stdout %q, got %q", want, stdout.String())
+	}
+
+	stmts, _ = NewParser().ParseStmts("GOTO 99")
+	_, err = state.Exec(stmts)
+	if err == nil || err.Error() != "GOTO: no such line 99" {
+		t.Fatalf("want immediate error without line, got %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package gobas
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+)
 
 func IsString(varName string) bool {
 	return strings.HasSuffix(varName, "$")
@@ -20,8 +24,20 @@ type DATA struct {
 }
 
 type DEF struct {
-	Name string
-	Expr Expr
+	Name   string
+	Params []string
+	Expr   Expr
+}
+
+// DEFTYPE is a DEFINT, DEFSNG, DEFDBL or DEFSTR
+type DEFTYPE struct {
+	Type    expr.Type
+	Letters []LetterRange
+}
+
+type LetterRange struct {
+	From byte
+	To   byte
 }
 
 type DIM struct {
@@ -67,12 +83,110 @@ type IFELSESTMT struct {
 	ElseStmts []Stmt
 }
 
+// IFBLOCK starts a block IF, which spans the lines up to its END IF. Its THEN ends the line.
+type IFBLOCK struct {
+	Expr Expr
+}
+
+type ELSEIF struct {
+	Expr Expr
+}
+
+// ELSE is the ELSE clause of a block IF
+type ELSE struct{}
+
+type ENDIF struct{}
+
+type WHILE struct {
+	Expr Expr
+}
+
+type WEND struct{}
+
+// DO starts a DO ... LOOP. The condition is tested before each iteration, if it is given with DO,
+// and after each iteration, if it is given with LOOP.
+type DO struct {
+	Cond *LoopCond
+}
+
+type LOOP struct {
+	Cond *LoopCond
+}
+
+// LoopCond is the WHILE or UNTIL condition of a DO ... LOOP
+type LoopCond struct {
+	Until bool
+	Expr  Expr
+}
+
+type EXITDO struct{}
+
+// SUB starts the definition of a procedure up to its END SUB, which is called with CALL
+type SUB struct {
+	Name   string
+	Params []Param
+}
+
+// FUNCTION starts the definition of a function up to its END FUNCTION. It is called in expressions and
+// returns the value assigned to its name.
+type FUNCTION struct {
+	Name   string
+	Params []Param
+}
+
+// Param is a parameter of a SUB or FUNCTION, or a variable declared by SHARED or STATIC.
+// Array is set for whole arrays like A().
+type Param struct {
+	Name  string
+	Array bool
+}
+
+type ENDSUB struct{}
+
+type ENDFUNCTION struct{}
+
+type EXITSUB struct{}
+
+type EXITFUNCTION struct{}
+
+// CALL calls a SUB. Variables, array elements and whole arrays are passed by reference, all other
+// expressions by value.
+type CALL struct {
+	Name string
+	Args []Expr
+}
+
+// SHARED gives a procedure access to global variables and arrays
+type SHARED struct {
+	Vars []Param
+}
+
+// STATIC declares local variables of a procedure, which keep their values between calls
+type STATIC struct {
+	Vars []string
+}
+
 type INPUT struct {
 	Msg       string
 	Semicolon bool
 	Vars      []string
 }
 
+// Prompt is the text INPUT prompts with. Like in Microsoft BASIC a question mark follows the message,
+// unless it is separated by a comma from the variables.
+func (stmt INPUT) Prompt() string {
+	if stmt.Msg != "" && !stmt.Semicolon {
+		return stmt.Msg
+	}
+	return stmt.Msg + "? "
+}
+
+// LINEINPUT reads a whole line into a string variable
+type LINEINPUT struct {
+	Msg string
+	Var string
+}
+
 type LET struct {
 	Var  string
 	Expr Expr
@@ -89,7 +203,7 @@ type ASSIGN_ARRAY struct {
 }
 
 type NEXT struct {
-	Var string
+	Vars []string
 }
 
 type ONGOSUB struct {
@@ -107,6 +221,12 @@ type PRINT struct {
 	Items []printItem
 }
 
+// PRINTUSING is a PRINT USING. Items are the values and their separators.
+type PRINTUSING struct {
+	Format Expr
+	Items  []printItem
+}
+
 type READ struct {
 	Vars []string
 }
@@ -123,3 +243,8 @@ type RETURN struct {
 
 type STOP struct {
 }
+
+// TRON turns on the tracing of line numbers, which prints [n] whenever the line n starts to execute
+type TRON struct{}
+
+type TROFF struct{}
@@ -0,0 +1,19 @@
+10 REM EXERCISES THE STATEMENTS SUPPORTED BY THE TRANSPILER
+20 DIM A(5),N$(3)
+30 DEF FNS(X)=X*X+1
+40 INPUT "NAME";M$
+50 PRINT "HELLO ";M$
+60 FOR I=1 TO 5: A(I)=FNS(I): NEXT I
+70 FOR I=5 TO 1 STEP -2: PRINT A(I);",";: NEXT
+80 PRINT
+90 READ N$(1),N$(2),N$(3),K
+100 FOR I=1 TO 3: GOSUB 200: NEXT I
+110 IF K=42 THEN PRINT "K IS ";K
+120 ON 2 GOSUB 300,310
+130 PRINT LEFT$(M$,2);LEN(M$),"DONE"
+140 END
+200 PRINT I;N$(I)
+210 RETURN
+300 PRINT "ONE": RETURN
+310 PRINT "TWO": RETURN
+500 DATA "ONE",TWO,"THREE",42
@@ -0,0 +1,14 @@
+10 REM EXERCISES TYPED VARIABLES
+20 DEFINT I-K: DEFDBL D: DEFSTR S
+30 DIM C%(3),E#(2),T(2)
+40 INPUT "NUMBER";N%
+50 S="N=": PRINT S;N%;N%/3;N%\3;N% MOD 3
+60 D=1/3: X=1/3: Y#=X: PRINT D;X;Y#
+70 FOR I=1 TO 3: C%(I)=I*2.6: NEXT: PRINT C%(1);C%(2);C%(3)
+80 READ E#(1),J,T(1): PRINT E#(1);J;T(1)
+90 DEF FNH(K)=K/2: PRINT FNH(5.6);FNH(D*3)
+100 FOR Z=0 TO 0.35 STEP 0.1: PRINT Z;: NEXT: PRINT
+110 PRINT LEN(S)*1.5;ABS(-N%);SQR(D);-N%>0;NOT N%
+115 PRINT TAB(5);"T";SPC(2);-1.5E-5,D;STR$(N%),
+117 PRINT USING "& ##.## **$#,###.# +.#^^^^";S,D,N%*1000,X;: PRINT USING "[!]";"AB"
+120 DATA 0.1234567891,7.5,2.25
@@ -0,0 +1,9 @@
+10 REM EXERCISES INPUT AND LINE INPUT
+20 DIM V(2)
+30 INPUT "A, B";A,B$
+40 PRINT A;B$
+50 INPUT "C",V(1)
+60 LINE INPUT "LINE: ";L$
+70 PRINT V(1);"[";L$;"]"
+80 INPUT X
+90 PRINT "NOT REACHED"
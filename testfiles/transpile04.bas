@@ -0,0 +1,22 @@
+10 REM EXERCISES STRUCTURED BLOCKS
+20 I=0
+30 WHILE I<5
+40   I=I+1
+50   IF I=1 THEN
+60     PRINT "ONE";
+70   ELSEIF I MOD 2=0 THEN
+80     PRINT "EVEN";I;
+90   ELSE
+100    PRINT "ODD";I;
+110  END IF
+120 WEND
+130 PRINT
+140 DO WHILE I>0: I=I-2: PRINT I;: LOOP
+150 DO
+160   J=J+1
+170   IF J>3 THEN EXIT DO
+180   IF J=2 THEN
+190     PRINT "TWO";
+200   END IF
+210 LOOP UNTIL J>10
+220 PRINT J
@@ -0,0 +1,345 @@
+package gobas
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokKeyword
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	// tokRaw is the unparsed rest of a REM or the constants of a DATA
+	tokRaw
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// col is the 1-based column of the token in its line
+	col int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of line"
+	case tokString:
+		return `"` + t.text + `"`
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// keywords are sorted by length, so that the longest keyword matches first
+var keywords = []string{
+	"FUNCTION",
+	"RESTORE",
+	"RETURN",
+	"SHARED",
+	"STATIC",
+	"DEFDBL",
+	"DEFINT",
+	"DEFSNG",
+	"DEFSTR",
+	"ELSEIF",
+	"GOSUB",
+	"INPUT",
+	"PRINT",
+	"TROFF",
+	"UNTIL",
+	"USING",
+	"WHILE",
+	"CALL",
+	"DATA",
+	"ELSE",
+	"EXIT",
+	"GOTO",
+	"LINE",
+	"LOOP",
+	"NEXT",
+	"READ",
+	"STEP",
+	"STOP",
+	"THEN",
+	"TRON",
+	"WEND",
+	"AND",
+	"DEF",
+	"DIM",
+	"END",
+	"FOR",
+	"LET",
+	"MOD",
+	"NOT",
+	"REM",
+	"SUB",
+	"XOR",
+	"DO",
+	"IF",
+	"ON",
+	"OR",
+	"TO",
+}
+
+// statementKeywords are the keywords, which Microsoft BASIC doesn't have. They are only keywords, where
+// a statement starts and it isn't the assignment of a variable like DOWN or LINES, or after one of the keywords
+// listed for them. Elsewhere they may be part of variable names.
+var statementKeywords = map[string][]string{
+	"CALL":     nil,
+	"DO":       {"EXIT"},
+	"ELSEIF":   nil,
+	"EXIT":     nil,
+	"FUNCTION": {"END", "EXIT"},
+	"LINE":     nil,
+	"LOOP":     nil,
+	"SHARED":   nil,
+	"STATIC":   nil,
+	"SUB":      {"END", "EXIT"},
+	"UNTIL":    {"DO", "LOOP"},
+	"WEND":     nil,
+	"WHILE":    {"DO", "LOOP"},
+}
+
+// exprKeywords are the keywords which are operators inside of expressions
+var exprKeywords = map[string]bool{
+	"AND": true,
+	"MOD": true,
+	"NOT": true,
+	"OR":  true,
+	"XOR": true,
+}
+
+var ops = []string{
+	"<=", ">=", "<>", "=<", "=>", "><",
+	"+", "-", "*", "/", "^", `\`, "(", ")", ",", ";", ":", "=", "<", ">",
+}
+
+var canonicalOps = map[string]string{
+	"=<": "<=",
+	"=>": ">=",
+	"><": "<>",
+}
+
+// SyntaxError is returned for statements which cannot be parsed
+type SyntaxError struct {
+	Col int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Col, e.Msg)
+}
+
+func expectedError(found token, expected ...string) *SyntaxError {
+	return &SyntaxError{
+		Col: found.col,
+		Msg: fmt.Sprintf("expected %s, found %s", strings.Join(expected, " or "), found),
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isTypeSuffix(c byte) bool {
+	return c == '$' || c == '%' || c == '!' || c == '#'
+}
+
+// keywordAt returns the keyword at pos. prev is the token before, it is nil inside of a variable name.
+func keywordAt(s string, pos int, prev *token) (string, bool) {
+	for _, kw := range keywords {
+		if !strings.HasPrefix(s[pos:], kw) {
+			continue
+		}
+		if after, ok := statementKeywords[kw]; ok && !isStatementKeyword(s, pos, prev, after) {
+			continue
+		}
+		return kw, true
+	}
+	return "", false
+}
+
+func isStatementKeyword(s string, pos int, prev *token, after []string) bool {
+	switch {
+	case prev == nil:
+		return false
+	case prev.is(tokOp, ":"), prev.is(tokKeyword, "THEN"), prev.is(tokKeyword, "ELSE"):
+		return !assignsAt(s, pos)
+	}
+	for _, kw := range after {
+		if prev.is(tokKeyword, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// assignsAt tells, if the statement at pos assigns a variable or an array element without LET
+func assignsAt(s string, pos int) bool {
+	i := pos + 1
+	for i < len(s) && (isLetter(s[i]) || isDigit(s[i])) {
+		if _, ok := keywordAt(s, i, nil); ok {
+			break
+		}
+		i++
+	}
+	if i < len(s) && isTypeSuffix(s[i]) {
+		i++
+	}
+	i = skipSpaces(s, i)
+	if i < len(s) && s[i] == '(' {
+		depth := 0
+		for ; i < len(s); i++ {
+			if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				depth--
+				if depth == 0 {
+					i++
+					break
+				}
+			}
+		}
+		i = skipSpaces(s, i)
+	}
+	return i < len(s) && s[i] == '='
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// tokenize splits the text of a line into tokens. Like in Microsoft BASIC keywords are recognized
+// everywhere outside of strings, even without surrounding spaces, so crunched code like `IFX>5THEN100` works.
+// The flip side is, that variable names may not contain keywords, except for the statementKeywords.
+// col0 is the column the text starts at.
+func tokenize(s string, col0 int) ([]token, error) {
+	var toks []token
+	add := func(kind tokenKind, text string, pos int) {
+		toks = append(toks, token{kind: kind, text: text, col: col0 + pos + 1})
+	}
+
+	pos := 0
+	for pos < len(s) {
+		c := s[pos]
+		switch {
+		case c == ' ' || c == '\t':
+			pos++
+		case c == '"':
+			end := strings.IndexByte(s[pos+1:], '"')
+			if end < 0 {
+				// an unterminated string ends with the line
+				add(tokString, s[pos+1:], pos)
+				pos = len(s)
+				break
+			}
+			add(tokString, s[pos+1:pos+1+end], pos)
+			pos += end + 2
+		case isDigit(c) || (c == '.' && pos+1 < len(s) && isDigit(s[pos+1])):
+			start := pos
+			for pos < len(s) && (isDigit(s[pos]) || s[pos] == '.') {
+				pos++
+			}
+			if pos < len(s) && (s[pos] == 'E' || s[pos] == 'D') {
+				exp := pos + 1
+				if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+					exp++
+				}
+				if exp < len(s) && isDigit(s[exp]) {
+					pos = exp
+					for pos < len(s) && isDigit(s[pos]) {
+						pos++
+					}
+				}
+			}
+			if pos < len(s) && isTypeSuffix(s[pos]) {
+				pos++
+			}
+			add(tokNumber, s[start:pos], start)
+		case isLetter(c):
+			prev := token{kind: tokOp, text: ":"}
+			if len(toks) > 0 {
+				prev = toks[len(toks)-1]
+			}
+			if kw, ok := keywordAt(s, pos, &prev); ok {
+				add(tokKeyword, kw, pos)
+				pos += len(kw)
+				switch kw {
+				case "REM":
+					add(tokRaw, s[pos:], pos)
+					pos = len(s)
+				case "DATA":
+					raw := dataRaw(s[pos:])
+					add(tokRaw, raw, pos)
+					pos += len(raw)
+				}
+				break
+			}
+			start := pos
+			pos++
+			for pos < len(s) && (isLetter(s[pos]) || isDigit(s[pos])) {
+				if _, ok := keywordAt(s, pos, nil); ok {
+					break
+				}
+				pos++
+			}
+			if pos < len(s) && isTypeSuffix(s[pos]) {
+				pos++
+			}
+			add(tokIdent, s[start:pos], start)
+		case c == '?':
+			add(tokKeyword, "PRINT", pos)
+			pos++
+		default:
+			var op string
+			for _, o := range ops {
+				if strings.HasPrefix(s[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &SyntaxError{Col: col0 + pos + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			if cop, ok := canonicalOps[op]; ok {
+				add(tokOp, cop, pos)
+			} else {
+				add(tokOp, op, pos)
+			}
+			pos += len(op)
+		}
+	}
+	add(tokEOF, "", len(s))
+	return toks, nil
+}
+
+// dataRaw returns the constants of a DATA statement, which end at the next colon outside of quotes
+func dataRaw(s string) string {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == ':' && !inQuotes:
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package gobas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type TraceFormat int
+
+const (
+	// TraceText writes a line like `20:1 ASSIGN_ARRAY [4 2] A(2)=4` per statement
+	TraceText TraceFormat = iota
+	// TraceJSON writes a TraceEntry as JSON per line
+	TraceJSON
+)
+
+// ParseTraceFormat parses the name of a trace format: text or json
+func ParseTraceFormat(s string) (TraceFormat, error) {
+	switch s {
+	case "text":
+		return TraceText, nil
+	case "json":
+		return TraceJSON, nil
+	default:
+		return 0, errors.Errorf("invalid trace format %q", s)
+	}
+}
+
+// TraceEntry is an executed statement with the values it evaluated and the variables it wrote in the order
+// they happened. Kind is the parsed type of the statement like LET, IFLN or ONGOSUB.
+type TraceEntry struct {
+	Line    int           `json:"line"`
+	StmtIdx int           `json:"stmt"`
+	Kind    string        `json:"kind"`
+	Values  []interface{} `json:"values,omitempty"`
+	Writes  []TraceWrite  `json:"writes,omitempty"`
+	Err     string        `json:"error,omitempty"`
+}
+
+// TraceWrite is the assignment of a value to a variable or to an array element like A(1,2)
+type TraceWrite struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// SetTrace traces the executed statements to w, which is usually the Stderr stream. A statement is written,
+// when it is done, so the statements of a called procedure or function precede the statement calling it.
+// A nil w turns tracing off. Errors writing the trace are ignored.
+func (s *State) SetTrace(w io.Writer, format TraceFormat) {
+	if w == nil {
+		s.trace = nil
+		return
+	}
+	s.trace = &tracer{w: w, format: format}
+}
+
+// tracer collects the entries of the statements being executed. Nested calls push entries of their own.
+type tracer struct {
+	w       io.Writer
+	format  TraceFormat
+	entries []*TraceEntry
+}
+
+func (t *tracer) begin(line int, stmtIdx int, stmt Stmt) {
+	t.entries = append(t.entries, &TraceEntry{Line: line, StmtIdx: stmtIdx, Kind: StmtKind(stmt)})
+}
+
+func (t *tracer) current() *TraceEntry {
+	if len(t.entries) == 0 {
+		return nil
+	}
+	return t.entries[len(t.entries)-1]
+}
+
+func (t *tracer) value(v interface{}) {
+	if e := t.current(); e != nil {
+		e.Values = append(e.Values, v)
+	}
+}
+
+func (t *tracer) write(name string, v interface{}) {
+	if e := t.current(); e != nil {
+		e.Writes = append(e.Writes, TraceWrite{Name: name, Value: v})
+	}
+}
+
+func (t *tracer) end(err error) {
+	e := t.current()
+	if e == nil {
+		return
+	}
+	t.entries = t.entries[:len(t.entries)-1]
+	// the jumps over the ELSE branch of single-line IFs are no statements of their own
+	if e.Kind == "jump" {
+		return
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	if t.format == TraceJSON {
+		json.NewEncoder(t.w).Encode(e)
+		return
+	}
+	io.WriteString(t.w, e.String()+"\n")
+}
+
+func (e TraceEntry) String() string {
+	s := fmt.Sprintf("%d:%d %s", e.Line, e.StmtIdx, e.Kind)
+	if len(e.Values) > 0 {
+		sl := make([]string, len(e.Values))
+		for i, v := range e.Values {
+			sl[i] = FormatValue(v)
+		}
+		s += " [" + strings.Join(sl, " ") + "]"
+	}
+	for _, w := range e.Writes {
+		s += " " + w.Name + "=" + FormatValue(w.Value)
+	}
+	if e.Err != "" {
+		s += " ERROR: " + e.Err
+	}
+	return s
+}
+
+func elementName(name string, cs []int) string {
+	sl := make([]string, len(cs))
+	for i, c := range cs {
+		sl[i] = strconv.Itoa(c)
+	}
+	return name + "(" + strings.Join(sl, ",") + ")"
+}
@@ -0,0 +1,104 @@
+package gobas
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const tronProgram = `
+10 TRON
+20 FOR I=1 TO 2
+30   PRINT I;
+40 NEXT
+50 GOSUB 100: TROFF: PRINT "OFF"
+60 END
+100 PRINT "SUB": RETURN`
+
+func TestTron(t *testing.T) {
+	out, _, err := runProgram(t, tronProgram, "")
+	if err != nil {
+		t.Fatalf("want NO error, got %v", err)
+	}
+	if want := "[20][30] 1 [40][30] 2 [40][50][100]SUB\nOFF\n"; out != want {
+		t.Fatalf("want %q, got %q", want, out)
+	}
+}
+
+const traceProgram = `
+10 DIM A(2): X=1
+20 A(X+1)=X*3: IF X THEN PRINT "Y"; ELSE PRINT "N";
+30 GOSUB 100: FOR I=1 TO 2: NEXT
+40 X$=1
+100 X$="S": RETURN`
+
+func runTraced(t *testing.T, src string, format TraceFormat) string {
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// the trace is a diagnostic, which goes to the stderr stream
+	trace := &bytes.Buffer{}
+	state.SetStreams(Streams{Stdout: &bytes.Buffer{}, Stderr: trace})
+	state.SetTrace(state.Stderr(), format)
+	state.Run()
+	return trace.String()
+}
+
+func TestTrace(t *testing.T) {
+	want := strings.Join([]string{
+		"10:0 DIM [2]",
+		"10:1 ASSIGN [1] X=1",
+		"20:0 ASSIGN_ARRAY [3 2] A(2)=3",
+		"20:1 IFELSESTMT [1]",
+		`20:2 PRINT ["Y"]`,
+		"30:0 GOSUB",
+		`100:0 ASSIGN ["S"] X$="S"`,
+		"100:1 RETURN",
+		"30:1 FOR [1 2 1] I=1",
+		"30:2 NEXT I=2",
+		"30:2 NEXT I=3",
+		"40:0 ASSIGN [1] ERROR: Type mismatch",
+	}, "\n") + "\n"
+	if got := runTraced(t, traceProgram, TraceText); got != want {
+		t.Fatalf("want trace\n%s\ngot\n%s", want, got)
+	}
+
+	var entries []TraceEntry
+	sc := bufio.NewScanner(strings.NewReader(runTraced(t, traceProgram, TraceJSON)))
+	for sc.Scan() {
+		var e TraceEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("decode %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 12 {
+		t.Fatalf("want 12 entries, got %d", len(entries))
+	}
+	if e := entries[2]; e.Kind != "ASSIGN_ARRAY" || len(e.Writes) != 1 || e.Writes[0].Name != "A(2)" || e.Writes[0].Value != 3.0 {
+		t.Fatalf("want the write of A(2), got %+v", e)
+	}
+	if e := entries[11]; e.Line != 40 || e.Err != "Type mismatch" {
+		t.Fatalf("want the error in line 40, got %+v", e)
+	}
+}
+
+func TestTraceCalls(t *testing.T) {
+	src := `
+10 PRINT F(2)
+20 FUNCTION F(N)
+30   F=N*2
+40 END FUNCTION`
+	want := strings.Join([]string{
+		"30:0 ASSIGN [4] F=4",
+		"40:0 ENDFUNCTION",
+		"10:0 PRINT [4]",
+		"20:0 FUNCTION",
+	}, "\n") + "\n"
+	if got := runTraced(t, src, TraceText); got != want {
+		t.Fatalf("want trace\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,236 @@
+package transpile
+
+// programHeader starts each generated program
+const programHeader = `package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/mazzegi/gobas"
+	"github.com/mazzegi/gobas/expr"
+)
+
+func main() {
+	p := newProgram(os.Stdin, os.Stdout)
+	if _, err := p.exec(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+`
+
+// programRuntime is appended to each generated program. It mirrors the GOSUB and FOR/NEXT handling of State.
+const programRuntime = `type programState struct {
+	con     *gobas.Console
+	funcs   *expr.Funcs
+	data    *gobas.Data
+	line    int
+	stmtIdx int
+	stmt    string
+	gosubs  []gosubFrame
+	loops   []forLoop
+	tron    bool
+}
+
+type gosubFrame struct {
+	pc        int
+	loopDepth int
+}
+
+// forLoop accesses its variable with get and set, which convert to the type of the variable
+type forLoop struct {
+	name string
+	get  func() float64
+	set  func(float64)
+	to   float64
+	step float64
+	pc   int
+}
+
+type runtimeError struct {
+	err error
+}
+
+func (p *programState) init(in io.Reader, out io.Writer) {
+	p.con = gobas.NewConsole(in, out)
+	p.funcs = gobas.BuiltinFuncs()
+	p.data = &gobas.Data{}
+}
+
+func (p *program) exec() (exit gobas.Exit, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(runtimeError)
+			if !ok {
+				panic(r)
+			}
+			exit = gobas.ExitError
+			err = &gobas.RuntimeError{
+				Line:    p.line,
+				StmtIdx: p.stmtIdx,
+				Stmt:    p.stmt,
+				Err:     rerr.err,
+			}
+		}
+	}()
+	return p.run()
+}
+
+func (p *programState) at(line int, stmtIdx int, stmt string) {
+	if p.tron && stmtIdx == 0 {
+		p.con.Print(fmt.Sprintf("[%d]", line))
+	}
+	p.line = line
+	p.stmtIdx = stmtIdx
+	p.stmt = stmt
+}
+
+// input reads the values of an INPUT statement. It returns false at the end of the input, which ends the program.
+func (p *programState) input(prompt string, types ...expr.Type) ([]interface{}, bool) {
+	vs, err := p.con.Input(prompt, types)
+	if err == io.EOF {
+		return nil, false
+	}
+	return must(vs, err), true
+}
+
+func (p *programState) lineInput(prompt string) (interface{}, bool) {
+	line, err := p.con.LineInput(prompt)
+	if err == io.EOF {
+		return nil, false
+	}
+	return must(line, err), true
+}
+
+func (p *programState) gosub(pc int) {
+	if len(p.gosubs) >= gobas.DefaultMaxGosubDepth {
+		throw(fmt.Errorf("stack overflow: more than %d nested GOSUBs", gobas.DefaultMaxGosubDepth))
+	}
+	p.gosubs = append(p.gosubs, gosubFrame{pc: pc, loopDepth: len(p.loops)})
+}
+
+func (p *programState) ret() int {
+	if len(p.gosubs) == 0 {
+		throw(fmt.Errorf("RETURN without GOSUB"))
+	}
+	f := p.gosubs[len(p.gosubs)-1]
+	p.gosubs = p.gosubs[:len(p.gosubs)-1]
+	if len(p.loops) > f.loopDepth {
+		p.loops = p.loops[:f.loopDepth]
+	}
+	return f.pc
+}
+
+func (p *programState) findLoop(name string) int {
+	if name == "" {
+		return len(p.loops) - 1
+	}
+	for i := len(p.loops) - 1; i >= 0; i-- {
+		if p.loops[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *programState) forLoop(name string, get func() float64, set func(float64), initial, to, step float64, pc int) {
+	set(initial)
+	if i := p.findLoop(name); i >= 0 {
+		p.loops = p.loops[:i]
+	}
+	p.loops = append(p.loops, forLoop{name: name, get: get, set: set, to: to, step: step, pc: pc})
+}
+
+func (p *programState) next(name string) (int, bool) {
+	i := p.findLoop(name)
+	if i < 0 {
+		throw(fmt.Errorf("NEXT without FOR"))
+	}
+	l := p.loops[i]
+	l.set(l.get() + l.step)
+	var done bool
+	if l.step >= 0 {
+		done = l.get() > l.to
+	} else {
+		done = l.get() < l.to
+	}
+	if done {
+		p.loops = p.loops[:i]
+		return 0, false
+	}
+	p.loops = p.loops[:i+1]
+	return l.pc, true
+}
+
+func throw(err error) {
+	panic(runtimeError{err: err})
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		throw(err)
+	}
+	return v
+}
+
+// result returns the value of a function call, which is of type T
+func result[T any](v interface{}, err error) T {
+	return must(v, err).(T)
+}
+
+func convert[T any](t expr.Type, v interface{}) T {
+	return must(t.Convert(v)).(T)
+}
+
+func binOp[T any](op expr.Op, x, y interface{}) T {
+	return must(expr.BinaryOp(op, x, y)).(T)
+}
+
+func unOp[T any](op expr.Op, x interface{}) T {
+	return must(expr.UnaryOp(op, x)).(T)
+}
+
+func b2i(b bool) int16 {
+	return expr.BoolToInt(b)
+}
+
+func round(f float64) float64 {
+	return math.Round(f)
+}
+
+func truth(f float64) bool {
+	return expr.Truth(f)
+}
+
+func printArg(f float64) int {
+	return int(must(expr.ToInt(f)))
+}
+
+func ints(fs ...float64) []int {
+	is := make([]int, len(fs))
+	for i, f := range fs {
+		is[i] = int(f)
+	}
+	return is
+}
+
+func index[T any](a *gobas.Array[T], name string, cs ...float64) T {
+	if a == nil {
+		throw(fmt.Errorf("no such func %q", name))
+	}
+	return must(a.Get(ints(cs...)))
+}
+
+func setIndex[T any](a *gobas.Array[T], name string, v T, cs ...float64) {
+	if a == nil {
+		throw(fmt.Errorf("no such array %q", name))
+	}
+	if err := a.Set(ints(cs...), v); err != nil {
+		throw(fmt.Errorf("set array %v: %v", ints(cs...), err))
+	}
+}
+`
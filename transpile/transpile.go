@@ -0,0 +1,925 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mazzegi/gobas"
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// Transpile compiles the parsed lines of a BASIC program to the source of a standalone Go program.
+// Each statement becomes a case of a dispatch switch over a program counter, so GOTO, GOSUB and
+// FOR/NEXT can continue at any statement. The generated program uses the Console, Data, Array and
+// builtin functions of gobas, so it behaves like State.Run.
+func Transpile(lines []gobas.Line) ([]byte, error) {
+	g := newGenerator(lines)
+	err := g.generate()
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(g.out.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "format generated source")
+	}
+	return src, nil
+}
+
+// goType is the Go type of values of type t. It is the same type State uses for them.
+func goType(t expr.Type) string {
+	switch t {
+	case expr.TypeInteger:
+		return "int16"
+	case expr.TypeDouble:
+		return "float64"
+	case expr.TypeString:
+		return "string"
+	default:
+		return "float32"
+	}
+}
+
+// typeIdent is the Go expression of type t in the generated program
+func typeIdent(t expr.Type) string {
+	switch t {
+	case expr.TypeInteger:
+		return "expr.TypeInteger"
+	case expr.TypeDouble:
+		return "expr.TypeDouble"
+	case expr.TypeString:
+		return "expr.TypeString"
+	default:
+		return "expr.TypeSingle"
+	}
+}
+
+// condJump continues at pc to, if expr evaluates to false
+type condJump struct {
+	stmt gobas.Stmt
+	expr gobas.Expr
+	to   int
+}
+
+// jump continues at pc to
+type jump struct {
+	to int
+}
+
+type instr struct {
+	line    int
+	stmtIdx int
+	stmt    gobas.Stmt
+}
+
+type generator struct {
+	lines   []gobas.Line
+	types   gobas.DefTypes
+	instrs  []instr
+	linePCs map[int]int
+	blocks  map[int]int
+	vars    map[string]bool
+	arrays  map[string]bool
+	defs    map[string]gobas.DEF
+	out     bytes.Buffer
+	body    bytes.Buffer
+	fncs    bytes.Buffer
+}
+
+func newGenerator(lines []gobas.Line) *generator {
+	return &generator{
+		lines:   lines,
+		types:   gobas.DeclaredTypes(lines),
+		linePCs: map[int]int{},
+		blocks:  map[int]int{},
+		vars:    map[string]bool{},
+		arrays:  map[string]bool{},
+		defs:    map[string]gobas.DEF{},
+	}
+}
+
+func (g *generator) generate() error {
+	for _, line := range g.lines {
+		g.linePCs[line.Num()] = len(g.instrs)
+		lineStart := len(g.instrs)
+		g.flatten(line.Num(), lineStart, line.Stmts())
+	}
+	blocks, err := gobas.ResolveBlocks(g.lines)
+	if err != nil {
+		return err
+	}
+	for from, to := range blocks {
+		g.blocks[g.blockPC(from)] = g.blockPC(to)
+	}
+	err = g.declare()
+	if err != nil {
+		return err
+	}
+	for pc, in := range g.instrs {
+		err := g.genInstr(pc, in)
+		if err != nil {
+			return errors.Wrapf(err, "line %d", in.line)
+		}
+	}
+	for _, name := range sortedKeys(g.defs) {
+		err := g.genDef(name, g.defs[name])
+		if err != nil {
+			return errors.Wrapf(err, "DEF %s", name)
+		}
+	}
+	g.genProgram()
+	return nil
+}
+
+// flatten inlines the branches of single-line IFs like State does, so every statement gets its own pc
+func (g *generator) flatten(lineNum int, lineStart int, stmts []gobas.Stmt) {
+	add := func(stmt gobas.Stmt) int {
+		g.instrs = append(g.instrs, instr{
+			line:    lineNum,
+			stmtIdx: len(g.instrs) - lineStart,
+			stmt:    stmt,
+		})
+		return len(g.instrs) - 1
+	}
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case gobas.IFSTMT:
+			at := add(nil)
+			g.flatten(lineNum, lineStart, stmt.Stmts)
+			g.instrs[at].stmt = condJump{stmt: stmt, expr: stmt.Expr, to: len(g.instrs)}
+		case gobas.IFELSESTMT:
+			at := add(nil)
+			g.flatten(lineNum, lineStart, stmt.Stmts)
+			jumpAt := add(nil)
+			g.instrs[at].stmt = condJump{stmt: stmt, expr: stmt.Expr, to: len(g.instrs)}
+			g.flatten(lineNum, lineStart, stmt.ElseStmts)
+			g.instrs[jumpAt].stmt = jump{to: len(g.instrs)}
+		default:
+			add(stmt)
+		}
+	}
+}
+
+// declare collects arrays and user-defined functions, which share their namespace with the builtin functions
+func (g *generator) declare() error {
+	for _, in := range g.instrs {
+		switch stmt := in.stmt.(type) {
+		case gobas.DIM:
+			for _, ad := range stmt.Arrays {
+				g.arrays[ad.Var] = true
+			}
+		case gobas.DEF:
+			if _, ok := g.defs[stmt.Name]; ok {
+				return errors.Errorf("line %d: multiple definitions of %s are not supported", in.line, stmt.Name)
+			}
+			g.defs[stmt.Name] = stmt
+		}
+	}
+	return nil
+}
+
+func (g *generator) bodyf(pattern string, args ...interface{}) {
+	fmt.Fprintf(&g.body, pattern+"\n", args...)
+}
+
+func (g *generator) fncsf(pattern string, args ...interface{}) {
+	fmt.Fprintf(&g.fncs, pattern+"\n", args...)
+}
+
+func (g *generator) typeOf(name string) expr.Type {
+	return g.types.TypeOf(name)
+}
+
+var suffixIdents = strings.NewReplacer("$", "_S", "%", "_I", "!", "_F", "#", "_D")
+
+func (g *generator) varIdent(name string) string {
+	return strings.ToLower(g.typeOf(name).String()[:3]) + "_" + suffixIdents.Replace(name)
+}
+
+func (g *generator) arrayIdent(name string) string {
+	return "arr_" + g.varIdent(name)
+}
+
+func fnIdent(name string) string {
+	return "fn_" + suffixIdents.Replace(name)
+}
+
+func validVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		case strings.ContainsRune("$%!#", r) && i == len(name)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (g *generator) varRef(name string) (string, error) {
+	if !validVarName(name) {
+		return "", errors.Errorf("unsupported variable %q", name)
+	}
+	g.vars[name] = true
+	return "p." + g.varIdent(name), nil
+}
+
+func (g *generator) linePC(num int) (int, bool) {
+	pc, ok := g.linePCs[num]
+	return pc, ok
+}
+
+// jumpTo returns the code to continue at BASIC line num
+func (g *generator) jumpTo(num int) string {
+	pc, ok := g.linePC(num)
+	if !ok {
+		return fmt.Sprintf("throw(fmt.Errorf(\"no such line %%d\", %d))", num)
+	}
+	return fmt.Sprintf("pc = %d\ncontinue", pc)
+}
+
+func (g *generator) gosubTo(num int, retPC int) string {
+	pc, ok := g.linePC(num)
+	if !ok {
+		return fmt.Sprintf("p.gosub(%d)\nthrow(fmt.Errorf(\"no such line %%d\", %d))", retPC, num)
+	}
+	return fmt.Sprintf("p.gosub(%d)\npc = %d\ncontinue", retPC, pc)
+}
+
+func (g *generator) genInstr(pc int, in instr) error {
+	g.bodyf("case %d:", pc)
+	g.bodyf("p.at(%d, %d, %q)", in.line, in.stmtIdx, kindOf(in.stmt))
+	next := pc + 1
+	fallsThrough := true
+
+	switch stmt := in.stmt.(type) {
+	case condJump:
+		cond, err := g.numExpr(stmt.expr, nil)
+		if err != nil {
+			return err
+		}
+		g.bodyf("if !truth(%s) {\npc = %d\ncontinue\n}", cond, stmt.to)
+	case jump:
+		g.bodyf("pc = %d\ncontinue", stmt.to)
+		fallsThrough = false
+	case gobas.DATA, gobas.DEFTYPE, gobas.REM:
+	case gobas.DEF:
+		g.bodyf("p.def_%s = true", fnIdent(stmt.Name))
+	case gobas.DIM:
+		for _, ad := range stmt.Arrays {
+			dims, err := g.numExprs(ad.Dimensions, nil)
+			if err != nil {
+				return err
+			}
+			g.bodyf("p.%s = gobas.NewArray[%s](ints(%s))", g.arrayIdent(ad.Var), goType(g.typeOf(ad.Var)), strings.Join(dims, ", "))
+		}
+	case gobas.END:
+		g.bodyf("return gobas.ExitEnd, nil")
+		fallsThrough = false
+	case gobas.STOP:
+		g.bodyf("return gobas.ExitStop, nil")
+		fallsThrough = false
+	case gobas.TRON:
+		g.bodyf("p.tron = true")
+	case gobas.TROFF:
+		g.bodyf("p.tron = false")
+	case gobas.FOR:
+		ref, err := g.varRef(stmt.Var)
+		if err != nil {
+			return err
+		}
+		var vals []string
+		for _, e := range []gobas.Expr{stmt.Initial, stmt.To, stmt.Step} {
+			v, err := g.numExpr(e, nil)
+			if err != nil {
+				return err
+			}
+			vals = append(vals, v)
+		}
+		t := g.typeOf(stmt.Var)
+		if t == expr.TypeString {
+			return errors.Errorf("type mismatch: FOR on string variable %q", stmt.Var)
+		}
+		get := fmt.Sprintf("func() float64 {\nreturn float64(%s)\n}", ref)
+		set := fmt.Sprintf("func(f float64) {\n%s = convert[%s](%s, f)\n}", ref, goType(t), typeIdent(t))
+		g.bodyf("p.forLoop(%q, %s, %s, %s, %s, %s, %d)", stmt.Var, get, set, vals[0], vals[1], vals[2], next)
+	case gobas.NEXT:
+		vars := stmt.Vars
+		if len(vars) == 0 {
+			vars = []string{""}
+		}
+		for _, v := range vars {
+			g.bodyf("if npc, ok := p.next(%q); ok {\npc = npc\ncontinue\n}", v)
+		}
+	case gobas.GOSUB:
+		g.bodyf("%s", g.gosubTo(stmt.Line, next))
+		fallsThrough = false
+	case gobas.GOTO:
+		g.bodyf("%s", g.jumpTo(stmt.Line))
+		fallsThrough = false
+	case gobas.IFLN:
+		cond, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return err
+		}
+		g.bodyf("if truth(%s) {\n%s\n}", cond, g.jumpTo(stmt.Line))
+	case gobas.IFELSELN:
+		cond, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return err
+		}
+		g.bodyf("if truth(%s) {\n%s\n}\n%s", cond, g.jumpTo(stmt.Line), g.jumpTo(stmt.ElseLine))
+		fallsThrough = false
+	case gobas.INPUT:
+		var types []string
+		for _, v := range stmt.Vars {
+			types = append(types, typeIdent(g.typeOf(v)))
+		}
+		g.bodyf("vs, ok := p.input(%q, %s)", stmt.Prompt(), strings.Join(types, ", "))
+		g.bodyf("if !ok {\nreturn gobas.ExitEnd, nil\n}")
+		for i, v := range stmt.Vars {
+			err := g.genSetRef(v, fmt.Sprintf("vs[%d]", i))
+			if err != nil {
+				return err
+			}
+		}
+	case gobas.LINEINPUT:
+		if g.typeOf(stmt.Var) != expr.TypeString {
+			return errors.Errorf("type mismatch: LINE INPUT to numeric variable %q", stmt.Var)
+		}
+		g.bodyf("line, ok := p.lineInput(%q)", stmt.Msg)
+		g.bodyf("if !ok {\nreturn gobas.ExitEnd, nil\n}")
+		err := g.genSetRef(stmt.Var, "line")
+		if err != nil {
+			return err
+		}
+	case gobas.LET:
+		err := g.genAssign(stmt.Var, stmt.Expr)
+		if err != nil {
+			return err
+		}
+	case gobas.ASSIGN:
+		err := g.genAssign(stmt.Var, stmt.Expr)
+		if err != nil {
+			return err
+		}
+	case gobas.ASSIGN_ARRAY:
+		val, err := g.typedExpr(stmt.Expr, g.typeOf(stmt.Array.Var), nil)
+		if err != nil {
+			return err
+		}
+		err = g.genSetArray(stmt.Array, val)
+		if err != nil {
+			return err
+		}
+	case gobas.ONGOSUB:
+		ix, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return err
+		}
+		g.bodyf("switch ix := int(%s); ix {", ix)
+		for i, ln := range stmt.Lines {
+			g.bodyf("case %d:\n%s", i+1, g.gosubTo(ln, next))
+		}
+		g.bodyf("default:\nthrow(fmt.Errorf(\"invalid index %%d\", ix))\n}")
+		fallsThrough = false
+	case gobas.ONGOTO:
+		ix, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return err
+		}
+		g.bodyf("switch ix := int(round(%s)); ix {", ix)
+		for i, ln := range stmt.Lines {
+			g.bodyf("case %d:\n%s", i+1, g.jumpTo(ln))
+		}
+		g.bodyf("default:\nthrow(fmt.Errorf(\"invalid index %%d\", ix))\n}")
+		fallsThrough = false
+	case gobas.PRINT:
+		noNewline := false
+		for _, pi := range stmt.Items {
+			noNewline = true
+			switch pi := pi.(type) {
+			case gobas.Expr:
+				v, _, err := g.expr(pi.Tree, nil)
+				if err != nil {
+					return err
+				}
+				g.bodyf("p.con.Print(%s)", v)
+				noNewline = false
+			case gobas.PrintComma:
+				g.bodyf("p.con.PrintComma()")
+			case gobas.PrintTab:
+				n, err := g.numExpr(pi.Expr, nil)
+				if err != nil {
+					return err
+				}
+				g.bodyf("p.con.Tab(printArg(%s))", n)
+			case gobas.PrintSpc:
+				n, err := g.numExpr(pi.Expr, nil)
+				if err != nil {
+					return err
+				}
+				g.bodyf("p.con.Spc(printArg(%s))", n)
+			}
+		}
+		if !noNewline {
+			g.bodyf("p.con.Println()")
+		}
+	case gobas.PRINTUSING:
+		format, err := g.typedExpr(stmt.Format, expr.TypeString, nil)
+		if err != nil {
+			return err
+		}
+		var vals []string
+		noNewline := false
+		for _, pi := range stmt.Items {
+			noNewline = true
+			if e, ok := pi.(gobas.Expr); ok {
+				v, _, err := g.expr(e.Tree, nil)
+				if err != nil {
+					return err
+				}
+				vals = append(vals, v)
+				noNewline = false
+			}
+		}
+		g.bodyf("p.con.Print(must(gobas.FormatUsing(%s, []interface{}{%s})))", format, strings.Join(vals, ", "))
+		if !noNewline {
+			g.bodyf("p.con.Println()")
+		}
+	case gobas.READ:
+		for _, v := range stmt.Vars {
+			err := g.genSetRef(v, fmt.Sprintf("must(p.data.ReadFor(%q, %s))", v, typeIdent(g.typeOf(v))))
+			if err != nil {
+				return err
+			}
+		}
+	case gobas.RESTORE:
+		g.bodyf("p.data.Restore()")
+	case gobas.RETURN:
+		g.bodyf("pc = p.ret()\ncontinue")
+		fallsThrough = false
+	case gobas.WHILE, gobas.WEND, gobas.DO, gobas.LOOP, gobas.EXITDO, gobas.IFBLOCK, gobas.ELSEIF, gobas.ELSE:
+		var err error
+		fallsThrough, err = g.genBlock(pc, stmt)
+		if err != nil {
+			return err
+		}
+	case gobas.ENDIF:
+	default:
+		return errors.Errorf("unsupported statement %T", stmt)
+	}
+	if fallsThrough {
+		g.bodyf("fallthrough")
+	}
+	return nil
+}
+
+// blockPC returns the pc of the statement at pos
+func (g *generator) blockPC(pos gobas.BlockPos) int {
+	return g.linePCs[g.lines[pos.LineIdx].Num()] + pos.StmtIdx
+}
+
+// genBlock generates the jumps of structured blocks like State does. It tells, if the statement falls through.
+func (g *generator) genBlock(pc int, stmt gobas.Stmt) (bool, error) {
+	to := g.blocks[pc]
+	switch stmt := stmt.(type) {
+	case gobas.WHILE:
+		cond, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return false, err
+		}
+		g.bodyf("if !truth(%s) {\npc = %d\ncontinue\n}", cond, to+1)
+	case gobas.WEND:
+		g.bodyf("pc = %d\ncontinue", to)
+		return false, nil
+	case gobas.DO:
+		if stmt.Cond == nil {
+			break
+		}
+		cond, err := g.loopCond(stmt.Cond)
+		if err != nil {
+			return false, err
+		}
+		g.bodyf("if !(%s) {\npc = %d\ncontinue\n}", cond, to+1)
+	case gobas.LOOP:
+		if stmt.Cond == nil {
+			g.bodyf("pc = %d\ncontinue", to)
+			return false, nil
+		}
+		cond, err := g.loopCond(stmt.Cond)
+		if err != nil {
+			return false, err
+		}
+		g.bodyf("if %s {\npc = %d\ncontinue\n}", cond, to)
+	case gobas.EXITDO:
+		g.bodyf("pc = %d\ncontinue", to+1)
+		return false, nil
+	case gobas.IFBLOCK:
+		cond, err := g.numExpr(stmt.Expr, nil)
+		if err != nil {
+			return false, err
+		}
+		g.bodyf("if !truth(%s) {", cond)
+		// continue in the first clause, whose condition is true
+		for ; ; to = g.blocks[to] {
+			elseIf, ok := g.instrs[to].stmt.(gobas.ELSEIF)
+			if !ok {
+				g.bodyf("pc = %d\ncontinue", to+1)
+				break
+			}
+			cond, err := g.numExpr(elseIf.Expr, nil)
+			if err != nil {
+				return false, err
+			}
+			g.bodyf("if truth(%s) {\npc = %d\ncontinue\n}", cond, to+1)
+		}
+		g.bodyf("}")
+	case gobas.ELSEIF, gobas.ELSE:
+		// the clause ends behind the END IF
+		end := pc
+		for {
+			if _, ok := g.instrs[end].stmt.(gobas.ENDIF); ok {
+				break
+			}
+			end = g.blocks[end]
+		}
+		g.bodyf("pc = %d\ncontinue", end+1)
+		return false, nil
+	}
+	return true, nil
+}
+
+// loopCond returns the code, which tells if a DO ... LOOP continues
+func (g *generator) loopCond(cond *gobas.LoopCond) (string, error) {
+	code, err := g.numExpr(cond.Expr, nil)
+	if err != nil {
+		return "", err
+	}
+	if cond.Until {
+		return fmt.Sprintf("!truth(%s)", code), nil
+	}
+	return fmt.Sprintf("truth(%s)", code), nil
+}
+
+func kindOf(stmt gobas.Stmt) string {
+	switch stmt := stmt.(type) {
+	case condJump:
+		return gobas.StmtKind(stmt.stmt)
+	case jump:
+		return "jump"
+	default:
+		return gobas.StmtKind(stmt)
+	}
+}
+
+func (g *generator) genAssign(name string, e gobas.Expr) error {
+	ref, err := g.varRef(name)
+	if err != nil {
+		return err
+	}
+	val, err := g.typedExpr(e, g.typeOf(name), nil)
+	if err != nil {
+		return err
+	}
+	g.bodyf("%s = %s", ref, val)
+	return nil
+}
+
+// genSetRef assigns val to a variable or an array element, like READ and INPUT reference them.
+// val is an interface{} holding a value of the type of the variable.
+func (g *generator) genSetRef(v string, val string) error {
+	typed := fmt.Sprintf("%s.(%s)", val, goType(g.typeOf(v)))
+	if !strings.HasSuffix(v, ")") {
+		ref, err := g.varRef(v)
+		if err != nil {
+			return err
+		}
+		g.bodyf("%s = %s", ref, typed)
+		return nil
+	}
+	ad, err := parseArray(v)
+	if err != nil {
+		return err
+	}
+	return g.genSetArray(ad, typed)
+}
+
+func (g *generator) genSetArray(ad gobas.ArrayDef, val string) error {
+	cs, err := g.numExprs(ad.Dimensions, nil)
+	if err != nil {
+		return err
+	}
+	g.bodyf("setIndex(p.%s, %q, %s, %s)", g.arrayIdent(ad.Var), ad.Var, val, strings.Join(cs, ", "))
+	return nil
+}
+
+func (g *generator) genDef(name string, def gobas.DEF) error {
+	locals := map[string]string{}
+	var params []string
+	for i, param := range def.Params {
+		if !validVarName(param) {
+			return errors.Errorf("unsupported parameter %q", param)
+		}
+		local := fmt.Sprintf("p%d", i)
+		locals[param] = local
+		params = append(params, local+" "+goType(g.typeOf(param)))
+	}
+	val, err := g.typedExpr(def.Expr, g.typeOf(name), locals)
+	if err != nil {
+		return err
+	}
+	ident := fnIdent(name)
+	g.fncsf("\nfunc (p *program) %s(%s) %s {", ident, strings.Join(params, ", "), goType(g.typeOf(name)))
+	g.fncsf("if !p.def_%s {\nthrow(fmt.Errorf(\"no such func %%q\", %q))\n}", ident, name)
+	g.fncsf("if p.active_%s {\nthrow(fmt.Errorf(\"recursive call of %%s\", %q))\n}", ident, name)
+	g.fncsf("p.active_%s = true\ndefer func() {\np.active_%s = false\n}()", ident, ident)
+	g.fncsf("return %s\n}", val)
+	return nil
+}
+
+//
+
+// typedExpr returns the code of e converted to type t
+func (g *generator) typedExpr(e gobas.Expr, t expr.Type, locals map[string]string) (string, error) {
+	if e.Tree == nil {
+		return "", errors.Errorf("empty expression")
+	}
+	code, et, err := g.expr(e.Tree, locals)
+	if err != nil {
+		return "", errors.Wrapf(err, "expression %q", e.Raw)
+	}
+	code, err = convertCode(code, et, t)
+	if err != nil {
+		return "", errors.Wrapf(err, "expression %q", e.Raw)
+	}
+	return code, nil
+}
+
+// convertCode converts the code of a value of type from to type to, like State converts values on assignments
+func convertCode(code string, from, to expr.Type) (string, error) {
+	switch {
+	case from == to:
+		return code, nil
+	case from == expr.TypeString || to == expr.TypeString:
+		return "", errors.Errorf("type mismatch: cannot use %s as %s", from, to)
+	default:
+		return fmt.Sprintf("convert[%s](%s, %s)", goType(to), typeIdent(to), code), nil
+	}
+}
+
+// numExpr returns the code of the numeric expression e as float64
+func (g *generator) numExpr(e gobas.Expr, locals map[string]string) (string, error) {
+	if e.Tree == nil {
+		return "", errors.Errorf("empty expression")
+	}
+	code, t, err := g.expr(e.Tree, locals)
+	if err != nil {
+		return "", errors.Wrapf(err, "expression %q", e.Raw)
+	}
+	switch t {
+	case expr.TypeString:
+		return "", errors.Errorf("type mismatch in expression %q", e.Raw)
+	case expr.TypeDouble:
+		return code, nil
+	default:
+		return fmt.Sprintf("float64(%s)", code), nil
+	}
+}
+
+func (g *generator) numExprs(es []gobas.Expr, locals map[string]string) ([]string, error) {
+	var codes []string
+	for _, e := range es {
+		c, err := g.numExpr(e, locals)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+// expr returns the code of ev and its type. The code evaluates to a value of the Go type of that type.
+func (g *generator) expr(ev expr.Evaler, locals map[string]string) (string, expr.Type, error) {
+	switch ev := ev.(type) {
+	case expr.BinaryEvaler:
+		return g.binaryExpr(ev, locals)
+	case expr.UnaryEvaler:
+		x, xt, err := g.expr(ev.X, locals)
+		if err != nil {
+			return "", 0, err
+		}
+		t, err := expr.UnaryResultType(ev.Op, xt)
+		if err != nil {
+			return "", 0, errors.Errorf("type mismatch: %s on string", ev.Op)
+		}
+		return fmt.Sprintf("unOp[%s](%s, %s)", goType(t), opIdents[ev.Op], x), t, nil
+	case expr.NumberEvaler[int16]:
+		return fmt.Sprintf("int16(%d)", ev.V), expr.TypeInteger, nil
+	case expr.NumberEvaler[float32]:
+		return fmt.Sprintf("float32(%s)", strconv.FormatFloat(float64(ev.V), 'g', -1, 32)), expr.TypeSingle, nil
+	case expr.NumberEvaler[float64]:
+		return fmt.Sprintf("float64(%s)", strconv.FormatFloat(ev.V, 'g', -1, 64)), expr.TypeDouble, nil
+	case expr.StringEvaler:
+		return strconv.Quote(string(ev)), expr.TypeString, nil
+	case expr.VarEvaler:
+		name := string(ev)
+		if local, ok := locals[name]; ok {
+			return local, g.typeOf(name), nil
+		}
+		ref, err := g.varRef(name)
+		if err != nil {
+			return "", 0, err
+		}
+		return ref, g.typeOf(name), nil
+	case expr.FuncEvaler:
+		return g.funcExpr(ev, locals)
+	default:
+		return "", 0, errors.Errorf("unsupported expression element %T", ev)
+	}
+}
+
+// opIdents are the Go expressions of the operators. Numeric operators are delegated to expr.BinaryOp and
+// expr.UnaryOp, to get the same types, errors and integer conversions like the interpreter.
+var opIdents = map[expr.Op]string{
+	expr.OpXOR:    "expr.OpXOR",
+	expr.OpOR:     "expr.OpOR",
+	expr.OpAND:    "expr.OpAND",
+	expr.OpNOT:    "expr.OpNOT",
+	expr.OpLs:     "expr.OpLs",
+	expr.OpGt:     "expr.OpGt",
+	expr.OpEq:     "expr.OpEq",
+	expr.OpNotEq:  "expr.OpNotEq",
+	expr.OpLsEq:   "expr.OpLsEq",
+	expr.OpGtEq:   "expr.OpGtEq",
+	expr.OpPlus:   "expr.OpPlus",
+	expr.OpMinus:  "expr.OpMinus",
+	expr.OpIntDiv: "expr.OpIntDiv",
+	expr.OpMOD:    "expr.OpMOD",
+	expr.OpTimes:  "expr.OpTimes",
+	expr.OpDiv:    "expr.OpDiv",
+	expr.OpNeg:    "expr.OpNeg",
+	expr.OpExp:    "expr.OpExp",
+}
+
+func (g *generator) binaryExpr(ev expr.BinaryEvaler, locals map[string]string) (string, expr.Type, error) {
+	x, xt, err := g.expr(ev.X, locals)
+	if err != nil {
+		return "", 0, err
+	}
+	y, yt, err := g.expr(ev.Y, locals)
+	if err != nil {
+		return "", 0, err
+	}
+	t, err := expr.ResultType(ev.Op, xt, yt)
+	if err != nil {
+		return "", 0, errors.Errorf("type mismatch: %s %s %s", x, ev.Op, y)
+	}
+	if xt == expr.TypeString {
+		if ev.Op == expr.OpPlus {
+			return fmt.Sprintf("(%s + %s)", x, y), t, nil
+		}
+		op := ev.Op.String()
+		switch ev.Op {
+		case expr.OpEq:
+			op = "=="
+		case expr.OpNotEq:
+			op = "!="
+		}
+		return fmt.Sprintf("b2i(%s %s %s)", x, op, y), t, nil
+	}
+	name, ok := opIdents[ev.Op]
+	if !ok {
+		return "", 0, errors.Errorf("unsupported operator %q", ev.Op)
+	}
+	return fmt.Sprintf("binOp[%s](%s, %s, %s)", goType(t), name, x, y), t, nil
+}
+
+func (g *generator) funcExpr(fe expr.FuncEvaler, locals map[string]string) (string, expr.Type, error) {
+	var args []string
+	var argTypes []expr.Type
+	for _, a := range fe.Args {
+		code, t, err := g.expr(a, locals)
+		if err != nil {
+			return "", 0, err
+		}
+		args = append(args, code)
+		argTypes = append(argTypes, t)
+	}
+
+	if g.arrays[fe.Name] {
+		for i, t := range argTypes {
+			if t == expr.TypeString {
+				return "", 0, errors.Errorf("type mismatch: string index of array %q", fe.Name)
+			}
+			args[i] = fmt.Sprintf("float64(%s)", args[i])
+		}
+		return fmt.Sprintf("index(p.%s, %q, %s)", g.arrayIdent(fe.Name), fe.Name, strings.Join(args, ", ")), g.typeOf(fe.Name), nil
+	}
+	if def, ok := g.defs[fe.Name]; ok {
+		if len(args) != len(def.Params) {
+			return "", 0, errors.Errorf("%s: expect %d args, got %d", def.Name, len(def.Params), len(args))
+		}
+		for i, t := range argTypes {
+			code, err := convertCode(args[i], t, g.typeOf(def.Params[i]))
+			if err != nil {
+				return "", 0, errors.Errorf("%s: type mismatch in param %q", def.Name, def.Params[i])
+			}
+			args[i] = code
+		}
+		return fmt.Sprintf("p.%s(%s)", fnIdent(fe.Name), strings.Join(args, ", ")), g.typeOf(fe.Name), nil
+	}
+
+	callArgs := append([]string{strconv.Quote(fe.Name)}, args...)
+	t := gobas.FuncType(fe.Name, argTypes)
+	return fmt.Sprintf("result[%s](p.funcs.Call(%s))", goType(t), strings.Join(callArgs, ", ")), t, nil
+}
+
+func parseArray(s string) (gobas.ArrayDef, error) {
+	name, idxRaw, ok := strings.Cut(s, "(")
+	if !ok || !strings.HasSuffix(idxRaw, ")") {
+		return gobas.ArrayDef{}, errors.Errorf("invalid array element %q", s)
+	}
+	var ad gobas.ArrayDef
+	ad.Var = strings.TrimSpace(name)
+	for _, raw := range strings.Split(strings.TrimSuffix(idxRaw, ")"), ",") {
+		tree, err := expr.NewParser(raw).Parse()
+		if err != nil {
+			return gobas.ArrayDef{}, errors.Wrapf(err, "parse index %q", raw)
+		}
+		ad.Dimensions = append(ad.Dimensions, gobas.Expr{Raw: raw, Tree: tree})
+	}
+	return ad, nil
+}
+
+func (g *generator) genProgram() {
+	w := &g.out
+	fmt.Fprintln(w, "// Code generated by gobas transpile. DO NOT EDIT.")
+	fmt.Fprintln(w)
+	w.WriteString(programHeader)
+
+	fmt.Fprintln(w, "type program struct {")
+	fmt.Fprintln(w, "programState")
+	for _, name := range sortedKeys(g.vars) {
+		fmt.Fprintf(w, "%s %s\n", g.varIdent(name), goType(g.typeOf(name)))
+	}
+	for _, name := range sortedKeys(g.arrays) {
+		fmt.Fprintf(w, "%s *gobas.Array[%s]\n", g.arrayIdent(name), goType(g.typeOf(name)))
+	}
+	defNames := sortedKeys(g.defs)
+	for _, name := range defNames {
+		fmt.Fprintf(w, "def_%s bool\n", fnIdent(name))
+		fmt.Fprintf(w, "active_%s bool\n", fnIdent(name))
+	}
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "func newProgram(in io.Reader, out io.Writer) *program {")
+	fmt.Fprintln(w, "p := &program{}")
+	fmt.Fprintln(w, "p.init(in, out)")
+	for _, line := range g.lines {
+		for _, stmt := range line.Stmts() {
+			if data, ok := stmt.(gobas.DATA); ok {
+				for _, c := range data.Consts {
+					fmt.Fprintf(w, "p.data.Add(%q)\n", strings.Trim(c, `"`))
+				}
+			}
+		}
+	}
+	fmt.Fprintln(w, "return p")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "func (p *program) run() (gobas.Exit, error) {")
+	fmt.Fprintln(w, "pc := 0")
+	fmt.Fprintln(w, "for {")
+	fmt.Fprintln(w, "switch pc {")
+	w.Write(g.body.Bytes())
+	fmt.Fprintf(w, "case %d:\n", len(g.instrs))
+	fmt.Fprintln(w, "return gobas.ExitLastLine, nil")
+	fmt.Fprintln(w, "default:")
+	w.WriteString("return gobas.ExitError, fmt.Errorf(\"invalid pc %d\", pc)\n")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "}")
+	w.Write(g.fncs.Bytes())
+	fmt.Fprintln(w)
+	w.WriteString(programRuntime)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package transpile
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mazzegi/gobas"
+)
+
+func runInterpreted(t *testing.T, src string, input string) string {
+	state, err := gobas.NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out := &bytes.Buffer{}
+	state.SetStreams(gobas.Streams{
+		Stdin:  strings.NewReader(input),
+		Stdout: out,
+	})
+	_, err = state.Run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return out.String()
+}
+
+const gobasModule = "github.com/mazzegi/gobas"
+
+func runTranspiled(t *testing.T, src string, input string) string {
+	state, err := gobas.NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	gosrc, err := Transpile(state.Lines())
+	if err != nil {
+		t.Fatalf("transpile: %v", err)
+	}
+	out, errOut, err := runGo(t, gosrc, input)
+	if err != nil {
+		t.Fatalf("run transpiled: %v\n%s\n%s", err, errOut, gosrc)
+	}
+	return out
+}
+
+// runGo builds and runs a generated program and returns its stdout and stderr
+func runGo(t *testing.T, gosrc []byte, input string) (string, string, error) {
+	// the generated program is built in a module of its own, which requires this module from the source tree
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	gomod, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		t.Fatalf("read go.mod: %v", err)
+	}
+	gosum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatalf("read go.sum: %v", err)
+	}
+	gomod = bytes.Replace(gomod, []byte("module "+gobasModule), []byte("module gen"), 1)
+	gomod = append(gomod, "\nrequire "+gobasModule+" v0.0.0\n\nreplace "+gobasModule+" => "+root+"\n"...)
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"go.mod": gomod, "go.sum": gosum, "main.go": gosrc} {
+		err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+		if err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+	err = cmd.Run()
+	return out.String(), errOut.String(), err
+}
+
+func skipWithoutGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip building transpiled programs in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain found")
+	}
+}
+
+// transpileInputs are the inputs of the programs in samples and testfiles, which read INPUT. When the input
+// is used up, INPUT ends a program like END, so the games stop after a few rounds.
+var transpileInputs = map[string]string{
+	"001_aceyducey.bas": "10\n0\n50\n100\n",
+	"002_amazing.bas":   "1,5\n8,6\n",
+	"transpile01.bas":   "MARTIN\n",
+	"transpile02.bas":   "7.6\n",
+	"transpile03.bas":   "X\n1\n \"HELLO, WORLD\" \n3,4\nA \"QUOTED\", LINE\n",
+}
+
+func TestTranspileSameOutput(t *testing.T) {
+	skipWithoutGo(t)
+
+	var files []string
+	for _, pattern := range []string{"../samples/*.bas", "../testfiles/transpile*.bas"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatalf("glob %q: %v", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no programs found")
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			bs, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read %q: %v", file, err)
+			}
+			// RND is seeded, so the games are the same in both runs
+			src := "1 X=RND(-7)\n" + string(bs)
+			input := transpileInputs[name]
+			want := runInterpreted(t, src, input)
+			got := runTranspiled(t, src, input)
+			if got != want {
+				t.Fatalf("want output\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+
+	t.Run("tron", func(t *testing.T) {
+		src := "10 TRON: FOR I=1 TO 2\n20 PRINT I;: NEXT: GOSUB 40\n30 TROFF: END\n40 RETURN"
+		if got, want := runTranspiled(t, src, ""), runInterpreted(t, src, ""); got != want {
+			t.Fatalf("want output\n%s\ngot\n%s", want, got)
+		}
+	})
+}
+
+func TestTranspileUndefinedLine(t *testing.T) {
+	skipWithoutGo(t)
+
+	// the jumps are not linked, so the missing lines are an error of the running program
+	tests := []struct {
+		src string
+		err string
+	}{
+		{src: "10 GOTO 99", err: "ERROR: line 10: GOTO: no such line 99\n"},
+		{src: "10 PRINT 1: GOSUB 99", err: "ERROR: line 10: GOSUB: no such line 99\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			lines, err := gobas.NewParser().ParseLines(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			gosrc, err := Transpile(lines)
+			if err != nil {
+				t.Fatalf("transpile: %v", err)
+			}
+			_, errOut, err := runGo(t, gosrc, "")
+			if err == nil || !strings.HasPrefix(errOut, test.err) {
+				t.Fatalf("want error %q, got %v with %q", test.err, err, errOut)
+			}
+		})
+	}
+}
+
+func TestTranspileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "assign string to number",
+			src:  `10 A="X"`,
+		},
+		{
+			name: "assign number to DEFSTR variable",
+			src:  "10 DEFSTR S\n20 S=1",
+		},
+		{
+			name: "multiply strings",
+			src:  `10 A$="X"*"Y"`,
+		},
+		{
+			name: "wrong number of fn args",
+			src:  "10 DEF FNA(X)=X\n20 Y=FNA(1,2)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := gobas.NewParser().Parse(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			_, err = Transpile(state.Lines())
+			if err == nil {
+				t.Fatalf("want error, got none")
+			}
+		})
+	}
+}
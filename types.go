@@ -0,0 +1,99 @@
+package gobas
+
+import (
+	"strings"
+
+	"github.com/mazzegi/gobas/expr"
+)
+
+// defTypeKeywords are the statements, which declare the type of variables by their first letter
+var defTypeKeywords = map[string]expr.Type{
+	"DEFINT": expr.TypeInteger,
+	"DEFSNG": expr.TypeSingle,
+	"DEFDBL": expr.TypeDouble,
+	"DEFSTR": expr.TypeString,
+}
+
+func defTypeKeyword(t expr.Type) string {
+	for kw, kt := range defTypeKeywords {
+		if kt == t {
+			return kw
+		}
+	}
+	return ""
+}
+
+// DefTypes are the types of the variables without a type suffix by their first letter.
+// Letters, which are not declared by a DEFINT, DEFSNG, DEFDBL or DEFSTR, are single precision.
+type DefTypes [26]expr.Type
+
+// DeclaredTypes collects the DEFINT, DEFSNG, DEFDBL and DEFSTR statements of a program. Like DATA they take effect
+// before the program runs, so a variable has the same type all over the program.
+func DeclaredTypes(lines []Line) DefTypes {
+	var dt DefTypes
+	for _, line := range lines {
+		for _, stmt := range line.stmts {
+			if deftype, ok := stmt.(DEFTYPE); ok {
+				dt.Declare(deftype)
+			}
+		}
+	}
+	return dt
+}
+
+func (dt *DefTypes) Declare(stmt DEFTYPE) {
+	for _, lr := range stmt.Letters {
+		for c := lr.From; c <= lr.To; c++ {
+			dt[c-'A'] = stmt.Type
+		}
+	}
+}
+
+// TypeOf returns the type of the variable or array name. The type suffix takes precedence over the declared type.
+func (dt *DefTypes) TypeOf(name string) expr.Type {
+	name, _, _ = strings.Cut(name, "(")
+	name = strings.TrimSpace(name)
+	if t, ok := expr.TypeOfSuffix(name); ok {
+		return t
+	}
+	if name == "" {
+		return expr.TypeSingle
+	}
+	c := name[0]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c < 'A' || c > 'Z' {
+		return expr.TypeSingle
+	}
+	return dt[c-'A']
+}
+
+// scope resolves and assigns the variables of the main program or of a procedure call
+type scope interface {
+	expr.Lookuper
+	set(name string, v interface{}) error
+}
+
+// variables are the global variables of a program. Like in Microsoft BASIC variables, which have not been
+// assigned yet, are zero or empty.
+type variables struct {
+	*expr.Vars
+	types *DefTypes
+}
+
+func (vs variables) LookupVar(name string) (interface{}, error) {
+	if v, err := vs.Vars.LookupVar(name); err == nil {
+		return v, nil
+	}
+	return vs.types.TypeOf(name).Zero(), nil
+}
+
+func (vs variables) CanEvalFloat(name string) bool {
+	return vs.types.TypeOf(name) != expr.TypeString
+}
+
+func (vs variables) set(name string, v interface{}) error {
+	vs.Add(name, v)
+	return nil
+}
@@ -0,0 +1,749 @@
+package gobas
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+// Program is a program compiled to bytecode by Compile. It runs on a VM, which keeps numbers and strings on
+// separate stacks and addresses variables and arrays by slots.
+type Program struct {
+	code      []instr
+	consts    []string
+	errs      []error
+	calls     []callInfo
+	defs      []*fnDef
+	ons       []onInfo
+	inputs    []inputInfo
+	usings    []usingInfo
+	arrays    []arrayInfo
+	fnNames   []string
+	numVars   int
+	strVars   int
+	data      []interface{}
+	wraps     []wrapSpan
+	zoneWidth int
+	// stmts are the statements of the program ordered by the offset of their first instruction
+	stmts []stmtInfo
+}
+
+type opcode uint8
+
+const (
+	opPushNum   opcode = iota // push f
+	opPushStr                 // push consts[a]
+	opLoadNum                 // push variable a
+	opLoadStr                 // push variable a
+	opStoreNum                // pop into variable a, converted to type t
+	opStoreStr                // pop into variable a
+	opLoadElem                // push element of array a with b indexes
+	opStoreElem               // pop b indexes and the value into array a
+	opDim                     // dimension array a with b dimensions
+	opNarrow                  // convert the number on top to type t
+	opNeg
+	opNot
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opArith // any other arithmetic or logical operator arithOps[a]
+	opEq
+	opNotEq
+	opLs
+	opGt
+	opLsEq
+	opGtEq
+	opConcat
+	opCompareStr // compare strings by the relational opcode b
+	opStrCond    // replace the string on top by a false condition
+	opCall       // call the builtin calls[a]
+	opCallFn     // call the DEF FN calls[a]
+	opDef        // define defs[a]
+	opRet        // return from a DEF FN
+	opJump
+	opJumpFalse
+	opJumpTrue
+	opGosub
+	opReturn
+	opOn // ON ... GOTO or ON ... GOSUB with ons[a]
+	opFor
+	opNext // next of the loop with variable a, the innermost one for -1
+	opPrint
+	opPrintComma
+	opTab
+	opSpc
+	opPrintln
+	opPrintUsing
+	opInput
+	opInputValue
+	opLineInput
+	opRead
+	opRestore
+	opHalt // halt with Exit a
+	opThrow
+	opTron // turn the tracing of line numbers on (a=1) or off (a=0)
+	opLine // print the line number a, if tracing is on
+)
+
+type instr struct {
+	op opcode
+	t  expr.Type
+	a  int
+	b  int
+	f  float64
+}
+
+var arithOps = []expr.Op{expr.OpIntDiv, expr.OpMOD, expr.OpExp, expr.OpAND, expr.OpOR, expr.OpXOR}
+
+type callInfo struct {
+	name     string
+	fn       int
+	argTypes []expr.Type
+}
+
+// fnDef is the body of a DEF FN. Its parameters have slots of their own, which are only used by the body.
+type fnDef struct {
+	name   string
+	fn     int
+	pc     int
+	params []param
+}
+
+type param struct {
+	name string
+	slot int
+	t    expr.Type
+}
+
+type onInfo struct {
+	lines   []int
+	targets []int
+	gosub   bool
+}
+
+type inputInfo struct {
+	prompt string
+	types  []expr.Type
+}
+
+type usingInfo struct {
+	types   []expr.Type
+	newline bool
+}
+
+type arrayInfo struct {
+	name string
+	t    expr.Type
+}
+
+type stmtInfo struct {
+	pc      int
+	line    int
+	stmtIdx int
+	kind    string
+}
+
+type vmFor struct {
+	slot int
+	t    expr.Type
+	to   float64
+	step float64
+	body int
+}
+
+type vmReturn struct {
+	pc       int
+	forDepth int
+}
+
+type vmCall struct {
+	pc  int
+	def *fnDef
+}
+
+// machine is the state of a run of a Program
+type machine struct {
+	p         *Program
+	nums      []float64
+	strs      []string
+	numVars   []float64
+	strVars   []string
+	numArrays []*Array[float64]
+	strArrays []*Array[string]
+	fors      []vmFor
+	gosubs    []vmReturn
+	calls     []vmCall
+	fns       []*fnDef
+	active    map[*fnDef]bool
+	cs        []int
+	input     []interface{}
+	console   *Console
+	data      *Data
+	funcs     *expr.Funcs
+	tron      bool
+}
+
+// Run runs the program against the streams. Like State.Run it fails with a RuntimeError.
+// SetZoneWidth sets the width of the print zones of the next runs like State.SetZoneWidth
+func (p *Program) SetZoneWidth(n int) {
+	p.zoneWidth = n
+}
+
+func (p *Program) Run(st Streams) (Exit, error) {
+	st = st.withDefaults()
+	m := &machine{
+		p:         p,
+		numVars:   make([]float64, p.numVars),
+		strVars:   make([]string, p.strVars),
+		numArrays: make([]*Array[float64], len(p.arrays)),
+		strArrays: make([]*Array[string], len(p.arrays)),
+		fns:       make([]*fnDef, len(p.fnNames)),
+		active:    map[*fnDef]bool{},
+		console:   NewConsole(st.Stdin, st.Stdout),
+		data:      &Data{vars: p.data},
+		funcs:     BuiltinFuncs(),
+	}
+	m.console.SetZoneWidth(p.zoneWidth)
+	pc, exit, err := m.run()
+	if err != nil {
+		// errors in the body of a DEF FN are wrapped by the calls up to the statement, which they belong to
+		for i := len(m.calls) - 1; i >= 0; i-- {
+			err = p.wrap(pc, err)
+			pc = m.calls[i].pc - 1
+		}
+		return ExitError, p.runtimeError(pc, p.wrap(pc, err))
+	}
+	return exit, nil
+}
+
+// wrapSpan wraps the errors of the instructions from start to end with msg
+type wrapSpan struct {
+	start, end int
+	msg        string
+}
+
+// wrap wraps err with the messages of the spans around pc. Inner spans are compiled first, so they wrap first.
+func (p *Program) wrap(pc int, err error) error {
+	for _, w := range p.wraps {
+		if w.start <= pc && pc < w.end {
+			err = errors.Wrap(err, w.msg)
+		}
+	}
+	return err
+}
+
+func (p *Program) runtimeError(pc int, err error) error {
+	i := sort.Search(len(p.stmts), func(i int) bool {
+		return p.stmts[i].pc > pc
+	}) - 1
+	if i < 0 {
+		return err
+	}
+	si := p.stmts[i]
+	return &RuntimeError{
+		Line:    si.line,
+		StmtIdx: si.stmtIdx,
+		Stmt:    si.kind,
+		Err:     err,
+	}
+}
+
+func (m *machine) push(f float64) {
+	m.nums = append(m.nums, f)
+}
+
+func (m *machine) pop() float64 {
+	n := len(m.nums) - 1
+	f := m.nums[n]
+	m.nums = m.nums[:n]
+	return f
+}
+
+func (m *machine) pushStr(s string) {
+	m.strs = append(m.strs, s)
+}
+
+func (m *machine) popStr() string {
+	n := len(m.strs) - 1
+	s := m.strs[n]
+	m.strs = m.strs[:n]
+	return s
+}
+
+// popIndexes pops n array indexes. Like in the interpreter they are truncated.
+func (m *machine) popIndexes(n int) []int {
+	if cap(m.cs) < n {
+		m.cs = make([]int, n)
+	}
+	cs := m.cs[:n]
+	for i := n - 1; i >= 0; i-- {
+		cs[i] = int(m.pop())
+	}
+	return cs
+}
+
+// popValue pops a value of type t and boxes it like the interpreter holds it
+func (m *machine) popValue(t expr.Type) interface{} {
+	if t == expr.TypeString {
+		return m.popStr()
+	}
+	return box(t, m.pop())
+}
+
+func (m *machine) pushValue(v interface{}) error {
+	if s, ok := v.(string); ok {
+		m.pushStr(s)
+		return nil
+	}
+	f, err := unbox(v)
+	if err != nil {
+		return err
+	}
+	m.push(f)
+	return nil
+}
+
+func (m *machine) cond() bool {
+	return m.pop() != 0
+}
+
+// run executes the program and returns the offset of the instruction, it halted or failed at
+func (m *machine) run() (int, Exit, error) {
+	code := m.p.code
+	pc := 0
+	for {
+		in := &code[pc]
+		pc++
+		var err error
+		switch in.op {
+		case opPushNum:
+			m.push(in.f)
+		case opPushStr:
+			m.pushStr(m.p.consts[in.a])
+		case opLoadNum:
+			m.push(m.numVars[in.a])
+		case opLoadStr:
+			m.pushStr(m.strVars[in.a])
+		case opStoreNum:
+			m.numVars[in.a], err = narrow(in.t, m.pop())
+		case opStoreStr:
+			m.strVars[in.a] = m.popStr()
+		case opLoadElem:
+			err = m.loadElem(in.a, in.b)
+		case opStoreElem:
+			err = m.storeElem(in.a, in.b)
+		case opDim:
+			dims := append([]int{}, m.popIndexes(in.b)...)
+			if m.p.arrays[in.a].t == expr.TypeString {
+				m.strArrays[in.a] = NewArray[string](dims)
+			} else {
+				m.numArrays[in.a] = NewArray[float64](dims)
+			}
+		case opNarrow:
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, m.nums[n])
+		case opNeg:
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, -m.nums[n])
+		case opNot:
+			n := len(m.nums) - 1
+			var i int64
+			i, err = expr.ToInt(m.nums[n])
+			m.nums[n] = float64(int16(^i))
+		case opAdd:
+			y := m.pop()
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, m.nums[n]+y)
+		case opSub:
+			y := m.pop()
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, m.nums[n]-y)
+		case opMul:
+			y := m.pop()
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, m.nums[n]*y)
+		case opDiv:
+			y := m.pop()
+			if y == 0 {
+				err = expr.ErrDivisionByZero
+				break
+			}
+			n := len(m.nums) - 1
+			m.nums[n], err = narrow(in.t, m.nums[n]/y)
+		case opArith:
+			y := m.pop()
+			n := len(m.nums) - 1
+			var f float64
+			f, err = expr.FloatOp(arithOps[in.a], m.nums[n], y)
+			if err == nil {
+				m.nums[n], err = narrow(in.t, f)
+			}
+		case opEq, opNotEq, opLs, opGt, opLsEq, opGtEq:
+			y := m.pop()
+			n := len(m.nums) - 1
+			m.nums[n] = float64(expr.BoolToInt(compare(in.op, m.nums[n], y)))
+		case opConcat:
+			y := m.popStr()
+			n := len(m.strs) - 1
+			m.strs[n] += y
+		case opCompareStr:
+			y := m.popStr()
+			x := m.popStr()
+			m.push(float64(expr.BoolToInt(compare(opcode(in.b), x, y))))
+		case opStrCond:
+			m.popStr()
+			m.push(0)
+		case opCall:
+			err = m.call(&m.p.calls[in.a])
+		case opCallFn:
+			pc, err = m.callFn(&m.p.calls[in.a], pc)
+		case opDef:
+			def := m.p.defs[in.a]
+			m.fns[def.fn] = def
+		case opRet:
+			call := m.calls[len(m.calls)-1]
+			m.calls = m.calls[:len(m.calls)-1]
+			delete(m.active, call.def)
+			pc = call.pc
+		case opJump:
+			pc = in.a
+		case opJumpFalse:
+			if !m.cond() {
+				pc = in.a
+			}
+		case opJumpTrue:
+			if m.cond() {
+				pc = in.a
+			}
+		case opGosub:
+			err = m.gosub(pc)
+			if err == nil {
+				pc = in.a
+			}
+		case opReturn:
+			if len(m.gosubs) == 0 {
+				err = errors.Errorf("RETURN without GOSUB")
+				break
+			}
+			from := m.gosubs[len(m.gosubs)-1]
+			m.gosubs = m.gosubs[:len(m.gosubs)-1]
+			if len(m.fors) > from.forDepth {
+				m.fors = m.fors[:from.forDepth]
+			}
+			pc = from.pc
+		case opOn:
+			pc, err = m.on(&m.p.ons[in.a], pc)
+		case opFor:
+			err = m.forLoop(in, pc)
+		case opNext:
+			pc, err = m.next(in.a, pc)
+		case opPrint:
+			m.console.Print(m.popValue(in.t))
+		case opPrintComma:
+			m.console.PrintComma()
+		case opTab, opSpc:
+			var n int64
+			n, err = expr.ToInt(m.pop())
+			switch {
+			case err != nil:
+			case in.op == opTab:
+				m.console.Tab(int(n))
+			default:
+				m.console.Spc(int(n))
+			}
+		case opPrintln:
+			m.console.Println()
+		case opPrintUsing:
+			err = m.printUsing(&m.p.usings[in.a])
+		case opInput:
+			info := &m.p.inputs[in.a]
+			m.input, err = m.console.Input(info.prompt, info.types)
+			if err == io.EOF {
+				return pc - 1, ExitEnd, nil
+			}
+		case opInputValue:
+			err = m.pushValue(m.input[in.a])
+		case opLineInput:
+			var line string
+			line, err = m.console.LineInput(m.p.consts[in.a])
+			if err == io.EOF {
+				return pc - 1, ExitEnd, nil
+			}
+			m.pushStr(line)
+		case opRead:
+			var v interface{}
+			v, err = m.data.ReadFor(m.p.consts[in.a], in.t)
+			if err == nil {
+				err = m.pushValue(v)
+			}
+		case opRestore:
+			m.data.Restore()
+		case opHalt:
+			return pc - 1, Exit(in.a), nil
+		case opThrow:
+			err = m.p.errs[in.a]
+		case opTron:
+			m.tron = in.a == 1
+		case opLine:
+			if m.tron {
+				m.console.Print("[" + strconv.Itoa(in.a) + "]")
+			}
+		}
+		if err != nil {
+			return pc - 1, ExitError, err
+		}
+	}
+}
+
+func (m *machine) loadElem(arr int, n int) error {
+	cs := m.popIndexes(n)
+	if m.p.arrays[arr].t == expr.TypeString {
+		a := m.strArrays[arr]
+		if a == nil {
+			return errors.Errorf("no such func %q", m.p.arrays[arr].name)
+		}
+		s, err := a.Get(cs)
+		m.pushStr(s)
+		return err
+	}
+	a := m.numArrays[arr]
+	if a == nil {
+		return errors.Errorf("no such func %q", m.p.arrays[arr].name)
+	}
+	f, err := a.Get(cs)
+	m.push(f)
+	return err
+}
+
+func (m *machine) storeElem(arr int, n int) error {
+	info := m.p.arrays[arr]
+	cs := m.popIndexes(n)
+	var err error
+	if info.t == expr.TypeString {
+		a := m.strArrays[arr]
+		s := m.popStr()
+		if a == nil {
+			return errors.Errorf("no such array %q", info.name)
+		}
+		err = a.Set(cs, s)
+	} else {
+		a := m.numArrays[arr]
+		f, nerr := narrow(info.t, m.pop())
+		if a == nil {
+			return errors.Errorf("no such array %q", info.name)
+		}
+		if nerr != nil {
+			return nerr
+		}
+		err = a.Set(cs, f)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "set array %v", cs)
+	}
+	return nil
+}
+
+func (m *machine) call(ci *callInfo) error {
+	args := make([]interface{}, len(ci.argTypes))
+	for i := len(args) - 1; i >= 0; i-- {
+		args[i] = m.popValue(ci.argTypes[i])
+	}
+	v, err := m.funcs.Call(ci.name, args...)
+	if err != nil {
+		return err
+	}
+	return m.pushValue(v)
+}
+
+// callFn binds the arguments to the parameters of the current definition of a DEF FN and continues with its body
+func (m *machine) callFn(ci *callInfo, pc int) (int, error) {
+	args := make([]interface{}, len(ci.argTypes))
+	for i := len(args) - 1; i >= 0; i-- {
+		args[i] = m.popValue(ci.argTypes[i])
+	}
+	def := m.fns[ci.fn]
+	switch {
+	case def == nil:
+		return pc, errors.Errorf("no such func %q", ci.name)
+	case m.active[def]:
+		return pc, errors.Errorf("recursive call of %s", def.name)
+	case len(args) != len(def.params):
+		return pc, errors.Errorf("%s: expect %d args, got %d", def.name, len(def.params), len(args))
+	}
+	for i, p := range def.params {
+		v, err := p.t.Convert(args[i])
+		if err != nil {
+			return pc, errors.Wrapf(err, "%s: param %q", def.name, p.name)
+		}
+		if s, ok := v.(string); ok {
+			m.strVars[p.slot] = s
+			continue
+		}
+		m.numVars[p.slot], _ = unbox(v)
+	}
+	m.active[def] = true
+	m.calls = append(m.calls, vmCall{pc: pc, def: def})
+	return def.pc, nil
+}
+
+func (m *machine) gosub(pc int) error {
+	if len(m.gosubs) >= DefaultMaxGosubDepth {
+		return errors.Errorf("stack overflow: more than %d nested GOSUBs", DefaultMaxGosubDepth)
+	}
+	m.gosubs = append(m.gosubs, vmReturn{pc: pc, forDepth: len(m.fors)})
+	return nil
+}
+
+func (m *machine) on(info *onInfo, pc int) (int, error) {
+	val := m.pop()
+	var ix int
+	if info.gosub {
+		ix = int(val) - 1
+	} else {
+		ix = int(math.Round(val)) - 1
+	}
+	if ix < 0 || ix >= len(info.targets) {
+		return pc, errors.Errorf("invalid index %d", ix+1)
+	}
+	if info.gosub {
+		if err := m.gosub(pc); err != nil {
+			return pc, err
+		}
+	}
+	if info.targets[ix] < 0 {
+		return pc, errors.Errorf("no such line %d", info.lines[ix])
+	}
+	return info.targets[ix], nil
+}
+
+// forLoop starts a loop like FOR in the interpreter. A loop on a variable, which is already looping, replaces it.
+func (m *machine) forLoop(in *instr, pc int) error {
+	step := m.pop()
+	to := m.pop()
+	f, err := narrow(in.t, m.pop())
+	if err != nil {
+		return err
+	}
+	m.numVars[in.a] = f
+	for i := len(m.fors) - 1; i >= 0; i-- {
+		if m.fors[i].slot == in.a {
+			m.fors = m.fors[:i]
+			break
+		}
+	}
+	m.fors = append(m.fors, vmFor{slot: in.a, t: in.t, to: to, step: step, body: pc})
+	return nil
+}
+
+func (m *machine) next(slot int, pc int) (int, error) {
+	idx := len(m.fors) - 1
+	if slot != -1 {
+		for idx >= 0 && m.fors[idx].slot != slot {
+			idx--
+		}
+	}
+	if idx < 0 {
+		return pc, errors.Errorf("NEXT without FOR")
+	}
+	fs := m.fors[idx]
+	f, err := narrow(fs.t, m.numVars[fs.slot]+fs.step)
+	if err != nil {
+		return pc, err
+	}
+	m.numVars[fs.slot] = f
+	var done bool
+	if fs.step >= 0 {
+		done = f > fs.to
+	} else {
+		done = f < fs.to
+	}
+	if done {
+		m.fors = m.fors[:idx]
+		return pc, nil
+	}
+	m.fors = m.fors[:idx+1]
+	return fs.body, nil
+}
+
+func (m *machine) printUsing(info *usingInfo) error {
+	vals := make([]interface{}, len(info.types))
+	for i := len(vals) - 1; i >= 0; i-- {
+		vals[i] = m.popValue(info.types[i])
+	}
+	out, err := FormatUsing(m.popStr(), vals)
+	if err != nil {
+		return err
+	}
+	m.console.Print(out)
+	if info.newline {
+		m.console.Println()
+	}
+	return nil
+}
+
+// narrow converts f to the precision of type t, like a value of type t holds it
+func narrow(t expr.Type, f float64) (float64, error) {
+	switch t {
+	case expr.TypeInteger:
+		n, err := expr.ToInt(f)
+		return float64(n), err
+	case expr.TypeSingle:
+		if err := expr.CheckFinite(f); err != nil {
+			return 0, err
+		}
+		if math.Abs(f) > math.MaxFloat32 {
+			return 0, expr.ErrOverflow
+		}
+		return float64(float32(f)), nil
+	default:
+		return f, expr.CheckFinite(f)
+	}
+}
+
+func box(t expr.Type, f float64) interface{} {
+	switch t {
+	case expr.TypeInteger:
+		return int16(f)
+	case expr.TypeSingle:
+		return float32(f)
+	default:
+		return f
+	}
+}
+
+func unbox(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int16:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	default:
+		return 0, expr.ErrTypeMismatch
+	}
+}
+
+func compare[T float64 | string](op opcode, x, y T) bool {
+	switch op {
+	case opEq:
+		return x == y
+	case opNotEq:
+		return x != y
+	case opLs:
+		return x < y
+	case opGt:
+		return x > y
+	case opLsEq:
+		return x <= y
+	default:
+		return x >= y
+	}
+}
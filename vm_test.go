@@ -0,0 +1,291 @@
+package gobas
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mazzegi/gobas/expr"
+	"github.com/pkg/errors"
+)
+
+func runCompiled(t *testing.T, src string, input string) (string, Exit, error) {
+	state, err := NewParser().Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	prog, err := Compile(state.Lines())
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	exit, err := prog.Run(Streams{
+		Stdin:  strings.NewReader(input),
+		Stdout: stdout,
+	})
+	return stdout.String(), exit, err
+}
+
+func TestVMSameOutput(t *testing.T) {
+	readFile := func(name string) string {
+		bs, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %q: %v", name, err)
+		}
+		return string(bs)
+	}
+
+	tests := []struct {
+		name  string
+		src   string
+		input string
+	}{
+		{
+			name: "amazing with seeded RND",
+			src:  "1 X=RND(-7)\n" + readFile("samples/002_amazing_debug.bas"),
+		},
+		{
+			name:  "statements",
+			src:   readFile("testfiles/transpile01.bas"),
+			input: "MARTIN\n",
+		},
+		{
+			name:  "typed variables",
+			src:   readFile("testfiles/transpile02.bas"),
+			input: "7.6\n",
+		},
+		{
+			name:  "input",
+			src:   readFile("testfiles/transpile03.bas"),
+			input: "X\n1\n \"HELLO, WORLD\" \n3,4\nA \"QUOTED\", LINE\n",
+		},
+		{
+			name: "structured blocks",
+			src:  readFile("testfiles/transpile04.bas"),
+		},
+		{
+			name: "jumps",
+			src: `
+				10 ON 3 GOTO 20,30,40
+				20 PRINT "NO"
+				30 PRINT "NO"
+				40 ON 1.6 GOSUB 100,110: ON 2.5 GOSUB 100,110
+				50 IF 1 THEN 60 ELSE 20
+				60 IF "A" THEN PRINT "NO" ELSE PRINT "STRING IS FALSE"
+				70 FOR I=1 TO 2: FOR J=1 TO 2: PRINT I*J;: NEXT J, I: PRINT
+				80 DEF FNA(X)=X+Y: Y=10: PRINT FNA(1);FNA(FNA(2))
+				90 STOP
+				100 PRINT "ONE": RETURN
+				110 PRINT "TWO": RETURN`,
+		},
+		{
+			name: "tron",
+			src:  tronProgram,
+		},
+		{
+			name: "arithmetic",
+			src: `
+				10 A%=7: B=2.5: C#=1/3: D$="X"
+				20 PRINT A%\2;A% MOD 3;A%^2;B*A%;-B;NOT A%;A% AND 3;A% OR 8;A% XOR 1
+				30 PRINT C#*3;B/A%;A%=7;B<>2.5;D$+"Y";D$<"Y";LEN(D$+D$);INT(-B)
+				40 A%=B: PRINT A%: A%=-B: PRINT A%`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want, wantExit, wantErr := runProgram(t, test.src, test.input)
+			got, gotExit, gotErr := runCompiled(t, test.src, test.input)
+			if got != want {
+				t.Fatalf("want output\n%s\ngot\n%s", want, got)
+			}
+			if gotExit != wantExit {
+				t.Fatalf("want exit %v, got %v", wantExit, gotExit)
+			}
+			if (gotErr == nil) != (wantErr == nil) || (gotErr != nil && gotErr.Error() != wantErr.Error()) {
+				t.Fatalf("want error %v, got %v", wantErr, gotErr)
+			}
+		})
+	}
+}
+
+func TestVMZoneWidth(t *testing.T) {
+	prog, err := Compile(parseLines(t, zoneProgram))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	prog.SetZoneWidth(10)
+	out := &bytes.Buffer{}
+	if _, err := prog.Run(Streams{Stdout: out}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := "          A" + strings.Repeat(" ", 59) + "B\nC\n"; !strings.HasSuffix(out.String(), want) {
+		t.Fatalf("want output ending with %q, got %q", want, out.String())
+	}
+}
+
+func TestVMErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		err    error
+		line   int
+		output string
+	}{
+		{
+			name:   "type mismatch is raised when the statement runs",
+			src:    "10 PRINT 1\n20 A=\"X\"",
+			err:    expr.ErrTypeMismatch,
+			line:   20,
+			output: " 1 \n",
+		},
+		{
+			name: "division by zero",
+			src:  "10 A%=0\n20 PRINT 1\\A%",
+			err:  expr.ErrDivisionByZero,
+			line: 20,
+		},
+		{
+			name: "overflow",
+			src:  "10 A%=32767\n20 A%=A%+1",
+			err:  expr.ErrOverflow,
+			line: 20,
+		},
+		{
+			name: "double overflow",
+			src:  "10 A#=1D300\n20 PRINT A#*A#",
+			err:  expr.ErrOverflow,
+			line: 20,
+		},
+		{
+			name: "illegal function call",
+			src:  "10 PRINT SQR(-1)",
+			err:  expr.ErrIllegalFunctionCall,
+			line: 10,
+		},
+		{
+			name: "error in a DEF FN is raised by its caller",
+			src:  "10 DEF FNA(X)=1\\X\n20 PRINT\n30 PRINT FNA(0)",
+			err:  expr.ErrDivisionByZero,
+			line: 30,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, exit, err := runCompiled(t, test.src, "")
+			if exit != ExitError || !errors.Is(err, test.err) {
+				t.Fatalf("want %v, got exit %v with %v", test.err, exit, err)
+			}
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) || rerr.Line != test.line {
+				t.Fatalf("want error in line %d, got %v", test.line, err)
+			}
+			if test.output != "" && out != test.output {
+				t.Fatalf("want output %q, got %q", test.output, out)
+			}
+		})
+	}
+
+	prog, err := Compile(parseLines(t, "10 GOTO 30"))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	_, err = prog.Run(Streams{Stdout: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "no such line 30") {
+		t.Fatalf("want no such line error, got %v", err)
+	}
+
+	state, err := NewParser().Parse(strings.NewReader("10 CALL P\n20 SUB P\n30 END SUB"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	_, err = Compile(state.Lines())
+	if !errors.Is(err, ErrProcedures) || err.Error() != "in line 10: procedures are not supported by the VM" {
+		t.Fatalf("want procedures error, got %v", err)
+	}
+}
+
+func TestVMErrorsLikeInterpreter(t *testing.T) {
+	tests := []string{
+		"10 M%=-32768\n20 PRINT -M%",
+		"10 PRINT 1/0",
+		"10 FOR I=1/0 TO 2",
+		"10 FOR I=1 TO 1/0",
+		"10 FOR I=1 TO 2 STEP 1/0",
+		"10 FOR I=\"A\" TO 2",
+		"10 DIM A(10)\n20 PRINT A(11)",
+		"10 DIM A(1/0)",
+		"10 DIM A(10)\n20 A(1/0)=1",
+		"10 A=SQR(-1)",
+		"10 PRINT MID$(5,1)",
+		"10 ON 1/0 GOTO 10",
+		"10 PRINT TAB(1/0)",
+		"10 PRINT SPC(\"A\")",
+		"10 IF 1/0 THEN 10",
+		"10 IF 1/0 THEN PRINT 1 ELSE PRINT 2",
+		"10 WHILE 1/0\n20 WEND",
+		"10 DO UNTIL 1/0\n20 LOOP",
+		"10 DO\n20 LOOP WHILE 1/0",
+		"10 IF 1/0 THEN\n20 END IF",
+		"10 PRINT USING 1/0; 1",
+		"10 PRINT USING \"##\"; 1/0",
+		"10 DEF FNA(X)=1/X\n20 PRINT FNA(0)",
+		"10 DEF FNA(X$)=1\n20 PRINT FNA(1)",
+		"10 DEF FNB(X)=1/X\n20 DEF FNA(X)=FNB(X)+1\n30 PRINT 1+FNA(0)",
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			_, _, want := runProgram(t, src, "")
+			_, _, got := runCompiled(t, src, "")
+			if want == nil || got == nil || got.Error() != want.Error() {
+				t.Fatalf("want error %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func benchmarkAmazing(b *testing.B, run func(src string, st Streams) error) {
+	bs, err := os.ReadFile("samples/002_amazing.bas")
+	if err != nil {
+		b.Fatalf("read sample: %v", err)
+	}
+	src := "1 X=RND(-7)\n" + string(bs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := run(src, Streams{
+			Stdin:  strings.NewReader("25,25\n"),
+			Stdout: io.Discard,
+		})
+		if err != nil {
+			b.Fatalf("run: %v", err)
+		}
+	}
+}
+
+func BenchmarkAmazingInterpreter(b *testing.B) {
+	benchmarkAmazing(b, func(src string, st Streams) error {
+		state, err := NewParser().Parse(strings.NewReader(src))
+		if err != nil {
+			return err
+		}
+		state.SetStreams(st)
+		_, err = state.Run()
+		return err
+	})
+}
+
+func BenchmarkAmazingVM(b *testing.B) {
+	benchmarkAmazing(b, func(src string, st Streams) error {
+		state, err := NewParser().Parse(strings.NewReader(src))
+		if err != nil {
+			return err
+		}
+		prog, err := Compile(state.Lines())
+		if err != nil {
+			return err
+		}
+		_, err = prog.Run(st)
+		return err
+	})
+}